@@ -1,33 +1,29 @@
 package main
 
 import (
-	"log"
+	"log/slog"
 	"os"
 
 	"github.com/kamdyn/ai-toolkit/pkg/common"
-	"github.com/kamdyn/ai-toolkit/pkg/docgen"
-	"github.com/urfave/cli/v2"
+
+	// The tool registers itself with the registry when imported
+	_ "github.com/kamdyn/ai-toolkit/pkg/docgen"
 )
 
 func main() {
 	// Load environment variables
 	common.LoadEnv()
 
-	// Get the docgen command
-	docgenCmd := docgen.GetDocGenCommand()
-
-	// Create CLI app
-	app := &cli.App{
-		Name:    "ai-tools-docgen",
-		Usage:   "Generate documentation for code",
-		Version: common.Version,
-		Flags:   docgenCmd.Flags,
-		Action:  docgenCmd.Action,
-		Before:  docgenCmd.Before,
+	// Create CLI app running the tool
+	app, err := common.NewStandaloneApp("ai-tools-docgen", "docgen")
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
 	}
 
 	// Run the app
 	if err := app.Run(os.Args); err != nil {
-		log.Fatal(err)
+		slog.Error(err.Error())
+		os.Exit(1)
 	}
-}
\ No newline at end of file
+}
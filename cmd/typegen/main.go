@@ -1,33 +1,29 @@
 package main
 
 import (
-	"log"
+	"log/slog"
 	"os"
 
 	"github.com/kamdyn/ai-toolkit/pkg/common"
-	"github.com/kamdyn/ai-toolkit/pkg/typegen"
-	"github.com/urfave/cli/v2"
+
+	// The tool registers itself with the registry when imported
+	_ "github.com/kamdyn/ai-toolkit/pkg/typegen"
 )
 
 func main() {
 	// Load environment variables
 	common.LoadEnv()
 
-	// Get the types generator command
-	typeGenCmd := typegen.GetTypeGenCommand()
-
-	// Create CLI app
-	app := &cli.App{
-		Name:    "ai-tools-typegen",
-		Usage:   "Generate type definitions from API documentation",
-		Version: common.Version,
-		Flags:   typeGenCmd.Flags,
-		Action:  typeGenCmd.Action,
-		Before:  typeGenCmd.Before,
+	// Create CLI app running the tool
+	app, err := common.NewStandaloneApp("ai-tools-typegen", "typegen")
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
 	}
 
 	// Run the app
 	if err := app.Run(os.Args); err != nil {
-		log.Fatal(err)
+		slog.Error(err.Error())
+		os.Exit(1)
 	}
-}
\ No newline at end of file
+}
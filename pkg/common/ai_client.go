@@ -3,121 +3,475 @@ package common
 import (
 	"context"
 	"fmt"
-	"regexp"
+	"log/slog"
 	"strings"
+	"time"
 
-	"github.com/google/generative-ai-go/genai"
-	"google.golang.org/api/option"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-// AIClient provides a unified interface for working with Gemini AI
+// AIClient provides a unified interface for working with the configured AI provider.
+// It implements Provider itself so generators can depend on the interface alone.
 type AIClient struct {
-	client *genai.Client
+	provider Provider
+	retry    RetryPolicy
+	cache    *ResponseCache
+	usage    *UsageTracker
+	limiter  *RateLimiter
+
+	// models is the ordered fallback chain tried when a model cannot serve a request
+	models []string
+
+	// maxContinuations limits the follow-up requests sent for truncated responses
+	maxContinuations int
+
+	// allowTruncated keeps responses still truncated after the continuations instead of failing
+	allowTruncated bool
+}
+
+// NewAIClient creates a new AIClient for the provider selected in the configuration
+func NewAIClient(ctx context.Context, config ToolConfig) (*AIClient, error) {
+	provider, err := newConfiguredProvider(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	client := NewAIClientWithProvider(provider)
+	client.retry = config.Retry
+	client.models = config.Models
+	client.limiter = NewRateLimiter(config.RPM, config.TPM)
+	client.allowTruncated = config.AllowTruncated
+
+	prices, err := LoadPriceTable(config.PriceFile)
+	if err != nil {
+		return nil, err
+	}
+	client.usage = NewUsageTracker(prices, config.MaxCost)
+
+	// Cache hits would bypass the cassette, so caching is off while recording or replaying
+	if config.Cache.Enabled && config.Cassette.Path == "" {
+		cache, err := NewResponseCache(config.Cache.Dir, config.Cache.TTL, config.Cache.MaxSize)
+		if err != nil {
+			return nil, err
+		}
+		client.cache = cache
+	}
+
+	return client, nil
 }
 
-// NewAIClient creates a new AIClient with the given API key
-func NewAIClient(ctx context.Context, apiKey string) (*AIClient, error) {
-	// Clean any carriage returns from the API key
-	apiKey = strings.ReplaceAll(apiKey, "\r", "")
-	
-	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+// newConfiguredProvider creates the provider selected in the configuration,
+// wrapped for recording or replaced by a replay when a cassette is set
+func newConfiguredProvider(ctx context.Context, config ToolConfig) (Provider, error) {
+	if config.Cassette.Path == "" {
+		return NewProvider(ctx, config.Provider, config.APIKey, config.BaseURL)
+	}
+
+	cassette, err := LoadCassette(config.Cassette.Path)
 	if err != nil {
-		return nil, fmt.Errorf("error creating Gemini client: %v", err)
+		return nil, err
+	}
+
+	switch config.Cassette.Mode {
+	case CassetteReplay:
+		slog.Debug("Replaying recorded responses", "file", cassette.Path(), "responses", cassette.Len())
+		return NewReplayProvider(config.Provider, cassette), nil
+	case CassetteRecord:
+		provider, err := NewProvider(ctx, config.Provider, config.APIKey, config.BaseURL)
+		if err != nil {
+			return nil, err
+		}
+		slog.Debug("Recording responses", "file", cassette.Path())
+		return NewRecordingProvider(provider, cassette), nil
+	default:
+		return nil, fmt.Errorf("unknown cassette mode: %s (supported: record, replay)", config.Cassette.Mode)
 	}
-	
+}
+
+// SetCache sets the response cache consulted before calling the provider (nil disables caching)
+func (c *AIClient) SetCache(cache *ResponseCache) {
+	c.cache = cache
+}
+
+// NewAIClientWithProvider creates a new AIClient backed by an existing provider
+func NewAIClientWithProvider(provider Provider) *AIClient {
 	return &AIClient{
-		client: client,
-	}, nil
+		provider: provider,
+		retry:    DefaultRetryPolicy(),
+		usage:    NewUsageTracker(nil, 0),
+
+		maxContinuations: DefaultMaxContinuations,
+	}
+}
+
+// SetRateLimiter sets the limiter every request passes through, so several
+// clients can share one quota (nil disables rate limiting)
+func (c *AIClient) SetRateLimiter(limiter *RateLimiter) {
+	c.limiter = limiter
+}
+
+// Usage returns the tracker accounting the token usage and cost of every call
+func (c *AIClient) Usage() *UsageTracker {
+	return c.usage
 }
 
-// Close closes the underlying AI client
+// Name returns the name of the underlying provider
+func (c *AIClient) Name() string {
+	return c.provider.Name()
+}
+
+// Close closes the underlying provider
 func (c *AIClient) Close() error {
-	if c.client != nil {
-		return c.client.Close()
+	if c.provider != nil {
+		return c.provider.Close()
 	}
 	return nil
 }
 
-// Generate generates content based on the given prompt
-func (c *AIClient) Generate(ctx context.Context, prompt string, model string, temperature float32) (string, error) {
-	// Use the specified model
-	genModel := c.client.GenerativeModel(model)
-	
-	// Set generation parameters
-	genModel.SetTemperature(temperature)
+// Generate generates content based on the given request, serving it from the
+// response cache when possible and retrying transient failures. Responses cut
+// off at the output limit are completed with continuation requests.
+func (c *AIClient) Generate(ctx context.Context, req GenerateRequest) (resp *GenerateResponse, err error) {
+	ctx, span := StartSpan(ctx, "ai.generate",
+		attribute.String(AttrGenAISystem, c.provider.Name()),
+		attribute.String(AttrGenAIRequestModel, req.Model))
+	defer func() { EndSpan(span, err) }()
+
+	cacheKey := c.cacheKey(req)
+	if cached, ok := c.cacheGet(cacheKey); ok {
+		c.usage.Record(ctx, c.provider.Name(), cached.Model, cached.Usage, true)
+		span.SetAttributes(attribute.Bool("ai_toolkit.cached", true))
+		span.SetAttributes(usageAttributes(cached.Model, cached.Usage, cached.FinishReason)...)
+		return cached, nil
+	}
 
-	// Generate content
-	resp, err := genModel.GenerateContent(ctx, genai.Text(prompt))
+	resp, err = c.generateOnce(ctx, req)
 	if err != nil {
-		return "", fmt.Errorf("error generating content: %v", err)
+		return nil, err
 	}
 
-	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
-		return "", fmt.Errorf("no response generated from the AI")
+	if resp.FinishReason == FinishMaxTokens {
+		// Continue with the model that produced the first part
+		next := req
+		next.Model = resp.Model
+		rest, finishReason, err := c.continueTruncated(ctx, next, resp.Text)
+		if err != nil {
+			return nil, err
+		}
+		resp.Text += rest
+		resp.FinishReason = finishReason
 	}
 
-	// Extract text from the response
-	var result strings.Builder
-	for _, part := range resp.Candidates[0].Content.Parts {
-		result.WriteString(fmt.Sprintf("%v", part))
+	span.SetAttributes(
+		attribute.String(AttrGenAIResponseModel, resp.Model),
+		attribute.StringSlice(AttrGenAIFinishReasons, []string{resp.FinishReason}))
+	c.cachePut(cacheKey, resp)
+	return resp, nil
+}
+
+// generateOnce sends a single request to the provider, checking the cost
+// budget first, retrying transient failures and accounting the usage. When
+// the model cannot serve the request, the configured fallback models are tried
+// in order.
+func (c *AIClient) generateOnce(ctx context.Context, req GenerateRequest) (*GenerateResponse, error) {
+	chain := c.modelChain(req.Model)
+
+	var err error
+	for i, model := range chain {
+		if i > 0 {
+			slog.Warn("Model failed, falling back", "model", chain[i-1], "fallback", model, "error", err)
+		}
+
+		attempt := req
+		attempt.Model = model
+		var budget *BudgetReservation
+		if budget, err = c.usage.CheckBudget(c.provider.Name(), model, req.Prompt); err != nil {
+			return nil, err
+		}
+
+		var resp *GenerateResponse
+		var reservation *RateReservation
+		start := time.Now()
+		requestCtx, span := StartSpan(ctx, "ai.request",
+			attribute.String(AttrGenAISystem, c.provider.Name()),
+			attribute.String(AttrGenAIRequestModel, model))
+		err = c.retry.Do(requestCtx, c.provider.Name(), func() error {
+			var err error
+			if reservation, err = c.throttle(requestCtx, attempt); err != nil {
+				return err
+			}
+			resp, err = c.provider.Generate(requestCtx, attempt)
+			return err
+		})
+		if err == nil {
+			if resp.Model == "" {
+				resp.Model = model
+			}
+			resp.Usage = completeUsage(attempt, resp.Text, resp.Usage)
+			slog.Debug("Response generated", "model", resp.Model, "duration", time.Since(start),
+				"tokens", resp.Usage.TotalTokens(), "finish_reason", resp.FinishReason)
+
+			reservation.Complete(resp.Usage.TotalTokens())
+			budget.Settle(ctx, c.provider.Name(), resp.Model, resp.Usage)
+			span.SetAttributes(usageAttributes(resp.Model, resp.Usage, resp.FinishReason)...)
+			EndSpan(span, nil)
+			return resp, nil
+		}
+		EndSpan(span, err)
+		budget.Release()
+		if !IsFallbackError(err) {
+			return nil, err
+		}
 	}
 
-	return result.String(), nil
+	return nil, err
 }
 
-// ExtractCode extracts code blocks from text, optionally filtering by language
-func ExtractCode(text, language string) string {
-	var result strings.Builder
-	
-	// Try to extract code blocks using language-specific markers
-	codeBlockPattern := "```(?:.*?)([\\s\\S]*?)```"
-	if language != "" {
-		// Try a language-specific pattern first
-		langPattern := fmt.Sprintf("```(?:%s)?([\\s\\S]*?)```", language)
-		matches := extractWithRegex(text, langPattern)
-		
-		if len(matches) > 0 {
-			for _, match := range matches {
-				result.WriteString(match)
-				result.WriteString("\n\n")
+// GenerateStream generates content based on the given request, yielding text as it arrives.
+// Failures before the first chunk arrives are retried; once output has been
+// streamed, errors are passed through to the caller.
+func (c *AIClient) GenerateStream(ctx context.Context, req GenerateRequest) (<-chan StreamChunk, error) {
+	// The span ends when the stream does
+	ctx, span := StartSpan(ctx, "ai.generate_stream",
+		attribute.String(AttrGenAISystem, c.provider.Name()),
+		attribute.String(AttrGenAIRequestModel, req.Model))
+
+	// A cached response is replayed as a single chunk
+	cacheKey := c.cacheKey(req)
+	if cached, ok := c.cacheGet(cacheKey); ok {
+		c.usage.Record(ctx, c.provider.Name(), cached.Model, cached.Usage, true)
+		span.SetAttributes(attribute.Bool("ai_toolkit.cached", true))
+		span.SetAttributes(usageAttributes(cached.Model, cached.Usage, cached.FinishReason)...)
+		EndSpan(span, nil)
+		out := make(chan StreamChunk, 1)
+		out <- StreamChunk{Text: cached.Text, Model: cached.Model, Usage: &cached.Usage, FinishReason: cached.FinishReason}
+		close(out)
+		return out, nil
+	}
+
+	start := time.Now()
+	stream, err := c.openStream(ctx, req)
+	if err != nil {
+		EndSpan(span, err)
+		return nil, err
+	}
+	req.Model = stream.model
+	stream.first.Model = stream.model
+
+	out := make(chan StreamChunk, 1)
+	go func() {
+		var streamErr error
+		defer func() { EndSpan(span, streamErr) }()
+		defer close(out)
+		// A stream that ends before its usage is settled costs nothing against the budget
+		defer stream.budget.Release()
+		if !stream.ok {
+			return
+		}
+
+		// Pass chunks through, keeping a copy so the complete response can be cached
+		var text strings.Builder
+		var usage Usage
+		var finishReason string
+		for chunk, ok := stream.first, true; ; {
+			if chunk.Err != nil {
+				streamErr = chunk.Err
+				sendError(ctx, out, chunk.Err)
+				return
+			}
+			if chunk.Usage != nil {
+				usage = *chunk.Usage
 			}
-			return strings.TrimSpace(result.String())
-		}
-	}
-	
-	// Fall back to any code blocks
-	matches := extractWithRegex(text, codeBlockPattern)
-	if len(matches) > 0 {
-		for _, match := range matches {
-			result.WriteString(match)
-			result.WriteString("\n\n")
-		}
-		return strings.TrimSpace(result.String())
-	}
-	
-	// If no code blocks found, try to clean up the text by removing markdown-like content
-	cleanText := text
-	
-	// Remove headers
-	cleanText = regexp.MustCompile(`(?m)^#+ .*$`).ReplaceAllString(cleanText, "")
-	
-	// Remove bullet points
-	cleanText = regexp.MustCompile(`(?m)^[*-] `).ReplaceAllString(cleanText, "")
-	
-	return strings.TrimSpace(cleanText)
-}
-
-// extractWithRegex extracts text matching the given pattern
-func extractWithRegex(text, pattern string) []string {
-	re := regexp.MustCompile(pattern)
-	matches := re.FindAllStringSubmatch(text, -1)
-	
-	var results []string
-	for _, match := range matches {
-		if len(match) > 1 {
-			results = append(results, strings.TrimSpace(match[1]))
-		}
-	}
-	
-	return results
-}
\ No newline at end of file
+			if chunk.FinishReason != "" {
+				finishReason = chunk.FinishReason
+			}
+			text.WriteString(chunk.Text)
+			if !sendChunk(ctx, out, chunk) {
+				return
+			}
+
+			chunk, ok = <-stream.chunks
+			if !ok {
+				break
+			}
+		}
+
+		// The provider may close the stream without an error when the context ends
+		if err := ctx.Err(); err != nil {
+			streamErr = err
+			sendError(ctx, out, err)
+			return
+		}
+
+		usage = completeUsage(req, text.String(), usage)
+		stream.rate.Complete(usage.TotalTokens())
+		span.SetAttributes(usageAttributes(req.Model, usage, finishReason)...)
+		slog.Debug("Response generated", "model", req.Model, "duration", time.Since(start),
+			"tokens", usage.TotalTokens(), "finish_reason", finishReason)
+		stream.budget.Settle(ctx, c.provider.Name(), req.Model, usage)
+
+		// Continuations of a truncated stream are fetched in one piece each
+		// and streamed as a single chunk
+		if finishReason == FinishMaxTokens {
+			rest, reason, err := c.continueTruncated(ctx, req, text.String())
+			if err != nil {
+				streamErr = err
+				sendError(ctx, out, err)
+				return
+			}
+			finishReason = reason
+			text.WriteString(rest)
+			if !sendChunk(ctx, out, StreamChunk{Text: rest, FinishReason: finishReason}) {
+				return
+			}
+		}
+
+		// Only a stream the model finished is complete; one that closed
+		// without a finish reason was cut off and must not be served again
+		if text.Len() > 0 && finishReason != "" {
+			c.cachePut(cacheKey, &GenerateResponse{Text: text.String(), Model: req.Model, Usage: usage, FinishReason: finishReason})
+		}
+	}()
+
+	return out, nil
+}
+
+// openedStream is a response stream that was opened by openStream
+type openedStream struct {
+	chunks <-chan StreamChunk
+	// first is the first chunk; ok is false if the stream closed without one
+	first StreamChunk
+	ok    bool
+	// model is the model serving the stream
+	model string
+	// rate and budget hold the request's share of the rate limits and the
+	// cost budget until the usage of the whole response is known
+	rate   *RateReservation
+	budget *BudgetReservation
+}
+
+// openStream opens a stream for the request and waits for its first chunk,
+// retrying transient failures and falling back to the configured models in
+// order
+func (c *AIClient) openStream(ctx context.Context, req GenerateRequest) (*openedStream, error) {
+	var err error
+	chain := c.modelChain(req.Model)
+	for i, model := range chain {
+		if i > 0 {
+			slog.Warn("Model failed, falling back", "model", chain[i-1], "fallback", model, "error", err)
+		}
+
+		attempt := req
+		attempt.Model = model
+		stream := &openedStream{model: model}
+		if stream.budget, err = c.usage.CheckBudget(c.provider.Name(), model, req.Prompt); err != nil {
+			return nil, err
+		}
+
+		err = c.retry.Do(ctx, c.provider.Name(), func() error {
+			var err error
+			if stream.rate, err = c.throttle(ctx, attempt); err != nil {
+				return err
+			}
+			stream.chunks, err = c.provider.GenerateStream(ctx, attempt)
+			if err != nil {
+				return err
+			}
+
+			// Wait for the first chunk so errors raised when the stream opens can be retried
+			stream.first, stream.ok = <-stream.chunks
+			if stream.ok && stream.first.Err != nil {
+				return stream.first.Err
+			}
+			return nil
+		})
+		if err == nil {
+			slog.Debug("Response stream opened", "model", model)
+			return stream, nil
+		}
+		stream.budget.Release()
+		if !IsFallbackError(err) {
+			return nil, err
+		}
+	}
+
+	return nil, err
+}
+
+// throttle waits until the rate limiter admits the request
+func (c *AIClient) throttle(ctx context.Context, req GenerateRequest) (*RateReservation, error) {
+	reservation, waited, err := c.limiter.Wait(ctx, EstimateTokens(req.Prompt))
+	if err != nil {
+		return nil, fmt.Errorf("error waiting for rate limit: %w", err)
+	}
+	if waited >= 10*time.Millisecond {
+		slog.Debug("Waited for rate limit", "model", req.Model, "duration", waited)
+	}
+	return reservation, nil
+}
+
+// GenerateJSON requests a JSON response matching the schema of out and
+// unmarshals it into out, re-prompting the model when the response is invalid
+func (c *AIClient) GenerateJSON(ctx context.Context, req GenerateRequest, out interface{}) error {
+	return GenerateJSON(ctx, c, req, out)
+}
+
+// CountTokens returns the number of tokens text occupies for the given model,
+// falling back to a local estimate when the provider cannot count them
+func (c *AIClient) CountTokens(ctx context.Context, model, text string) (int, error) {
+	ctx, span := StartSpan(ctx, "ai.count_tokens",
+		attribute.String(AttrGenAISystem, c.provider.Name()),
+		attribute.String(AttrGenAIRequestModel, model))
+	defer span.End()
+
+	count, err := c.provider.CountTokens(ctx, model, text)
+	if err != nil {
+		slog.Debug("Token counting failed, using local estimate", "model", model, "error", err)
+		return EstimateTokens(text), nil
+	}
+	return count, nil
+}
+
+// completeUsage fills in local estimates when the provider did not report usage
+func completeUsage(req GenerateRequest, text string, usage Usage) Usage {
+	if usage.TotalTokens() > 0 {
+		return usage
+	}
+	return Usage{
+		PromptTokens:    EstimateTokens(req.Prompt),
+		CandidateTokens: EstimateTokens(text),
+		Estimated:       true,
+	}
+}
+
+// cacheKey returns the cache key for a request, or "" when caching is disabled
+func (c *AIClient) cacheKey(req GenerateRequest) string {
+	if c.cache == nil {
+		return ""
+	}
+	return CacheKey(c.provider.Name(), req)
+}
+
+// cacheGet looks up a response in the cache
+func (c *AIClient) cacheGet(key string) (*GenerateResponse, bool) {
+	if key == "" {
+		return nil, false
+	}
+
+	resp, ok := c.cache.Get(key)
+	if ok {
+		slog.Debug("Using cached response", "key", key[:12], "model", resp.Model)
+	}
+	return resp, ok
+}
+
+// cachePut stores a response in the cache. Failures are logged but never fail the call.
+func (c *AIClient) cachePut(key string, resp *GenerateResponse) {
+	if key == "" {
+		return
+	}
+
+	if err := c.cache.Put(key, c.provider.Name(), resp); err != nil {
+		slog.Warn("Error writing response cache", "error", err)
+	}
+}
@@ -0,0 +1,279 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Cache defaults
+const (
+	DefaultCacheTTLHours  = 7 * 24
+	DefaultCacheMaxSizeMB = 100
+)
+
+// CacheConfig holds the settings of the on-disk response cache
+type CacheConfig struct {
+	Enabled bool
+	Dir     string
+	TTL     time.Duration
+	MaxSize int64
+}
+
+// ResponseCache is an on-disk, content-addressed cache of model responses.
+// Entries are keyed by a hash of the provider and the full generation request
+// (prompt, model and generation parameters).
+type ResponseCache struct {
+	dir     string
+	ttl     time.Duration
+	maxSize int64
+}
+
+// cacheEntry is the on-disk representation of a cached response
+type cacheEntry struct {
+	Key       string           `json:"key"`
+	Provider  string           `json:"provider"`
+	CreatedAt time.Time        `json:"created_at"`
+	Response  GenerateResponse `json:"response"`
+}
+
+// CacheStats summarizes the contents of a cache directory
+type CacheStats struct {
+	Dir     string
+	Entries int
+	Expired int
+	Size    int64
+	Oldest  time.Time
+	Newest  time.Time
+}
+
+// DefaultCacheDir returns the cache directory under the user's cache dir
+func DefaultCacheDir() (string, error) {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("error locating user cache directory: %v", err)
+	}
+	return filepath.Join(base, "ai-toolkit", "responses"), nil
+}
+
+// NewResponseCache creates a cache rooted at dir. A zero ttl or maxSize disables that limit.
+func NewResponseCache(dir string, ttl time.Duration, maxSize int64) (*ResponseCache, error) {
+	if dir == "" {
+		var err error
+		dir, err = DefaultCacheDir()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &ResponseCache{
+		dir:     dir,
+		ttl:     ttl,
+		maxSize: maxSize,
+	}, nil
+}
+
+// Dir returns the directory the cache is stored in
+func (c *ResponseCache) Dir() string {
+	return c.dir
+}
+
+// CacheKey returns the content address of a request sent to the named provider
+func CacheKey(provider string, req GenerateRequest) string {
+	data, _ := json.Marshal(struct {
+		Provider string          `json:"provider"`
+		Request  GenerateRequest `json:"request"`
+	}{provider, req})
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Get returns the cached response for key, if present and not expired.
+// Entries that cannot be decoded are removed, so the response is fetched and
+// cached again.
+func (c *ResponseCache) Get(key string) (*GenerateResponse, bool) {
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+
+	var entry cacheEntry
+	err = json.Unmarshal(data, &entry)
+	if err == nil && entry.Key != key {
+		err = fmt.Errorf("entry is stored under key %s", entry.Key)
+	}
+	if err != nil {
+		slog.Warn("Removing corrupt cache entry", "file", c.path(key), "error", err)
+		os.Remove(c.path(key))
+		return nil, false
+	}
+
+	if c.expired(entry.CreatedAt) {
+		os.Remove(c.path(key))
+		return nil, false
+	}
+
+	return &entry.Response, true
+}
+
+// Put stores a response under key and prunes the cache to its size limit
+func (c *ResponseCache) Put(key, provider string, resp *GenerateResponse) error {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return fmt.Errorf("error creating cache directory: %v", err)
+	}
+
+	data, err := json.Marshal(cacheEntry{
+		Key:       key,
+		Provider:  provider,
+		CreatedAt: now(),
+		Response:  *resp,
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding cache entry: %v", err)
+	}
+
+	// Write to a temporary file first so readers never see a partial entry
+	tmp, err := os.CreateTemp(c.dir, key+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error writing cache entry: %v", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error writing cache entry: %v", err)
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), c.path(key)); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error writing cache entry: %v", err)
+	}
+
+	return c.prune()
+}
+
+// Clear removes every cache entry and returns how many were removed
+func (c *ResponseCache) Clear() (int, error) {
+	files, err := c.entries()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, file := range files {
+		if err := os.Remove(file.path); err != nil && !os.IsNotExist(err) {
+			return removed, fmt.Errorf("error removing cache entry: %v", err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Stats returns a summary of the cache contents
+func (c *ResponseCache) Stats() (CacheStats, error) {
+	stats := CacheStats{Dir: c.dir}
+
+	files, err := c.entries()
+	if err != nil {
+		return stats, err
+	}
+
+	for _, file := range files {
+		stats.Entries++
+		stats.Size += file.size
+		if c.expired(file.modTime) {
+			stats.Expired++
+		}
+		if stats.Oldest.IsZero() || file.modTime.Before(stats.Oldest) {
+			stats.Oldest = file.modTime
+		}
+		if file.modTime.After(stats.Newest) {
+			stats.Newest = file.modTime
+		}
+	}
+	return stats, nil
+}
+
+// cacheFile describes a single entry file on disk
+type cacheFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// entries lists the entry files in the cache directory, oldest first
+func (c *ResponseCache) entries() ([]cacheFile, error) {
+	dirEntries, err := os.ReadDir(c.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading cache directory: %v", err)
+	}
+
+	var files []cacheFile
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || !strings.HasSuffix(dirEntry.Name(), ".json") {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, cacheFile{
+			path:    filepath.Join(c.dir, dirEntry.Name()),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	return files, nil
+}
+
+// prune removes expired entries, then the oldest entries until the cache fits its size limit
+func (c *ResponseCache) prune() error {
+	files, err := c.entries()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	var live []cacheFile
+	for _, file := range files {
+		if c.expired(file.modTime) {
+			os.Remove(file.path)
+			continue
+		}
+		total += file.size
+		live = append(live, file)
+	}
+
+	for _, file := range live {
+		if c.maxSize <= 0 || total <= c.maxSize {
+			break
+		}
+		os.Remove(file.path)
+		total -= file.size
+	}
+	return nil
+}
+
+// expired reports whether an entry created at the given time is past the TTL
+func (c *ResponseCache) expired(created time.Time) bool {
+	return c.ttl > 0 && now().Sub(created) > c.ttl
+}
+
+// path returns the file an entry is stored in
+func (c *ResponseCache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
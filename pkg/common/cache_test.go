@@ -0,0 +1,194 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheKey(t *testing.T) {
+	req := GenerateRequest{Prompt: "Document this", Model: "gemini-2.0-flash", Temperature: 0.2}
+	key := CacheKey(ProviderGemini, req)
+
+	// Keys must not change between releases, or every cached response is lost
+	if want := "dc35b40249344061de0be89f5e2b64e097fdee7dcf61508a5328572b20c1b743"; key != want {
+		t.Errorf("CacheKey = %s, want %s", key, want)
+	}
+	if again := CacheKey(ProviderGemini, req); again != key {
+		t.Errorf("CacheKey is not stable: %s then %s", key, again)
+	}
+
+	changed := map[string]string{
+		"provider":    CacheKey(ProviderOpenAI, req),
+		"model":       CacheKey(ProviderGemini, GenerateRequest{Prompt: req.Prompt, Model: "gemini-1.5-pro", Temperature: req.Temperature}),
+		"temperature": CacheKey(ProviderGemini, GenerateRequest{Prompt: req.Prompt, Model: req.Model, Temperature: 0.7}),
+		"prompt":      CacheKey(ProviderGemini, GenerateRequest{Prompt: "Document that", Model: req.Model, Temperature: req.Temperature}),
+		"format":      CacheKey(ProviderGemini, GenerateRequest{Prompt: req.Prompt, Model: req.Model, Temperature: req.Temperature, ResponseMIMEType: JSONMIMEType}),
+	}
+	for field, other := range changed {
+		if other == key {
+			t.Errorf("changing the %s does not change the key", field)
+		}
+	}
+}
+
+func TestResponseCacheGetPut(t *testing.T) {
+	fakeClock(t)
+	cache, err := NewResponseCache(t.TempDir(), time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := CacheKey(ProviderGemini, GenerateRequest{Prompt: "p", Model: "m"})
+	if _, ok := cache.Get(key); ok {
+		t.Fatal("hit in an empty cache")
+	}
+	want := &GenerateResponse{Text: "answer", Model: "m", FinishReason: FinishStop, Usage: Usage{PromptTokens: 3, CandidateTokens: 5}}
+	if err := cache.Put(key, ProviderGemini, want); err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := cache.Get(key)
+	if !ok {
+		t.Fatal("miss after Put")
+	}
+	if got.Text != want.Text || got.Model != want.Model || got.FinishReason != want.FinishReason || got.Usage != want.Usage {
+		t.Errorf("Get = %+v, want %+v", got, want)
+	}
+}
+
+func TestResponseCacheTTL(t *testing.T) {
+	clock := fakeClock(t)
+	cache, err := NewResponseCache(t.TempDir(), time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Put("key", ProviderGemini, &GenerateResponse{Text: "answer"}); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(59 * time.Minute)
+	if _, ok := cache.Get("key"); !ok {
+		t.Fatal("entry expired before its TTL")
+	}
+
+	clock.Advance(2 * time.Minute)
+	if _, ok := cache.Get("key"); ok {
+		t.Fatal("hit on an expired entry")
+	}
+	if _, err := os.Stat(cache.path("key")); !os.IsNotExist(err) {
+		t.Errorf("expired entry was not removed: %v", err)
+	}
+}
+
+func TestResponseCachePrune(t *testing.T) {
+	clock := fakeClock(t)
+	cache, err := NewResponseCache(t.TempDir(), time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries written a minute apart, the first of them past the TTL
+	put := func(key string, age time.Duration) {
+		t.Helper()
+		if err := cache.Put(key, ProviderGemini, &GenerateResponse{Text: "answer"}); err != nil {
+			t.Fatal(err)
+		}
+		modTime := clock.current.Add(-age)
+		if err := os.Chtimes(cache.path(key), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put("expired", 2*time.Hour)
+	put("oldest", 3*time.Minute)
+	put("older", 2*time.Minute)
+	put("newest", time.Minute)
+
+	info, err := os.Stat(cache.path("newest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Room for two entries: pruning drops the expired one, then the oldest
+	cache.maxSize = 2 * info.Size()
+	if err := cache.prune(); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]bool{"expired": false, "oldest": false, "older": true, "newest": true} {
+		_, err := os.Stat(cache.path(key))
+		if got := err == nil; got != want {
+			t.Errorf("entry %s kept = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestResponseCacheCorruptEntry(t *testing.T) {
+	logs := captureLogs(t)
+	cache, err := NewResponseCache(t.TempDir(), time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string]string{
+		"truncated":     `{"key": "truncated", "response": {"Text": "ans`,
+		"mismatched":    `{"key": "other", "response": {"Text": "answer"}}`,
+		"not an object": `[]`,
+	} {
+		if err := os.WriteFile(cache.path(name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := cache.Get(name); ok {
+			t.Errorf("hit on the %s entry", name)
+		}
+		if _, err := os.Stat(cache.path(name)); !os.IsNotExist(err) {
+			t.Errorf("%s entry was not removed: %v", name, err)
+		}
+		if !strings.Contains(logs.String(), "Removing corrupt cache entry") {
+			t.Errorf("removing the %s entry was not logged", name)
+		}
+		logs.Reset()
+	}
+}
+
+func TestResponseCacheClearAndStats(t *testing.T) {
+	clock := fakeClock(t)
+	dir := t.TempDir()
+	cache, err := NewResponseCache(dir, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if err := cache.Put(key, ProviderGemini, &GenerateResponse{Text: "answer"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := clock.current.Add(-2 * time.Hour)
+	if err := os.Chtimes(cache.path("a"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	// Files that are not entries are left alone
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("keep"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := cache.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Entries != 2 || stats.Expired != 1 || stats.Size == 0 || !stats.Oldest.Equal(old) {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	removed, err := cache.Clear()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 {
+		t.Errorf("removed %d entries, want 2", removed)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+		t.Errorf("Clear removed a file that is not an entry: %v", err)
+	}
+}
@@ -0,0 +1,321 @@
+package common
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Cassette modes for the --cassette-mode flag
+const (
+	CassetteRecord = "record"
+	CassetteReplay = "replay"
+)
+
+// CassetteConfig selects a cassette file and whether to record to or replay from it
+type CassetteConfig struct {
+	Path string
+	Mode string
+}
+
+// Cassette is a file of recorded prompt/response pairs, used to run the tools
+// deterministically and offline
+type Cassette struct {
+	path string
+
+	mu           sync.Mutex
+	interactions []CassetteInteraction
+	served       map[string]int
+}
+
+// CassetteInteraction is a single recorded generation call
+type CassetteInteraction struct {
+	Key      string           `json:"key"`
+	Provider string           `json:"provider"`
+	Request  GenerateRequest  `json:"request"`
+	Response GenerateResponse `json:"response"`
+}
+
+// cassetteFile is the on-disk representation of a cassette
+type cassetteFile struct {
+	Interactions []CassetteInteraction `json:"interactions"`
+}
+
+// CassetteMissError is returned in replay mode when no recording matches a request
+type CassetteMissError struct {
+	Path   string
+	Key    string
+	Prompt string
+}
+
+func (e *CassetteMissError) Error() string {
+	prompt := e.Prompt
+	if len(prompt) > 80 {
+		prompt = prompt[:80] + "..."
+	}
+	return fmt.Sprintf("no recorded response in %s for request %s (prompt: %q)", e.Path, e.Key, prompt)
+}
+
+// CassetteKey returns the hash a request is matched by. It covers the prompt,
+// model and generation parameters but not the provider, so a cassette can be
+// replayed regardless of the configured provider.
+func CassetteKey(req GenerateRequest) string {
+	data, _ := json.Marshal(req)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadCassette reads a cassette file. A missing file yields an empty cassette
+// that is created on the first Save.
+func LoadCassette(path string) (*Cassette, error) {
+	cassette := &Cassette{
+		path:   path,
+		served: map[string]int{},
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cassette, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading cassette: %v", err)
+	}
+
+	var file cassetteFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error parsing cassette %s: %v", path, err)
+	}
+	cassette.interactions = file.Interactions
+
+	return cassette, nil
+}
+
+// Path returns the file the cassette is stored in
+func (c *Cassette) Path() string {
+	return c.path
+}
+
+// Len returns the number of recorded interactions
+func (c *Cassette) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.interactions)
+}
+
+// Record adds an interaction to the cassette
+func (c *Cassette) Record(provider string, req GenerateRequest, resp *GenerateResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.interactions = append(c.interactions, CassetteInteraction{
+		Key:      CassetteKey(req),
+		Provider: provider,
+		Request:  req,
+		Response: *resp,
+	})
+}
+
+// Lookup returns the recorded response for req. When the same request was
+// recorded several times, the recordings are served in order and the last one
+// is repeated.
+func (c *Cassette) Lookup(req GenerateRequest) (*GenerateResponse, error) {
+	key := CassetteKey(req)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var matches []*CassetteInteraction
+	for i := range c.interactions {
+		if c.interactions[i].Key == key {
+			matches = append(matches, &c.interactions[i])
+		}
+	}
+	if len(matches) == 0 {
+		return nil, &CassetteMissError{Path: c.path, Key: key, Prompt: req.Prompt}
+	}
+
+	index := c.served[key]
+	if index >= len(matches) {
+		index = len(matches) - 1
+	}
+	c.served[key]++
+
+	resp := matches[index].Response
+	return &resp, nil
+}
+
+// Save writes the cassette to its file
+func (c *Cassette) Save() error {
+	c.mu.Lock()
+	data, err := json.MarshalIndent(cassetteFile{Interactions: c.interactions}, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("error encoding cassette: %v", err)
+	}
+
+	if dir := filepath.Dir(c.path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("error creating cassette directory: %v", err)
+		}
+	}
+
+	// Write to a temporary file first so an interrupted run never truncates the cassette
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing cassette: %v", err)
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error writing cassette: %v", err)
+	}
+	return nil
+}
+
+// RecordingProvider wraps a provider and records every successful call to a cassette
+type RecordingProvider struct {
+	provider Provider
+	cassette *Cassette
+}
+
+// NewRecordingProvider creates a RecordingProvider writing to cassette
+func NewRecordingProvider(provider Provider, cassette *Cassette) *RecordingProvider {
+	return &RecordingProvider{
+		provider: provider,
+		cassette: cassette,
+	}
+}
+
+// Name returns the name of the wrapped provider
+func (p *RecordingProvider) Name() string {
+	return p.provider.Name()
+}
+
+// Generate calls the wrapped provider and records the response
+func (p *RecordingProvider) Generate(ctx context.Context, req GenerateRequest) (*GenerateResponse, error) {
+	resp, err := p.provider.Generate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	p.cassette.Record(p.provider.Name(), req, resp)
+	return resp, nil
+}
+
+// GenerateStream calls the wrapped provider and records the response once the stream finishes cleanly
+func (p *RecordingProvider) GenerateStream(ctx context.Context, req GenerateRequest) (<-chan StreamChunk, error) {
+	chunks, err := p.provider.GenerateStream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan StreamChunk, 1)
+	go func() {
+		defer close(out)
+
+		var text strings.Builder
+		var usage Usage
+		var finishReason string
+		for chunk := range chunks {
+			if chunk.Err != nil {
+				sendError(ctx, out, chunk.Err)
+				return
+			}
+			if chunk.Usage != nil {
+				usage = *chunk.Usage
+			}
+			if chunk.FinishReason != "" {
+				finishReason = chunk.FinishReason
+			}
+			text.WriteString(chunk.Text)
+			if !sendChunk(ctx, out, chunk) {
+				return
+			}
+		}
+
+		// A stream cut short by the context or closed without a finish reason
+		// would replay as a complete response
+		if ctx.Err() != nil || finishReason == "" {
+			slog.Debug("Incomplete stream not recorded", "model", req.Model, "finish_reason", finishReason)
+			return
+		}
+		p.cassette.Record(p.provider.Name(), req, &GenerateResponse{Text: text.String(), Model: req.Model, Usage: usage, FinishReason: finishReason})
+	}()
+
+	return out, nil
+}
+
+// CountTokens returns a local estimate, matching ReplayProvider so that
+// inputs are split the same way when the cassette is replayed
+func (p *RecordingProvider) CountTokens(ctx context.Context, model, text string) (int, error) {
+	return EstimateTokens(text), nil
+}
+
+// Close saves the cassette and closes the wrapped provider
+func (p *RecordingProvider) Close() error {
+	saveErr := p.cassette.Save()
+	if saveErr != nil {
+		slog.Warn("Recorded responses were not saved", "file", p.cassette.Path(), "error", saveErr)
+	}
+	if err := p.provider.Close(); err != nil {
+		return err
+	}
+	return saveErr
+}
+
+// ReplayProvider serves responses from a cassette without calling any model
+type ReplayProvider struct {
+	name     string
+	cassette *Cassette
+}
+
+// NewReplayProvider creates a ReplayProvider that reports itself as the named provider
+func NewReplayProvider(name string, cassette *Cassette) *ReplayProvider {
+	if name == "" {
+		name = DefaultProvider
+	}
+
+	return &ReplayProvider{
+		name:     name,
+		cassette: cassette,
+	}
+}
+
+// Name returns the provider name the cassette is replayed as
+func (p *ReplayProvider) Name() string {
+	return p.name
+}
+
+// Generate returns the recorded response for the request
+func (p *ReplayProvider) Generate(ctx context.Context, req GenerateRequest) (*GenerateResponse, error) {
+	return p.cassette.Lookup(req)
+}
+
+// GenerateStream returns the recorded response for the request as a single chunk
+func (p *ReplayProvider) GenerateStream(ctx context.Context, req GenerateRequest) (<-chan StreamChunk, error) {
+	resp, err := p.cassette.Lookup(req)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := make(chan StreamChunk, 1)
+	chunks <- StreamChunk{Text: resp.Text, Usage: &resp.Usage, FinishReason: resp.FinishReason}
+	close(chunks)
+	return chunks, nil
+}
+
+// CountTokens returns a local estimate so replays never depend on a tokenizer endpoint
+func (p *ReplayProvider) CountTokens(ctx context.Context, model, text string) (int, error) {
+	return EstimateTokens(text), nil
+}
+
+// Close is a no-op for the replay provider
+func (p *ReplayProvider) Close() error {
+	return nil
+}
@@ -0,0 +1,171 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+)
+
+// BoundaryFunc reports whether a chunk may start at line, given the line before it
+type BoundaryFunc func(prev, line string) bool
+
+// SymbolBoundary allows splits before top-level declarations: an unindented
+// line following a blank line, which matches how top-level functions, types
+// and classes are laid out in most languages
+func SymbolBoundary(prev, line string) bool {
+	if strings.TrimSpace(prev) != "" || line == "" {
+		return false
+	}
+	if line[0] == ' ' || line[0] == '\t' {
+		return false
+	}
+
+	// Closing delimiters belong to the previous declaration
+	switch line[0] {
+	case '}', ')', ']':
+		return false
+	}
+	return true
+}
+
+// SectionBoundary allows splits before Markdown headings and HTML heading tags
+func SectionBoundary(prev, line string) bool {
+	trimmed := strings.TrimSpace(line)
+	if strings.HasPrefix(trimmed, "#") {
+		return true
+	}
+
+	lower := strings.ToLower(trimmed)
+	for level := 1; level <= 6; level++ {
+		if strings.HasPrefix(lower, fmt.Sprintf("<h%d", level)) {
+			return true
+		}
+	}
+	return false
+}
+
+// blankLineBoundary allows splits after any blank line
+func blankLineBoundary(prev, line string) bool {
+	return strings.TrimSpace(prev) == ""
+}
+
+// SplitText splits text into chunks of at most maxTokens (as estimated by
+// EstimateTokens). Chunks preferably start where boundary allows; if a section
+// between boundaries is too large, it is split at blank lines and, as a last
+// resort, at arbitrary lines. A single line larger than maxTokens is split
+// within the line, preferably at whitespace.
+func SplitText(text string, maxTokens int, boundary BoundaryFunc) []string {
+	if maxTokens <= 0 || EstimateTokens(text) <= maxTokens {
+		return []string{text}
+	}
+
+	var chunks []string
+	for _, section := range splitAt(text, boundary) {
+		if EstimateTokens(section) <= maxTokens {
+			chunks = appendPacked(chunks, section, maxTokens)
+			continue
+		}
+
+		// The section alone is too large, so fall back to finer boundaries
+		for _, paragraph := range splitAt(section, blankLineBoundary) {
+			if EstimateTokens(paragraph) <= maxTokens {
+				chunks = appendPacked(chunks, paragraph, maxTokens)
+				continue
+			}
+			for _, line := range splitAt(paragraph, func(prev, line string) bool { return true }) {
+				if EstimateTokens(line) <= maxTokens {
+					chunks = appendPacked(chunks, line, maxTokens)
+					continue
+				}
+				slog.Warn("Splitting a line larger than the token budget", "tokens", EstimateTokens(line), "max_tokens", maxTokens)
+				for _, piece := range splitLine(line, maxTokens) {
+					chunks = appendPacked(chunks, piece, maxTokens)
+				}
+			}
+		}
+	}
+
+	return chunks
+}
+
+// splitAt splits text into consecutive pieces, starting a new piece at every
+// line where boundary allows. Joining the pieces yields the original text.
+func splitAt(text string, boundary BoundaryFunc) []string {
+	lines := strings.SplitAfter(text, "\n")
+
+	var pieces []string
+	var current strings.Builder
+	prev := ""
+	for i, line := range lines {
+		content := strings.TrimRight(line, "\r\n")
+		if i > 0 && current.Len() > 0 && boundary(prev, content) {
+			pieces = append(pieces, current.String())
+			current.Reset()
+		}
+		current.WriteString(line)
+		prev = content
+	}
+	if current.Len() > 0 {
+		pieces = append(pieces, current.String())
+	}
+
+	return pieces
+}
+
+// splitLine splits a line into pieces of at most maxTokens, breaking after
+// the last space of a piece when there is one
+func splitLine(line string, maxTokens int) []string {
+	// EstimateTokens counts three runes per token
+	maxRunes := maxTokens * 3
+
+	var pieces []string
+	runes := []rune(line)
+	for len(runes) > maxRunes {
+		cut := maxRunes
+		if space := strings.LastIndexAny(string(runes[:maxRunes]), " \t"); space > 0 {
+			cut = utf8.RuneCountInString(string(runes[:maxRunes])[:space]) + 1
+		}
+		pieces = append(pieces, string(runes[:cut]))
+		runes = runes[cut:]
+	}
+	if len(runes) > 0 {
+		pieces = append(pieces, string(runes))
+	}
+	return pieces
+}
+
+// appendPacked adds piece to the last chunk if it still fits, otherwise starts a new chunk
+func appendPacked(chunks []string, piece string, maxTokens int) []string {
+	if len(chunks) > 0 {
+		last := chunks[len(chunks)-1]
+		if EstimateTokens(last)+EstimateTokens(piece) <= maxTokens {
+			chunks[len(chunks)-1] = last + piece
+			return chunks
+		}
+	}
+	return append(chunks, piece)
+}
+
+// ChunkNote returns an instruction telling the model that the input is one part of a larger whole
+func ChunkNote(index, total int, unit string) string {
+	return fmt.Sprintf("NOTE: The input below is part %d of %d of a larger %s that was split to fit the model's context window. "+
+		"Process only this part, do not repeat content from other parts, and do not add introductions or conclusions for the whole %s.\n\n",
+		index+1, total, unit, unit)
+}
+
+// FitsInBudget reports whether text fits within maxTokens for the model. The
+// provider's tokenizer is only consulted when the local estimate says the text
+// may be too large.
+func FitsInBudget(ctx context.Context, provider Provider, model, text string, maxTokens int) bool {
+	if EstimateTokens(text) <= maxTokens {
+		return true
+	}
+
+	count, err := provider.CountTokens(ctx, model, text)
+	if err != nil {
+		return false
+	}
+	return count <= maxTokens
+}
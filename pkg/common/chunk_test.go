@@ -0,0 +1,160 @@
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSymbolBoundary(t *testing.T) {
+	tests := []struct {
+		prev, line string
+		want       bool
+	}{
+		{"", "func main() {", true},
+		{"", "class Parser:", true},
+		{"", "// Parse reads the input", true},
+		{"}", "func main() {", false},
+		{"", "    return nil", false},
+		{"", "\treturn nil", false},
+		{"", "}", false},
+		{"", ")", false},
+		{"", "", false},
+		{"  ", "type Config struct {", true},
+	}
+
+	for _, tt := range tests {
+		if got := SymbolBoundary(tt.prev, tt.line); got != tt.want {
+			t.Errorf("SymbolBoundary(%q, %q) = %v, want %v", tt.prev, tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestSectionBoundary(t *testing.T) {
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{"# Charges", true},
+		{"  ### Parameters", true},
+		{"<h2 id=\"create\">Create a charge</h2>", true},
+		{"<H3>Returns</H3>", true},
+		{"<hr>", false},
+		{"Some text", false},
+	}
+
+	for _, tt := range tests {
+		if got := SectionBoundary("", tt.line); got != tt.want {
+			t.Errorf("SectionBoundary(%q) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
+
+// function returns a Go function of roughly the given number of body lines
+func function(name string, lines int) string {
+	var b strings.Builder
+	b.WriteString("func " + name + "() {\n")
+	for i := 0; i < lines; i++ {
+		b.WriteString("\tfmt.Println(\"" + name + "\")\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func TestSplitText(t *testing.T) {
+	code := "package main\n\n" + function("first", 5) + "\n" + function("second", 5) + "\n" + function("third", 20)
+
+	t.Run("fits", func(t *testing.T) {
+		if chunks := SplitText(code, EstimateTokens(code), SymbolBoundary); len(chunks) != 1 || chunks[0] != code {
+			t.Errorf("got %d chunks, want the text unchanged", len(chunks))
+		}
+	})
+
+	t.Run("no limit", func(t *testing.T) {
+		if chunks := SplitText(code, 0, SymbolBoundary); len(chunks) != 1 {
+			t.Errorf("got %d chunks, want 1", len(chunks))
+		}
+	})
+
+	t.Run("symbol boundaries", func(t *testing.T) {
+		// Room for the first two functions but not the third as well
+		budget := EstimateTokens(function("third", 20)) + 5
+		chunks := SplitText(code, budget, SymbolBoundary)
+
+		if strings.Join(chunks, "") != code {
+			t.Fatal("chunks do not add up to the text")
+		}
+		if len(chunks) != 2 {
+			t.Fatalf("got %d chunks, want 2: %q", len(chunks), chunks)
+		}
+		if !strings.HasPrefix(chunks[1], "func third") {
+			t.Errorf("second chunk does not start at a declaration: %q", chunks[1])
+		}
+		for i, chunk := range chunks {
+			if EstimateTokens(chunk) > budget {
+				t.Errorf("chunk %d has %d tokens, over the budget of %d", i, EstimateTokens(chunk), budget)
+			}
+		}
+	})
+
+	t.Run("large declaration", func(t *testing.T) {
+		// A declaration over the budget is split at blank lines, then at lines
+		body := function("large", 10)
+		text := "package main\n\n" + strings.Replace(body, "{\n", "{\n\tsetup()\n\n", 1)
+		budget := EstimateTokens(function("large", 4))
+		chunks := SplitText(text, budget, SymbolBoundary)
+
+		if strings.Join(chunks, "") != text {
+			t.Fatal("chunks do not add up to the text")
+		}
+		for i, chunk := range chunks {
+			if EstimateTokens(chunk) > budget {
+				t.Errorf("chunk %d has %d tokens, over the budget of %d", i, EstimateTokens(chunk), budget)
+			}
+			if !strings.HasSuffix(chunk, "\n") {
+				t.Errorf("chunk %d ends within a line: %q", i, chunk)
+			}
+		}
+	})
+
+	t.Run("line over the budget", func(t *testing.T) {
+		logs := captureLogs(t)
+		long := "var names = []string{" + strings.Repeat(`"name", `, 40) + "}\n"
+		text := "package main\n\n" + long
+		chunks := SplitText(text, 30, SymbolBoundary)
+
+		if strings.Join(chunks, "") != text {
+			t.Fatal("chunks do not add up to the text")
+		}
+		if len(chunks) < 3 {
+			t.Errorf("got %d chunks, want the line split", len(chunks))
+		}
+		for i, chunk := range chunks {
+			if EstimateTokens(chunk) > 30 {
+				t.Errorf("chunk %d has %d tokens, over the budget of 30", i, EstimateTokens(chunk))
+			}
+		}
+		if !strings.Contains(logs.String(), "level=WARN") || !strings.Contains(logs.String(), "max_tokens=30") {
+			t.Errorf("splitting the line was not logged: %q", logs.String())
+		}
+	})
+}
+
+func TestSplitLine(t *testing.T) {
+	tests := []struct {
+		line      string
+		maxTokens int
+		want      []string
+	}{
+		{"short", 5, []string{"short"}},
+		{"alpha beta gamma", 3, []string{"alpha ", "beta ", "gamma"}},
+		{"abcdefghij", 2, []string{"abcdef", "ghij"}},
+		{"äöüäöüäöü", 1, []string{"äöü", "äöü", "äöü"}},
+	}
+
+	for _, tt := range tests {
+		got := splitLine(tt.line, tt.maxTokens)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("splitLine(%q, %d) = %q, want %q", tt.line, tt.maxTokens, got, tt.want)
+		}
+	}
+}
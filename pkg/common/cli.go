@@ -2,34 +2,70 @@ package common
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/urfave/cli/v2"
 )
 
 // ToolConfig represents common configuration for AI tools
 type ToolConfig struct {
-	APIKey      string
-	Model       string
-	Temperature float32
-	Timeout     int
-	Verbose     bool
-	OutputFile  string
+	Provider       string
+	BaseURL        string
+	APIKey         string
+	Model          string
+	Models         []string
+	Temperature    float32
+	Timeout        int
+	Verbose        bool
+	LogFormat      string
+	Trace          TraceConfig
+	Stream         bool
+	Structured     bool
+	CodeBlocks     CodeSelection
+	ChunkTokens    int
+	AllowTruncated bool
+	OutputFile     string
+	Retry          RetryPolicy
+	Cache          CacheConfig
+	Cassette       CassetteConfig
+	RPM            int
+	TPM            int
+	MaxCost        float64
+	PriceFile      string
+	Profile        string
 }
 
 // CommonFlags returns common CLI flags used across tools
 func CommonFlags() []cli.Flag {
 	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "profile",
+			Usage:   "Named profile from the config file (.ai-toolkit.yaml or the user config) to apply",
+			EnvVars: []string{"AI_TOOLKIT_PROFILE"},
+		},
+		&cli.StringFlag{
+			Name:    "provider",
+			Usage:   "LLM provider to use (gemini, openai, ollama)",
+			Value:   GetEnvOrDefault("DEFAULT_PROVIDER", DefaultProvider),
+			EnvVars: []string{"DEFAULT_PROVIDER"},
+		},
+		&cli.StringFlag{
+			Name:    "base-url",
+			Usage:   "Base URL of the provider API (for OpenAI-compatible servers or a remote Ollama host)",
+			EnvVars: []string{"AI_BASE_URL"},
+		},
 		&cli.StringFlag{
 			Name:    "api-key",
 			Aliases: []string{"k"},
-			Usage:   "Gemini API key (can also be set with GEMINI_API_KEY environment variable)",
-			EnvVars: []string{"GEMINI_API_KEY"},
+			Usage:   "API key for the provider (defaults to GEMINI_API_KEY for gemini and OPENAI_API_KEY for openai)",
 		},
 		&cli.StringFlag{
 			Name:    "model",
-			Usage:   "Gemini model to use",
+			Usage:   "Model to use, or a comma-separated list of models to fall back through in order (defaults to a provider-specific model)",
 			Value:   GetEnvOrDefault("DEFAULT_MODEL", DefaultAIModel),
 			EnvVars: []string{"DEFAULT_MODEL"},
 		},
@@ -45,12 +81,145 @@ func CommonFlags() []cli.Flag {
 			Value:   GetEnvOrDefaultInt("DEFAULT_TIMEOUT", 120),
 			EnvVars: []string{"DEFAULT_TIMEOUT"},
 		},
+		&cli.IntFlag{
+			Name:    "max-retries",
+			Usage:   "Maximum number of retries for rate-limited or transient API errors (0 disables retrying)",
+			Value:   GetEnvOrDefaultInt("DEFAULT_MAX_RETRIES", DefaultRetryPolicy().MaxRetries),
+			EnvVars: []string{"DEFAULT_MAX_RETRIES"},
+		},
+		&cli.Float64Flag{
+			Name:    "retry-delay",
+			Usage:   "Initial delay in seconds before retrying, doubled after each attempt",
+			Value:   GetEnvOrDefaultFloat("DEFAULT_RETRY_DELAY", DefaultRetryPolicy().InitialBackoff.Seconds()),
+			EnvVars: []string{"DEFAULT_RETRY_DELAY"},
+		},
+		&cli.Float64Flag{
+			Name:    "retry-max-delay",
+			Usage:   "Maximum delay in seconds between retries",
+			Value:   GetEnvOrDefaultFloat("DEFAULT_RETRY_MAX_DELAY", DefaultRetryPolicy().MaxBackoff.Seconds()),
+			EnvVars: []string{"DEFAULT_RETRY_MAX_DELAY"},
+		},
+		&cli.IntFlag{
+			Name:    "chunk-tokens",
+			Usage:   "Maximum input tokens per request before large inputs are split (0 = derived from the model's limits)",
+			Value:   GetEnvOrDefaultInt("DEFAULT_CHUNK_TOKENS", 0),
+			EnvVars: []string{"DEFAULT_CHUNK_TOKENS"},
+		},
+		&cli.BoolFlag{
+			Name:    "allow-truncated",
+			Usage:   "Keep responses still cut off at the output limit after the continuations instead of failing",
+			Value:   GetEnvOrDefaultBool("DEFAULT_ALLOW_TRUNCATED", false),
+			EnvVars: []string{"DEFAULT_ALLOW_TRUNCATED"},
+		},
+		&cli.BoolFlag{
+			Name:    "no-cache",
+			Usage:   "Always call the model instead of reusing cached responses",
+			Value:   GetEnvOrDefaultBool("DEFAULT_NO_CACHE", false),
+			EnvVars: []string{"DEFAULT_NO_CACHE"},
+		},
+		&cli.StringFlag{
+			Name:    "cache-dir",
+			Usage:   "Directory for cached responses (default: ai-toolkit/responses in the user cache directory)",
+			EnvVars: []string{"AI_TOOLKIT_CACHE_DIR"},
+		},
+		&cli.IntFlag{
+			Name:    "cache-ttl",
+			Usage:   "Hours a cached response stays valid (0 = never expires)",
+			Value:   GetEnvOrDefaultInt("DEFAULT_CACHE_TTL", DefaultCacheTTLHours),
+			EnvVars: []string{"DEFAULT_CACHE_TTL"},
+		},
+		&cli.IntFlag{
+			Name:    "cache-max-size",
+			Usage:   "Maximum size of the response cache in MB (0 = unlimited)",
+			Value:   GetEnvOrDefaultInt("DEFAULT_CACHE_MAX_SIZE", DefaultCacheMaxSizeMB),
+			EnvVars: []string{"DEFAULT_CACHE_MAX_SIZE"},
+		},
+		&cli.IntFlag{
+			Name:    "rpm",
+			Usage:   "Maximum requests per minute sent to the provider (0 = unlimited)",
+			Value:   GetEnvOrDefaultInt("DEFAULT_RPM", 0),
+			EnvVars: []string{"DEFAULT_RPM"},
+		},
+		&cli.IntFlag{
+			Name:    "tpm",
+			Usage:   "Maximum tokens per minute sent to the provider (0 = unlimited)",
+			Value:   GetEnvOrDefaultInt("DEFAULT_TPM", 0),
+			EnvVars: []string{"DEFAULT_TPM"},
+		},
+		&cli.Float64Flag{
+			Name:    "max-cost",
+			Usage:   "Abort the run before its estimated cost in USD exceeds this budget (0 = no limit)",
+			Value:   GetEnvOrDefaultFloat("DEFAULT_MAX_COST", 0),
+			EnvVars: []string{"DEFAULT_MAX_COST"},
+		},
+		&cli.StringFlag{
+			Name:    "price-file",
+			Usage:   "JSON file of model prices in USD per million tokens, overriding the built-in prices",
+			EnvVars: []string{"AI_TOOLKIT_PRICE_FILE"},
+		},
+		&cli.StringFlag{
+			Name:    "cassette",
+			Usage:   "Cassette file to record model responses to or replay them from",
+			EnvVars: []string{"AI_TOOLKIT_CASSETTE"},
+		},
+		&cli.StringFlag{
+			Name:    "cassette-mode",
+			Usage:   "Whether to record to the cassette or replay from it (record, replay)",
+			Value:   GetEnvOrDefault("DEFAULT_CASSETTE_MODE", CassetteReplay),
+			EnvVars: []string{"DEFAULT_CASSETTE_MODE"},
+		},
 		&cli.BoolFlag{
 			Name:    "verbose",
 			Usage:   "Enable verbose logging",
 			Value:   GetEnvOrDefaultBool("DEFAULT_VERBOSE", false),
 			EnvVars: []string{"DEFAULT_VERBOSE"},
 		},
+		&cli.StringFlag{
+			Name:    "log-format",
+			Usage:   "Log output format (text, json)",
+			Value:   GetEnvOrDefault("DEFAULT_LOG_FORMAT", LogFormatText),
+			EnvVars: []string{"DEFAULT_LOG_FORMAT"},
+			Action: func(c *cli.Context, value string) error {
+				return ValidateLogFormat(value)
+			},
+		},
+		&cli.StringFlag{
+			Name:    "trace-exporter",
+			Usage:   "Export OpenTelemetry traces of the run (none, otlp, file)",
+			Value:   GetEnvOrDefault("DEFAULT_TRACE_EXPORTER", TraceExporterNone),
+			EnvVars: []string{"DEFAULT_TRACE_EXPORTER"},
+			Action: func(c *cli.Context, value string) error {
+				return ValidateTraceExporter(value)
+			},
+		},
+		&cli.StringFlag{
+			Name:    "trace-file",
+			Usage:   "File the file trace exporter writes spans to as JSON",
+			Value:   DefaultTraceFile,
+			EnvVars: []string{"AI_TOOLKIT_TRACE_FILE"},
+		},
+		&cli.BoolFlag{
+			Name:    "stream",
+			Usage:   "Stream model output to stdout as it is generated",
+			Value:   GetEnvOrDefaultBool("DEFAULT_STREAM", false),
+			EnvVars: []string{"DEFAULT_STREAM"},
+		},
+		&cli.BoolFlag{
+			Name:    "structured",
+			Usage:   "Request JSON output matching a schema instead of extracting fenced code blocks",
+			Value:   GetEnvOrDefaultBool("DEFAULT_STRUCTURED", false),
+			EnvVars: []string{"DEFAULT_STRUCTURED"},
+		},
+		&cli.StringFlag{
+			Name:    "code-blocks",
+			Usage:   "Which code blocks of a response to keep (language: all in the target language, largest: the largest one, concat: all blocks)",
+			Value:   GetEnvOrDefault("DEFAULT_CODE_BLOCKS", string(DefaultCodeSelection)),
+			EnvVars: []string{"DEFAULT_CODE_BLOCKS"},
+			Action: func(c *cli.Context, value string) error {
+				_, err := ParseCodeSelection(value)
+				return err
+			},
+		},
 		&cli.StringFlag{
 			Name:    "output",
 			Aliases: []string{"o"},
@@ -59,55 +228,334 @@ func CommonFlags() []cli.Flag {
 	}
 }
 
-// PrepareLogger configures logging based on verbosity
-func PrepareLogger(toolName string, verbose bool) {
-	// Configure logging
-	log.SetPrefix(fmt.Sprintf("[%s] ", toolName))
-	
-	if verbose {
-		log.SetFlags(log.LstdFlags | log.Lshortfile)
-	} else {
-		log.SetFlags(0)
+// ValidateAPIKey checks if an API key is provided for providers that need one
+func ValidateAPIKey(provider, baseURL, apiKey string) error {
+	// OpenAI-compatible servers often need no key, so one is only sent when given
+	if !ProviderRequiresAPIKey(provider) || customBaseURL(provider, baseURL) {
+		return nil
 	}
+	if ResolveAPIKey(provider, baseURL, apiKey) == "" {
+		return fmt.Errorf("API key is required. Provide it using --api-key flag or %s environment variable", APIKeyEnvVar(provider))
+	}
+	return nil
 }
 
-// ValidateAPIKey checks if an API key is provided
-func ValidateAPIKey(apiKey string) error {
-	if apiKey == "" {
-		return fmt.Errorf("API key is required. Provide it using --api-key flag or GEMINI_API_KEY environment variable")
+// ValidateCredentials checks the API key unless responses are replayed from a cassette
+func ValidateCredentials(c *cli.Context) error {
+	if c.String("cassette") != "" && strings.ToLower(c.String("cassette-mode")) == CassetteReplay {
+		return nil
 	}
-	return nil
+	return ValidateAPIKey(c.String("provider"), c.String("base-url"), c.String("api-key"))
 }
 
-// ExtractCommonConfig extracts common configuration from CLI context
+// ResolveAPIKey returns the API key for the provider: the one given with
+// --api-key, else the provider's own environment variable. Environment keys
+// are never sent to a custom base URL, which may be any host.
+func ResolveAPIKey(provider, baseURL, apiKey string) string {
+	if apiKey != "" {
+		return apiKey
+	}
+	envVar := APIKeyEnvVar(provider)
+	if envVar == "" || customBaseURL(provider, baseURL) {
+		return ""
+	}
+	return os.Getenv(envVar)
+}
+
+// APIKeyEnvVar returns the environment variable holding the API key of a
+// provider, or "" for providers without one
+func APIKeyEnvVar(provider string) string {
+	switch strings.ToLower(provider) {
+	case "", ProviderGemini:
+		return "GEMINI_API_KEY"
+	case ProviderOpenAI:
+		return "OPENAI_API_KEY"
+	default:
+		return ""
+	}
+}
+
+// customBaseURL reports whether the provider is pointed at a host other than
+// its official API. Only the openai provider honors --base-url with a key.
+func customBaseURL(provider, baseURL string) bool {
+	return strings.ToLower(provider) == ProviderOpenAI && baseURL != "" &&
+		strings.TrimRight(baseURL, "/") != DefaultOpenAIBaseURL
+}
+
+// ExtractCommonConfig extracts common configuration from CLI context. Values
+// from the config files are merged into the context by ApplyConfig beforehand.
 func ExtractCommonConfig(c *cli.Context) ToolConfig {
+	provider := strings.ToLower(c.String("provider"))
+
+	// Fall back to the provider's default model unless one was chosen explicitly
+	models := ParseModels(c.String("model"))
+	if !c.IsSet("model") || len(models) == 0 {
+		models = []string{DefaultModelForProvider(provider)}
+	}
+
 	return ToolConfig{
-		APIKey:      c.String("api-key"),
-		Model:       c.String("model"),
+		Provider:    provider,
+		BaseURL:     c.String("base-url"),
+		APIKey:      ResolveAPIKey(provider, c.String("base-url"), c.String("api-key")),
+		Model:       models[0],
+		Models:      models,
 		Temperature: float32(c.Float64("temp")),
 		Timeout:     c.Int("timeout"),
 		Verbose:     c.Bool("verbose"),
-		OutputFile:  c.String("output"),
+		LogFormat:   strings.ToLower(c.String("log-format")),
+		Trace: TraceConfig{
+			Exporter: strings.ToLower(c.String("trace-exporter")),
+			File:     c.String("trace-file"),
+		},
+		Stream:         c.Bool("stream"),
+		Structured:     c.Bool("structured"),
+		CodeBlocks:     parseCodeSelectionOrDefault(c.String("code-blocks")),
+		ChunkTokens:    c.Int("chunk-tokens"),
+		AllowTruncated: c.Bool("allow-truncated"),
+		OutputFile:     c.String("output"),
+		Retry: RetryPolicy{
+			MaxRetries:     c.Int("max-retries"),
+			InitialBackoff: secondsToDuration(c.Float64("retry-delay")),
+			MaxBackoff:     secondsToDuration(c.Float64("retry-max-delay")),
+			Multiplier:     DefaultRetryPolicy().Multiplier,
+		},
+		Cache: CacheConfig{
+			Enabled: !c.Bool("no-cache"),
+			Dir:     c.String("cache-dir"),
+			TTL:     time.Duration(c.Int("cache-ttl")) * time.Hour,
+			MaxSize: int64(c.Int("cache-max-size")) * 1024 * 1024,
+		},
+		RPM:       c.Int("rpm"),
+		TPM:       c.Int("tpm"),
+		MaxCost:   c.Float64("max-cost"),
+		PriceFile: c.String("price-file"),
+		Profile:   c.String("profile"),
+		Cassette: CassetteConfig{
+			Path: c.String("cassette"),
+			Mode: strings.ToLower(c.String("cassette-mode")),
+		},
 	}
 }
 
+// parseCodeSelectionOrDefault parses a --code-blocks value already validated by the flag
+func parseCodeSelectionOrDefault(value string) CodeSelection {
+	policy, err := ParseCodeSelection(value)
+	if err != nil {
+		return DefaultCodeSelection
+	}
+	return policy
+}
+
+// secondsToDuration converts a (possibly fractional) number of seconds to a time.Duration
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
 // WriteOutput writes content to a file or stdout
-func WriteOutput(content, outputFile string, verbose bool) error {
+func WriteOutput(content, outputFile string) error {
 	if outputFile != "" {
 		err := os.WriteFile(outputFile, []byte(content), 0644)
 		if err != nil {
 			return fmt.Errorf("error writing to output file: %v", err)
 		}
-		
-		if verbose {
-			log.Printf("Output written to %s", outputFile)
-		} else {
-			fmt.Printf("Output written to %s\n", outputFile)
-		}
+
+		slog.Info("Output written", "file", outputFile)
 	} else {
 		// Write to stdout
 		fmt.Println(content)
 	}
-	
+
 	return nil
-}
\ No newline at end of file
+}
+
+// GetCacheCommand returns the CLI command for managing the response cache
+func GetCacheCommand() *cli.Command {
+	cacheDirFlag := &cli.StringFlag{
+		Name:    "cache-dir",
+		Usage:   "Directory for cached responses (default: ai-toolkit/responses in the user cache directory)",
+		EnvVars: []string{"AI_TOOLKIT_CACHE_DIR"},
+	}
+
+	openCache := func(c *cli.Context) (*ResponseCache, error) {
+		ttl := time.Duration(GetEnvOrDefaultInt("DEFAULT_CACHE_TTL", DefaultCacheTTLHours)) * time.Hour
+		return NewResponseCache(c.String("cache-dir"), ttl, 0)
+	}
+
+	return &cli.Command{
+		Name:  "cache",
+		Usage: "Manage the on-disk response cache",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "clear",
+				Usage: "Remove all cached responses",
+				Flags: []cli.Flag{cacheDirFlag},
+				Action: func(c *cli.Context) error {
+					cache, err := openCache(c)
+					if err != nil {
+						return err
+					}
+
+					removed, err := cache.Clear()
+					if err != nil {
+						return err
+					}
+
+					fmt.Printf("Removed %d cached responses from %s\n", removed, cache.Dir())
+					return nil
+				},
+			},
+			{
+				Name:  "stats",
+				Usage: "Show the size and contents of the response cache",
+				Flags: []cli.Flag{cacheDirFlag},
+				Action: func(c *cli.Context) error {
+					cache, err := openCache(c)
+					if err != nil {
+						return err
+					}
+
+					stats, err := cache.Stats()
+					if err != nil {
+						return err
+					}
+
+					fmt.Printf("Cache directory: %s\n", stats.Dir)
+					fmt.Printf("Entries:         %d (%d expired)\n", stats.Entries, stats.Expired)
+					fmt.Printf("Size:            %.2f MB\n", float64(stats.Size)/(1024*1024))
+					if stats.Entries > 0 {
+						fmt.Printf("Oldest entry:    %s\n", stats.Oldest.Format(time.RFC3339))
+						fmt.Printf("Newest entry:    %s\n", stats.Newest.Format(time.RFC3339))
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// GetPromptsCommand returns the CLI command for inspecting and exporting prompt templates
+func GetPromptsCommand() *cli.Command {
+	lookup := func(tool string) (*PromptSet, error) {
+		set, ok := LookupPromptSet(tool)
+		if !ok {
+			return nil, fmt.Errorf("unknown tool %q", tool)
+		}
+		return set, nil
+	}
+
+	return &cli.Command{
+		Name:  "prompts",
+		Usage: "List, show and export the prompt templates",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "list",
+				Usage: "List the prompt templates and where each is loaded from",
+				Action: func(c *cli.Context) error {
+					tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+					fmt.Fprintln(tw, "TOOL\tNAME\tSOURCE")
+					for _, set := range PromptSets() {
+						for _, name := range set.Names() {
+							_, origin, err := set.Source(name)
+							if err != nil {
+								return err
+							}
+							fmt.Fprintf(tw, "%s\t%s\t%s\n", set.Tool(), name, origin)
+						}
+					}
+					return tw.Flush()
+				},
+			},
+			{
+				Name:      "show",
+				Usage:     "Print the effective text of a prompt template",
+				ArgsUsage: "<tool> <name>",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "built-in",
+						Usage: "Show the built-in template, ignoring overrides",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 2 {
+						return fmt.Errorf("usage: prompts show <tool> <name>")
+					}
+
+					set, err := lookup(c.Args().Get(0))
+					if err != nil {
+						return err
+					}
+
+					var text string
+					if c.Bool("built-in") {
+						text, err = set.BuiltIn(c.Args().Get(1))
+					} else {
+						text, _, err = set.Source(c.Args().Get(1))
+					}
+					if err != nil {
+						return err
+					}
+
+					fmt.Print(text)
+					return nil
+				},
+			},
+			{
+				Name:      "export",
+				Usage:     "Write the built-in prompt templates to a directory so they can be edited",
+				ArgsUsage: "[tool]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "dir",
+						Usage: "Directory to export to; templates are written to <dir>/<tool>/<name>.tmpl",
+						Value: ProjectPromptsDir,
+					},
+					&cli.BoolFlag{
+						Name:  "force",
+						Usage: "Overwrite templates that already exist",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					sets := PromptSets()
+					if c.NArg() > 0 {
+						set, err := lookup(c.Args().First())
+						if err != nil {
+							return err
+						}
+						sets = []*PromptSet{set}
+					}
+
+					for _, set := range sets {
+						written, err := set.Export(c.String("dir"), c.Bool("force"))
+						for _, path := range written {
+							fmt.Printf("Wrote %s\n", path)
+						}
+						if err != nil {
+							return err
+						}
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// GetListToolsCommand returns the CLI command listing the registered tools
+func GetListToolsCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "list-tools",
+		Usage: "List the available tools",
+		Action: func(c *cli.Context) error {
+			tw := tabwriter.NewWriter(c.App.Writer, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "NAME\tALIASES\tDESCRIPTION")
+			for _, t := range Tools() {
+				aliases := strings.Join(t.Aliases, ", ")
+				if aliases == "" {
+					aliases = "-"
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\n", t.Name, aliases, t.Command().Usage)
+			}
+			return tw.Flush()
+		},
+	}
+}
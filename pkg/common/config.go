@@ -0,0 +1,357 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// ProjectConfigFile is the name of the project configuration file, looked up in the working directory
+const ProjectConfigFile = ".ai-toolkit.yaml"
+
+// Settings holds the options a configuration file or profile can set. Unset
+// fields leave the option to the next source in order of precedence.
+type Settings struct {
+	Provider       *string   `yaml:"provider"`
+	BaseURL        *string   `yaml:"base_url"`
+	Model          *string   `yaml:"model"`
+	Temperature    *float64  `yaml:"temperature"`
+	Timeout        *int      `yaml:"timeout"`
+	Lang           *string   `yaml:"lang"`
+	Style          *string   `yaml:"style"`
+	AST            *bool     `yaml:"ast"`
+	NoVerify       *bool     `yaml:"no_verify"`
+	VerifyRetries  *int      `yaml:"verify_retries"`
+	Concurrency    *int      `yaml:"concurrency"`
+	FileTimeout    *int      `yaml:"file_timeout"`
+	Include        *[]string `yaml:"include"`
+	Exclude        *[]string `yaml:"exclude"`
+	MaxFileSize    *int      `yaml:"max_file_size"`
+	Addr           *string   `yaml:"addr"`
+	AllowedModels  *[]string `yaml:"allowed_models"`
+	Root           *string   `yaml:"root"`
+	Verbose        *bool     `yaml:"verbose"`
+	LogFormat      *string   `yaml:"log_format"`
+	TraceExporter  *string   `yaml:"trace_exporter"`
+	TraceFile      *string   `yaml:"trace_file"`
+	Stream         *bool     `yaml:"stream"`
+	Structured     *bool     `yaml:"structured"`
+	CodeBlocks     *string   `yaml:"code_blocks"`
+	ChunkTokens    *int      `yaml:"chunk_tokens"`
+	AllowTruncated *bool     `yaml:"allow_truncated"`
+	MaxRetries     *int      `yaml:"max_retries"`
+	RetryDelay     *float64  `yaml:"retry_delay"`
+	RetryMaxDelay  *float64  `yaml:"retry_max_delay"`
+	NoCache        *bool     `yaml:"no_cache"`
+	CacheDir       *string   `yaml:"cache_dir"`
+	CacheTTL       *int      `yaml:"cache_ttl"`
+	CacheMaxSize   *int      `yaml:"cache_max_size"`
+	RPM            *int      `yaml:"rpm"`
+	TPM            *int      `yaml:"tpm"`
+	MaxCost        *float64  `yaml:"max_cost"`
+	PriceFile      *string   `yaml:"price_file"`
+}
+
+// ConfigFile is the contents of a configuration file: top-level settings,
+// named profiles, and the profile to use when --profile is not given
+type ConfigFile struct {
+	Settings `yaml:",inline"`
+	Profile  string              `yaml:"profile"`
+	Profiles map[string]Settings `yaml:"profiles"`
+}
+
+// UserConfigFile returns the path of the user configuration file
+func UserConfigFile() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("error locating user config directory: %v", err)
+	}
+	return filepath.Join(dir, "ai-toolkit", "config.yaml"), nil
+}
+
+// LoadConfigFile reads a configuration file. It returns nil if the file does
+// not exist; unknown keys are reported as errors to catch typos.
+func LoadConfigFile(path string) (*ConfigFile, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file %s: %v", path, err)
+	}
+
+	var file ConfigFile
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error parsing config file %s: %v", path, err)
+	}
+	return &file, nil
+}
+
+// ResolvedConfig is the result of merging the configuration files
+type ResolvedConfig struct {
+	Settings Settings
+	// Profile is the selected profile, empty if none
+	Profile string
+	// Files lists the configuration files that were found, lowest precedence first
+	Files []string
+}
+
+// ResolveConfig merges the user and project configuration files and applies
+// the named profile. An empty profile selects the one named by the files'
+// profile key, if any. From lowest to highest precedence: user settings,
+// project settings, the user's profile, the project's profile.
+func ResolveConfig(profile string) (*ResolvedConfig, error) {
+	var paths []string
+	if path, err := UserConfigFile(); err == nil {
+		paths = append(paths, path)
+	}
+	paths = append(paths, ProjectConfigFile)
+
+	resolved := &ResolvedConfig{}
+	var files []*ConfigFile
+	for _, path := range paths {
+		file, err := LoadConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if file == nil {
+			continue
+		}
+		files = append(files, file)
+		resolved.Files = append(resolved.Files, path)
+
+		resolved.Settings.merge(file.Settings)
+		if file.Profile != "" {
+			resolved.Profile = file.Profile
+		}
+	}
+
+	if profile != "" {
+		resolved.Profile = profile
+	}
+	if resolved.Profile == "" {
+		return resolved, nil
+	}
+
+	found := false
+	for _, file := range files {
+		if settings, ok := file.Profiles[resolved.Profile]; ok {
+			resolved.Settings.merge(settings)
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("unknown profile %q (available: %v)", resolved.Profile, profileNames(files))
+	}
+
+	return resolved, nil
+}
+
+// merge overrides s with the fields set in other
+func (s *Settings) merge(other Settings) {
+	mergeField(&s.Provider, other.Provider)
+	mergeField(&s.BaseURL, other.BaseURL)
+	mergeField(&s.Model, other.Model)
+	mergeField(&s.Temperature, other.Temperature)
+	mergeField(&s.Timeout, other.Timeout)
+	mergeField(&s.Lang, other.Lang)
+	mergeField(&s.Style, other.Style)
+	mergeField(&s.AST, other.AST)
+	mergeField(&s.NoVerify, other.NoVerify)
+	mergeField(&s.VerifyRetries, other.VerifyRetries)
+	mergeField(&s.Concurrency, other.Concurrency)
+	mergeField(&s.FileTimeout, other.FileTimeout)
+	mergeField(&s.Include, other.Include)
+	mergeField(&s.Exclude, other.Exclude)
+	mergeField(&s.MaxFileSize, other.MaxFileSize)
+	mergeField(&s.Addr, other.Addr)
+	mergeField(&s.AllowedModels, other.AllowedModels)
+	mergeField(&s.Root, other.Root)
+	mergeField(&s.Verbose, other.Verbose)
+	mergeField(&s.LogFormat, other.LogFormat)
+	mergeField(&s.TraceExporter, other.TraceExporter)
+	mergeField(&s.TraceFile, other.TraceFile)
+	mergeField(&s.Stream, other.Stream)
+	mergeField(&s.Structured, other.Structured)
+	mergeField(&s.CodeBlocks, other.CodeBlocks)
+	mergeField(&s.ChunkTokens, other.ChunkTokens)
+	mergeField(&s.AllowTruncated, other.AllowTruncated)
+	mergeField(&s.MaxRetries, other.MaxRetries)
+	mergeField(&s.RetryDelay, other.RetryDelay)
+	mergeField(&s.RetryMaxDelay, other.RetryMaxDelay)
+	mergeField(&s.NoCache, other.NoCache)
+	mergeField(&s.CacheDir, other.CacheDir)
+	mergeField(&s.CacheTTL, other.CacheTTL)
+	mergeField(&s.CacheMaxSize, other.CacheMaxSize)
+	mergeField(&s.RPM, other.RPM)
+	mergeField(&s.TPM, other.TPM)
+	mergeField(&s.MaxCost, other.MaxCost)
+	mergeField(&s.PriceFile, other.PriceFile)
+}
+
+// mergeField sets *dst to src if src is set
+func mergeField[T any](dst **T, src *T) {
+	if src != nil {
+		*dst = src
+	}
+}
+
+// FlagValues returns the set fields as CLI flag values keyed by flag name
+func (s Settings) FlagValues() map[string]string {
+	values := map[string]string{}
+	setString := func(flag string, v *string) {
+		if v != nil {
+			values[flag] = *v
+		}
+	}
+	setInt := func(flag string, v *int) {
+		if v != nil {
+			values[flag] = strconv.Itoa(*v)
+		}
+	}
+	setFloat := func(flag string, v *float64) {
+		if v != nil {
+			values[flag] = strconv.FormatFloat(*v, 'f', -1, 64)
+		}
+	}
+	setBool := func(flag string, v *bool) {
+		if v != nil {
+			values[flag] = strconv.FormatBool(*v)
+		}
+	}
+	setList := func(flag string, v *[]string) {
+		if v != nil {
+			values[flag] = strings.Join(*v, ",")
+		}
+	}
+
+	setString("provider", s.Provider)
+	setString("base-url", s.BaseURL)
+	setString("model", s.Model)
+	setFloat("temp", s.Temperature)
+	setInt("timeout", s.Timeout)
+	setString("lang", s.Lang)
+	setString("style", s.Style)
+	setBool("ast", s.AST)
+	setBool("no-verify", s.NoVerify)
+	setInt("verify-retries", s.VerifyRetries)
+	setInt("concurrency", s.Concurrency)
+	setInt("file-timeout", s.FileTimeout)
+	setList("include", s.Include)
+	setList("exclude", s.Exclude)
+	setInt("max-file-size", s.MaxFileSize)
+	setString("addr", s.Addr)
+	setList("allowed-models", s.AllowedModels)
+	setString("root", s.Root)
+	setBool("verbose", s.Verbose)
+	setString("log-format", s.LogFormat)
+	setString("trace-exporter", s.TraceExporter)
+	setString("trace-file", s.TraceFile)
+	setBool("stream", s.Stream)
+	setBool("structured", s.Structured)
+	setString("code-blocks", s.CodeBlocks)
+	setInt("chunk-tokens", s.ChunkTokens)
+	setBool("allow-truncated", s.AllowTruncated)
+	setInt("max-retries", s.MaxRetries)
+	setFloat("retry-delay", s.RetryDelay)
+	setFloat("retry-max-delay", s.RetryMaxDelay)
+	setBool("no-cache", s.NoCache)
+	setString("cache-dir", s.CacheDir)
+	setInt("cache-ttl", s.CacheTTL)
+	setInt("cache-max-size", s.CacheMaxSize)
+	setInt("rpm", s.RPM)
+	setInt("tpm", s.TPM)
+	setFloat("max-cost", s.MaxCost)
+	setString("price-file", s.PriceFile)
+	return values
+}
+
+// ApplyConfig fills the flags not set on the command line or through the
+// environment from the configuration files and the profile chosen with
+// --profile, then from the defaults of the registered tool being run.
+// Commands call it first in their Before hook, so everything after it,
+// including ExtractCommonConfig, sees the merged values.
+func ApplyConfig(c *cli.Context) error {
+	resolved, err := ResolveConfig(c.String("profile"))
+	if err != nil {
+		return err
+	}
+
+	// The configuration files override the tool's defaults
+	var settings Settings
+	if t, ok := toolOf(c); ok {
+		settings = t.Defaults
+	}
+	settings.merge(resolved.Settings)
+
+	values := settings.FlagValues()
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		// Options a command does not have, like style for typegen, are skipped
+		if c.IsSet(name) || !hasFlag(c, name) {
+			continue
+		}
+		if err := c.Set(name, values[name]); err != nil {
+			return fmt.Errorf("invalid value %q for %s in config: %v", values[name], name, err)
+		}
+	}
+
+	if resolved.Profile != "" && !c.IsSet("profile") {
+		if err := c.Set("profile", resolved.Profile); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hasFlag reports whether the command or one of its parents defines the flag
+func hasFlag(c *cli.Context, name string) bool {
+	for _, ctx := range c.Lineage() {
+		var flags []cli.Flag
+		if ctx.Command != nil {
+			flags = append(flags, ctx.Command.Flags...)
+		}
+		if ctx.App != nil {
+			flags = append(flags, ctx.App.Flags...)
+		}
+		for _, flag := range flags {
+			for _, flagName := range flag.Names() {
+				if flagName == name {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// profileNames returns the names of the profiles defined in files, sorted
+func profileNames(files []*ConfigFile) []string {
+	var names []string
+	for _, file := range files {
+		for name := range file.Profiles {
+			if !containsString(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,218 @@
+package common
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+// configTestTool is registered by the tests that need a tool's defaults
+const configTestTool = "config-test"
+
+// unsetenv removes environment variables for a test
+func unsetenv(t *testing.T, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+}
+
+// writeConfigFiles writes the user and project configuration files, skipping empty ones
+func writeConfigFiles(t *testing.T, user, project string) {
+	t.Helper()
+	configHome := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+	chdir(t, t.TempDir())
+
+	if user != "" {
+		dir := filepath.Join(configHome, "ai-toolkit")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "config.yaml"), []byte(user), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if project != "" {
+		if err := os.WriteFile(ProjectConfigFile, []byte(project), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// newConfigContext builds the context of a command line of a tool with the
+// common flags
+func newConfigContext(t *testing.T, args ...string) *cli.Context {
+	t.Helper()
+	app := &cli.App{
+		Name:     configTestTool,
+		Flags:    CommonFlags(),
+		Metadata: map[string]interface{}{toolMetadataKey: configTestTool},
+	}
+
+	set := flag.NewFlagSet(configTestTool, flag.ContinueOnError)
+	for _, f := range app.Flags {
+		if err := f.Apply(set); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := set.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return cli.NewContext(app, set, nil)
+}
+
+func TestApplyConfig(t *testing.T) {
+	const user = `
+model: user-model
+temperature: 0.1
+timeout: 10
+profiles:
+  fast:
+    model: user-fast-model
+    timeout: 20
+  review:
+    temperature: 0.9
+`
+	const project = `
+model: project-model
+temperature: 0.3
+profiles:
+  fast:
+    model: project-fast-model
+`
+
+	tests := []struct {
+		name    string
+		user    string
+		project string
+		env     map[string]string
+		args    []string
+		want    map[string]string
+	}{
+		{
+			name: "tool defaults",
+			want: map[string]string{"model": "tool-model", "temp": "0.5", "timeout": "120", "profile": ""},
+		},
+		{
+			name: "user over tool defaults",
+			user: user,
+			want: map[string]string{"model": "user-model", "temp": "0.1", "timeout": "10"},
+		},
+		{
+			name:    "project over user",
+			user:    user,
+			project: project,
+			want:    map[string]string{"model": "project-model", "temp": "0.3", "timeout": "10"},
+		},
+		{
+			name:    "user profile over project",
+			user:    user,
+			project: project,
+			args:    []string{"--profile", "review"},
+			want:    map[string]string{"model": "project-model", "temp": "0.9", "timeout": "10"},
+		},
+		{
+			name:    "project profile over user profile",
+			user:    user,
+			project: project,
+			args:    []string{"--profile", "fast"},
+			want:    map[string]string{"model": "project-fast-model", "temp": "0.3", "timeout": "20"},
+		},
+		{
+			name:    "profile chosen by a file",
+			user:    user,
+			project: project + "profile: fast\n",
+			want:    map[string]string{"model": "project-fast-model", "timeout": "20", "profile": "fast"},
+		},
+		{
+			name:    "profile from the environment",
+			user:    user,
+			project: project,
+			env:     map[string]string{"AI_TOOLKIT_PROFILE": "review"},
+			want:    map[string]string{"temp": "0.9", "profile": "review"},
+		},
+		{
+			name:    "flags over everything",
+			user:    user,
+			project: project,
+			args:    []string{"--profile", "fast", "--model", "flag-model", "--temp", "0"},
+			want:    map[string]string{"model": "flag-model", "temp": "0", "timeout": "20"},
+		},
+		{
+			name:    "environment over files",
+			user:    user,
+			project: project,
+			env:     map[string]string{"DEFAULT_MODEL": "env-model"},
+			want:    map[string]string{"model": "env-model", "temp": "0.3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetenv(t, "AI_TOOLKIT_PROFILE", "DEFAULT_MODEL", "DEFAULT_TEMPERATURE", "DEFAULT_TIMEOUT")
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			writeConfigFiles(t, tt.user, tt.project)
+			model, temperature := "tool-model", 0.5
+			registerTestTool(t, Tool{Name: configTestTool, Command: func() *cli.Command { return &cli.Command{} }, Defaults: Settings{Model: &model, Temperature: &temperature}})
+
+			c := newConfigContext(t, tt.args...)
+			if err := ApplyConfig(c); err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range tt.want {
+				// String formats any flag's value as it is written on the command line
+				if got := c.String(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		project string
+		args    []string
+		wantErr string
+	}{
+		{"unknown profile", "profiles:\n  fast:\n    model: m\n", []string{"--profile", "slow"}, `unknown profile "slow" (available: [fast])`},
+		{"unknown key", "modle: m\n", nil, "field modle not found"},
+		{"invalid value", "timeout: soon\n", nil, "error parsing config file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetenv(t, "AI_TOOLKIT_PROFILE")
+			writeConfigFiles(t, "", tt.project)
+
+			err := ApplyConfig(newConfigContext(t, tt.args...))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveConfigFiles(t *testing.T) {
+	writeConfigFiles(t, "model: user-model\n", "model: project-model\n")
+
+	resolved, err := ResolveConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resolved.Files) != 2 || !strings.HasSuffix(resolved.Files[0], filepath.Join("ai-toolkit", "config.yaml")) || resolved.Files[1] != ProjectConfigFile {
+		t.Errorf("Files = %q, want the user file then the project file", resolved.Files)
+	}
+	if resolved.Settings.Model == nil || *resolved.Settings.Model != "project-model" {
+		t.Errorf("Model = %v, want project-model", resolved.Settings.Model)
+	}
+}
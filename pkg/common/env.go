@@ -28,11 +28,48 @@ func CleanWindowsLineEndings() {
 	// Common environment variables used across tools
 	keysToClean := []string{
 		"GEMINI_API_KEY", 
+		"OPENAI_API_KEY",
+		"OLLAMA_HOST",
+		"AI_BASE_URL",
+		"DEFAULT_PROVIDER",
 		"DEFAULT_LANG", 
 		"DEFAULT_MODEL", 
 		"DEFAULT_TEMPERATURE", 
 		"DEFAULT_TIMEOUT", 
 		"DEFAULT_VERBOSE",
+		"DEFAULT_LOG_FORMAT",
+		"DEFAULT_TRACE_EXPORTER",
+		"AI_TOOLKIT_TRACE_FILE",
+		"DEFAULT_STREAM",
+		"DEFAULT_STRUCTURED",
+		"DEFAULT_CODE_BLOCKS",
+		"DEFAULT_CASSETTE_MODE",
+		"AI_TOOLKIT_CASSETTE",
+		"DEFAULT_RPM",
+		"DEFAULT_TPM",
+		"DEFAULT_MAX_COST",
+		"AI_TOOLKIT_PRICE_FILE",
+		"DEFAULT_CHUNK_TOKENS",
+		"DEFAULT_MAX_RETRIES",
+		"DEFAULT_RETRY_DELAY",
+		"DEFAULT_RETRY_MAX_DELAY",
+		"DEFAULT_NO_CACHE",
+		"DEFAULT_CACHE_TTL",
+		"DEFAULT_CACHE_MAX_SIZE",
+		"AI_TOOLKIT_CACHE_DIR",
+		"AI_TOOLKIT_PROFILE",
+		"AI_TOOLKIT_ADDR",
+		"AI_TOOLKIT_ALLOWED_MODELS",
+		"AI_TOOLKIT_ROOT",
+		"DEFAULT_CONCURRENCY",
+		"DEFAULT_FILE_TIMEOUT",
+		"DEFAULT_INCLUDE",
+		"DEFAULT_EXCLUDE",
+		"DEFAULT_MAX_FILE_SIZE",
+		"DEFAULT_AST",
+		"DEFAULT_NO_VERIFY",
+		"DEFAULT_VERIFY_RETRIES",
+		"DEFAULT_ALLOW_TRUNCATED",
 	}
 	
 	for _, key := range keysToClean {
@@ -0,0 +1,147 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ParseModels splits a comma-separated --model value into an ordered fallback chain
+func ParseModels(value string) []string {
+	var models []string
+	for _, model := range strings.Split(value, ",") {
+		if model = strings.TrimSpace(model); model != "" {
+			models = append(models, model)
+		}
+	}
+	return models
+}
+
+// IsFallbackError reports whether err means the model cannot serve the
+// request, so the next model in the fallback chain should be tried: the model
+// does not exist, is still overloaded after retrying, or rejected the prompt
+// as too long
+func IsFallbackError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	code, ok := httpStatusOf(err)
+	if !ok {
+		return isPromptTooLong(err)
+	}
+
+	switch code {
+	case http.StatusNotFound:
+		return true
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+		return isPromptTooLong(err)
+	default:
+		return false
+	}
+}
+
+// httpStatusOf returns the HTTP status of a provider error, mapping gRPC codes
+// to their HTTP equivalents
+func httpStatusOf(err error) (int, bool) {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode, true
+	}
+
+	var apiErr *apierror.APIError
+	if errors.As(err, &apiErr) {
+		if code := apiErr.HTTPCode(); code > 0 {
+			return code, true
+		}
+		if st := apiErr.GRPCStatus(); st != nil {
+			return httpStatusForCode(st.Code())
+		}
+	}
+
+	var googleErr *googleapi.Error
+	if errors.As(err, &googleErr) {
+		return googleErr.Code, true
+	}
+
+	if st, ok := status.FromError(err); ok && st.Code() != codes.Unknown {
+		return httpStatusForCode(st.Code())
+	}
+
+	return 0, false
+}
+
+// httpStatusForCode maps the gRPC codes relevant to fallback to HTTP statuses
+func httpStatusForCode(code codes.Code) (int, bool) {
+	switch code {
+	case codes.NotFound:
+		return http.StatusNotFound, true
+	case codes.InvalidArgument:
+		return http.StatusBadRequest, true
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests, true
+	case codes.Internal:
+		return http.StatusInternalServerError, true
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable, true
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout, true
+	default:
+		return 0, false
+	}
+}
+
+// promptTooLongMessages are fragments of the errors providers return for
+// prompts exceeding the model's context window
+var promptTooLongMessages = []string{
+	"context length",
+	"context_length_exceeded",
+	"context window",
+	"prompt is too long",
+	"input is too long",
+	"too many tokens",
+	"maximum number of tokens",
+	"exceeds the maximum",
+	"input token count",
+}
+
+// isPromptTooLong reports whether err says the prompt does not fit the model's context window
+func isPromptTooLong(err error) bool {
+	msg := strings.ToLower(err.Error())
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		msg += " " + strings.ToLower(httpErr.Body)
+	}
+
+	for _, fragment := range promptTooLongMessages {
+		if strings.Contains(msg, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// modelChain returns the models to try for a request: the requested model
+// followed by the configured fallbacks
+func (c *AIClient) modelChain(model string) []string {
+	chain := []string{model}
+	for _, fallback := range c.models {
+		if fallback != model {
+			chain = append(chain, fallback)
+		}
+	}
+	return chain
+}
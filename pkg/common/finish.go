@@ -0,0 +1,143 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// Normalized finish reasons reported in GenerateResponse.FinishReason
+const (
+	FinishStop       = "stop"
+	FinishMaxTokens  = "max_tokens"
+	FinishSafety     = "safety"
+	FinishRecitation = "recitation"
+	FinishOther      = "other"
+)
+
+// DefaultMaxContinuations is how many follow-up requests are sent to complete
+// a response that was cut off at the model's output limit
+const DefaultMaxContinuations = 3
+
+// BlockedError is returned when the provider refused to answer a prompt or
+// withheld the response for safety reasons
+type BlockedError struct {
+	Provider string
+	// Reason is the normalized finish reason or the provider's prompt block reason
+	Reason string
+	// PromptBlocked is set when the prompt itself was rejected
+	PromptBlocked bool
+	// Categories lists the safety categories that triggered the block, if reported
+	Categories []string
+}
+
+// TruncatedError is returned when a response is still cut off at the output
+// limit after the maximum number of continuations
+type TruncatedError struct {
+	Provider      string
+	Model         string
+	Continuations int
+	// Text is the incomplete response
+	Text string
+}
+
+func (e *TruncatedError) Error() string {
+	return fmt.Sprintf("response from %s (model: %s) is still truncated at the output limit after %d continuations",
+		e.Provider, e.Model, e.Continuations)
+}
+
+func (e *BlockedError) Error() string {
+	subject := "response"
+	if e.PromptBlocked {
+		subject = "prompt"
+	}
+
+	msg := fmt.Sprintf("%s blocked by %s (reason: %s)", subject, e.Provider, e.Reason)
+	if len(e.Categories) > 0 {
+		msg += fmt.Sprintf(", categories: %s", strings.Join(e.Categories, ", "))
+	}
+	return msg
+}
+
+// continuationPrompt asks the model to resume a response that was cut off
+func continuationPrompt(prompt, partial string) string {
+	return fmt.Sprintf("%s\n\nYour previous response was cut off because it reached the output limit. "+
+		"This is what you have written so far:\n\n%s\n\n"+
+		"Continue exactly where the response stops. Do not repeat any of the text above, "+
+		"do not add an introduction and do not wrap the continuation in a new code block.", prompt, partial)
+}
+
+// continueTruncated sends continuation requests until the response is complete
+// or the continuation limit is reached. It returns the text to append to
+// partial and the final finish reason. A response still incomplete at the
+// limit yields a TruncatedError unless truncated responses are allowed.
+func (c *AIClient) continueTruncated(ctx context.Context, req GenerateRequest, partial string) (string, string, error) {
+	text := partial
+	finishReason := FinishMaxTokens
+	for i := 0; i < c.maxContinuations && finishReason == FinishMaxTokens; i++ {
+		slog.Debug("Response was truncated at the output limit, requesting a continuation",
+			"model", req.Model, "continuation", i+1, "max_continuations", c.maxContinuations)
+
+		next := req
+		next.Prompt = continuationPrompt(req.Prompt, text)
+		resp, err := c.generateOnce(ctx, next)
+		if err != nil {
+			return "", "", fmt.Errorf("error continuing truncated response: %w", err)
+		}
+
+		text = stitch(text, resp.Text)
+		finishReason = resp.FinishReason
+	}
+
+	if finishReason == FinishMaxTokens {
+		if !c.allowTruncated {
+			return "", "", &TruncatedError{Provider: c.provider.Name(), Model: req.Model, Continuations: c.maxContinuations, Text: text}
+		}
+		slog.Warn("Response is still truncated after the maximum number of continuations",
+			"provider", c.provider.Name(), "model", req.Model, "continuations", c.maxContinuations)
+	}
+
+	return text[len(partial):], finishReason, nil
+}
+
+// stitch appends a continuation to text, dropping any part of the continuation
+// that repeats the end of text
+func stitch(text, continuation string) string {
+	// If text stopped inside a code block, a continuation that opens a new
+	// block would leave a stray fence in the middle of the code
+	blocks := ParseCodeBlocks(text)
+	if len(blocks) > 0 && !blocks[len(blocks)-1].Closed && startsWithFence(continuation) {
+		continuation = strings.TrimLeft(continuation, " \t\r\n")
+		if newline := strings.Index(continuation, "\n"); newline >= 0 {
+			continuation = continuation[newline+1:]
+		} else {
+			continuation = ""
+		}
+	}
+
+	const maxOverlap = 500
+	const minOverlap = 16
+
+	limit := maxOverlap
+	if len(text) < limit {
+		limit = len(text)
+	}
+	if len(continuation) < limit {
+		limit = len(continuation)
+	}
+
+	for n := limit; n >= minOverlap; n-- {
+		if strings.HasSuffix(text, continuation[:n]) {
+			return text + continuation[n:]
+		}
+	}
+	return text + continuation
+}
+
+// startsWithFence reports whether text opens with a code fence
+func startsWithFence(text string) bool {
+	line, _, _ := strings.Cut(strings.TrimLeft(text, " \t\r\n"), "\n")
+	_, _, _, ok := openingFence(strings.TrimRight(line, "\r"), 0)
+	return ok
+}
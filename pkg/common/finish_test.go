@@ -0,0 +1,79 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// truncatingProvider answers every request with a response cut off at the
+// output limit
+type truncatingProvider struct {
+	calls int
+}
+
+func (p *truncatingProvider) Name() string { return "test" }
+
+func (p *truncatingProvider) Generate(ctx context.Context, req GenerateRequest) (*GenerateResponse, error) {
+	p.calls++
+	return &GenerateResponse{Text: fmt.Sprintf("part %d ", p.calls), Model: req.Model, FinishReason: FinishMaxTokens}, nil
+}
+
+func (p *truncatingProvider) GenerateStream(ctx context.Context, req GenerateRequest) (<-chan StreamChunk, error) {
+	resp, _ := p.Generate(ctx, req)
+	ch := make(chan StreamChunk, 1)
+	ch <- StreamChunk{Text: resp.Text, FinishReason: resp.FinishReason}
+	close(ch)
+	return ch, nil
+}
+
+func (p *truncatingProvider) CountTokens(ctx context.Context, model, text string) (int, error) {
+	return len(text) / 4, nil
+}
+
+func (p *truncatingProvider) Close() error { return nil }
+
+func TestGenerateFailsWhenStillTruncated(t *testing.T) {
+	provider := &truncatingProvider{}
+	client := NewAIClientWithProvider(provider)
+
+	_, err := client.Generate(context.Background(), GenerateRequest{Prompt: "write", Model: "test-model"})
+	var truncated *TruncatedError
+	if !errors.As(err, &truncated) {
+		t.Fatalf("expected a TruncatedError, got %v", err)
+	}
+	if provider.calls != 1+DefaultMaxContinuations {
+		t.Errorf("expected %d requests, got %d", 1+DefaultMaxContinuations, provider.calls)
+	}
+	if want := "part 1 part 2 part 3 part 4 "; truncated.Text != want {
+		t.Errorf("partial text = %q, want %q", truncated.Text, want)
+	}
+}
+
+func TestGenerateTextFailsWhenStreamStillTruncated(t *testing.T) {
+	client := NewAIClientWithProvider(&truncatingProvider{})
+
+	_, err := GenerateText(context.Background(), client, GenerateRequest{Prompt: "write", Model: "test-model"}, io.Discard)
+	var truncated *TruncatedError
+	if !errors.As(err, &truncated) {
+		t.Fatalf("expected a TruncatedError, got %v", err)
+	}
+}
+
+func TestGenerateKeepsTruncatedWhenAllowed(t *testing.T) {
+	client := NewAIClientWithProvider(&truncatingProvider{})
+	client.allowTruncated = true
+
+	resp, err := client.Generate(context.Background(), GenerateRequest{Prompt: "write", Model: "test-model"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.FinishReason != FinishMaxTokens {
+		t.Errorf("finish reason = %q, want %q", resp.FinishReason, FinishMaxTokens)
+	}
+	if want := "part 1 part 2 part 3 part 4 "; resp.Text != want {
+		t.Errorf("text = %q, want %q", resp.Text, want)
+	}
+}
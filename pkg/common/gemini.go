@@ -0,0 +1,257 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// GeminiProvider talks to Google's Gemini models
+type GeminiProvider struct {
+	client *genai.Client
+}
+
+// NewGeminiProvider creates a new GeminiProvider with the given API key
+func NewGeminiProvider(ctx context.Context, apiKey string) (*GeminiProvider, error) {
+	// Clean any carriage returns from the API key
+	apiKey = strings.ReplaceAll(apiKey, "\r", "")
+
+	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+	if err != nil {
+		return nil, fmt.Errorf("error creating Gemini client: %v", err)
+	}
+
+	return &GeminiProvider{
+		client: client,
+	}, nil
+}
+
+// Name returns the provider identifier
+func (p *GeminiProvider) Name() string {
+	return ProviderGemini
+}
+
+// Close closes the underlying Gemini client
+func (p *GeminiProvider) Close() error {
+	if p.client != nil {
+		return p.client.Close()
+	}
+	return nil
+}
+
+// Generate generates content based on the given request
+func (p *GeminiProvider) Generate(ctx context.Context, req GenerateRequest) (*GenerateResponse, error) {
+	genModel := p.model(req)
+
+	// Generate content
+	resp, err := genModel.GenerateContent(ctx, genai.Text(req.Prompt))
+	if err != nil {
+		return nil, geminiError(err)
+	}
+
+	if len(resp.Candidates) == 0 {
+		return nil, fmt.Errorf("no response generated from the AI")
+	}
+	finishReason := geminiFinishReason(resp.Candidates[0].FinishReason)
+	if resp.Candidates[0].Content == nil {
+		return nil, fmt.Errorf("no response generated from the AI (finish reason: %s)", finishReason)
+	}
+
+	response := &GenerateResponse{
+		Text:         candidateText(resp),
+		Model:        req.Model,
+		FinishReason: finishReason,
+	}
+	if usage := geminiUsage(resp); usage != nil {
+		response.Usage = *usage
+	}
+	return response, nil
+}
+
+// GenerateStream generates content based on the given request, yielding text as it arrives
+func (p *GeminiProvider) GenerateStream(ctx context.Context, req GenerateRequest) (<-chan StreamChunk, error) {
+	genModel := p.model(req)
+
+	iter := genModel.GenerateContentStream(ctx, genai.Text(req.Prompt))
+
+	chunks := make(chan StreamChunk, 1)
+	go func() {
+		defer close(chunks)
+
+		// Every streamed response carries the usage so far, so the last one
+		// wins; the finish reason arrives with the final response
+		var usage *Usage
+		var finishReason string
+		for {
+			resp, err := iter.Next()
+			if err == iterator.Done {
+				sendChunk(ctx, chunks, StreamChunk{Usage: usage, FinishReason: finishReason})
+				return
+			}
+			if err != nil {
+				sendError(ctx, chunks, geminiError(err))
+				return
+			}
+
+			if u := geminiUsage(resp); u != nil {
+				usage = u
+			}
+			if len(resp.Candidates) > 0 && resp.Candidates[0].FinishReason != genai.FinishReasonUnspecified {
+				finishReason = geminiFinishReason(resp.Candidates[0].FinishReason)
+			}
+
+			text := candidateText(resp)
+			if text == "" {
+				continue
+			}
+			if !sendChunk(ctx, chunks, StreamChunk{Text: text}) {
+				return
+			}
+		}
+	}()
+
+	return chunks, nil
+}
+
+// CountTokens returns the number of tokens text occupies for the given model
+func (p *GeminiProvider) CountTokens(ctx context.Context, model, text string) (int, error) {
+	resp, err := p.client.GenerativeModel(model).CountTokens(ctx, genai.Text(text))
+	if err != nil {
+		return 0, fmt.Errorf("error counting tokens: %w", err)
+	}
+	return int(resp.TotalTokens), nil
+}
+
+// model returns the generative model configured for the request
+func (p *GeminiProvider) model(req GenerateRequest) *genai.GenerativeModel {
+	// Use the specified model
+	genModel := p.client.GenerativeModel(req.Model)
+
+	// Set generation parameters
+	genModel.SetTemperature(req.Temperature)
+	if req.ResponseMIMEType != "" {
+		genModel.ResponseMIMEType = req.ResponseMIMEType
+	}
+	if req.ResponseSchema != nil {
+		genModel.ResponseMIMEType = JSONMIMEType
+		genModel.ResponseSchema = geminiSchema(req.ResponseSchema)
+	}
+
+	return genModel
+}
+
+// geminiSchema converts a schema to the Gemini representation
+func geminiSchema(s *Schema) *genai.Schema {
+	result := &genai.Schema{
+		Description: s.Description,
+		Nullable:    s.Nullable,
+		Enum:        s.Enum,
+		Required:    s.Required,
+	}
+
+	switch s.Type {
+	case SchemaObject:
+		result.Type = genai.TypeObject
+	case SchemaArray:
+		result.Type = genai.TypeArray
+	case SchemaInteger:
+		result.Type = genai.TypeInteger
+	case SchemaNumber:
+		result.Type = genai.TypeNumber
+	case SchemaBoolean:
+		result.Type = genai.TypeBoolean
+	default:
+		result.Type = genai.TypeString
+	}
+
+	if s.Items != nil {
+		result.Items = geminiSchema(s.Items)
+	}
+	if len(s.Properties) > 0 {
+		result.Properties = map[string]*genai.Schema{}
+		for name, property := range s.Properties {
+			result.Properties[name] = geminiSchema(property)
+		}
+	}
+
+	return result
+}
+
+// geminiError converts a Gemini error, turning safety blocks into a BlockedError
+func geminiError(err error) error {
+	var blocked *genai.BlockedError
+	if !errors.As(err, &blocked) {
+		return fmt.Errorf("error generating content: %w", err)
+	}
+
+	result := &BlockedError{Provider: ProviderGemini}
+	var ratings []*genai.SafetyRating
+	if blocked.PromptFeedback != nil {
+		result.PromptBlocked = true
+		result.Reason = FinishOther
+		if blocked.PromptFeedback.BlockReason == genai.BlockReasonSafety {
+			result.Reason = FinishSafety
+		}
+		ratings = blocked.PromptFeedback.SafetyRatings
+	}
+	if blocked.Candidate != nil {
+		result.Reason = geminiFinishReason(blocked.Candidate.FinishReason)
+		ratings = append(ratings, blocked.Candidate.SafetyRatings...)
+	}
+
+	// Report the categories that caused the block, or were rated likely harmful
+	for _, rating := range ratings {
+		if rating.Blocked || rating.Probability >= genai.HarmProbabilityMedium {
+			result.Categories = append(result.Categories, strings.TrimPrefix(rating.Category.String(), "HarmCategory"))
+		}
+	}
+
+	return result
+}
+
+// geminiFinishReason normalizes a Gemini finish reason
+func geminiFinishReason(reason genai.FinishReason) string {
+	switch reason {
+	case genai.FinishReasonUnspecified:
+		return ""
+	case genai.FinishReasonStop:
+		return FinishStop
+	case genai.FinishReasonMaxTokens:
+		return FinishMaxTokens
+	case genai.FinishReasonSafety:
+		return FinishSafety
+	case genai.FinishReasonRecitation:
+		return FinishRecitation
+	default:
+		return FinishOther
+	}
+}
+
+// geminiUsage returns the token usage reported in a response, if any
+func geminiUsage(resp *genai.GenerateContentResponse) *Usage {
+	if resp.UsageMetadata == nil {
+		return nil
+	}
+	return &Usage{
+		PromptTokens:    int(resp.UsageMetadata.PromptTokenCount),
+		CandidateTokens: int(resp.UsageMetadata.CandidatesTokenCount),
+	}
+}
+
+// candidateText extracts the text of the first candidate in a response
+func candidateText(resp *genai.GenerateContentResponse) string {
+	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+		return ""
+	}
+
+	var result strings.Builder
+	for _, part := range resp.Candidates[0].Content.Parts {
+		result.WriteString(fmt.Sprintf("%v", part))
+	}
+	return result.String()
+}
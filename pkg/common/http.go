@@ -0,0 +1,89 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HTTPError is returned by HTTP-based providers when the API responds with a non-2xx status
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	Body       string
+	// RetryAfter is the delay requested by the server's Retry-After header, if any
+	RetryAfter time.Duration
+}
+
+func (e *HTTPError) Error() string {
+	body := strings.TrimSpace(e.Body)
+	if len(body) > 500 {
+		body = body[:500] + "..."
+	}
+	if body == "" {
+		return fmt.Sprintf("API request failed: %s", e.Status)
+	}
+	return fmt.Sprintf("API request failed: %s: %s", e.Status, body)
+}
+
+// postJSON sends body as JSON to url and decodes the JSON response into out
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, body, out interface{}) error {
+	respBody, err := postJSONStream(ctx, client, url, headers, body)
+	if err != nil {
+		return err
+	}
+	defer respBody.Close()
+
+	data, err := io.ReadAll(respBody)
+	if err != nil {
+		return fmt.Errorf("error reading response: %w", err)
+	}
+
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("error decoding response: %v", err)
+	}
+
+	return nil
+}
+
+// postJSONStream sends body as JSON to url and returns the response body for
+// incremental reading. The caller must close the returned reader.
+func postJSONStream(ctx context.Context, client *http.Client, url string, headers map[string]string, body interface{}) (io.ReadCloser, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(resp.Body)
+		retryAfter, _ := parseRetryAfter(resp.Header.Get("Retry-After"))
+		return nil, &HTTPError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Body:       string(respBody),
+			RetryAfter: retryAfter,
+		}
+	}
+
+	return resp.Body, nil
+}
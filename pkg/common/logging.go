@@ -0,0 +1,190 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log formats selectable with --log-format
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// ValidateLogFormat checks a --log-format value
+func ValidateLogFormat(format string) error {
+	switch strings.ToLower(format) {
+	case LogFormatText, LogFormatJSON:
+		return nil
+	default:
+		return fmt.Errorf("unknown log format %q (supported: text, json)", format)
+	}
+}
+
+// NewLogger creates the logger of a tool writing to w. The text format is
+// meant for people: "[Tool] message key=value", with a timestamp and source
+// location in verbose mode. The JSON format writes one object per line with
+// the tool name in the tool field. Debug messages are only written in verbose
+// mode.
+func NewLogger(w io.Writer, toolName, format string, verbose bool) *slog.Logger {
+	level := slog.LevelInfo
+	if verbose {
+		level = slog.LevelDebug
+	}
+
+	if strings.ToLower(format) == LogFormatJSON {
+		handler := slog.NewJSONHandler(w, &slog.HandlerOptions{
+			Level:       level,
+			ReplaceAttr: jsonDurationsInSeconds,
+		})
+		return slog.New(handler).With("tool", toolName)
+	}
+
+	return slog.New(&textHandler{
+		mu:      &sync.Mutex{},
+		w:       w,
+		prefix:  fmt.Sprintf("[%s] ", toolName),
+		level:   level,
+		verbose: verbose,
+	})
+}
+
+// jsonDurationsInSeconds writes durations as fractional seconds instead of nanoseconds
+func jsonDurationsInSeconds(groups []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() == slog.KindDuration {
+		return slog.Float64(a.Key, a.Value.Duration().Seconds())
+	}
+	return a
+}
+
+// textHandler is the human-readable slog handler, formatting records like the
+// standard logger did before the toolkit moved to slog
+type textHandler struct {
+	mu      *sync.Mutex
+	w       io.Writer
+	prefix  string
+	level   slog.Level
+	verbose bool
+
+	// attrs are the preformatted attributes added with WithAttrs
+	attrs string
+	// group is the dotted key prefix added with WithGroup
+	group string
+}
+
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	var sb strings.Builder
+	sb.WriteString(h.prefix)
+
+	if h.verbose {
+		sb.WriteString(r.Time.Format("2006/01/02 15:04:05 "))
+		if r.PC != 0 {
+			frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+			fmt.Fprintf(&sb, "%s:%d: ", filepath.Base(frame.File), frame.Line)
+		}
+	}
+
+	switch {
+	case r.Level >= slog.LevelError:
+		sb.WriteString("Error: ")
+	case r.Level >= slog.LevelWarn:
+		sb.WriteString("Warning: ")
+	}
+
+	sb.WriteString(r.Message)
+	sb.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		writeTextAttr(&sb, h.group, a)
+		return true
+	})
+	sb.WriteString("\n")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, sb.String())
+	return err
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var sb strings.Builder
+	sb.WriteString(h.attrs)
+	for _, a := range attrs {
+		writeTextAttr(&sb, h.group, a)
+	}
+
+	clone := *h
+	clone.attrs = sb.String()
+	return &clone
+}
+
+func (h *textHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	clone := *h
+	clone.group = h.group + name + "."
+	return &clone
+}
+
+// writeTextAttr writes " key=value", quoting values that contain spaces
+func writeTextAttr(sb *strings.Builder, group string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+
+	if a.Value.Kind() == slog.KindGroup {
+		prefix := group
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, attr := range a.Value.Group() {
+			writeTextAttr(sb, prefix, attr)
+		}
+		return
+	}
+
+	var value string
+	switch a.Value.Kind() {
+	case slog.KindDuration:
+		value = a.Value.Duration().Round(time.Millisecond).String()
+	default:
+		value = a.Value.String()
+	}
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		value = strconv.Quote(value)
+	}
+
+	sb.WriteString(" ")
+	sb.WriteString(group)
+	sb.WriteString(a.Key)
+	sb.WriteString("=")
+	sb.WriteString(value)
+}
+
+// PrepareLogger configures logging for a tool based on the log format and
+// verbosity. Messages of the standard log package are routed to the same
+// handler.
+func PrepareLogger(toolName, format string, verbose bool) {
+	// slog.SetDefault sends the standard logger through the handler, which
+	// already adds the prefix and timestamp
+	log.SetPrefix("")
+	log.SetFlags(0)
+
+	slog.SetDefault(NewLogger(os.Stderr, toolName, format, verbose))
+}
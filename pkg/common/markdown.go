@@ -0,0 +1,415 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CodeBlock is a fenced code block found in Markdown text
+type CodeBlock struct {
+	// Language is the first word of the info string, lowercased
+	Language string
+	// Info is the full info string after the opening fence
+	Info string
+	// Attributes holds the key=value pairs and bare words following the language
+	Attributes map[string]string
+	// Code is the content of the block without the fences
+	Code string
+	// Fence is the opening fence, e.g. "```" or "~~~~"
+	Fence string
+	// Closed is false when the text ends before the closing fence
+	Closed bool
+	// StartLine and EndLine are the 1-based lines of the opening and closing fences
+	StartLine int
+	EndLine   int
+	// Start and End are the byte offsets of the block, fences included
+	Start int
+	End   int
+}
+
+// ParseCodeBlocks returns the fenced code blocks of a Markdown text following
+// the CommonMark rules: fences are runs of at least three backticks or tildes
+// indented by at most three spaces, a block is closed only by a fence of the
+// same character that is at least as long, and an unclosed block runs to the
+// end of the text or of the list item it is in. Within list items, the
+// indentation is counted from the column of the item's content.
+func ParseCodeBlocks(text string) []CodeBlock {
+	var blocks []CodeBlock
+	var open *CodeBlock
+	var indent int
+	var body []string
+
+	// items holds the content columns of the list items enclosing the current line
+	var items []int
+
+	offset := 0
+	lineCount := 0
+	lines := strings.SplitAfter(text, "\n")
+	for i, raw := range lines {
+		if raw == "" {
+			continue
+		}
+		lineCount = i + 1
+		line := strings.TrimRight(raw, "\r\n")
+
+		if open != nil {
+			base := containerColumn(items)
+			switch {
+			case isClosingFence(line, open.Fence, base):
+				open.Code = strings.Join(body, "\n")
+				open.Closed = true
+				open.EndLine = i + 1
+				open.End = offset + len(raw)
+				blocks = append(blocks, *open)
+				open = nil
+				offset += len(raw)
+				continue
+			case strings.TrimSpace(line) != "" && leadingSpaces(line) < base:
+				// The line is outside the list item, which ends the block
+				open.Code = strings.Join(body, "\n")
+				open.EndLine = i
+				open.End = offset
+				blocks = append(blocks, *open)
+				open = nil
+			default:
+				body = append(body, trimIndent(line, indent))
+				offset += len(raw)
+				continue
+			}
+		}
+
+		if strings.TrimSpace(line) != "" {
+			// Leave the list items the line is not indented under
+			column := leadingSpaces(line)
+			for len(items) > 0 && column < items[len(items)-1] {
+				items = items[:len(items)-1]
+			}
+
+			// Enter the list items the line starts, which may open with a fence
+			for column-containerColumn(items) <= 3 {
+				width, ok := listMarker(line[column:])
+				if !ok {
+					break
+				}
+				content := column + width
+				items = append(items, content)
+				line = strings.Repeat(" ", content) + line[min(content, len(line)):]
+				column = leadingSpaces(line)
+			}
+
+			if fence, fenceIndent, info, ok := openingFence(line, containerColumn(items)); ok {
+				open = &CodeBlock{
+					Info:      info,
+					Fence:     fence,
+					StartLine: i + 1,
+					Start:     offset,
+				}
+				open.Language, open.Attributes = parseInfoString(info)
+				indent = fenceIndent
+				body = nil
+			}
+		}
+
+		offset += len(raw)
+	}
+
+	if open != nil {
+		open.Code = strings.Join(body, "\n")
+		open.EndLine = lineCount
+		open.End = len(text)
+		blocks = append(blocks, *open)
+	}
+
+	return blocks
+}
+
+// openingFence parses a line opening a fenced code block, which must be
+// indented by at most three spaces past base, the column of the enclosing
+// list item's content. The returned indent is the column of the fence.
+func openingFence(line string, base int) (fence string, indent int, info string, ok bool) {
+	indent = leadingSpaces(line)
+	if indent < base || indent-base > 3 {
+		return "", 0, "", false
+	}
+	rest := line[indent:]
+	if rest == "" || (rest[0] != '`' && rest[0] != '~') {
+		return "", 0, "", false
+	}
+
+	n := fenceLength(rest)
+	if n < 3 {
+		return "", 0, "", false
+	}
+
+	info = strings.TrimSpace(rest[n:])
+	// Backtick fences cannot have backticks in the info string, which keeps
+	// inline code like ```x``` from opening a block
+	if rest[0] == '`' && strings.Contains(info, "`") {
+		return "", 0, "", false
+	}
+
+	return rest[:n], indent, info, true
+}
+
+// isClosingFence reports whether line closes a block opened with fence in a
+// list item whose content starts at column base (0 outside of lists)
+func isClosingFence(line, fence string, base int) bool {
+	lineIndent := leadingSpaces(line)
+	if lineIndent < base || lineIndent-base > 3 {
+		return false
+	}
+
+	rest := line[lineIndent:]
+	if rest == "" || rest[0] != fence[0] {
+		return false
+	}
+
+	n := fenceLength(rest)
+	return n >= len(fence) && strings.TrimSpace(rest[n:]) == ""
+}
+
+// listMarker reports whether s starts with a list item marker ("-", "*", "+",
+// "1." or "1)") and returns the width of the marker and the spaces after it
+func listMarker(s string) (int, bool) {
+	n := 0
+	switch {
+	case s == "":
+		return 0, false
+	case s[0] == '-' || s[0] == '*' || s[0] == '+':
+		n = 1
+	default:
+		for n < len(s) && n < 9 && s[n] >= '0' && s[n] <= '9' {
+			n++
+		}
+		if n == 0 || n == len(s) || (s[n] != '.' && s[n] != ')') {
+			return 0, false
+		}
+		n++
+	}
+
+	// The marker is followed by one to four spaces, or ends the line. With
+	// more spaces, the content is an indented code block one space in.
+	if n == len(s) {
+		return n + 1, true
+	}
+	spaces := leadingSpaces(s[n:])
+	switch {
+	case spaces == 0:
+		return 0, false
+	case spaces > 4 || n+spaces == len(s):
+		return n + 1, true
+	default:
+		return n + spaces, true
+	}
+}
+
+// containerColumn returns the column the content of the innermost list item
+// starts at, or 0 outside of lists
+func containerColumn(items []int) int {
+	if len(items) == 0 {
+		return 0
+	}
+	return items[len(items)-1]
+}
+
+// leadingSpaces returns the number of spaces line starts with
+func leadingSpaces(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// fenceLength returns the length of the run of fence characters s starts with
+func fenceLength(s string) int {
+	n := 0
+	for n < len(s) && s[n] == s[0] {
+		n++
+	}
+	return n
+}
+
+// trimIndent removes up to n leading spaces, the indentation of the opening fence
+func trimIndent(line string, n int) string {
+	for i := 0; i < n && strings.HasPrefix(line, " "); i++ {
+		line = line[1:]
+	}
+	return line
+}
+
+// parseInfoString splits an info string like `ts title="api.ts" linenos` into
+// the language and its attributes. Pandoc-style `{.ts title="x"}` is accepted too.
+func parseInfoString(info string) (string, map[string]string) {
+	info = strings.TrimSpace(info)
+	if strings.HasPrefix(info, "{") && strings.HasSuffix(info, "}") {
+		info = strings.TrimPrefix(strings.TrimSpace(info[1:len(info)-1]), ".")
+	}
+
+	words := splitInfoWords(info)
+	if len(words) == 0 {
+		return "", nil
+	}
+
+	language := strings.ToLower(words[0])
+	if strings.Contains(language, "=") {
+		// No language, only attributes
+		language = ""
+	} else {
+		words = words[1:]
+	}
+
+	var attributes map[string]string
+	for _, word := range words {
+		if attributes == nil {
+			attributes = map[string]string{}
+		}
+		key, value, _ := strings.Cut(word, "=")
+		attributes[key] = strings.Trim(value, `"'`)
+	}
+
+	return language, attributes
+}
+
+// splitInfoWords splits an info string at spaces outside of quotes
+func splitInfoWords(info string) []string {
+	var words []string
+	var word strings.Builder
+	var quote rune
+
+	for _, r := range info {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+			word.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			word.WriteRune(r)
+		case r == ' ' || r == '\t':
+			if word.Len() > 0 {
+				words = append(words, word.String())
+				word.Reset()
+			}
+		default:
+			word.WriteRune(r)
+		}
+	}
+	if word.Len() > 0 {
+		words = append(words, word.String())
+	}
+
+	return words
+}
+
+// CodeSelection is the policy for choosing the output among the code blocks of a response
+type CodeSelection string
+
+// Code selection policies
+const (
+	// SelectLanguage joins every block labeled with the target language
+	SelectLanguage CodeSelection = "language"
+	// SelectLargest takes the largest block labeled with the target language
+	SelectLargest CodeSelection = "largest"
+	// SelectConcat joins every block regardless of its language
+	SelectConcat CodeSelection = "concat"
+)
+
+// DefaultCodeSelection is the policy used when none is configured
+const DefaultCodeSelection = SelectLanguage
+
+// ParseCodeSelection validates a --code-blocks value
+func ParseCodeSelection(value string) (CodeSelection, error) {
+	switch policy := CodeSelection(strings.ToLower(strings.TrimSpace(value))); policy {
+	case "":
+		return DefaultCodeSelection, nil
+	case SelectLanguage, SelectLargest, SelectConcat:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown code block selection %q (supported: language, largest, concat)", value)
+	}
+}
+
+// SelectCode extracts the output from a model response according to policy.
+// languages lists the names a block of the target language may be labeled
+// with; when no block carries one of them, unlabeled blocks are used, then
+// any block. A response without code blocks is returned as is.
+//
+// When the target is Markdown the response is the document itself, so code
+// blocks within it are kept; only a fence wrapping the whole response, or
+// blocks explicitly labeled Markdown, are unwrapped.
+func SelectCode(text string, languages []string, policy CodeSelection) string {
+	blocks := ParseCodeBlocks(text)
+
+	if containsString(languages, "markdown") || containsString(languages, "md") {
+		if inner, ok := unwrapDocument(text); ok {
+			return inner
+		}
+		blocks = filterBlocks(blocks, languages)
+		if len(blocks) == 0 {
+			return strings.TrimSpace(text)
+		}
+	}
+
+	if len(blocks) == 0 {
+		return strings.TrimSpace(text)
+	}
+
+	if policy != SelectConcat {
+		if matching := filterBlocks(blocks, languages); len(matching) > 0 {
+			blocks = matching
+		} else if unlabeled := filterBlocks(blocks, []string{""}); len(unlabeled) > 0 {
+			blocks = unlabeled
+		}
+	}
+
+	if policy == SelectLargest {
+		largest := blocks[0]
+		for _, block := range blocks[1:] {
+			if len(block.Code) > len(largest.Code) {
+				largest = block
+			}
+		}
+		return strings.TrimSpace(largest.Code)
+	}
+
+	parts := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		if code := strings.TrimSpace(block.Code); code != "" {
+			parts = append(parts, code)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// filterBlocks returns the blocks labeled with one of languages
+func filterBlocks(blocks []CodeBlock, languages []string) []CodeBlock {
+	var matching []CodeBlock
+	for _, block := range blocks {
+		if containsString(languages, block.Language) {
+			matching = append(matching, block)
+		}
+	}
+	return matching
+}
+
+// unwrapDocument returns the content of a response wrapped in a single
+// Markdown fence. Models often reuse a three-backtick fence for the wrapper
+// while the document inside has its own blocks, which CommonMark would close
+// early, so the wrapper is matched on the first and last lines only.
+func unwrapDocument(text string) (string, bool) {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	if len(lines) < 2 {
+		return "", false
+	}
+
+	fence, _, info, ok := openingFence(strings.TrimRight(lines[0], "\r"), 0)
+	if !ok {
+		return "", false
+	}
+	if language, _ := parseInfoString(info); language != "markdown" && language != "md" {
+		return "", false
+	}
+	if !isClosingFence(strings.TrimRight(lines[len(lines)-1], "\r"), fence, 0) {
+		return "", false
+	}
+
+	return strings.TrimSpace(strings.Join(lines[1:len(lines)-1], "\n")), true
+}
@@ -0,0 +1,136 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCodeBlocks(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []CodeBlock
+	}{
+		{
+			name: "backticks with attributes",
+			text: "Intro\n```ts title=\"api.ts\" linenos\nconst a = 1\n```\nOutro\n",
+			want: []CodeBlock{{
+				Language:   "ts",
+				Info:       `ts title="api.ts" linenos`,
+				Attributes: map[string]string{"title": "api.ts", "linenos": ""},
+				Code:       "const a = 1",
+				Fence:      "```",
+				Closed:     true,
+				StartLine:  2,
+				EndLine:    4,
+				Start:      6,
+				End:        51,
+			}},
+		},
+		{
+			name: "tildes",
+			text: "~~~go\nx := 1\n~~~\n",
+			want: []CodeBlock{{Language: "go", Info: "go", Code: "x := 1", Fence: "~~~", Closed: true, StartLine: 1, EndLine: 3, Start: 0, End: 17}},
+		},
+		{
+			name: "longer fence holds a shorter one",
+			text: "````markdown\n```go\nx := 1\n```\n````",
+			want: []CodeBlock{{Language: "markdown", Info: "markdown", Code: "```go\nx := 1\n```", Fence: "````", Closed: true, StartLine: 1, EndLine: 5, Start: 0, End: 34}},
+		},
+		{
+			name: "indented by three spaces",
+			text: "   ```go\n   x := 1\n     y := 2\n   ```",
+			want: []CodeBlock{{Language: "go", Info: "go", Code: "x := 1\n  y := 2", Fence: "```", Closed: true, StartLine: 1, EndLine: 4, Start: 0, End: 37}},
+		},
+		{
+			name: "indented by four spaces",
+			text: "    ```go\n    x := 1\n    ```",
+		},
+		{
+			name: "closing fence indented by four spaces",
+			text: "```\na\n    ```\n",
+			want: []CodeBlock{{Code: "a\n    ```", Fence: "```", StartLine: 1, EndLine: 3, Start: 0, End: 14}},
+		},
+		{
+			name: "inline code",
+			text: "Use ```x``` here\n```x``` too",
+		},
+		{
+			name: "unclosed",
+			text: "```py\nprint(1)\n",
+			want: []CodeBlock{{Language: "py", Info: "py", Code: "print(1)", Fence: "```", StartLine: 1, EndLine: 2, Start: 0, End: 15}},
+		},
+		{
+			name: "in a list item",
+			text: "1. Install:\n\n   ```sh\n   go install\n   ```\n",
+			want: []CodeBlock{{Language: "sh", Info: "sh", Code: "go install", Fence: "```", Closed: true, StartLine: 3, EndLine: 5, Start: 13, End: 43}},
+		},
+		{
+			name: "opening a nested list item",
+			text: "- a\n  - ```go\n    x := 1\n    ```\n",
+			want: []CodeBlock{{Language: "go", Info: "go", Code: "x := 1", Fence: "```", Closed: true, StartLine: 2, EndLine: 4, Start: 4, End: 33}},
+		},
+		{
+			name: "indented by four spaces in a list item",
+			text: "- a\n\n      ```go\n      x := 1\n      ```\n",
+		},
+		{
+			name: "ended by the end of the list item",
+			text: "- ```go\n  x := 1\nDone\n```\n",
+			want: []CodeBlock{
+				{Language: "go", Info: "go", Code: "x := 1", Fence: "```", StartLine: 1, EndLine: 2, Start: 0, End: 17},
+				// The fence meant to close the block opens a new one
+				{Fence: "```", StartLine: 4, EndLine: 4, Start: 22, End: 26},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseCodeBlocks(tt.text)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseCodeBlocks(%q)\ngot:  %+v\nwant: %+v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectCode(t *testing.T) {
+	response := "Here you go:\n```ts\ntype A = string\n```\n```\nunlabeled\n```\n```py\nclass B:\n    pass\n```\n```ts\ntype LongerName = number\n```"
+
+	tests := []struct {
+		name      string
+		text      string
+		languages []string
+		policy    CodeSelection
+		want      string
+	}{
+		{"language", response, []string{"typescript", "ts"}, SelectLanguage, "type A = string\n\ntype LongerName = number"},
+		{"largest", response, []string{"typescript", "ts"}, SelectLargest, "type LongerName = number"},
+		{"concat", response, []string{"typescript", "ts"}, SelectConcat, "type A = string\n\nunlabeled\n\nclass B:\n    pass\n\ntype LongerName = number"},
+		{"unlabeled", "```\nx = 1\n```\n```js\ny\n```", []string{"python", "py"}, SelectLanguage, "x = 1"},
+		{"no blocks", "  plain text \n", []string{"go"}, SelectLanguage, "plain text"},
+		{"markdown keeps inner blocks", "# Title\n\n```go\nx := 1\n```", []string{"markdown", "md"}, SelectLanguage, "# Title\n\n```go\nx := 1\n```"},
+		{"markdown wrapper", "```markdown\n# Title\n\n```go\nx := 1\n```\n```", []string{"markdown", "md"}, SelectLanguage, "# Title\n\n```go\nx := 1\n```"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SelectCode(tt.text, tt.languages, tt.policy); got != tt.want {
+				t.Errorf("SelectCode\ngot:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCodeSelection(t *testing.T) {
+	for value, want := range map[string]CodeSelection{"": SelectLanguage, " Largest ": SelectLargest, "concat": SelectConcat} {
+		got, err := ParseCodeSelection(value)
+		if err != nil || got != want {
+			t.Errorf("ParseCodeSelection(%q) = %q, %v; want %q", value, got, err, want)
+		}
+	}
+	if _, err := ParseCodeSelection("first"); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
@@ -0,0 +1,180 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultOllamaBaseURL is the address of a local Ollama server
+const DefaultOllamaBaseURL = "http://localhost:11434"
+
+// DefaultOllamaModel is the model used with the ollama provider when --model is not set
+const DefaultOllamaModel = "llama3.1"
+
+// OllamaProvider talks to a self-hosted Ollama server
+type OllamaProvider struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewOllamaProvider creates a new OllamaProvider. An empty baseURL uses OLLAMA_HOST or the local default.
+func NewOllamaProvider(baseURL string) *OllamaProvider {
+	if baseURL == "" {
+		baseURL = GetEnvOrDefault("OLLAMA_HOST", DefaultOllamaBaseURL)
+	}
+	if !strings.HasPrefix(baseURL, "http://") && !strings.HasPrefix(baseURL, "https://") {
+		baseURL = "http://" + baseURL
+	}
+
+	return &OllamaProvider{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{},
+	}
+}
+
+// ollamaGenerateRequest is the request body of the /api/generate endpoint
+type ollamaGenerateRequest struct {
+	Model   string                 `json:"model"`
+	Prompt  string                 `json:"prompt"`
+	Stream  bool                   `json:"stream"`
+	Format  interface{}            `json:"format,omitempty"`
+	Options map[string]interface{} `json:"options,omitempty"`
+}
+
+// ollamaGenerateResponse is the subset of the /api/generate response we use
+type ollamaGenerateResponse struct {
+	Model      string `json:"model"`
+	Response   string `json:"response"`
+	Done       bool   `json:"done"`
+	DoneReason string `json:"done_reason"`
+
+	// Token counts, reported once the response is done
+	PromptEvalCount int `json:"prompt_eval_count"`
+	EvalCount       int `json:"eval_count"`
+}
+
+// Name returns the provider identifier
+func (p *OllamaProvider) Name() string {
+	return ProviderOllama
+}
+
+// CountTokens returns a local estimate, as the API has no tokenizer endpoint
+func (p *OllamaProvider) CountTokens(ctx context.Context, model, text string) (int, error) {
+	return EstimateTokens(text), nil
+}
+
+// Close is a no-op for the HTTP based provider
+func (p *OllamaProvider) Close() error {
+	return nil
+}
+
+// Generate generates content based on the given request
+func (p *OllamaProvider) Generate(ctx context.Context, req GenerateRequest) (*GenerateResponse, error) {
+	body := newOllamaGenerateRequest(req, false)
+
+	var resp ollamaGenerateResponse
+	if err := postJSON(ctx, p.httpClient, p.baseURL+"/api/generate", nil, body, &resp); err != nil {
+		return nil, fmt.Errorf("error generating content: %w", err)
+	}
+
+	if resp.Response == "" {
+		return nil, fmt.Errorf("no response generated from the AI")
+	}
+
+	model := resp.Model
+	if model == "" {
+		model = req.Model
+	}
+
+	return &GenerateResponse{
+		Text:         resp.Response,
+		Model:        model,
+		Usage:        resp.usage(),
+		FinishReason: ollamaFinishReason(resp.DoneReason),
+	}, nil
+}
+
+// GenerateStream generates content based on the given request, yielding text as it arrives
+func (p *OllamaProvider) GenerateStream(ctx context.Context, req GenerateRequest) (<-chan StreamChunk, error) {
+	body := newOllamaGenerateRequest(req, true)
+
+	respBody, err := postJSONStream(ctx, p.httpClient, p.baseURL+"/api/generate", nil, body)
+	if err != nil {
+		return nil, fmt.Errorf("error generating content: %w", err)
+	}
+
+	chunks := make(chan StreamChunk, 1)
+	go func() {
+		defer close(chunks)
+		defer respBody.Close()
+
+		// Ollama streams one JSON object per line until "done" is true
+		decoder := json.NewDecoder(respBody)
+		for {
+			var event ollamaGenerateResponse
+			if err := decoder.Decode(&event); err != nil {
+				if err != io.EOF {
+					sendError(ctx, chunks, fmt.Errorf("error decoding stream event: %w", err))
+				}
+				return
+			}
+			if event.Response != "" {
+				if !sendChunk(ctx, chunks, StreamChunk{Text: event.Response}) {
+					return
+				}
+			}
+			if event.Done {
+				usage := event.usage()
+				sendChunk(ctx, chunks, StreamChunk{Usage: &usage, FinishReason: ollamaFinishReason(event.DoneReason)})
+				return
+			}
+		}
+	}()
+
+	return chunks, nil
+}
+
+// usage returns the token usage reported in a completed response
+func (r *ollamaGenerateResponse) usage() Usage {
+	return Usage{
+		PromptTokens:    r.PromptEvalCount,
+		CandidateTokens: r.EvalCount,
+	}
+}
+
+// ollamaFinishReason normalizes the done_reason of a completed response
+func ollamaFinishReason(reason string) string {
+	switch reason {
+	case "", "stop":
+		return FinishStop
+	case "length":
+		return FinishMaxTokens
+	default:
+		return FinishOther
+	}
+}
+
+// newOllamaGenerateRequest builds the /api/generate request body for req
+func newOllamaGenerateRequest(req GenerateRequest, stream bool) ollamaGenerateRequest {
+	body := ollamaGenerateRequest{
+		Model:  req.Model,
+		Prompt: req.Prompt,
+		Stream: stream,
+		Options: map[string]interface{}{
+			"temperature": req.Temperature,
+		},
+	}
+
+	// Ollama accepts either "json" or a full JSON schema as the format
+	if req.ResponseSchema != nil {
+		body.Format = req.ResponseSchema.JSONSchema()
+	} else if req.ResponseMIMEType == JSONMIMEType {
+		body.Format = "json"
+	}
+
+	return body
+}
@@ -0,0 +1,269 @@
+package common
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// DefaultOpenAIBaseURL is the base URL of the official OpenAI API
+const DefaultOpenAIBaseURL = "https://api.openai.com/v1"
+
+// DefaultOpenAIModel is the model used with the openai provider when --model is not set
+const DefaultOpenAIModel = "gpt-4o-mini"
+
+// OpenAIProvider talks to any OpenAI-compatible chat completions endpoint
+// (OpenAI itself, vLLM, LM Studio, llama.cpp server, LiteLLM, ...)
+type OpenAIProvider struct {
+	apiKey     string
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewOpenAIProvider creates a new OpenAIProvider. An empty baseURL uses the official OpenAI API.
+func NewOpenAIProvider(apiKey, baseURL string) *OpenAIProvider {
+	if baseURL == "" {
+		baseURL = DefaultOpenAIBaseURL
+	}
+
+	return &OpenAIProvider{
+		apiKey:     strings.ReplaceAll(apiKey, "\r", ""),
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{},
+	}
+}
+
+// openAIMessage is a single chat message in the chat completions API
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// openAIChatRequest is the request body of the chat completions API
+type openAIChatRequest struct {
+	Model       string          `json:"model"`
+	Messages    []openAIMessage `json:"messages"`
+	Temperature float32         `json:"temperature"`
+	Stream      bool            `json:"stream,omitempty"`
+
+	ResponseFormat *openAIResponseFormat `json:"response_format,omitempty"`
+}
+
+// openAIResponseFormat selects JSON mode or schema-constrained structured output
+type openAIResponseFormat struct {
+	Type       string                `json:"type"`
+	JSONSchema *openAIJSONSchemaSpec `json:"json_schema,omitempty"`
+}
+
+// openAIJSONSchemaSpec names the schema of a structured output request
+type openAIJSONSchemaSpec struct {
+	Name   string                 `json:"name"`
+	Schema map[string]interface{} `json:"schema"`
+}
+
+// openAIChatResponse is the subset of the chat completions response we use
+type openAIChatResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message      openAIMessage `json:"message"`
+		FinishReason string        `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *openAIUsage `json:"usage"`
+}
+
+// openAIUsage is the token usage reported by the chat completions API
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// openAIStreamResponse is a single server-sent event of a streamed chat completion
+type openAIStreamResponse struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *openAIUsage `json:"usage"`
+}
+
+// Name returns the provider identifier
+func (p *OpenAIProvider) Name() string {
+	return ProviderOpenAI
+}
+
+// CountTokens returns a local estimate, as the API has no tokenizer endpoint
+func (p *OpenAIProvider) CountTokens(ctx context.Context, model, text string) (int, error) {
+	return EstimateTokens(text), nil
+}
+
+// Close is a no-op for the HTTP based provider
+func (p *OpenAIProvider) Close() error {
+	return nil
+}
+
+// Generate generates content based on the given request
+func (p *OpenAIProvider) Generate(ctx context.Context, req GenerateRequest) (*GenerateResponse, error) {
+	body := newOpenAIChatRequest(req, false)
+
+	var resp openAIChatResponse
+	if err := postJSON(ctx, p.httpClient, p.baseURL+"/chat/completions", p.headers(), body, &resp); err != nil {
+		return nil, fmt.Errorf("error generating content: %w", err)
+	}
+
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("no response generated from the AI")
+	}
+
+	finishReason := openAIFinishReason(resp.Choices[0].FinishReason)
+	if finishReason == FinishSafety {
+		return nil, &BlockedError{Provider: ProviderOpenAI, Reason: finishReason}
+	}
+
+	model := resp.Model
+	if model == "" {
+		model = req.Model
+	}
+
+	response := &GenerateResponse{
+		Text:         resp.Choices[0].Message.Content,
+		Model:        model,
+		FinishReason: finishReason,
+	}
+	if resp.Usage != nil {
+		response.Usage = resp.Usage.toUsage()
+	}
+	return response, nil
+}
+
+// GenerateStream generates content based on the given request, yielding text as it arrives
+func (p *OpenAIProvider) GenerateStream(ctx context.Context, req GenerateRequest) (<-chan StreamChunk, error) {
+	body := newOpenAIChatRequest(req, true)
+
+	respBody, err := postJSONStream(ctx, p.httpClient, p.baseURL+"/chat/completions", p.headers(), body)
+	if err != nil {
+		return nil, fmt.Errorf("error generating content: %w", err)
+	}
+
+	chunks := make(chan StreamChunk, 1)
+	go func() {
+		defer close(chunks)
+		defer respBody.Close()
+
+		// The response is a series of server-sent events: "data: {json}" lines
+		// terminated by "data: [DONE]"
+		scanner := bufio.NewScanner(respBody)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if !strings.HasPrefix(line, "data:") {
+				continue
+			}
+			data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+			if data == "[DONE]" {
+				return
+			}
+
+			var event openAIStreamResponse
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				sendError(ctx, chunks, fmt.Errorf("error decoding stream event: %v", err))
+				return
+			}
+			// Servers that report usage while streaming send it in a final event without choices
+			if event.Usage != nil {
+				usage := event.Usage.toUsage()
+				if !sendChunk(ctx, chunks, StreamChunk{Usage: &usage}) {
+					return
+				}
+			}
+			if len(event.Choices) == 0 {
+				continue
+			}
+
+			// The last event of a choice carries its finish reason
+			chunk := StreamChunk{Text: event.Choices[0].Delta.Content}
+			if event.Choices[0].FinishReason != "" {
+				chunk.FinishReason = openAIFinishReason(event.Choices[0].FinishReason)
+				if chunk.FinishReason == FinishSafety {
+					sendError(ctx, chunks, &BlockedError{Provider: ProviderOpenAI, Reason: FinishSafety})
+					return
+				}
+			}
+			if chunk.Text == "" && chunk.FinishReason == "" {
+				continue
+			}
+			if !sendChunk(ctx, chunks, chunk) {
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			sendError(ctx, chunks, fmt.Errorf("error reading stream: %w", err))
+		}
+	}()
+
+	return chunks, nil
+}
+
+// newOpenAIChatRequest builds the chat completions request body for req
+func newOpenAIChatRequest(req GenerateRequest, stream bool) openAIChatRequest {
+	body := openAIChatRequest{
+		Model: req.Model,
+		Messages: []openAIMessage{
+			{Role: "user", Content: req.Prompt},
+		},
+		Temperature: req.Temperature,
+		Stream:      stream,
+	}
+
+	// Request structured output when a schema is given, plain JSON mode otherwise
+	if req.ResponseSchema != nil {
+		body.ResponseFormat = &openAIResponseFormat{
+			Type: "json_schema",
+			JSONSchema: &openAIJSONSchemaSpec{
+				Name:   "response",
+				Schema: req.ResponseSchema.JSONSchema(),
+			},
+		}
+	} else if req.ResponseMIMEType == JSONMIMEType {
+		body.ResponseFormat = &openAIResponseFormat{Type: "json_object"}
+	}
+
+	return body
+}
+
+// openAIFinishReason normalizes a chat completions finish reason
+func openAIFinishReason(reason string) string {
+	switch reason {
+	case "":
+		return ""
+	case "stop", "tool_calls", "function_call":
+		return FinishStop
+	case "length":
+		return FinishMaxTokens
+	case "content_filter":
+		return FinishSafety
+	default:
+		return FinishOther
+	}
+}
+
+// toUsage converts the reported usage to the common representation
+func (u *openAIUsage) toUsage() Usage {
+	return Usage{
+		PromptTokens:    u.PromptTokens,
+		CandidateTokens: u.CompletionTokens,
+	}
+}
+
+// headers returns the HTTP headers sent with every request
+func (p *OpenAIProvider) headers() map[string]string {
+	headers := map[string]string{}
+	if p.apiKey != "" {
+		headers["Authorization"] = "Bearer " + p.apiKey
+	}
+	return headers
+}
@@ -0,0 +1,105 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Price is the cost of a model in USD per million tokens
+type Price struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// Cost returns the cost in USD of the given token usage
+func (p Price) Cost(usage Usage) float64 {
+	return (float64(usage.PromptTokens)*p.Input + float64(usage.CandidateTokens)*p.Output) / 1e6
+}
+
+// defaultPrices maps model name prefixes to list prices in USD per million
+// tokens. They are estimates for reporting and budgeting; override them with
+// --price-file when they change or for negotiated rates.
+var defaultPrices = map[string]Price{
+	// Gemini
+	"gemini-2.5-pro":        {Input: 1.25, Output: 10.00},
+	"gemini-2.5-flash":      {Input: 0.30, Output: 2.50},
+	"gemini-2.0-flash-lite": {Input: 0.075, Output: 0.30},
+	"gemini-2.0-flash":      {Input: 0.10, Output: 0.40},
+	"gemini-1.5-pro":        {Input: 1.25, Output: 5.00},
+	"gemini-1.5-flash":      {Input: 0.075, Output: 0.30},
+
+	// OpenAI
+	"gpt-4.1-nano":  {Input: 0.10, Output: 0.40},
+	"gpt-4.1-mini":  {Input: 0.40, Output: 1.60},
+	"gpt-4.1":       {Input: 2.00, Output: 8.00},
+	"gpt-4o-mini":   {Input: 0.15, Output: 0.60},
+	"gpt-4o":        {Input: 2.50, Output: 10.00},
+	"gpt-4-turbo":   {Input: 10.00, Output: 30.00},
+	"gpt-3.5-turbo": {Input: 0.50, Output: 1.50},
+	"o3-mini":       {Input: 1.10, Output: 4.40},
+	"o3":            {Input: 2.00, Output: 8.00},
+	"o1":            {Input: 15.00, Output: 60.00},
+}
+
+// PriceTable looks up model prices by the longest matching model name prefix
+type PriceTable struct {
+	prices map[string]Price
+}
+
+// DefaultPriceTable returns a table of the built-in list prices
+func DefaultPriceTable() *PriceTable {
+	prices := make(map[string]Price, len(defaultPrices))
+	for prefix, price := range defaultPrices {
+		prices[prefix] = price
+	}
+	return &PriceTable{prices: prices}
+}
+
+// LoadPriceTable returns the built-in prices overridden by those in a JSON
+// file mapping model name prefixes to {"input": ..., "output": ...} prices in
+// USD per million tokens. An empty path returns the built-in prices.
+func LoadPriceTable(path string) (*PriceTable, error) {
+	table := DefaultPriceTable()
+	if path == "" {
+		return table, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading price file: %v", err)
+	}
+
+	var prices map[string]Price
+	if err := json.Unmarshal(data, &prices); err != nil {
+		return nil, fmt.Errorf("error parsing price file %s: %v", path, err)
+	}
+	for prefix, price := range prices {
+		table.prices[strings.ToLower(prefix)] = price
+	}
+
+	return table, nil
+}
+
+// Lookup returns the price of a model served by the named provider. Models
+// served by Ollama run locally and are free; models missing from the table
+// report false.
+func (t *PriceTable) Lookup(provider, model string) (Price, bool) {
+	if strings.ToLower(provider) == ProviderOllama {
+		return Price{}, true
+	}
+
+	name := strings.TrimPrefix(strings.ToLower(model), "models/")
+
+	var best string
+	for prefix := range t.prices {
+		if strings.HasPrefix(name, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best == "" {
+		return Price{}, false
+	}
+	return t.prices[best], true
+}
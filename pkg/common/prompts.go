@@ -0,0 +1,173 @@
+package common
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// PromptTemplateExt is the file extension of prompt templates
+const PromptTemplateExt = ".tmpl"
+
+// ProjectPromptsDir is where a project keeps its prompt overrides, relative to the working directory
+const ProjectPromptsDir = ".ai-toolkit/prompts"
+
+// PromptSet is the collection of prompt templates a tool renders its prompts from
+type PromptSet struct {
+	tool  string
+	files fs.FS
+}
+
+var (
+	promptSetsMu sync.Mutex
+	promptSets   = map[string]*PromptSet{}
+)
+
+// RegisterPromptSet registers the built-in templates of a tool. files holds
+// the templates as <name>.tmpl at its root, usually an embedded directory.
+func RegisterPromptSet(tool string, files fs.FS) *PromptSet {
+	set := &PromptSet{
+		tool:  tool,
+		files: files,
+	}
+
+	promptSetsMu.Lock()
+	defer promptSetsMu.Unlock()
+	promptSets[tool] = set
+	return set
+}
+
+// LookupPromptSet returns the prompt set registered for a tool
+func LookupPromptSet(tool string) (*PromptSet, bool) {
+	promptSetsMu.Lock()
+	defer promptSetsMu.Unlock()
+	set, ok := promptSets[tool]
+	return set, ok
+}
+
+// PromptSets returns every registered prompt set, sorted by tool name
+func PromptSets() []*PromptSet {
+	promptSetsMu.Lock()
+	defer promptSetsMu.Unlock()
+
+	sets := make([]*PromptSet, 0, len(promptSets))
+	for _, set := range promptSets {
+		sets = append(sets, set)
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		return sets[i].tool < sets[j].tool
+	})
+	return sets
+}
+
+// Tool returns the name of the tool the prompts belong to
+func (s *PromptSet) Tool() string {
+	return s.tool
+}
+
+// Names returns the names of the built-in templates, sorted
+func (s *PromptSet) Names() []string {
+	matches, _ := fs.Glob(s.files, "*"+PromptTemplateExt)
+
+	names := make([]string, 0, len(matches))
+	for _, match := range matches {
+		names = append(names, strings.TrimSuffix(match, PromptTemplateExt))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// OverrideDirs returns the directories searched for overrides of this set's
+// templates, in order: the project directory, then the user config directory
+func (s *PromptSet) OverrideDirs() []string {
+	dirs := []string{filepath.Join(ProjectPromptsDir, s.tool)}
+	if configDir, err := os.UserConfigDir(); err == nil {
+		dirs = append(dirs, filepath.Join(configDir, "ai-toolkit", "prompts", s.tool))
+	}
+	return dirs
+}
+
+// Source returns the effective text of a template and where it was loaded
+// from: an override file path, or "built-in"
+func (s *PromptSet) Source(name string) (string, string, error) {
+	file := name + PromptTemplateExt
+
+	for _, dir := range s.OverrideDirs() {
+		path := filepath.Join(dir, file)
+		data, err := os.ReadFile(path)
+		if err == nil {
+			return string(data), path, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", "", fmt.Errorf("error reading prompt template %s: %v", path, err)
+		}
+	}
+
+	data, err := fs.ReadFile(s.files, file)
+	if err != nil {
+		return "", "", fmt.Errorf("unknown prompt template %s/%s", s.tool, name)
+	}
+	return string(data), "built-in", nil
+}
+
+// BuiltIn returns the text of the embedded template, ignoring overrides
+func (s *PromptSet) BuiltIn(name string) (string, error) {
+	data, err := fs.ReadFile(s.files, name+PromptTemplateExt)
+	if err != nil {
+		return "", fmt.Errorf("unknown prompt template %s/%s", s.tool, name)
+	}
+	return string(data), nil
+}
+
+// Render executes the effective template with data and returns the prompt
+func (s *PromptSet) Render(name string, data interface{}) (string, error) {
+	text, origin, err := s.Source(name)
+	if err != nil {
+		return "", err
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("error parsing prompt template %s/%s (%s): %v", s.tool, name, origin, err)
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("error rendering prompt template %s/%s (%s): %v", s.tool, name, origin, err)
+	}
+
+	return strings.TrimSpace(sb.String()), nil
+}
+
+// Export writes the built-in templates to dir/<tool>/<name>.tmpl and returns
+// the files written. Existing files are kept unless overwrite is set.
+func (s *PromptSet) Export(dir string, overwrite bool) ([]string, error) {
+	target := filepath.Join(dir, s.tool)
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return nil, fmt.Errorf("error creating prompt directory: %v", err)
+	}
+
+	var written []string
+	for _, name := range s.Names() {
+		path := filepath.Join(target, name+PromptTemplateExt)
+		if _, err := os.Stat(path); err == nil && !overwrite {
+			continue
+		}
+
+		text, err := s.BuiltIn(name)
+		if err != nil {
+			return written, err
+		}
+		if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+			return written, fmt.Errorf("error writing prompt template: %v", err)
+		}
+		written = append(written, path)
+	}
+
+	return written, nil
+}
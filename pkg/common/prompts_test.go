@@ -0,0 +1,180 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// chdir changes the working directory for a test
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	old, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(old) })
+}
+
+// writePrompt writes a template override under dir
+func writePrompt(t *testing.T, dir, name, text string) string {
+	t.Helper()
+	path := filepath.Join(dir, name+PromptTemplateExt)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// testPromptSet returns a prompt set for a made-up tool, with project and
+// user override directories under temporary directories
+func testPromptSet(t *testing.T) (set *PromptSet, projectDir, userDir string) {
+	t.Helper()
+	configHome := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+	project := t.TempDir()
+	chdir(t, project)
+
+	set = &PromptSet{tool: "greeter", files: fstest.MapFS{
+		"greet.tmpl":    {Data: []byte("Hello, {{.Name}}!\n")},
+		"farewell.tmpl": {Data: []byte("Goodbye, {{.Name}}.")},
+		"README.md":     {Data: []byte("not a template")},
+	}}
+	return set, filepath.Join(ProjectPromptsDir, "greeter"), filepath.Join(configHome, "ai-toolkit", "prompts", "greeter")
+}
+
+func TestPromptSetSource(t *testing.T) {
+	tests := []struct {
+		name        string
+		project     string
+		user        string
+		want        string
+		wantProject bool
+		wantUser    bool
+	}{
+		{name: "built-in", want: "Hello, {{.Name}}!\n"},
+		{name: "user override", user: "Hi {{.Name}}", want: "Hi {{.Name}}", wantUser: true},
+		{name: "project override", project: "Hey {{.Name}}", want: "Hey {{.Name}}", wantProject: true},
+		{name: "project before user", project: "Hey {{.Name}}", user: "Hi {{.Name}}", want: "Hey {{.Name}}", wantProject: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, projectDir, userDir := testPromptSet(t)
+			wantOrigin := "built-in"
+			if tt.user != "" {
+				path := writePrompt(t, userDir, "greet", tt.user)
+				if tt.wantUser {
+					wantOrigin = path
+				}
+			}
+			if tt.project != "" {
+				path := writePrompt(t, projectDir, "greet", tt.project)
+				if tt.wantProject {
+					wantOrigin = path
+				}
+			}
+
+			text, origin, err := set.Source("greet")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if text != tt.want || origin != wantOrigin {
+				t.Errorf("Source = %q from %s, want %q from %s", text, origin, tt.want, wantOrigin)
+			}
+
+			// Overrides never change what BuiltIn returns
+			if builtIn, err := set.BuiltIn("greet"); err != nil || builtIn != "Hello, {{.Name}}!\n" {
+				t.Errorf("BuiltIn = %q, %v", builtIn, err)
+			}
+		})
+	}
+}
+
+func TestPromptSetRender(t *testing.T) {
+	type greeting struct{ Name string }
+
+	tests := []struct {
+		name     string
+		override string
+		data     interface{}
+		want     string
+		wantErr  string
+	}{
+		{name: "built-in", data: greeting{"Ada"}, want: "Hello, Ada!"},
+		{name: "override", override: "  Hi {{.Name}}  \n", data: greeting{"Ada"}, want: "Hi Ada"},
+		{name: "parse error", override: "Hi {{.Name", data: greeting{"Ada"}, wantErr: "error parsing prompt template greeter/greet (.ai-toolkit/prompts/greeter/greet.tmpl)"},
+		{name: "unknown field", override: "Hi {{.Nickname}}", data: greeting{"Ada"}, wantErr: "error rendering prompt template greeter/greet (.ai-toolkit/prompts/greeter/greet.tmpl)"},
+		{name: "missing key", data: map[string]string{"Title": "Dr"}, wantErr: "error rendering prompt template greeter/greet (built-in)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, projectDir, _ := testPromptSet(t)
+			if tt.override != "" {
+				writePrompt(t, projectDir, "greet", tt.override)
+			}
+
+			got, err := set.Render("greet", tt.data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Render = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromptSetUnknownTemplate(t *testing.T) {
+	set, _, _ := testPromptSet(t)
+
+	if _, err := set.Render("missing", nil); err == nil || err.Error() != "unknown prompt template greeter/missing" {
+		t.Errorf("got error %v", err)
+	}
+	if _, err := set.BuiltIn("missing"); err == nil {
+		t.Error("expected an error for an unknown template")
+	}
+}
+
+func TestPromptSetExport(t *testing.T) {
+	set, _, _ := testPromptSet(t)
+	if names := strings.Join(set.Names(), ","); names != "farewell,greet" {
+		t.Errorf("Names = %s, want farewell,greet", names)
+	}
+
+	dir := t.TempDir()
+	edited := writePrompt(t, filepath.Join(dir, "greeter"), "greet", "edited")
+
+	written, err := set.Export(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 1 || written[0] != filepath.Join(dir, "greeter", "farewell.tmpl") {
+		t.Errorf("wrote %q, want only the missing template", written)
+	}
+	if data, _ := os.ReadFile(edited); string(data) != "edited" {
+		t.Errorf("an existing template was overwritten: %q", data)
+	}
+
+	if written, err = set.Export(dir, true); err != nil || len(written) != 2 {
+		t.Fatalf("wrote %q, %v; want both templates", written, err)
+	}
+	if data, _ := os.ReadFile(edited); string(data) != "Hello, {{.Name}}!\n" {
+		t.Errorf("overwrite kept the edited template: %q", data)
+	}
+}
@@ -0,0 +1,123 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Supported provider names for the --provider flag
+const (
+	ProviderGemini = "gemini"
+	ProviderOpenAI = "openai"
+	ProviderOllama = "ollama"
+)
+
+// DefaultProvider is the provider used when none is configured
+const DefaultProvider = ProviderGemini
+
+// Provider is implemented by every LLM backend the toolkit can talk to
+type Provider interface {
+	// Name returns the identifier of the provider (e.g. "gemini")
+	Name() string
+
+	// Generate sends a prompt to the model and returns the complete response
+	Generate(ctx context.Context, req GenerateRequest) (*GenerateResponse, error)
+
+	// GenerateStream sends a prompt to the model and returns a channel that
+	// yields text chunks as they arrive. The channel is closed when the
+	// response is complete; a chunk with a non-nil Err ends the stream early.
+	GenerateStream(ctx context.Context, req GenerateRequest) (<-chan StreamChunk, error)
+
+	// CountTokens returns the number of tokens text occupies for the given model.
+	// Providers without a tokenizer endpoint return a local estimate.
+	CountTokens(ctx context.Context, model, text string) (int, error)
+
+	// Close releases any resources held by the provider
+	Close() error
+}
+
+// GenerateRequest describes a single generation call
+type GenerateRequest struct {
+	Prompt      string
+	Model       string
+	Temperature float32
+
+	// ResponseMIMEType requests a specific output format, e.g. JSONMIMEType
+	ResponseMIMEType string `json:",omitempty"`
+	// ResponseSchema constrains JSON output to the given schema
+	ResponseSchema *Schema `json:",omitempty"`
+}
+
+// GenerateResponse holds the result of a generation call
+type GenerateResponse struct {
+	Text  string
+	Model string
+	Usage Usage
+
+	// FinishReason is why the model stopped generating, one of the Finish* constants
+	FinishReason string `json:",omitempty"`
+}
+
+// Usage reports the number of tokens a generation call consumed
+type Usage struct {
+	PromptTokens    int
+	CandidateTokens int
+
+	// Estimated is set when the provider did not report usage and the counts
+	// are local estimates
+	Estimated bool `json:",omitempty"`
+}
+
+// TotalTokens returns the number of prompt and candidate tokens combined
+func (u Usage) TotalTokens() int {
+	return u.PromptTokens + u.CandidateTokens
+}
+
+// StreamChunk is a piece of streamed model output
+type StreamChunk struct {
+	Text string
+	Err  error
+
+	// Usage is set on the chunk that reports the token usage of the whole
+	// response, usually the last one, for providers that report it
+	Usage *Usage
+
+	// FinishReason is set on the chunk that ends the response
+	FinishReason string
+
+	// Model is set on the first chunk to the model serving the response,
+	// which differs from the requested one when a fallback model took over
+	Model string
+}
+
+// NewProvider creates the provider with the given name
+func NewProvider(ctx context.Context, name, apiKey, baseURL string) (Provider, error) {
+	switch strings.ToLower(name) {
+	case "", ProviderGemini:
+		return NewGeminiProvider(ctx, apiKey)
+	case ProviderOpenAI:
+		return NewOpenAIProvider(apiKey, baseURL), nil
+	case ProviderOllama:
+		return NewOllamaProvider(baseURL), nil
+	default:
+		return nil, fmt.Errorf("unknown provider: %s (supported: gemini, openai, ollama)", name)
+	}
+}
+
+// DefaultModelForProvider returns the model used when --model is not set
+func DefaultModelForProvider(name string) string {
+	switch strings.ToLower(name) {
+	case ProviderOpenAI:
+		return DefaultOpenAIModel
+	case ProviderOllama:
+		return DefaultOllamaModel
+	default:
+		return DefaultAIModel
+	}
+}
+
+// ProviderRequiresAPIKey reports whether the provider needs an API key
+func ProviderRequiresAPIKey(name string) bool {
+	return strings.ToLower(name) != ProviderOllama
+}
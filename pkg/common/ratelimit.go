@@ -0,0 +1,144 @@
+package common
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateWindow is the period RPM and TPM limits apply to
+const rateWindow = time.Minute
+
+// RateLimiter caps the requests and tokens sent per minute. It is safe for
+// concurrent use, and callers are admitted in the order they arrive.
+type RateLimiter struct {
+	rpm int
+	tpm int
+
+	// turn admits one caller at a time; goroutines blocked sending on a
+	// channel are woken in FIFO order, which makes the queue fair
+	turn chan struct{}
+
+	mu      sync.Mutex
+	history []*RateReservation
+}
+
+// RateReservation records a request admitted by the limiter
+type RateReservation struct {
+	limiter *RateLimiter
+	at      time.Time
+	tokens  int
+}
+
+// NewRateLimiter creates a limiter allowing rpm requests and tpm tokens per
+// minute. A zero limit is unlimited; nil is returned when both are zero.
+func NewRateLimiter(rpm, tpm int) *RateLimiter {
+	if rpm <= 0 && tpm <= 0 {
+		return nil
+	}
+
+	return &RateLimiter{
+		rpm:  rpm,
+		tpm:  tpm,
+		turn: make(chan struct{}, 1),
+	}
+}
+
+// Wait blocks until a request of the given number of tokens may be sent
+// without exceeding the limits, then reserves it. It returns the reservation
+// and how long the caller waited. A nil limiter never waits.
+func (l *RateLimiter) Wait(ctx context.Context, tokens int) (*RateReservation, time.Duration, error) {
+	if l == nil {
+		return nil, 0, nil
+	}
+
+	start := time.Now()
+
+	// Queue for our turn
+	select {
+	case l.turn <- struct{}{}:
+	case <-ctx.Done():
+		return nil, time.Since(start), ctx.Err()
+	}
+	defer func() { <-l.turn }()
+
+	for {
+		delay := l.delay(tokens)
+		if delay <= 0 {
+			break
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, time.Since(start), ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	reservation := &RateReservation{
+		limiter: l,
+		at:      time.Now(),
+		tokens:  tokens,
+	}
+
+	l.mu.Lock()
+	l.history = append(l.history, reservation)
+	l.mu.Unlock()
+
+	return reservation, time.Since(start), nil
+}
+
+// delay returns how long to wait before a request of the given size fits in the window
+func (l *RateLimiter) delay(tokens int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Forget requests that have left the window
+	now := time.Now()
+	cutoff := now.Add(-rateWindow)
+	for len(l.history) > 0 && !l.history[0].at.After(cutoff) {
+		l.history = l.history[1:]
+	}
+	if len(l.history) == 0 {
+		return 0
+	}
+
+	// Wait for the oldest request to expire until there is room for this one.
+	// A request larger than the whole token limit is sent once the window is empty.
+	used := 0
+	for _, reservation := range l.history {
+		used += reservation.tokens
+	}
+
+	expireAll := l.history[len(l.history)-1].at.Add(rateWindow).Sub(now)
+	if l.rpm > 0 && len(l.history) >= l.rpm {
+		return l.history[len(l.history)-l.rpm].at.Add(rateWindow).Sub(now)
+	}
+	if l.tpm > 0 && used+tokens > l.tpm {
+		if tokens >= l.tpm {
+			return expireAll
+		}
+		for _, reservation := range l.history {
+			used -= reservation.tokens
+			if used+tokens <= l.tpm {
+				return reservation.at.Add(rateWindow).Sub(now)
+			}
+		}
+		return expireAll
+	}
+	return 0
+}
+
+// Complete replaces the estimated token count of a reservation with the
+// actual usage reported for the request
+func (r *RateReservation) Complete(tokens int) {
+	if r == nil || tokens <= 0 {
+		return
+	}
+
+	r.limiter.mu.Lock()
+	defer r.limiter.mu.Unlock()
+	r.tokens = tokens
+}
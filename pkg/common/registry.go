@@ -0,0 +1,137 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/urfave/cli/v2"
+)
+
+// toolMetadataKey is the cli.App metadata key naming the tool of a standalone app
+const toolMetadataKey = "tool"
+
+// Tool describes a tool the CLI apps are built from. Tools register
+// themselves from an init function, so importing a package, even blank, is
+// enough to add its tool to ai-tools.
+type Tool struct {
+	// Name is the command name, e.g. "docgen"
+	Name string
+	// Aliases are the alternative command names in ai-tools
+	Aliases []string
+	// Command builds the CLI command of the tool. Its Before hook is expected
+	// to call ApplyConfig.
+	Command func() *cli.Command
+	// Defaults are the tool's own defaults for the config settings. They
+	// override the flag defaults and are overridden by the configuration files.
+	Defaults Settings
+}
+
+var (
+	toolsMu sync.Mutex
+	tools   = map[string]Tool{}
+)
+
+// RegisterTool registers a tool. It panics if the name or an alias is already
+// taken, since two tools cannot share a command name.
+func RegisterTool(t Tool) {
+	if t.Name == "" || t.Command == nil {
+		panic("common: RegisterTool needs a name and a command")
+	}
+
+	toolsMu.Lock()
+	defer toolsMu.Unlock()
+	for _, name := range append([]string{t.Name}, t.Aliases...) {
+		for _, other := range tools {
+			if name == other.Name || containsString(other.Aliases, name) {
+				panic(fmt.Sprintf("common: tool name %q registered twice, by %s and %s", name, other.Name, t.Name))
+			}
+		}
+	}
+	tools[t.Name] = t
+}
+
+// LookupTool returns the tool registered under name
+func LookupTool(name string) (Tool, bool) {
+	toolsMu.Lock()
+	defer toolsMu.Unlock()
+	t, ok := tools[name]
+	return t, ok
+}
+
+// Tools returns every registered tool, sorted by name
+func Tools() []Tool {
+	toolsMu.Lock()
+	defer toolsMu.Unlock()
+
+	list := make([]Tool, 0, len(tools))
+	for _, t := range tools {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// NewApp builds an app with a command for every registered tool, followed by
+// list-tools and the cache and prompts maintenance commands
+func NewApp(name, usage string) *cli.App {
+	var commands []*cli.Command
+	for _, t := range Tools() {
+		cmd := t.Command()
+		cmd.Name = t.Name
+		cmd.Aliases = t.Aliases
+		commands = append(commands, cmd)
+	}
+	commands = append(commands,
+		GetListToolsCommand(),
+		GetCacheCommand(),
+		GetPromptsCommand(),
+	)
+
+	return &cli.App{
+		Name:     name,
+		Usage:    usage,
+		Version:  Version,
+		Commands: commands,
+	}
+}
+
+// NewStandaloneApp builds an app running a single registered tool as its
+// root command
+func NewStandaloneApp(name, tool string) (*cli.App, error) {
+	t, ok := LookupTool(tool)
+	if !ok {
+		return nil, fmt.Errorf("unknown tool %q", tool)
+	}
+
+	cmd := t.Command()
+	return &cli.App{
+		Name:     name,
+		Usage:    cmd.Usage,
+		Version:  Version,
+		Flags:    cmd.Flags,
+		Commands: cmd.Subcommands,
+		Before:   cmd.Before,
+		Action:   cmd.Action,
+		Metadata: map[string]interface{}{toolMetadataKey: t.Name},
+	}, nil
+}
+
+// toolOf returns the registered tool a command line runs, if any
+func toolOf(c *cli.Context) (Tool, bool) {
+	for _, ctx := range c.Lineage() {
+		if ctx.Command != nil {
+			if t, ok := LookupTool(ctx.Command.Name); ok {
+				return t, true
+			}
+		}
+		if ctx.App != nil {
+			if name, ok := ctx.App.Metadata[toolMetadataKey].(string); ok {
+				return LookupTool(name)
+			}
+		}
+	}
+	return Tool{}, false
+}
@@ -0,0 +1,152 @@
+package common
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+// registerTestTool registers a tool for the duration of a test
+func registerTestTool(t *testing.T, tool Tool) {
+	t.Helper()
+	RegisterTool(tool)
+	t.Cleanup(func() {
+		toolsMu.Lock()
+		delete(tools, tool.Name)
+		toolsMu.Unlock()
+	})
+}
+
+// greeterTool returns a tool whose command records the flag values it ran with
+func greeterTool(got *map[string]string) Tool {
+	greeting := "Hi"
+	return Tool{
+		Name:    "greeter",
+		Aliases: []string{"greet"},
+		Command: func() *cli.Command {
+			return &cli.Command{
+				Usage: "Greet the user",
+				Flags: append(CommonFlags(), &cli.StringFlag{Name: "style", Value: "Hello"}),
+				Before: func(c *cli.Context) error {
+					return ApplyConfig(c)
+				},
+				Action: func(c *cli.Context) error {
+					*got = map[string]string{"style": c.String("style"), "model": c.String("model")}
+					return nil
+				},
+			}
+		},
+		Defaults: Settings{Style: &greeting},
+	}
+}
+
+func TestRegisterToolRejectsDuplicates(t *testing.T) {
+	registerTestTool(t, greeterTool(nil))
+
+	tests := []struct {
+		name string
+		tool Tool
+	}{
+		{"name", Tool{Name: "greeter", Command: func() *cli.Command { return &cli.Command{} }}},
+		{"alias of another tool", Tool{Name: "greet", Command: func() *cli.Command { return &cli.Command{} }}},
+		{"alias", Tool{Name: "welcome", Aliases: []string{"greeter"}, Command: func() *cli.Command { return &cli.Command{} }}},
+		{"no command", Tool{Name: "farewell"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("RegisterTool did not panic")
+				}
+			}()
+			RegisterTool(tt.tool)
+		})
+	}
+
+	if len(Tools()) != 1 {
+		t.Errorf("got %d tools, want only the first registration", len(Tools()))
+	}
+}
+
+func TestNewAppRunsRegisteredTools(t *testing.T) {
+	writeConfigFiles(t, "", "")
+	unsetenv(t, "DEFAULT_MODEL")
+
+	tests := []struct {
+		name string
+		args []string
+		want map[string]string
+	}{
+		{"tool defaults", []string{"greeter"}, map[string]string{"style": "Hi", "model": DefaultAIModel}},
+		{"alias", []string{"greet"}, map[string]string{"style": "Hi"}},
+		{"flags", []string{"greeter", "--style", "Hey", "--model", "m"}, map[string]string{"style": "Hey", "model": "m"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]string
+			registerTestTool(t, greeterTool(&got))
+
+			if err := NewApp("ai-tools", "test").Run(append([]string{"ai-tools"}, tt.args...)); err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range tt.want {
+				if got[name] != want {
+					t.Errorf("%s = %q, want %q", name, got[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewStandaloneApp(t *testing.T) {
+	writeConfigFiles(t, "", "style: Howdy\n")
+	var got map[string]string
+	registerTestTool(t, greeterTool(&got))
+
+	app, err := NewStandaloneApp("greeter", "greeter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.Usage != "Greet the user" || len(app.Flags) != len(CommonFlags())+1 {
+		t.Errorf("app does not take the tool's usage and flags: %q, %d flags", app.Usage, len(app.Flags))
+	}
+	if err := app.Run([]string{"greeter"}); err != nil {
+		t.Fatal(err)
+	}
+	// The project config overrides the tool's default
+	if got["style"] != "Howdy" {
+		t.Errorf("style = %q, want Howdy", got["style"])
+	}
+
+	if _, err := NewStandaloneApp("farewell", "farewell"); err == nil {
+		t.Error("expected an error for an unknown tool")
+	}
+}
+
+func TestListToolsCommand(t *testing.T) {
+	registerTestTool(t, greeterTool(nil))
+	registerTestTool(t, Tool{Name: "apidoc", Command: func() *cli.Command { return &cli.Command{Usage: "Document an API"} }})
+
+	var out bytes.Buffer
+	app := NewApp("ai-tools", "test")
+	app.Writer = &out
+	if err := app.Run([]string{"ai-tools", "list-tools"}); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	want := []string{"NAME ALIASES DESCRIPTION", "apidoc - Document an API", "greeter greet Greet the user"}
+	if len(lines) != len(want) {
+		t.Fatalf("got output %q", out.String())
+	}
+	for i := range want {
+		// Compare the columns, not the padding
+		if strings.Join(strings.Fields(lines[i]), " ") != want[i] {
+			t.Errorf("line %d = %q, want %q", i, lines[i], want[i])
+		}
+	}
+}
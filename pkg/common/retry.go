@@ -0,0 +1,250 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// now is the package's clock and sleep the wait between retries, both
+// replaced in tests
+var (
+	now   = time.Now
+	sleep = func(ctx context.Context, delay time.Duration) error {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return nil
+		}
+	}
+)
+
+// RetryPolicy controls how failed AI calls are retried
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt (0 disables retrying)
+	MaxRetries int
+	// InitialBackoff is the delay before the first retry
+	InitialBackoff time.Duration
+	// MaxBackoff caps the exponential backoff delay
+	MaxBackoff time.Duration
+	// Multiplier is the factor the delay grows by after each attempt
+	Multiplier float64
+}
+
+// DefaultRetryPolicy returns the retry policy used when none is configured
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries:     5,
+		InitialBackoff: time.Second,
+		MaxBackoff:     60 * time.Second,
+		Multiplier:     2,
+	}
+}
+
+// Backoff returns the jittered delay before the given retry (1 for the first retry)
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	delay := float64(p.InitialBackoff) * math.Pow(multiplier, float64(retry-1))
+	if p.MaxBackoff > 0 && delay > float64(p.MaxBackoff) {
+		delay = float64(p.MaxBackoff)
+	}
+
+	// Use "equal jitter": half of the delay is fixed, the other half random,
+	// so concurrent clients spread out without retrying immediately
+	half := delay / 2
+	return time.Duration(half + rand.Float64()*half)
+}
+
+// Do calls fn until it succeeds, returns a non-retryable error, the retries are
+// exhausted or ctx is done. The label identifies the operation in log output.
+func (p RetryPolicy) Do(ctx context.Context, label string, fn func() error) error {
+	for retry := 0; ; retry++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		// Never retry once the caller has given up
+		if ctx.Err() != nil {
+			return err
+		}
+
+		if !IsRetryable(err) {
+			return err
+		}
+		if retry >= p.MaxRetries {
+			return fmt.Errorf("giving up after %d attempts: %w", retry+1, err)
+		}
+
+		delay := p.Backoff(retry + 1)
+		if retryAfter, ok := RetryAfter(err); ok {
+			// Never wait longer than a retry delay may be, whatever the server asks for
+			if p.MaxBackoff > 0 && retryAfter > p.MaxBackoff {
+				slog.Warn("Server asked to retry later than the maximum retry delay, giving up", "provider", label,
+					"attempt", retry+1, "retry_after", retryAfter, "max_delay", p.MaxBackoff, "error", err)
+				return fmt.Errorf("giving up, the server asked to retry in %s, more than the maximum retry delay of %s: %w", retryAfter, p.MaxBackoff, err)
+			}
+			delay = retryAfter
+		}
+
+		// Waiting past the deadline would only end in a timeout
+		if deadline, ok := ctx.Deadline(); ok && deadline.Sub(now()) < delay {
+			slog.Warn("Retrying would pass the deadline, giving up", "provider", label,
+				"attempt", retry+1, "delay", delay, "error", err)
+			return fmt.Errorf("giving up, retrying in %s would pass the deadline: %w", delay.Round(time.Millisecond), err)
+		}
+
+		slog.Warn("Transient error, retrying", "provider", label, "attempt", retry+1,
+			"max_attempts", p.MaxRetries+1, "delay", delay, "error", err)
+
+		if sleep(ctx, delay) != nil {
+			return err
+		}
+	}
+}
+
+// IsRetryable reports whether err is a transient failure (rate limiting,
+// overload, server errors, dropped connections) that is worth retrying
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	// Cancellation is always final
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	// Errors from HTTP based providers
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return isRetryableStatus(httpErr.StatusCode)
+	}
+
+	// Errors from Google APIs, over either gRPC or REST
+	var apiErr *apierror.APIError
+	if errors.As(err, &apiErr) {
+		if code := apiErr.HTTPCode(); code > 0 {
+			return isRetryableStatus(code)
+		}
+		if st := apiErr.GRPCStatus(); st != nil {
+			return isRetryableCode(st.Code())
+		}
+	}
+
+	var googleErr *googleapi.Error
+	if errors.As(err, &googleErr) {
+		return isRetryableStatus(googleErr.Code)
+	}
+
+	if st, ok := status.FromError(err); ok && st.Code() != codes.Unknown {
+		return isRetryableCode(st.Code())
+	}
+
+	// Network level failures
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+
+	return false
+}
+
+// RetryAfter returns the delay requested by the server, if any
+func RetryAfter(err error) (time.Duration, bool) {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) && httpErr.RetryAfter > 0 {
+		return httpErr.RetryAfter, true
+	}
+
+	var apiErr *apierror.APIError
+	if errors.As(err, &apiErr) {
+		if info := apiErr.Details().RetryInfo; info != nil && info.GetRetryDelay() != nil {
+			if delay := info.GetRetryDelay().AsDuration(); delay > 0 {
+				return delay, true
+			}
+		}
+	}
+
+	var googleErr *googleapi.Error
+	if errors.As(err, &googleErr) && googleErr.Header != nil {
+		if delay, ok := parseRetryAfter(googleErr.Header.Get("Retry-After")); ok {
+			return delay, true
+		}
+	}
+
+	return 0, false
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as an HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds <= 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := date.Sub(now()); delay > 0 {
+			return delay, true
+		}
+	}
+
+	return 0, false
+}
+
+// isRetryableStatus reports whether an HTTP status code indicates a transient failure
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// isRetryableCode reports whether a gRPC status code indicates a transient failure
+func isRetryableCode(code codes.Code) bool {
+	switch code {
+	case codes.ResourceExhausted, codes.Unavailable, codes.Internal, codes.DeadlineExceeded, codes.Aborted:
+		return true
+	default:
+		return false
+	}
+}
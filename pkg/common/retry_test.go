@@ -0,0 +1,285 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// testClock is a fake clock that only moves when slept on or advanced
+type testClock struct {
+	current time.Time
+	// slept records the delays slept
+	slept []time.Duration
+}
+
+// Advance moves the clock forward
+func (c *testClock) Advance(d time.Duration) {
+	c.current = c.current.Add(d)
+}
+
+// fakeClock replaces the clock and the sleep between attempts for a test
+func fakeClock(t *testing.T) *testClock {
+	t.Helper()
+	clock := &testClock{current: time.Now().Truncate(time.Second)}
+
+	oldNow, oldSleep := now, sleep
+	now = func() time.Time { return clock.current }
+	sleep = func(ctx context.Context, delay time.Duration) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		clock.slept = append(clock.slept, delay)
+		clock.Advance(delay)
+		return nil
+	}
+	t.Cleanup(func() { now, sleep = oldNow, oldSleep })
+	return clock
+}
+
+// captureLogs sends the default logger's output to a buffer for a test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	old := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(old) })
+	return &buf
+}
+
+// grpcAPIError returns the error of a Google API call over gRPC failing with code
+func grpcAPIError(t *testing.T, code codes.Code) error {
+	t.Helper()
+	apiErr, ok := apierror.FromError(status.Error(code, "failed"))
+	if !ok {
+		t.Fatalf("status %s is not an API error", code)
+	}
+	return apiErr
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"429", &HTTPError{StatusCode: http.StatusTooManyRequests}, true},
+		{"500", &HTTPError{StatusCode: http.StatusInternalServerError}, true},
+		{"502", &HTTPError{StatusCode: http.StatusBadGateway}, true},
+		{"503", &HTTPError{StatusCode: http.StatusServiceUnavailable}, true},
+		{"504", &HTTPError{StatusCode: http.StatusGatewayTimeout}, true},
+		{"408", &HTTPError{StatusCode: http.StatusRequestTimeout}, true},
+		{"400", &HTTPError{StatusCode: http.StatusBadRequest}, false},
+		{"401", &HTTPError{StatusCode: http.StatusUnauthorized}, false},
+		{"404", &HTTPError{StatusCode: http.StatusNotFound}, false},
+		{"501", &HTTPError{StatusCode: http.StatusNotImplemented}, false},
+		{"wrapped 503", fmt.Errorf("calling model: %w", &HTTPError{StatusCode: http.StatusServiceUnavailable}), true},
+		{"googleapi 429", &googleapi.Error{Code: http.StatusTooManyRequests}, true},
+		{"googleapi 403", &googleapi.Error{Code: http.StatusForbidden}, false},
+		{"gRPC unavailable", status.Error(codes.Unavailable, "down"), true},
+		{"gRPC resource exhausted", status.Error(codes.ResourceExhausted, "quota"), true},
+		{"gRPC deadline exceeded", status.Error(codes.DeadlineExceeded, "slow"), true},
+		{"gRPC invalid argument", status.Error(codes.InvalidArgument, "bad"), false},
+		{"gRPC permission denied", status.Error(codes.PermissionDenied, "no"), false},
+		{"API error unavailable", grpcAPIError(t, codes.Unavailable), true},
+		{"API error not found", grpcAPIError(t, codes.NotFound), false},
+		{"canceled", context.Canceled, false},
+		{"wrapped canceled", fmt.Errorf("stream: %w", context.Canceled), false},
+		{"unexpected EOF", fmt.Errorf("reading body: %w", io.ErrUnexpectedEOF), true},
+		{"connection reset", fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{"connection refused", fmt.Errorf("dial: %w", syscall.ECONNREFUSED), true},
+		{"other", errors.New("invalid response"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	fakeClock(t)
+	date := func(d time.Duration) string { return now().Add(d).Format(http.TimeFormat) }
+
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{" 3 ", 3 * time.Second, true},
+		{"0", 0, false},
+		{"-5", 0, false},
+		{"", 0, false},
+		{"soon", 0, false},
+		{date(90 * time.Second), 90 * time.Second, true},
+		{date(-time.Minute), 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseRetryAfter(%q) = %s, %v; want %s, %v", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	fakeClock(t)
+
+	header := http.Header{}
+	header.Set("Retry-After", "7")
+	tests := []struct {
+		name   string
+		err    error
+		want   time.Duration
+		wantOK bool
+	}{
+		{"HTTP error", &HTTPError{StatusCode: http.StatusTooManyRequests, RetryAfter: 5 * time.Second}, 5 * time.Second, true},
+		{"HTTP error without header", &HTTPError{StatusCode: http.StatusTooManyRequests}, 0, false},
+		{"googleapi error", &googleapi.Error{Code: http.StatusTooManyRequests, Header: header}, 7 * time.Second, true},
+		{"other", errors.New("failed"), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := RetryAfter(tt.err)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("RetryAfter = %s, %v; want %s, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 3, InitialBackoff: time.Second, MaxBackoff: 10 * time.Second, Multiplier: 2}
+	unavailable := &HTTPError{StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable"}
+
+	// failing returns an fn failing with errs in turn, then succeeding
+	failing := func(calls *int, errs ...error) func() error {
+		return func() error {
+			*calls++
+			if *calls <= len(errs) {
+				return errs[*calls-1]
+			}
+			return nil
+		}
+	}
+
+	t.Run("succeeds after transient errors", func(t *testing.T) {
+		clock := fakeClock(t)
+		calls := 0
+		if err := policy.Do(context.Background(), "test", failing(&calls, unavailable, unavailable)); err != nil {
+			t.Fatal(err)
+		}
+		if calls != 3 {
+			t.Errorf("calls = %d, want 3", calls)
+		}
+		// Equal jitter keeps each delay between half and all of the backoff
+		for i, want := range []time.Duration{time.Second, 2 * time.Second} {
+			if got := clock.slept[i]; got < want/2 || got > want {
+				t.Errorf("delay %d = %s, want between %s and %s", i+1, got, want/2, want)
+			}
+		}
+	})
+
+	t.Run("gives up after the last retry", func(t *testing.T) {
+		fakeClock(t)
+		calls := 0
+		err := policy.Do(context.Background(), "test", failing(&calls, unavailable, unavailable, unavailable, unavailable, unavailable))
+		if err == nil || !strings.Contains(err.Error(), "giving up after 4 attempts") || !errors.Is(err, unavailable) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if calls != 4 {
+			t.Errorf("calls = %d, want 4", calls)
+		}
+	})
+
+	t.Run("does not retry permanent errors", func(t *testing.T) {
+		fakeClock(t)
+		calls := 0
+		badRequest := &HTTPError{StatusCode: http.StatusBadRequest}
+		if err := policy.Do(context.Background(), "test", failing(&calls, badRequest)); err != badRequest {
+			t.Fatalf("got %v, want the error unchanged", err)
+		}
+		if calls != 1 {
+			t.Errorf("calls = %d, want 1", calls)
+		}
+	})
+
+	t.Run("waits as long as Retry-After asks", func(t *testing.T) {
+		clock := fakeClock(t)
+		calls := 0
+		limited := &HTTPError{StatusCode: http.StatusTooManyRequests, RetryAfter: 8 * time.Second}
+		if err := policy.Do(context.Background(), "test", failing(&calls, limited)); err != nil {
+			t.Fatal(err)
+		}
+		if len(clock.slept) != 1 || clock.slept[0] != 8*time.Second {
+			t.Errorf("slept %v, want [8s]", clock.slept)
+		}
+	})
+
+	t.Run("gives up when Retry-After exceeds the maximum delay", func(t *testing.T) {
+		clock := fakeClock(t)
+		logs := captureLogs(t)
+		calls := 0
+		limited := &HTTPError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Minute}
+		err := policy.Do(context.Background(), "test", failing(&calls, limited))
+		if err == nil || !strings.Contains(err.Error(), "more than the maximum retry delay") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if calls != 1 || len(clock.slept) != 0 {
+			t.Errorf("calls = %d, slept %v; want a single call and no wait", calls, clock.slept)
+		}
+		if !strings.Contains(logs.String(), "level=WARN") || !strings.Contains(logs.String(), "retry_after=1m0s") {
+			t.Errorf("giving up was not logged: %q", logs.String())
+		}
+	})
+
+	t.Run("gives up when the delay passes the deadline", func(t *testing.T) {
+		fakeClock(t)
+		logs := captureLogs(t)
+		ctx, cancel := context.WithDeadline(context.Background(), now().Add(5*time.Second))
+		defer cancel()
+		calls := 0
+		limited := &HTTPError{StatusCode: http.StatusTooManyRequests, RetryAfter: 8 * time.Second}
+		err := policy.Do(ctx, "test", failing(&calls, limited))
+		if err == nil || !strings.Contains(err.Error(), "would pass the deadline") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.Contains(logs.String(), "level=WARN") {
+			t.Errorf("giving up was not logged: %q", logs.String())
+		}
+	})
+
+	t.Run("stops once the context is canceled", func(t *testing.T) {
+		fakeClock(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		calls := 0
+		err := policy.Do(ctx, "test", func() error {
+			calls++
+			cancel()
+			return unavailable
+		})
+		if err != unavailable || calls != 1 {
+			t.Errorf("got %v after %d calls, want the error after 1", err, calls)
+		}
+	})
+}
@@ -0,0 +1,241 @@
+package common
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Schema types, following JSON Schema naming
+const (
+	SchemaObject  = "object"
+	SchemaArray   = "array"
+	SchemaString  = "string"
+	SchemaInteger = "integer"
+	SchemaNumber  = "number"
+	SchemaBoolean = "boolean"
+)
+
+// Schema describes the shape of a JSON response. It covers the subset of
+// JSON Schema supported by all providers' structured output modes.
+type Schema struct {
+	Type        string             `json:"type"`
+	Description string             `json:"description,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	Enum        []string           `json:"enum,omitempty"`
+	Nullable    bool               `json:"nullable,omitempty"`
+}
+
+// SchemaFor derives a schema from the Go type of v. Struct fields use their
+// json tag names, fields without omitempty are required, a "description"
+// struct tag becomes the field's description and an "enum" tag lists the
+// allowed values of a string field, separated by commas.
+func SchemaFor(v interface{}) (*Schema, error) {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return nil, fmt.Errorf("cannot derive a schema from nil")
+	}
+	return schemaForType(t)
+}
+
+// schemaForType derives a schema from a reflected Go type
+func schemaForType(t reflect.Type) (*Schema, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: SchemaString}, nil
+	case reflect.Bool:
+		return &Schema{Type: SchemaBoolean}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: SchemaInteger}, nil
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: SchemaNumber}, nil
+	case reflect.Slice, reflect.Array:
+		items, err := schemaForType(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: SchemaArray, Items: items}, nil
+	case reflect.Struct:
+		schema := &Schema{Type: SchemaObject, Properties: map[string]*Schema{}}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+
+			name, omitEmpty, skip := jsonFieldName(field)
+			if skip {
+				continue
+			}
+
+			fieldSchema, err := schemaForType(field.Type)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %v", field.Name, err)
+			}
+			fieldSchema.Description = field.Tag.Get("description")
+			if enum := field.Tag.Get("enum"); enum != "" {
+				fieldSchema.Enum = strings.Split(enum, ",")
+			}
+			if field.Type.Kind() == reflect.Pointer {
+				fieldSchema.Nullable = true
+			}
+
+			schema.Properties[name] = fieldSchema
+			if !omitEmpty {
+				schema.Required = append(schema.Required, name)
+			}
+		}
+		return schema, nil
+	default:
+		return nil, fmt.Errorf("unsupported type %s", t)
+	}
+}
+
+// jsonFieldName returns the JSON name of a struct field and whether it is optional or skipped
+func jsonFieldName(field reflect.StructField) (name string, omitEmpty bool, skip bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false, true
+	}
+
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	if name == "" {
+		name = field.Name
+	}
+	for _, option := range parts[1:] {
+		if option == "omitempty" {
+			omitEmpty = true
+		}
+	}
+	return name, omitEmpty, false
+}
+
+// Validate checks a decoded JSON value (as produced by encoding/json into an
+// interface{}) against the schema
+func (s *Schema) Validate(value interface{}) error {
+	return s.validate(value, "$")
+}
+
+// validate checks value against the schema, reporting errors relative to path
+func (s *Schema) validate(value interface{}, path string) error {
+	if value == nil {
+		if s.Nullable {
+			return nil
+		}
+		return fmt.Errorf("%s: value must not be null", path)
+	}
+
+	switch s.Type {
+	case SchemaObject:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected an object", path)
+		}
+		for _, name := range s.Required {
+			if _, ok := object[name]; !ok {
+				return fmt.Errorf("%s: missing required field %q", path, name)
+			}
+		}
+
+		// Check properties in a stable order so errors are reproducible
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if fieldValue, ok := object[name]; ok {
+				if err := s.Properties[name].validate(fieldValue, path+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+	case SchemaArray:
+		array, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected an array", path)
+		}
+		if s.Items != nil {
+			for i, item := range array {
+				if err := s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case SchemaString:
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected a string", path)
+		}
+		if len(s.Enum) > 0 && !containsString(s.Enum, str) {
+			return fmt.Errorf("%s: %q is not one of %s", path, str, strings.Join(s.Enum, ", "))
+		}
+	case SchemaInteger:
+		number, ok := value.(float64)
+		if !ok || number != float64(int64(number)) {
+			return fmt.Errorf("%s: expected an integer", path)
+		}
+	case SchemaNumber:
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("%s: expected a number", path)
+		}
+	case SchemaBoolean:
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: expected a boolean", path)
+		}
+	}
+
+	return nil
+}
+
+// JSONSchema returns the schema as a standard JSON Schema document, as
+// expected by OpenAI-compatible and Ollama structured outputs
+func (s *Schema) JSONSchema() map[string]interface{} {
+	result := map[string]interface{}{}
+
+	if s.Nullable {
+		result["type"] = []string{s.Type, "null"}
+	} else {
+		result["type"] = s.Type
+	}
+	if s.Description != "" {
+		result["description"] = s.Description
+	}
+	if len(s.Enum) > 0 {
+		result["enum"] = s.Enum
+	}
+	if s.Items != nil {
+		result["items"] = s.Items.JSONSchema()
+	}
+	if s.Type == SchemaObject {
+		properties := map[string]interface{}{}
+		for name, property := range s.Properties {
+			properties[name] = property.JSONSchema()
+		}
+		result["properties"] = properties
+		if len(s.Required) > 0 {
+			result["required"] = s.Required
+		}
+	}
+
+	return result
+}
+
+// containsString reports whether list contains value
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package common
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// changeSummary exercises the struct tags SchemaFor understands
+type changeSummary struct {
+	Title    string   `json:"title" description:"One-line summary"`
+	Kind     string   `json:"kind" enum:"feature,fix,docs"`
+	Files    []string `json:"files"`
+	Breaking bool     `json:"breaking,omitempty"`
+	Score    float64  `json:"score,omitempty"`
+	Lines    int      `json:"lines"`
+	Reviewer *string  `json:"reviewer,omitempty"`
+	Notes    []struct {
+		Line int    `json:"line"`
+		Text string `json:"text"`
+	} `json:"notes,omitempty"`
+	Internal string `json:"-"`
+	Untagged string
+	private  string
+}
+
+func TestSchemaFor(t *testing.T) {
+	schema, err := SchemaFor(&changeSummary{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notes := &Schema{Type: SchemaArray, Items: &Schema{
+		Type: SchemaObject,
+		Properties: map[string]*Schema{
+			"line": {Type: SchemaInteger},
+			"text": {Type: SchemaString},
+		},
+		Required: []string{"line", "text"},
+	}}
+	want := &Schema{
+		Type: SchemaObject,
+		Properties: map[string]*Schema{
+			"title":    {Type: SchemaString, Description: "One-line summary"},
+			"kind":     {Type: SchemaString, Enum: []string{"feature", "fix", "docs"}},
+			"files":    {Type: SchemaArray, Items: &Schema{Type: SchemaString}},
+			"breaking": {Type: SchemaBoolean},
+			"score":    {Type: SchemaNumber},
+			"lines":    {Type: SchemaInteger},
+			"reviewer": {Type: SchemaString, Nullable: true},
+			"notes":    notes,
+			"Untagged": {Type: SchemaString},
+		},
+		Required: []string{"title", "kind", "files", "lines", "Untagged"},
+	}
+
+	if !reflect.DeepEqual(schema, want) {
+		got, _ := json.MarshalIndent(schema, "", "  ")
+		expected, _ := json.MarshalIndent(want, "", "  ")
+		t.Errorf("got schema\n%s\nwant\n%s", got, expected)
+	}
+}
+
+func TestSchemaForUnsupportedTypes(t *testing.T) {
+	if _, err := SchemaFor(nil); err == nil {
+		t.Error("expected an error for nil")
+	}
+
+	_, err := SchemaFor(struct {
+		Callback func() `json:"callback"`
+	}{})
+	if err == nil || !strings.Contains(err.Error(), "field Callback") {
+		t.Errorf("expected an error naming the field, got %v", err)
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	schema, err := SchemaFor(changeSummary{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := `{"title": "Add caching", "kind": "feature", "files": ["cache.go"], "lines": 120, "Untagged": "", "reviewer": null}`
+	tests := []struct {
+		name    string
+		json    string
+		wantErr string
+	}{
+		{"valid", valid, ""},
+		{"optional fields", strings.Replace(valid, `"lines"`, `"breaking": true, "score": 0.5, "notes": [{"line": 3, "text": "ok"}], "lines"`, 1), ""},
+		{"not an object", `[]`, "$: expected an object"},
+		{"missing field", strings.Replace(valid, `"title": "Add caching", `, "", 1), `$: missing required field "title"`},
+		{"enum", strings.Replace(valid, `"feature"`, `"refactor"`, 1), `$.kind: "refactor" is not one of feature, fix, docs`},
+		{"array item", strings.Replace(valid, `["cache.go"]`, `["cache.go", 3]`, 1), "$.files[1]: expected a string"},
+		{"integer", strings.Replace(valid, "120", "12.5", 1), "$.lines: expected an integer"},
+		{"boolean", strings.Replace(valid, `"lines"`, `"breaking": "yes", "lines"`, 1), "$.breaking: expected a boolean"},
+		{"number", strings.Replace(valid, `"lines"`, `"score": "high", "lines"`, 1), "$.score: expected a number"},
+		{"null", strings.Replace(valid, `"Add caching"`, "null", 1), "$.title: value must not be null"},
+		{"nested", strings.Replace(valid, `"lines"`, `"notes": [{"line": 3}], "lines"`, 1), `$.notes[0]: missing required field "text"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value interface{}
+			if err := json.Unmarshal([]byte(tt.json), &value); err != nil {
+				t.Fatal(err)
+			}
+
+			err := schema.Validate(value)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchemaJSONSchema(t *testing.T) {
+	schema := &Schema{
+		Type: SchemaObject,
+		Properties: map[string]*Schema{
+			"kind":     {Type: SchemaString, Description: "Kind of change", Enum: []string{"fix", "docs"}},
+			"reviewer": {Type: SchemaString, Nullable: true},
+			"files":    {Type: SchemaArray, Items: &Schema{Type: SchemaString}},
+		},
+		Required: []string{"kind"},
+	}
+
+	got, err := json.Marshal(schema.JSONSchema())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"properties":{"files":{"items":{"type":"string"},"type":"array"},` +
+		`"kind":{"description":"Kind of change","enum":["fix","docs"],"type":"string"},` +
+		`"reviewer":{"type":["string","null"]}},"required":["kind"],"type":"object"}`
+	if string(got) != want {
+		t.Errorf("got %s\nwant %s", got, want)
+	}
+}
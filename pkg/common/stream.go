@@ -0,0 +1,118 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// CollectStream drains a stream of chunks, writing each one to w as it
+// arrives (if w is non-nil) and returning the complete text
+func CollectStream(chunks <-chan StreamChunk, w io.Writer) (string, error) {
+	resp, err := collectStream(chunks, w)
+	return resp.Text, err
+}
+
+// collectStream drains a stream of chunks like CollectStream, returning the
+// complete text along with the model, usage and finish reason the chunks report
+func collectStream(chunks <-chan StreamChunk, w io.Writer) (GenerateResponse, error) {
+	var resp GenerateResponse
+	var result strings.Builder
+	for chunk := range chunks {
+		if chunk.Err != nil {
+			resp.Text = result.String()
+			return resp, chunk.Err
+		}
+		if chunk.Model != "" && resp.Model == "" {
+			resp.Model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			resp.Usage = *chunk.Usage
+		}
+		if chunk.FinishReason != "" {
+			resp.FinishReason = chunk.FinishReason
+		}
+
+		result.WriteString(chunk.Text)
+		if w != nil {
+			if _, err := io.WriteString(w, chunk.Text); err != nil {
+				resp.Text = result.String()
+				return resp, fmt.Errorf("error writing stream output: %v", err)
+			}
+		}
+	}
+
+	// Finish the streamed output on its own line
+	if w != nil && result.Len() > 0 && !strings.HasSuffix(result.String(), "\n") {
+		io.WriteString(w, "\n")
+	}
+
+	resp.Text = result.String()
+	return resp, nil
+}
+
+// GenerateText runs a generation request, streaming the output to stream when
+// it is non-nil and falling back to a blocking call otherwise
+func GenerateText(ctx context.Context, provider Provider, req GenerateRequest, stream io.Writer) (*GenerateResponse, error) {
+	if stream == nil {
+		return provider.Generate(ctx, req)
+	}
+
+	chunks, err := provider.GenerateStream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := collectStream(chunks, stream)
+	if err != nil {
+		return nil, err
+	}
+	// A stream cut short by the context may close without an error
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if resp.Text == "" {
+		return nil, fmt.Errorf("no response generated from the AI")
+	}
+
+	// Providers that do not report the serving model answered with the requested one
+	if resp.Model == "" {
+		resp.Model = req.Model
+	}
+	return &resp, nil
+}
+
+// sendChunk delivers a chunk to the stream. If the context is cancelled
+// first, the stream ends with the context's error and false is returned.
+func sendChunk(ctx context.Context, ch chan<- StreamChunk, chunk StreamChunk) bool {
+	select {
+	case ch <- chunk:
+		return true
+	case <-ctx.Done():
+		endStream(ch, ctx.Err())
+		return false
+	}
+}
+
+// sendError ends the stream with err, which is delivered even when the
+// context is cancelled while waiting for the consumer
+func sendError(ctx context.Context, ch chan<- StreamChunk, err error) {
+	select {
+	case ch <- StreamChunk{Err: err}:
+	case <-ctx.Done():
+		endStream(ch, err)
+	}
+}
+
+// endStream leaves a final error in the buffer of a stream whose context is
+// done. Streams buffer one chunk, so this only fails when the consumer left
+// an earlier chunk unread, and consumers check the context once the stream
+// closes for that case.
+func endStream(ch chan<- StreamChunk, err error) {
+	select {
+	case ch <- StreamChunk{Err: err}:
+	default:
+	}
+}
@@ -0,0 +1,72 @@
+package common
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+)
+
+// missingModelProvider rejects one model as not found and streams a complete
+// response of 100 tokens from any other
+type missingModelProvider struct {
+	missing string
+}
+
+func (p *missingModelProvider) Name() string { return "test" }
+
+func (p *missingModelProvider) Generate(ctx context.Context, req GenerateRequest) (*GenerateResponse, error) {
+	if req.Model == p.missing {
+		return nil, &HTTPError{StatusCode: http.StatusNotFound, Status: "404 Not Found"}
+	}
+	return &GenerateResponse{Text: "answer", Model: req.Model, FinishReason: FinishStop}, nil
+}
+
+func (p *missingModelProvider) GenerateStream(ctx context.Context, req GenerateRequest) (<-chan StreamChunk, error) {
+	resp, err := p.Generate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan StreamChunk, 1)
+	ch <- StreamChunk{Text: resp.Text, FinishReason: resp.FinishReason, Usage: &Usage{PromptTokens: 10, CandidateTokens: 90}}
+	close(ch)
+	return ch, nil
+}
+
+func (p *missingModelProvider) CountTokens(ctx context.Context, model, text string) (int, error) {
+	return len(text) / 4, nil
+}
+
+func (p *missingModelProvider) Close() error { return nil }
+
+func TestGenerateTextReportsFallbackModel(t *testing.T) {
+	client := NewAIClientWithProvider(&missingModelProvider{missing: "primary"})
+	client.models = []string{"primary", "fallback"}
+
+	resp, err := GenerateText(context.Background(), client, GenerateRequest{Prompt: "write", Model: "primary"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Model != "fallback" {
+		t.Errorf("model = %q, want %q", resp.Model, "fallback")
+	}
+	if resp.FinishReason != FinishStop {
+		t.Errorf("finish reason = %q, want %q", resp.FinishReason, FinishStop)
+	}
+}
+
+func TestGenerateStreamCompletesRateReservation(t *testing.T) {
+	client := NewAIClientWithProvider(&missingModelProvider{})
+	limiter := NewRateLimiter(0, 1000)
+	client.SetRateLimiter(limiter)
+
+	if _, err := GenerateText(context.Background(), client, GenerateRequest{Prompt: "write", Model: "model"}, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if len(limiter.history) != 1 {
+		t.Fatalf("expected 1 reservation, got %d", len(limiter.history))
+	}
+	if got := limiter.history[0].tokens; got != 100 {
+		t.Errorf("reserved tokens = %d, want the 100 reported", got)
+	}
+}
@@ -0,0 +1,102 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// JSONMIMEType is the response MIME type that requests JSON output
+const JSONMIMEType = "application/json"
+
+// MaxJSONAttempts is how many times GenerateJSON asks the model for a valid response
+const MaxJSONAttempts = 3
+
+// JSONValidationError is returned when the model keeps producing invalid JSON
+type JSONValidationError struct {
+	Attempts int
+	Response string
+	Err      error
+}
+
+func (e *JSONValidationError) Error() string {
+	return fmt.Sprintf("model returned invalid JSON after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *JSONValidationError) Unwrap() error {
+	return e.Err
+}
+
+// GenerateJSON asks the model for a JSON response matching the schema of out
+// (or req.ResponseSchema when set) and unmarshals it into out, which must be a
+// pointer. Responses that fail to parse or validate are sent back to the model
+// together with the error so it can correct itself.
+func GenerateJSON(ctx context.Context, provider Provider, req GenerateRequest, out interface{}) error {
+	if req.ResponseSchema == nil {
+		schema, err := SchemaFor(out)
+		if err != nil {
+			return fmt.Errorf("error deriving response schema: %v", err)
+		}
+		req.ResponseSchema = schema
+	}
+	req.ResponseMIMEType = JSONMIMEType
+
+	originalPrompt := req.Prompt
+	var lastErr error
+	var lastText string
+	for attempt := 1; attempt <= MaxJSONAttempts; attempt++ {
+		resp, err := provider.Generate(ctx, req)
+		if err != nil {
+			return err
+		}
+
+		lastText = resp.Text
+		lastErr = decodeJSONResponse(resp.Text, req.ResponseSchema, out)
+		if lastErr == nil {
+			return nil
+		}
+
+		// Re-prompt with the validation error so the model can fix its answer
+		req.Prompt = fmt.Sprintf("%s\n\nYour previous response was rejected because it was not valid: %v\n\nPrevious response:\n%s\n\n"+
+			"Respond again with only a JSON value that matches the required schema.", originalPrompt, lastErr, resp.Text)
+	}
+
+	return &JSONValidationError{
+		Attempts: MaxJSONAttempts,
+		Response: lastText,
+		Err:      lastErr,
+	}
+}
+
+// decodeJSONResponse validates a JSON response against schema and unmarshals it into out
+func decodeJSONResponse(text string, schema *Schema, out interface{}) error {
+	text = stripJSONFence(text)
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+
+	if err := schema.Validate(value); err != nil {
+		return fmt.Errorf("schema mismatch: %v", err)
+	}
+
+	if err := json.Unmarshal([]byte(text), out); err != nil {
+		return fmt.Errorf("invalid JSON for %T: %v", out, err)
+	}
+	return nil
+}
+
+// stripJSONFence removes a Markdown code fence some models wrap JSON output in
+func stripJSONFence(text string) string {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "```") && !strings.HasPrefix(text, "~~~") {
+		return text
+	}
+
+	if blocks := ParseCodeBlocks(text); len(blocks) > 0 {
+		return strings.TrimSpace(blocks[0].Code)
+	}
+	return text
+}
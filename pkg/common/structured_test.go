@@ -0,0 +1,131 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// scriptedProvider answers requests with the given responses in turn and
+// records the requests it received
+type scriptedProvider struct {
+	responses []string
+	requests  []GenerateRequest
+}
+
+func (p *scriptedProvider) Name() string { return "test" }
+
+func (p *scriptedProvider) Generate(ctx context.Context, req GenerateRequest) (*GenerateResponse, error) {
+	p.requests = append(p.requests, req)
+	if len(p.requests) > len(p.responses) {
+		return nil, errors.New("unexpected request")
+	}
+	return &GenerateResponse{Text: p.responses[len(p.requests)-1], Model: req.Model, FinishReason: FinishStop}, nil
+}
+
+func (p *scriptedProvider) GenerateStream(ctx context.Context, req GenerateRequest) (<-chan StreamChunk, error) {
+	resp, err := p.Generate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan StreamChunk, 1)
+	ch <- StreamChunk{Text: resp.Text, FinishReason: resp.FinishReason}
+	close(ch)
+	return ch, nil
+}
+
+func (p *scriptedProvider) CountTokens(ctx context.Context, model, text string) (int, error) {
+	return EstimateTokens(text), nil
+}
+
+func (p *scriptedProvider) Close() error { return nil }
+
+// verdict is the structured answer requested in the tests
+type verdict struct {
+	Decision string `json:"decision" enum:"approve,reject"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+func TestGenerateJSON(t *testing.T) {
+	provider := &scriptedProvider{responses: []string{"```json\n{\"decision\": \"approve\", \"reason\": \"looks good\"}\n```"}}
+
+	var out verdict
+	if err := GenerateJSON(context.Background(), provider, GenerateRequest{Prompt: "Review this", Model: "m"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Decision != "approve" || out.Reason != "looks good" {
+		t.Errorf("got %+v", out)
+	}
+
+	req := provider.requests[0]
+	if req.ResponseMIMEType != JSONMIMEType {
+		t.Errorf("response MIME type = %q, want %q", req.ResponseMIMEType, JSONMIMEType)
+	}
+	if req.ResponseSchema == nil || req.ResponseSchema.Properties["decision"] == nil || len(req.ResponseSchema.Properties["decision"].Enum) != 2 {
+		t.Errorf("request does not carry the schema of the output: %+v", req.ResponseSchema)
+	}
+}
+
+func TestGenerateJSONReprompts(t *testing.T) {
+	provider := &scriptedProvider{responses: []string{
+		`{"decision": "approve"`,
+		`{"decision": "maybe"}`,
+		`{"decision": "reject", "reason": "missing tests"}`,
+	}}
+
+	var out verdict
+	if err := GenerateJSON(context.Background(), provider, GenerateRequest{Prompt: "Review this", Model: "m"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Decision != "reject" {
+		t.Errorf("got %+v", out)
+	}
+	if len(provider.requests) != 3 {
+		t.Fatalf("got %d requests, want 3", len(provider.requests))
+	}
+
+	// Each retry repeats the original prompt with the error and the rejected answer
+	for i, want := range []string{"invalid JSON", `schema mismatch: $.decision: "maybe" is not one of approve, reject`} {
+		prompt := provider.requests[i+1].Prompt
+		if !strings.HasPrefix(prompt, "Review this\n\n") || strings.Count(prompt, "Review this") != 1 {
+			t.Errorf("retry %d does not start from the original prompt: %q", i+1, prompt)
+		}
+		if !strings.Contains(prompt, want) || !strings.Contains(prompt, provider.responses[i]) {
+			t.Errorf("retry %d does not explain the rejection %q: %q", i+1, want, prompt)
+		}
+	}
+}
+
+func TestGenerateJSONGivesUp(t *testing.T) {
+	provider := &scriptedProvider{responses: []string{"no", "still no", `{"reason": "none"}`}}
+
+	var out verdict
+	err := GenerateJSON(context.Background(), provider, GenerateRequest{Prompt: "Review this", Model: "m"}, &out)
+
+	var validationErr *JSONValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("got %v, want a validation error", err)
+	}
+	if validationErr.Attempts != MaxJSONAttempts || validationErr.Response != `{"reason": "none"}` {
+		t.Errorf("unexpected error: %+v", validationErr)
+	}
+	if !strings.Contains(err.Error(), `missing required field "decision"`) {
+		t.Errorf("error does not report the last problem: %v", err)
+	}
+	if len(provider.requests) != MaxJSONAttempts {
+		t.Errorf("got %d requests, want %d", len(provider.requests), MaxJSONAttempts)
+	}
+}
+
+func TestGenerateJSONProviderError(t *testing.T) {
+	provider := &scriptedProvider{}
+
+	var out verdict
+	if err := GenerateJSON(context.Background(), provider, GenerateRequest{Prompt: "Review this"}, &out); err == nil || err.Error() != "unexpected request" {
+		t.Errorf("got %v, want the provider's error unchanged", err)
+	}
+	if len(provider.requests) != 1 {
+		t.Errorf("got %d requests, want no retry", len(provider.requests))
+	}
+}
@@ -0,0 +1,86 @@
+package common
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// ModelLimits describes the token limits of a model
+type ModelLimits struct {
+	// Context is the maximum number of input tokens
+	Context int
+	// Output is the maximum number of tokens the model generates in one response
+	Output int
+}
+
+// DefaultModelLimits are used for models missing from the limits table
+var DefaultModelLimits = ModelLimits{Context: 32768, Output: 4096}
+
+// modelLimits maps model name prefixes to their limits. Longer prefixes are
+// listed first so the most specific entry wins.
+var modelLimits = []struct {
+	prefix string
+	limits ModelLimits
+}{
+	// Gemini
+	{"gemini-2.5-pro", ModelLimits{Context: 1048576, Output: 65536}},
+	{"gemini-2.5-flash", ModelLimits{Context: 1048576, Output: 65536}},
+	{"gemini-2.0-flash-lite", ModelLimits{Context: 1048576, Output: 8192}},
+	{"gemini-2.0-flash", ModelLimits{Context: 1048576, Output: 8192}},
+	{"gemini-2.0-pro", ModelLimits{Context: 2097152, Output: 8192}},
+	{"gemini-1.5-pro", ModelLimits{Context: 2097152, Output: 8192}},
+	{"gemini-1.5-flash", ModelLimits{Context: 1048576, Output: 8192}},
+	{"gemini-1.0-pro", ModelLimits{Context: 30720, Output: 2048}},
+	{"gemini-pro", ModelLimits{Context: 30720, Output: 2048}},
+
+	// OpenAI
+	{"gpt-4.1", ModelLimits{Context: 1047576, Output: 32768}},
+	{"gpt-4o-mini", ModelLimits{Context: 128000, Output: 16384}},
+	{"gpt-4o", ModelLimits{Context: 128000, Output: 16384}},
+	{"gpt-4-turbo", ModelLimits{Context: 128000, Output: 4096}},
+	{"gpt-4", ModelLimits{Context: 8192, Output: 4096}},
+	{"gpt-3.5-turbo", ModelLimits{Context: 16385, Output: 4096}},
+	{"o1", ModelLimits{Context: 200000, Output: 100000}},
+	{"o3", ModelLimits{Context: 200000, Output: 100000}},
+
+	// Common open models served through Ollama or OpenAI-compatible servers.
+	// Note that Ollama uses a smaller context by default unless num_ctx is raised.
+	{"llama3.1", ModelLimits{Context: 131072, Output: 4096}},
+	{"llama3.2", ModelLimits{Context: 131072, Output: 4096}},
+	{"llama3", ModelLimits{Context: 8192, Output: 4096}},
+	{"qwen2.5-coder", ModelLimits{Context: 32768, Output: 8192}},
+	{"codellama", ModelLimits{Context: 16384, Output: 4096}},
+	{"mistral", ModelLimits{Context: 32768, Output: 4096}},
+	{"deepseek-coder", ModelLimits{Context: 16384, Output: 4096}},
+}
+
+// LimitsForModel returns the token limits of the named model
+func LimitsForModel(model string) ModelLimits {
+	name := strings.ToLower(model)
+	name = strings.TrimPrefix(name, "models/")
+
+	for _, entry := range modelLimits {
+		if strings.HasPrefix(name, entry.prefix) {
+			return entry.limits
+		}
+	}
+	return DefaultModelLimits
+}
+
+// EstimateTokens returns a conservative local estimate of the number of tokens
+// in text. Source code tokenizes more densely than prose, so this assumes
+// roughly three characters per token.
+func EstimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 2) / 3
+}
+
+// InputBudget returns how many tokens of content can be placed in a prompt
+// whose fixed instructions take overhead tokens, leaving room for the response
+func InputBudget(model string, overhead int) int {
+	limits := LimitsForModel(model)
+	budget := limits.Context - overhead - limits.Output
+	if budget < 0 {
+		return 0
+	}
+	return budget
+}
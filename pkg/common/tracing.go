@@ -0,0 +1,154 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Trace exporters selectable with --trace-exporter
+const (
+	TraceExporterNone = "none"
+	TraceExporterOTLP = "otlp"
+	TraceExporterFile = "file"
+)
+
+// DefaultTraceFile is where the file exporter writes spans unless --trace-file is given
+const DefaultTraceFile = "trace.json"
+
+// tracerName is the instrumentation scope of the toolkit's spans
+const tracerName = "github.com/kamdyn/ai-toolkit"
+
+// Span attributes for model calls, following the OpenTelemetry GenAI conventions
+const (
+	AttrGenAISystem        = "gen_ai.system"
+	AttrGenAIRequestModel  = "gen_ai.request.model"
+	AttrGenAIResponseModel = "gen_ai.response.model"
+	AttrGenAIInputTokens   = "gen_ai.usage.input_tokens"
+	AttrGenAIOutputTokens  = "gen_ai.usage.output_tokens"
+	AttrGenAIFinishReasons = "gen_ai.response.finish_reasons"
+)
+
+// TraceConfig holds the tracing settings
+type TraceConfig struct {
+	// Exporter is none, otlp or file
+	Exporter string
+	// File is the output of the file exporter
+	File string
+}
+
+// ValidateTraceExporter checks a --trace-exporter value
+func ValidateTraceExporter(exporter string) error {
+	switch strings.ToLower(exporter) {
+	case "", TraceExporterNone, TraceExporterOTLP, TraceExporterFile:
+		return nil
+	default:
+		return fmt.Errorf("unknown trace exporter %q (supported: none, otlp, file)", exporter)
+	}
+}
+
+// SetupTracing installs a global tracer provider exporting the spans of a run.
+// The otlp exporter sends them over OTLP/HTTP, configured with the standard
+// OTEL_EXPORTER_OTLP_* environment variables; the file exporter writes one
+// JSON object per span to config.File and needs no collector. The returned
+// function flushes and stops the exporter and must be called before exiting.
+func SetupTracing(ctx context.Context, toolName string, config TraceConfig) (func(), error) {
+	var exporter sdktrace.SpanExporter
+	var file *os.File
+
+	switch strings.ToLower(config.Exporter) {
+	case "", TraceExporterNone:
+		return func() {}, nil
+	case TraceExporterOTLP:
+		otlpExporter, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error creating OTLP trace exporter: %v", err)
+		}
+		exporter = otlpExporter
+	case TraceExporterFile:
+		path := config.File
+		if path == "" {
+			path = DefaultTraceFile
+		}
+
+		var err error
+		file, err = os.Create(path)
+		if err != nil {
+			return nil, fmt.Errorf("error creating trace file: %v", err)
+		}
+		fileExporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("error creating trace file exporter: %v", err)
+		}
+		exporter = fileExporter
+	default:
+		return nil, ValidateTraceExporter(config.Exporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", "ai-toolkit"),
+			attribute.String("service.version", Version),
+			attribute.String("ai_toolkit.tool", toolName),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+
+	shutdown := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := provider.Shutdown(ctx); err != nil {
+			slog.Warn("Error exporting traces", "error", err)
+		}
+		if file != nil {
+			file.Close()
+		}
+	}
+	return shutdown, nil
+}
+
+// StartSpan starts a span as a child of the span in ctx, if any. Without
+// SetupTracing the global provider is a no-op and spans cost nothing.
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// EndSpan records err on the span, if any, and ends it
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// usageAttributes returns the span attributes describing the result of a model call
+func usageAttributes(model string, usage Usage, finishReason string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String(AttrGenAIResponseModel, model),
+		attribute.Int(AttrGenAIInputTokens, usage.PromptTokens),
+		attribute.Int(AttrGenAIOutputTokens, usage.CandidateTokens),
+	}
+	if usage.Estimated {
+		attrs = append(attrs, attribute.Bool("ai_toolkit.usage.estimated", true))
+	}
+	if finishReason != "" {
+		attrs = append(attrs, attribute.StringSlice(AttrGenAIFinishReasons, []string{finishReason}))
+	}
+	return attrs
+}
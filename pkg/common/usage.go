@@ -0,0 +1,361 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// usageLabelKey is the context key of the usage label
+type usageLabelKey struct{}
+
+// WithUsageLabel returns a context whose generation calls are accounted under
+// label (e.g. the file or page being processed) in the usage report
+func WithUsageLabel(ctx context.Context, label string) context.Context {
+	return context.WithValue(ctx, usageLabelKey{}, label)
+}
+
+// UsageLabel returns the usage label of a context, if any
+func UsageLabel(ctx context.Context) string {
+	label, _ := ctx.Value(usageLabelKey{}).(string)
+	return label
+}
+
+// BudgetExceededError is returned when a request would push the estimated
+// cost of the run past the --max-cost budget
+type BudgetExceededError struct {
+	Budget float64
+	Spent  float64
+	// Reserved is the projected cost of the requests in flight
+	Reserved  float64
+	Projected float64
+}
+
+func (e *BudgetExceededError) Error() string {
+	return fmt.Sprintf("cost budget of $%.4f would be exceeded: $%.4f spent, $%.4f reserved by requests in flight, next request estimated at up to $%.4f",
+		e.Budget, e.Spent, e.Reserved, e.Projected)
+}
+
+// UsageRecord is the accounting of a single generation call
+type UsageRecord struct {
+	Label    string
+	Provider string
+	Model    string
+	Usage    Usage
+	Cost     float64
+	Priced   bool
+	Cached   bool
+}
+
+// UsageSummary aggregates the usage records sharing a label
+type UsageSummary struct {
+	Label           string
+	Models          []string
+	Calls           int
+	CachedCalls     int
+	PromptTokens    int
+	CandidateTokens int
+	Cost            float64
+	Estimated       bool
+	Unpriced        bool
+}
+
+// UsageTracker accumulates token usage and cost over a run and enforces the cost budget
+type UsageTracker struct {
+	prices  *PriceTable
+	maxCost float64
+
+	mu      sync.Mutex
+	records []UsageRecord
+	spent   float64
+	// reserved is the projected cost of the requests in flight
+	reserved float64
+}
+
+// BudgetReservation holds the projected cost of a request against the budget
+// while it is in flight, so concurrent requests cannot overshoot it together
+type BudgetReservation struct {
+	tracker *UsageTracker
+	amount  float64
+	done    bool
+}
+
+// NewUsageTracker creates a tracker pricing calls with prices. A maxCost of zero disables the budget.
+func NewUsageTracker(prices *PriceTable, maxCost float64) *UsageTracker {
+	if prices == nil {
+		prices = DefaultPriceTable()
+	}
+
+	return &UsageTracker{
+		prices:  prices,
+		maxCost: maxCost,
+	}
+}
+
+// Record accounts a completed call. Cached responses are counted but cost nothing.
+func (t *UsageTracker) Record(ctx context.Context, provider, model string, usage Usage, cached bool) {
+	record := t.newRecord(ctx, provider, model, usage, cached)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.records = append(t.records, record)
+	t.spent += record.Cost
+}
+
+// newRecord prices a call
+func (t *UsageTracker) newRecord(ctx context.Context, provider, model string, usage Usage, cached bool) UsageRecord {
+	record := UsageRecord{
+		Label:    UsageLabel(ctx),
+		Provider: provider,
+		Model:    model,
+		Usage:    usage,
+		Cached:   cached,
+		Priced:   true,
+	}
+	if !cached {
+		price, ok := t.prices.Lookup(provider, model)
+		record.Priced = ok
+		record.Cost = price.Cost(usage)
+	}
+	return record
+}
+
+// CheckBudget returns a BudgetExceededError if sending prompt to the model
+// could push the run over its budget, counting the requests still in flight.
+// The response is assumed to be as long as the prompt, capped at the model's
+// output limit. Otherwise that projected cost is reserved until the returned
+// reservation is settled with the actual usage or released.
+func (t *UsageTracker) CheckBudget(provider, model, prompt string) (*BudgetReservation, error) {
+	reservation := &BudgetReservation{tracker: t}
+	if t.maxCost <= 0 {
+		return reservation, nil
+	}
+
+	price, ok := t.prices.Lookup(provider, model)
+	if !ok {
+		return reservation, nil
+	}
+
+	promptTokens := EstimateTokens(prompt)
+	outputTokens := promptTokens
+	if limit := LimitsForModel(model).Output; outputTokens > limit {
+		outputTokens = limit
+	}
+	projected := price.Cost(Usage{PromptTokens: promptTokens, CandidateTokens: outputTokens})
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.spent+t.reserved+projected > t.maxCost {
+		return nil, &BudgetExceededError{
+			Budget:    t.maxCost,
+			Spent:     t.spent,
+			Reserved:  t.reserved,
+			Projected: projected,
+		}
+	}
+	t.reserved += projected
+	reservation.amount = projected
+	return reservation, nil
+}
+
+// Settle accounts the completed call like Record, replacing the reserved
+// cost with the actual one
+func (r *BudgetReservation) Settle(ctx context.Context, provider, model string, usage Usage) {
+	t := r.tracker
+	record := t.newRecord(ctx, provider, model, usage, false)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r.release()
+	t.records = append(t.records, record)
+	t.spent += record.Cost
+}
+
+// Release returns the reserved cost of a request that failed to the budget.
+// It does nothing once the reservation is settled or released.
+func (r *BudgetReservation) Release() {
+	if r == nil {
+		return
+	}
+
+	r.tracker.mu.Lock()
+	defer r.tracker.mu.Unlock()
+	r.release()
+}
+
+// release drops the reservation; the tracker's lock must be held
+func (r *BudgetReservation) release() {
+	if r.done {
+		return
+	}
+	r.done = true
+	r.tracker.reserved -= r.amount
+}
+
+// Cost returns the estimated cost of the run so far in USD
+func (t *UsageTracker) Cost() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.spent
+}
+
+// Records returns a copy of every call accounted so far
+func (t *UsageTracker) Records() []UsageRecord {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]UsageRecord(nil), t.records...)
+}
+
+// Summaries aggregates the records by label, in the order labels were first seen
+func (t *UsageTracker) Summaries() []UsageSummary {
+	var summaries []UsageSummary
+	index := map[string]int{}
+
+	for _, record := range t.Records() {
+		i, ok := index[record.Label]
+		if !ok {
+			i = len(summaries)
+			index[record.Label] = i
+			summaries = append(summaries, UsageSummary{Label: record.Label})
+		}
+
+		summary := &summaries[i]
+		summary.Calls++
+		if record.Model != "" && !containsString(summary.Models, record.Model) {
+			summary.Models = append(summary.Models, record.Model)
+		}
+		if record.Cached {
+			summary.CachedCalls++
+			continue
+		}
+		summary.PromptTokens += record.Usage.PromptTokens
+		summary.CandidateTokens += record.Usage.CandidateTokens
+		summary.Cost += record.Cost
+		summary.Estimated = summary.Estimated || record.Usage.Estimated
+		summary.Unpriced = summary.Unpriced || !record.Priced
+	}
+
+	return summaries
+}
+
+// WriteReport writes a table of token usage and estimated cost per label.
+// Nothing is written if no calls were made.
+func (t *UsageTracker) WriteReport(w io.Writer) {
+	summaries := t.Summaries()
+	if len(summaries) == 0 {
+		return
+	}
+
+	total := UsageSummary{Label: "TOTAL"}
+	for _, summary := range summaries {
+		for _, model := range summary.Models {
+			if !containsString(total.Models, model) {
+				total.Models = append(total.Models, model)
+			}
+		}
+		total.Calls += summary.Calls
+		total.CachedCalls += summary.CachedCalls
+		total.PromptTokens += summary.PromptTokens
+		total.CandidateTokens += summary.CandidateTokens
+		total.Cost += summary.Cost
+		total.Estimated = total.Estimated || summary.Estimated
+		total.Unpriced = total.Unpriced || summary.Unpriced
+	}
+
+	fmt.Fprintln(w, "\nToken usage:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "INPUT\tMODEL\tCALLS\tCACHED\tPROMPT\tOUTPUT\tCOST")
+	for _, summary := range summaries {
+		writeUsageRow(tw, summary)
+	}
+	if len(summaries) > 1 {
+		writeUsageRow(tw, total)
+	}
+	tw.Flush()
+
+	if total.Estimated {
+		fmt.Fprintln(w, "~ token counts estimated locally; the provider did not report usage")
+	}
+	if total.Unpriced {
+		fmt.Fprintln(w, "? no price known for the model; add it with --price-file")
+	}
+}
+
+// writeUsageRow writes a single row of the usage table
+func writeUsageRow(w io.Writer, summary UsageSummary) {
+	label := summary.Label
+	if label == "" {
+		label = "(run)"
+	}
+
+	marker := ""
+	if summary.Estimated {
+		marker = "~"
+	}
+
+	cost := fmt.Sprintf("$%.4f", summary.Cost)
+	if summary.Unpriced {
+		cost += "?"
+	}
+
+	fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s%d\t%s%d\t%s\n", label, strings.Join(summary.Models, ","), summary.Calls, summary.CachedCalls,
+		marker, summary.PromptTokens, marker, summary.CandidateTokens, cost)
+}
+
+// LogReport logs the token usage and estimated cost per label, and the run
+// total, as one record each for machine-readable logs
+func (t *UsageTracker) LogReport(logger *slog.Logger) {
+	summaries := t.Summaries()
+	if len(summaries) == 0 {
+		return
+	}
+
+	total := UsageSummary{Label: "TOTAL"}
+	for _, summary := range summaries {
+		logUsageSummary(logger, "Token usage", summary)
+		for _, model := range summary.Models {
+			if !containsString(total.Models, model) {
+				total.Models = append(total.Models, model)
+			}
+		}
+		total.Calls += summary.Calls
+		total.CachedCalls += summary.CachedCalls
+		total.PromptTokens += summary.PromptTokens
+		total.CandidateTokens += summary.CandidateTokens
+		total.Cost += summary.Cost
+		total.Estimated = total.Estimated || summary.Estimated
+		total.Unpriced = total.Unpriced || summary.Unpriced
+	}
+	logUsageSummary(logger, "Total token usage", total)
+}
+
+// logUsageSummary logs a single usage summary
+func logUsageSummary(logger *slog.Logger, msg string, summary UsageSummary) {
+	logger.Info(msg,
+		"input", summary.Label,
+		"model", strings.Join(summary.Models, ","),
+		"calls", summary.Calls,
+		"cached_calls", summary.CachedCalls,
+		"prompt_tokens", summary.PromptTokens,
+		"output_tokens", summary.CandidateTokens,
+		"tokens", summary.PromptTokens+summary.CandidateTokens,
+		"cost", summary.Cost,
+		"estimated", summary.Estimated,
+		"priced", !summary.Unpriced,
+	)
+}
+
+// ReportUsage reports the usage of a run in the given log format: a table on
+// stderr for text, log records for JSON
+func ReportUsage(t *UsageTracker, logFormat string) {
+	if logFormat == LogFormatJSON {
+		t.LogReport(slog.Default())
+		return
+	}
+	t.WriteReport(os.Stderr)
+}
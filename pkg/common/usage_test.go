@@ -0,0 +1,48 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCheckBudgetReservesInFlightRequests(t *testing.T) {
+	// $1 per token
+	prices := &PriceTable{prices: map[string]Price{"priced-model": {Input: 1e6, Output: 1e6}}}
+	prompt := "Document this function for me, please."
+	projected := float64(2 * EstimateTokens(prompt))
+	tracker := NewUsageTracker(prices, 1.5*projected)
+
+	first, err := tracker.CheckBudget("test", "priced-model", prompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first request leaves no room for a second one until it completes
+	_, err = tracker.CheckBudget("test", "priced-model", prompt)
+	var budgetErr *BudgetExceededError
+	if !errors.As(err, &budgetErr) {
+		t.Fatalf("expected a BudgetExceededError, got %v", err)
+	}
+	if budgetErr.Reserved != projected {
+		t.Errorf("reserved = %v, want %v", budgetErr.Reserved, projected)
+	}
+
+	// A failed request gives its reservation back
+	first.Release()
+	first.Release()
+	second, err := tracker.CheckBudget("test", "priced-model", prompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A completed request is charged its actual cost instead of the projection
+	second.Settle(context.Background(), "test", "priced-model", Usage{PromptTokens: 1, CandidateTokens: 2})
+	second.Release()
+	if got := tracker.Cost(); got != 3 {
+		t.Errorf("cost = %v, want 3", got)
+	}
+	if _, err := tracker.CheckBudget("test", "priced-model", prompt); err != nil {
+		t.Errorf("expected the settled request to leave room for another: %v", err)
+	}
+}
@@ -2,18 +2,28 @@ package docgen
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io/fs"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/kamdyn/ai-toolkit/pkg/common"
 	"github.com/urfave/cli/v2"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+func init() {
+	common.RegisterTool(common.Tool{
+		Name:    "docgen",
+		Aliases: []string{"docs", "d"},
+		Command: GetDocGenCommand,
+	})
+}
+
 // GetDocGenCommand returns the CLI command for docgen
 func GetDocGenCommand() *cli.Command {
 	return &cli.Command{
@@ -38,7 +48,25 @@ func GetDocGenCommand() *cli.Command {
 			&cli.StringFlag{
 				Name:    "style",
 				Aliases: []string{"s"},
-				Usage:   "Documentation style (jsdoc, godoc, docstring, xml, markdown)",
+				Usage:   "Documentation style (jsdoc, godoc, docstring, xml, markdown). Markdown output, also used for .md outputs and --dir, is not verified",
+			},
+			&cli.BoolFlag{
+				Name:    "ast",
+				Usage:   "For a Go file, only add doc comments to exported declarations that lack one, leaving the code untouched; the file is updated in place unless --output is given (requires --file, not allowed with --dir)",
+				Value:   common.GetEnvOrDefaultBool("DEFAULT_AST", false),
+				EnvVars: []string{"DEFAULT_AST"},
+			},
+			&cli.BoolFlag{
+				Name:    "no-verify",
+				Usage:   "Write documented code without checking that only its comments changed (Markdown output is never checked)",
+				Value:   common.GetEnvOrDefaultBool("DEFAULT_NO_VERIFY", false),
+				EnvVars: []string{"DEFAULT_NO_VERIFY"},
+			},
+			&cli.IntFlag{
+				Name:    "verify-retries",
+				Usage:   "Number of times documented code that changed the source is regenerated before failing",
+				Value:   common.GetEnvOrDefaultInt("DEFAULT_VERIFY_RETRIES", DefaultVerifyRetries),
+				EnvVars: []string{"DEFAULT_VERIFY_RETRIES"},
 			},
 			&cli.StringFlag{
 				Name:    "title",
@@ -46,10 +74,44 @@ func GetDocGenCommand() *cli.Command {
 				Usage:   "Project title for documentation (only used with --dir)",
 				Value:   "Project Documentation",
 			},
+			&cli.StringSliceFlag{
+				Name:    "include",
+				Usage:   "Only document files matching these globs, e.g. 'src/**/*.ts' (only used with --dir)",
+				EnvVars: []string{"DEFAULT_INCLUDE"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "exclude",
+				Usage:   "Skip files and directories matching these globs, e.g. '*_test.go' (only used with --dir)",
+				EnvVars: []string{"DEFAULT_EXCLUDE"},
+			},
+			&cli.IntFlag{
+				Name:    "max-file-size",
+				Usage:   "Skip files larger than this many KB (only used with --dir, 0 = no limit)",
+				Value:   common.GetEnvOrDefaultInt("DEFAULT_MAX_FILE_SIZE", DefaultMaxFileSizeKB),
+				EnvVars: []string{"DEFAULT_MAX_FILE_SIZE"},
+			},
+			&cli.IntFlag{
+				Name:    "concurrency",
+				Aliases: []string{"j"},
+				Usage:   "Number of files documented in parallel (only used with --dir)",
+				Value:   common.GetEnvOrDefaultInt("DEFAULT_CONCURRENCY", DefaultConcurrency),
+				EnvVars: []string{"DEFAULT_CONCURRENCY"},
+			},
+			&cli.IntFlag{
+				Name:    "file-timeout",
+				Usage:   "Timeout in seconds for documenting a single file (only used with --dir, 0 = bounded by --timeout only)",
+				Value:   common.GetEnvOrDefaultInt("DEFAULT_FILE_TIMEOUT", 0),
+				EnvVars: []string{"DEFAULT_FILE_TIMEOUT"},
+			},
 		),
 		Before: func(c *cli.Context) error {
+			// Fill unset options from the config files and profile
+			if err := common.ApplyConfig(c); err != nil {
+				return err
+			}
+
 			// Validate API key
-			if err := common.ValidateAPIKey(c.String("api-key")); err != nil {
+			if err := common.ValidateCredentials(c); err != nil {
 				return err
 			}
 
@@ -74,6 +136,25 @@ func GetDocGenCommand() *cli.Command {
 				}
 			}
 
+			// The AST mode rewrites a single Go file
+			if c.Bool("ast") && filePath == "" {
+				return fmt.Errorf("--ast requires --file")
+			}
+			if c.Bool("ast") && dirPath != "" {
+				return fmt.Errorf("--ast cannot be combined with --dir")
+			}
+
+			// Validate the worker pool settings
+			if c.Int("concurrency") < 1 {
+				return fmt.Errorf("--concurrency must be at least 1")
+			}
+			if c.Int("file-timeout") < 0 {
+				return fmt.Errorf("--file-timeout cannot be negative")
+			}
+			if c.Int("max-file-size") < 0 {
+				return fmt.Errorf("--max-file-size cannot be negative")
+			}
+
 			return nil
 		},
 		Action: func(c *cli.Context) error {
@@ -83,7 +164,7 @@ func GetDocGenCommand() *cli.Command {
 }
 
 // runDocGen runs the documentation generator
-func runDocGen(c *cli.Context) error {
+func runDocGen(c *cli.Context) (err error) {
 	// Extract configuration
 	config := common.ExtractCommonConfig(c)
 	
@@ -91,42 +172,73 @@ func runDocGen(c *cli.Context) error {
 	dirPath := c.String("dir")
 	language := c.String("lang")
 	style := c.String("style")
-	projectTitle := c.String("title")
+	project := projectOptions{
+		Title:       c.String("title"),
+		Concurrency: c.Int("concurrency"),
+		FileTimeout: time.Duration(c.Int("file-timeout")) * time.Second,
+		Include:     c.StringSlice("include"),
+		Exclude:     c.StringSlice("exclude"),
+		MaxFileSize: int64(c.Int("max-file-size")) * 1024,
+	}
 	
 	// Configure logging based on verbose flag
-	common.PrepareLogger("DocGen", config.Verbose)
+	common.PrepareLogger("DocGen", config.LogFormat, config.Verbose)
 
 	// Create timeout context
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.Timeout)*time.Second)
 	defer cancel()
 
+	// Export traces of the run if enabled
+	shutdownTracing, err := common.SetupTracing(ctx, "docgen", config.Trace)
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing()
+
+	ctx, span := common.StartSpan(ctx, "docgen",
+		attribute.String("file.path", filePath),
+		attribute.String("docgen.dir", dirPath))
+	defer func() { common.EndSpan(span, err) }()
+
 	// Create AI client
-	aiClient, err := common.NewAIClient(ctx, config.APIKey)
+	aiClient, err := common.NewAIClient(ctx, config)
 	if err != nil {
 		return fmt.Errorf("error creating AI client: %v", err)
 	}
 	defer aiClient.Close()
 
+	// Print token usage and cost once the run is over, including when it fails
+	defer common.ReportUsage(aiClient.Usage(), config.LogFormat)
+
 	// Create generator
 	generator := NewDocGenerator(aiClient)
+	generator.ChunkTokens = config.ChunkTokens
+	generator.Structured = config.Structured
+	generator.Selection = config.CodeBlocks
+	generator.NoVerify = c.Bool("no-verify")
+	generator.VerifyRetries = c.Int("verify-retries")
+	if config.Stream {
+		generator.Stream = os.Stdout
+	}
 
 	// If directory is provided, generate project documentation
 	if dirPath != "" {
-		return generateProjectDocumentation(ctx, generator, dirPath, projectTitle, config)
+		return generateProjectDocumentation(ctx, generator, dirPath, project, config)
 	}
 
 	// Otherwise, generate documentation for a single file
+	if c.Bool("ast") {
+		return documentGoFile(ctx, generator, filePath, language, config)
+	}
 	return generateFileDocumentation(ctx, generator, filePath, language, style, config)
 }
 
 // generateFileDocumentation generates documentation for a single file
-func generateFileDocumentation(ctx context.Context, generator *DocGenerator, filePath, language, style string, config common.ToolConfig) error {
+func generateFileDocumentation(ctx context.Context, generator *DocGenerator, filePath, language, style string, config common.ToolConfig) (err error) {
 	// If language not provided, try to detect from file extension
 	if language == "" {
-		language = detectLanguage(filePath)
-		if config.Verbose {
-			log.Printf("Auto-detected language: %s", language)
-		}
+		language = DetectLanguage(filePath)
+		slog.Debug("Auto-detected language", "file", filePath, "lang", language)
 	}
 
 	// If no output file specified, create one in the docs directory
@@ -146,14 +258,7 @@ func generateFileDocumentation(ctx context.Context, generator *DocGenerator, fil
 		config.OutputFile = filepath.Join(docsDir, fileName+".md")
 	}
 
-	if config.Verbose {
-		log.Printf("Generating documentation for file: %s", filePath)
-		log.Printf("Language: %s", language)
-		if style != "" {
-			log.Printf("Documentation style: %s", style)
-		}
-		log.Printf("Output file: %s", config.OutputFile)
-	}
+	slog.Debug("Documentation settings", "file", filePath, "lang", language, "style", style, "output", config.OutputFile)
 
 	// Read the source code
 	codeBytes, err := os.ReadFile(filePath)
@@ -163,16 +268,21 @@ func generateFileDocumentation(ctx context.Context, generator *DocGenerator, fil
 	code := string(codeBytes)
 
 	// Generate documentation
-	if config.Verbose {
-		log.Printf("Generating documentation using model %s...", config.Model)
-	}
-	
+	slog.Debug("Generating documentation", "file", filePath, "model", config.Model)
+	start := time.Now()
+
 	// If we're generating markdown-style documentation, always use "markdown" style
 	if strings.HasSuffix(config.OutputFile, ".md") {
 		style = "markdown"
 	}
 	
-	documentation, err := generator.GenerateDocumentation(ctx, config.Model, config.Temperature, code, language, style, config.Verbose)
+	ctx, span := common.StartSpan(ctx, "docgen.file",
+		attribute.String("file.path", filePath),
+		attribute.String("docgen.lang", language))
+	defer func() { common.EndSpan(span, err) }()
+
+	ctx = common.WithUsageLabel(ctx, filePath)
+	documentation, err := generator.GenerateDocumentation(ctx, config.Model, config.Temperature, code, language, style)
 	if err != nil {
 		return fmt.Errorf("error generating documentation: %v", err)
 	}
@@ -186,19 +296,95 @@ func generateFileDocumentation(ctx context.Context, generator *DocGenerator, fil
 		}
 	}
 
-	if config.Verbose {
-		log.Println("Documentation successfully generated")
+	slog.Debug("Documentation successfully generated", "file", filePath, "duration", time.Since(start))
+
+	// Write to output file
+	_, writeSpan := common.StartSpan(ctx, "docgen.write", attribute.String("file.path", config.OutputFile))
+	err = common.WriteOutput(documentation, config.OutputFile)
+	common.EndSpan(writeSpan, err)
+	return err
+}
+
+// DefaultConcurrency is the number of files documented in parallel unless --concurrency is given
+const DefaultConcurrency = 4
+
+// projectOptions holds the settings specific to documenting a directory
+type projectOptions struct {
+	// Title heads the combined documentation
+	Title string
+	// Concurrency is the number of files documented in parallel
+	Concurrency int
+	// FileTimeout bounds the time spent on a single file; zero leaves only the run's timeout
+	FileTimeout time.Duration
+	// Include and Exclude are globs selecting the files to document
+	Include []string
+	Exclude []string
+	// MaxFileSize skips files larger than this many bytes; zero means no limit
+	MaxFileSize int64
+}
+
+// newFileFilter compiles the file selection settings of a project
+func newFileFilter(project projectOptions) (fileFilter, error) {
+	include, err := compileGlobs(project.Include)
+	if err != nil {
+		return fileFilter{}, fmt.Errorf("error in --include: %v", err)
+	}
+	exclude, err := compileGlobs(project.Exclude)
+	if err != nil {
+		return fileFilter{}, fmt.Errorf("error in --exclude: %v", err)
+	}
+	return fileFilter{include: include, exclude: exclude, maxSize: project.MaxFileSize}, nil
+}
+
+// documentGoFile adds doc comments to the exported declarations of a Go file
+// that lack one, without letting the model rewrite the code
+func documentGoFile(ctx context.Context, generator *DocGenerator, filePath, language string, config common.ToolConfig) (err error) {
+	if language == "" {
+		language = DetectLanguage(filePath)
+	}
+	if language != "go" && language != "golang" {
+		return fmt.Errorf("--ast only supports Go files, not %s", language)
+	}
+
+	// If no output file specified, insert the comments in place, as the
+	// source stays a valid member of its package
+	if config.OutputFile == "" {
+		config.OutputFile = filePath
+	}
+
+	// Read the source code
+	src, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("error reading file: %v", err)
+	}
+
+	ctx, span := common.StartSpan(ctx, "docgen.file",
+		attribute.String("file.path", filePath),
+		attribute.String("docgen.lang", language),
+		attribute.Bool("docgen.ast", true))
+	defer func() { common.EndSpan(span, err) }()
+
+	slog.Debug("Documenting Go declarations", "file", filePath, "model", config.Model, "output", config.OutputFile)
+	start := time.Now()
+
+	ctx = common.WithUsageLabel(ctx, filePath)
+	documented, err := generator.DocumentGoSource(ctx, config.Model, config.Temperature, src, filePath)
+	if err != nil {
+		return fmt.Errorf("error generating documentation: %v", err)
 	}
 
+	slog.Debug("Documentation successfully generated", "file", filePath, "duration", time.Since(start))
+
 	// Write to output file
-	return common.WriteOutput(documentation, config.OutputFile, config.Verbose)
+	_, writeSpan := common.StartSpan(ctx, "docgen.write", attribute.String("file.path", config.OutputFile))
+	err = common.WriteOutput(string(documented), config.OutputFile)
+	common.EndSpan(writeSpan, err)
+	return err
 }
 
 // generateProjectDocumentation generates documentation for a project directory
-func generateProjectDocumentation(ctx context.Context, generator *DocGenerator, dirPath, projectTitle string, config common.ToolConfig) error {
-	if config.Verbose {
-		log.Printf("Generating project documentation for directory: %s", dirPath)
-	}
+func generateProjectDocumentation(ctx context.Context, generator *DocGenerator, dirPath string, project projectOptions, config common.ToolConfig) error {
+	slog.Debug("Generating project documentation", "dir", dirPath)
 
 	// If no output file specified, use PROJECT.md in the root directory
 	if config.OutputFile == "" {
@@ -212,35 +398,11 @@ func generateProjectDocumentation(ctx context.Context, generator *DocGenerator,
 	}
 
 	// Find all source code files in the directory
-	var codeFiles []string
-	err := filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Skip the docs directory itself
-		rel, err := filepath.Rel(dirPath, path)
-		if err != nil {
-			return err
-		}
-		if strings.HasPrefix(rel, "docs/") || strings.HasPrefix(rel, ".git/") {
-			if d.IsDir() {
-				return filepath.SkipDir
-			}
-			return nil
-		}
-
-		// Only process regular files
-		if !d.IsDir() {
-			ext := strings.ToLower(filepath.Ext(path))
-			// Only include source code files
-			if isSourceCodeFile(ext) {
-				codeFiles = append(codeFiles, path)
-			}
-		}
-		return nil
-	})
-
+	filter, err := newFileFilter(project)
+	if err != nil {
+		return err
+	}
+	codeFiles, err := findSourceFiles(dirPath, filter)
 	if err != nil {
 		return fmt.Errorf("error walking directory: %v", err)
 	}
@@ -249,78 +411,253 @@ func generateProjectDocumentation(ctx context.Context, generator *DocGenerator,
 		return fmt.Errorf("no source code files found in directory: %s", dirPath)
 	}
 
-	if config.Verbose {
-		log.Printf("Found %d source code files", len(codeFiles))
-	}
+	slog.Debug("Found source code files", "dir", dirPath, "files", len(codeFiles))
 
-	// Generate documentation for each file
-	fileInfos := make([]FileDocInfo, 0, len(codeFiles))
-	for _, file := range codeFiles {
+	// Skip the files whose document was generated from the same inputs by an earlier run
+	promptTemplate, _, err := Prompts.Source("document")
+	if err != nil {
+		return err
+	}
+	promptHash := hashContent([]byte(promptTemplate))
+
+	manifest := loadManifest(docsDir)
+	entries := make(map[string]manifestEntry, len(codeFiles))
+	relPaths := make([]string, len(codeFiles))
+	documented := make(map[string]FileDocInfo)
+	var pending []string
+	for i, file := range codeFiles {
 		relPath, err := filepath.Rel(dirPath, file)
 		if err != nil {
 			return fmt.Errorf("error getting relative path: %v", err)
 		}
+		relPaths[i] = relPath
 
-		language := detectLanguage(file)
-		outputPath := filepath.Join(docsDir, strings.ReplaceAll(relPath, "/", "_")+".md")
-
-		if config.Verbose {
-			log.Printf("Generating documentation for %s (%s)", relPath, language)
-		}
-
-		// Read the source code
-		codeBytes, err := os.ReadFile(file)
+		// Unreadable files are left to fail when documented
+		source, err := os.ReadFile(file)
 		if err != nil {
-			log.Printf("Warning: error reading file %s: %v", file, err)
+			pending = append(pending, file)
 			continue
 		}
-		code := string(codeBytes)
-
-		// Generate documentation 
-		documentation, err := generator.GenerateDocumentation(ctx, config.Model, config.Temperature, code, language, "markdown", config.Verbose)
-		if err != nil {
-			log.Printf("Warning: error generating documentation for %s: %v", file, err)
-			continue
+		entry := manifestEntry{
+			SourceHash: hashContent(source),
+			PromptHash: promptHash,
+			Model:      config.Model,
+			Output:     docFileName(relPath),
 		}
-
-		// Write the documentation to a file in the docs directory
-		err = os.WriteFile(outputPath, []byte(documentation), 0644)
-		if err != nil {
-			log.Printf("Warning: error writing documentation for %s: %v", file, err)
+		entries[relPath] = entry
+
+		if manifest.upToDate(relPath, entry, docsDir) {
+			slog.Debug("Documentation up to date", "file", relPath)
+			documented[relPath] = FileDocInfo{
+				RelativePath: relPath,
+				Language:     DetectLanguage(file),
+				DocPath:      filepath.Join(docsDir, entry.Output),
+			}
 			continue
 		}
+		pending = append(pending, file)
+	}
+	manifest.removeStale(relPaths, docsDir)
+	slog.Info("Documenting changed files", "changed", len(pending), "unchanged", len(documented))
+
+	// Generate documentation for each changed file
+	fresh, docErr := documentProjectFiles(ctx, generator, dirPath, pending, docsDir, project, config)
+	for _, info := range fresh {
+		if entry, ok := entries[info.RelativePath]; ok {
+			manifest.Files[info.RelativePath] = entry
+		}
+		documented[info.RelativePath] = info
+	}
 
-		// Store file info for the combined documentation
-		fileInfos = append(fileInfos, FileDocInfo{
-			RelativePath: relPath,
-			Language:     language,
-			DocPath:      outputPath,
-		})
+	// Record what was generated even when the run stopped early, so the next run resumes from there
+	if err := manifest.save(docsDir); err != nil {
+		return err
+	}
+	if docErr != nil {
+		return docErr
+	}
 
-		if config.Verbose {
-			log.Printf("Documentation for %s written to %s", relPath, outputPath)
+	// Combine cached and fresh documents in the order the files were found
+	fileInfos := make([]FileDocInfo, 0, len(codeFiles))
+	for _, relPath := range relPaths {
+		if info, ok := documented[relPath]; ok {
+			fileInfos = append(fileInfos, info)
 		}
 	}
 
 	// Create a combined documentation file
-	if config.Verbose {
-		log.Printf("Creating combined documentation file: %s", config.OutputFile)
-	}
+	slog.Debug("Creating combined documentation file", "file", config.OutputFile)
 
-	err = createCombinedDocumentation(fileInfos, projectTitle, config.OutputFile)
+	_, writeSpan := common.StartSpan(ctx, "docgen.write", attribute.String("file.path", config.OutputFile))
+	err = createCombinedDocumentation(fileInfos, project.Title, config.OutputFile)
+	common.EndSpan(writeSpan, err)
 	if err != nil {
 		return fmt.Errorf("error creating combined documentation: %v", err)
 	}
 
-	if config.Verbose {
-		log.Printf("Project documentation successfully written to %s", config.OutputFile)
-	} else {
-		fmt.Printf("Project documentation successfully written to %s\n", config.OutputFile)
-	}
+	slog.Info("Project documentation successfully written", "file", config.OutputFile)
 
 	return nil
 }
 
+// documentProjectFiles documents the files of a project through a pool of
+// project.Concurrency workers sharing the generator, and with it the client's
+// cache, rate limits and budget. The result follows the order of files and
+// leaves out the files that failed. It stops early when the budget is spent or
+// the run times out, returning the files documented so far with the error.
+func documentProjectFiles(ctx context.Context, generator *DocGenerator, dirPath string, files []string, docsDir string, project projectOptions, config common.ToolConfig) ([]FileDocInfo, error) {
+	// Streamed output of files documented in parallel would interleave
+	workers := project.Concurrency
+	if generator.Stream != nil && workers > 1 {
+		slog.Warn("Streaming documents one file at a time", "concurrency", workers)
+		workers = 1
+	}
+	if workers > len(files) {
+		workers = len(files)
+	}
+
+	// Stopping the pool cancels the files in flight
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	type result struct {
+		info FileDocInfo
+		err  error
+		// done is set once the file was attempted
+		done bool
+	}
+	results := make([]result, len(files))
+
+	var (
+		stopOnce sync.Once
+		stopErr  error
+	)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				relPath, err := filepath.Rel(dirPath, files[i])
+				if err != nil {
+					results[i] = result{err: fmt.Errorf("error getting relative path: %w", err), done: true}
+					continue
+				}
+
+				// A slow file only uses up its own time
+				fileCtx, cancel := ctx, func() {}
+				if project.FileTimeout > 0 {
+					fileCtx, cancel = context.WithTimeout(ctx, project.FileTimeout)
+				}
+				info, err := documentProjectFile(fileCtx, generator, files[i], relPath, docsDir, config)
+				timedOut := fileCtx.Err() != nil
+				cancel()
+				results[i] = result{info: info, err: err, done: true}
+				if err == nil {
+					continue
+				}
+
+				// Running out of budget or time affects every remaining file, so stop here
+				var budgetErr *common.BudgetExceededError
+				switch {
+				case errors.As(err, &budgetErr):
+					stopOnce.Do(func() {
+						stopErr = fmt.Errorf("stopped before documenting %s: %w", relPath, budgetErr)
+						stop()
+					})
+				case ctx.Err() != nil:
+					stopOnce.Do(func() {
+						stopErr = fmt.Errorf("stopped before documenting %s: %w", relPath, ctx.Err())
+						stop()
+					})
+				case timedOut:
+					slog.Warn("Skipping file that timed out", "file", files[i], "timeout", project.FileTimeout)
+				default:
+					slog.Warn("Skipping file", "file", files[i], "error", err)
+				}
+			}
+		}()
+	}
+
+	// Hand out the files in order until the pool is stopped
+dispatch:
+	for i := range files {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	// Collect the documented files in the order they were found
+	fileInfos := make([]FileDocInfo, 0, len(files))
+	for _, r := range results {
+		if r.done && r.err == nil {
+			fileInfos = append(fileInfos, r.info)
+		}
+	}
+
+	if stopErr != nil {
+		return fileInfos, stopErr
+	}
+	if err := ctx.Err(); err != nil {
+		return fileInfos, fmt.Errorf("stopped before documenting every file: %w", err)
+	}
+	return fileInfos, nil
+}
+
+// documentProjectFile documents a single file of a project into docsDir
+func documentProjectFile(ctx context.Context, generator *DocGenerator, file, relPath, docsDir string, config common.ToolConfig) (info FileDocInfo, err error) {
+	language := DetectLanguage(file)
+	outputPath := filepath.Join(docsDir, docFileName(relPath))
+
+	ctx, span := common.StartSpan(ctx, "docgen.file",
+		attribute.String("file.path", relPath),
+		attribute.String("docgen.lang", language))
+	defer func() { common.EndSpan(span, err) }()
+
+	slog.Debug("Generating documentation", "file", relPath, "lang", language)
+	start := time.Now()
+
+	// Read the source code
+	codeBytes, err := os.ReadFile(file)
+	if err != nil {
+		return FileDocInfo{}, fmt.Errorf("error reading file: %w", err)
+	}
+	code := string(codeBytes)
+
+	// Generate documentation, accounting token usage to this file
+	ctx = common.WithUsageLabel(ctx, relPath)
+	documentation, err := generator.GenerateDocumentation(ctx, config.Model, config.Temperature, code, language, "markdown")
+	if err != nil {
+		return FileDocInfo{}, fmt.Errorf("error generating documentation: %w", err)
+	}
+
+	// Write the documentation to a file in the docs directory
+	_, writeSpan := common.StartSpan(ctx, "docgen.write", attribute.String("file.path", outputPath))
+	err = os.WriteFile(outputPath, []byte(documentation), 0644)
+	common.EndSpan(writeSpan, err)
+	if err != nil {
+		return FileDocInfo{}, fmt.Errorf("error writing documentation: %w", err)
+	}
+
+	slog.Debug("Documentation written", "file", relPath, "output", outputPath, "duration", time.Since(start))
+
+	return FileDocInfo{
+		RelativePath: relPath,
+		Language:     language,
+		DocPath:      outputPath,
+	}, nil
+}
+
+// docFileName returns the name of the document of a project file in the docs directory
+func docFileName(relPath string) string {
+	return strings.ReplaceAll(relPath, "/", "_") + ".md"
+}
+
 // FileDocInfo holds information about a documented file
 type FileDocInfo struct {
 	RelativePath string
@@ -342,19 +679,23 @@ func createCombinedDocumentation(fileInfos []FileDocInfo, title string, outputPa
 	// Add project overview
 	sb.WriteString("1. [Project Overview](#project-overview)\n")
 	
-	// Group files by directory
+	// Group files by directory, keeping directories in the order they first appear
 	dirMap := make(map[string][]FileDocInfo)
+	var dirs []string
 	for _, info := range fileInfos {
 		dir := filepath.Dir(info.RelativePath)
 		if dir == "." {
 			dir = "Root"
 		}
+		if _, ok := dirMap[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
 		dirMap[dir] = append(dirMap[dir], info)
 	}
 
 	// Add TOC entries for each directory
 	i := 2
-	for dir := range dirMap {
+	for _, dir := range dirs {
 		anchor := strings.ReplaceAll(strings.ToLower(dir), " ", "-")
 		anchor = strings.ReplaceAll(anchor, "/", "")
 		sb.WriteString(fmt.Sprintf("%d. [%s](#%s)\n", i, dir, anchor))
@@ -366,7 +707,8 @@ func createCombinedDocumentation(fileInfos []FileDocInfo, title string, outputPa
 	sb.WriteString("## Project Overview\n\n")
 	sb.WriteString("The project contains the following key components:\n\n")
 	
-	for dir, files := range dirMap {
+	for _, dir := range dirs {
+		files := dirMap[dir]
 		sb.WriteString(fmt.Sprintf("- **%s**: ", dir))
 		fileDescs := make([]string, 0, len(files))
 		for _, file := range files {
@@ -378,7 +720,8 @@ func createCombinedDocumentation(fileInfos []FileDocInfo, title string, outputPa
 	sb.WriteString("\n")
 
 	// Add documentation for each directory
-	for dir, files := range dirMap {
+	for _, dir := range dirs {
+		files := dirMap[dir]
 		dirHeader := dir
 		sb.WriteString(fmt.Sprintf("## %s\n\n", dirHeader))
 		
@@ -427,8 +770,8 @@ func isSourceCodeFile(ext string) bool {
 	return sourceExtensions[ext]
 }
 
-// detectLanguage attempts to determine language from file extension
-func detectLanguage(filePath string) string {
+// DetectLanguage attempts to determine language from file extension
+func DetectLanguage(filePath string) string {
 	ext := strings.ToLower(filepath.Ext(filePath))
 	
 	switch ext {
@@ -0,0 +1,82 @@
+package docgen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+func TestDocGenCommand(t *testing.T) {
+	// Keep the user's configuration file out of the run
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	output := filepath.Join(t.TempDir(), "sample.go")
+	app := &cli.App{Commands: []*cli.Command{GetDocGenCommand()}}
+	err := app.Run([]string{"ai-tools", "docgen",
+		"--file", filepath.Join("testdata", "sample.go"),
+		"--style", "godoc",
+		"--output", output,
+		"--model", testModel,
+		"--temp", "0.2",
+		"--cassette", filepath.Join("testdata", "docgen.cassette.json"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.TrimSpace(readTestdata(t, "sample.documented.go")); string(got) != want {
+		t.Errorf("output mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestDocGenCommandRejectsASTWithDir(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	app := &cli.App{Commands: []*cli.Command{GetDocGenCommand()}}
+	err := app.Run([]string{"ai-tools", "docgen",
+		"--file", filepath.Join("testdata", "sample.go"),
+		"--dir", "testdata",
+		"--ast",
+		"--cassette", filepath.Join("testdata", "docgen.cassette.json"),
+	})
+	if err == nil || !strings.Contains(err.Error(), "--ast cannot be combined with --dir") {
+		t.Fatalf("expected --ast to be rejected with --dir, got %v", err)
+	}
+}
+
+func TestDocGenCommandASTWritesInPlace(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	// The file is rewritten, so it is documented in a copy
+	path := filepath.Join(t.TempDir(), "sample.go")
+	if err := os.WriteFile(path, []byte(readTestdata(t, "sample.go")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	app := &cli.App{Commands: []*cli.Command{GetDocGenCommand()}}
+	err := app.Run([]string{"ai-tools", "docgen",
+		"--file", path,
+		"--ast",
+		"--model", testModel,
+		"--temp", "0.2",
+		"--cassette", filepath.Join("testdata", "docgen-ast.cassette.json"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := readTestdata(t, "sample.ast.go"); string(got) != want {
+		t.Errorf("output mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
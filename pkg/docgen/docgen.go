@@ -3,7 +3,8 @@ package docgen
 import (
 	"context"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"strings"
 
 	"github.com/kamdyn/ai-toolkit/pkg/common"
@@ -11,93 +12,293 @@ import (
 
 // DocGenerator handles the generation of documentation
 type DocGenerator struct {
-	client *common.AIClient
+	client common.Provider
+
+	// Stream receives the raw model output as it is generated when non-nil
+	Stream io.Writer
+
+	// ChunkTokens caps the number of source tokens sent per request.
+	// Zero derives the limit from the model's context and output limits.
+	ChunkTokens int
+
+	// Structured requests a JSON response instead of extracting fenced code blocks
+	Structured bool
+
+	// Selection chooses among the code blocks of a response. Empty uses common.DefaultCodeSelection.
+	Selection common.CodeSelection
+
+	// NoVerify skips checking that documented code only differs from the source in its comments
+	NoVerify bool
+
+	// VerifyRetries is how many times an output that changes the code is regenerated before failing
+	VerifyRetries int
+}
+
+// documentationResult is the JSON response requested in structured mode
+type documentationResult struct {
+	Documentation string `json:"documentation" description:"The complete documented code or Markdown documentation, without Markdown code fences"`
 }
 
 // NewDocGenerator creates a new DocGenerator
-func NewDocGenerator(client *common.AIClient) *DocGenerator {
+func NewDocGenerator(client common.Provider) *DocGenerator {
 	return &DocGenerator{
-		client: client,
+		client:        client,
+		VerifyRetries: DefaultVerifyRetries,
+	}
+}
+
+// GenerateDocumentation generates documentation for code. Sources too large
+// for the model are split at top-level declarations, documented part by part
+// and merged back together.
+func (g *DocGenerator) GenerateDocumentation(ctx context.Context, modelName string, temperature float32, code string, language string, style string) (string, error) {
+	budget := g.chunkBudget(modelName, language, style)
+	if common.FitsInBudget(ctx, g.client, modelName, code, budget) {
+		// Prepare the prompt based on the language and style
+		prompt, err := buildPrompt(ctx, code, language, style)
+		if err != nil {
+			return "", err
+		}
+		return g.generateDocumented(ctx, modelName, temperature, prompt, code, language, style)
+	}
+
+	chunks := common.SplitText(code, budget, common.SymbolBoundary)
+	slog.Debug("Source exceeds the token budget, splitting", "model", modelName, "tokens", budget, "parts", len(chunks))
+
+	parts := make([]string, 0, len(chunks))
+	for i, chunk := range chunks {
+		slog.Debug("Documenting part", "part", i+1, "parts", len(chunks))
+
+		prompt, err := buildPrompt(ctx, chunk, language, style)
+		if err != nil {
+			return "", err
+		}
+		prompt = common.ChunkNote(i, len(chunks), "source file") + prompt
+		output, err := g.generateDocumented(ctx, modelName, temperature, prompt, chunk, language, style)
+		if err != nil {
+			return "", fmt.Errorf("error documenting part %d of %d: %w", i+1, len(chunks), err)
+		}
+		parts = append(parts, output)
+	}
+
+	return strings.Join(parts, "\n\n"), nil
+}
+
+// GenerateDocComment generates the doc comment of a single declaration, such
+// as a function or type picked in an editor. surrounding is the code around
+// it, sent as context. Only the comment is returned, unindented, for the
+// caller to insert.
+func (g *DocGenerator) GenerateDocComment(ctx context.Context, modelName string, temperature float32, code string, surrounding string, language string, style string) (string, error) {
+	prompt, err := buildCommentPrompt(ctx, code, surrounding, language, style)
+	if err != nil {
+		return "", err
+	}
+
+	generate := func(note string) (string, error) {
+		req := common.GenerateRequest{
+			Prompt:      prompt + note,
+			Model:       modelName,
+			Temperature: temperature,
+		}
+		slog.Debug("Sending request", "provider", g.client.Name(), "model", modelName)
+
+		resp, err := common.GenerateText(ctx, g.client, req, g.Stream)
+		if err != nil {
+			return "", fmt.Errorf("error generating doc comment: %w", err)
+		}
+
+		comment := common.SelectCode(resp.Text, getLanguageMarkers(language), g.Selection)
+		comment = stripEchoedCode(comment, code, language)
+		if canonicalLanguage(language) == "go" {
+			// Plain text and block comments are turned into // lines, so
+			// that they pass the check below and can be inserted as is
+			comment = strings.Join(goLineComment(comment), "\n")
+		}
+		if comment == "" {
+			return "", fmt.Errorf("the model returned no comment")
+		}
+		return comment, nil
+	}
+
+	// The comment is inserted as is, so it must not hold any code
+	return g.verified(generate, func(comment string) *CodeChangedError {
+		return compareCode("", comment, language)
+	}, language, style)
+}
+
+// generateDocumented sends a prompt asking to document code and checks that
+// the output only adds comments to it
+func (g *DocGenerator) generateDocumented(ctx context.Context, modelName string, temperature float32, prompt string, code string, language string, style string) (string, error) {
+	generate := func(note string) (string, error) {
+		return g.generate(ctx, modelName, temperature, prompt+note, outputLanguage(language, style))
+	}
+	return g.verified(generate, func(output string) *CodeChangedError {
+		return compareCode(code, output, language)
+	}, language, style)
+}
+
+// verified calls generate until its output passes check, up to VerifyRetries
+// more times. Retries tell the model what it changed. Markdown documentation
+// and outputs in languages the verifier cannot tokenize are not checked.
+func (g *DocGenerator) verified(generate func(note string) (string, error), check func(output string) *CodeChangedError, language string, style string) (string, error) {
+	output, err := generate("")
+	if err != nil || g.NoVerify {
+		return output, err
+	}
+	if style == "markdown" {
+		slog.Debug("Output not verified, Markdown documentation is not checked", "lang", language)
+		return output, nil
+	}
+	if !canVerify(language) {
+		slog.Debug("Output not verified, the language cannot be tokenized", "lang", language)
+		return output, nil
+	}
+
+	for attempt := 1; ; attempt++ {
+		changed := check(output)
+		if changed == nil {
+			return output, nil
+		}
+		if attempt > g.VerifyRetries {
+			return "", fmt.Errorf("output rejected after %d attempts: %w", attempt, changed)
+		}
+
+		slog.Warn("Output changed the code, regenerating", "attempt", attempt, "max_attempts", g.VerifyRetries+1, "hunk", changed.Hunk, "invalid", changed.Invalid)
+		reason := "it changed the code, which must stay exactly as it is apart from comments"
+		if changed.Invalid != "" {
+			reason = "it is not valid code (" + changed.Invalid + ")"
+		}
+		note := "\n\nA previous answer was rejected because " + reason + ":\n```diff\n" +
+			changed.Hunk + "\n```\nOnly add documentation comments."
+		if output, err = generate(note); err != nil {
+			return "", err
+		}
+	}
+}
+
+// stripEchoedCode removes the declaration from a generated comment when the
+// model repeated it despite being asked not to. Python docstrings follow the
+// signature, so for Python the docstring itself is extracted.
+func stripEchoedCode(comment string, code string, language string) string {
+	if language == "python" || language == "py" {
+		if docstring, ok := extractDocstring(comment); ok {
+			return docstring
+		}
+	}
+
+	var first string
+	for _, line := range strings.Split(code, "\n") {
+		if first = strings.TrimSpace(line); first != "" {
+			break
+		}
 	}
+	if first == "" {
+		return strings.TrimSpace(comment)
+	}
+
+	lines := strings.Split(comment, "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) == first {
+			lines = lines[:i]
+			break
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
 }
 
-// GenerateDocumentation generates documentation for code
-func (g *DocGenerator) GenerateDocumentation(ctx context.Context, modelName string, temperature float32, code string, language string, style string, verbose bool) (string, error) {
-	// Prepare the prompt based on the language and style
-	prompt := buildPrompt(code, language, style)
+// extractDocstring returns the first triple-quoted string in text
+func extractDocstring(text string) (string, bool) {
+	start := -1
+	quote := ""
+	for _, q := range []string{`"""`, "'''"} {
+		if i := strings.Index(text, q); i >= 0 && (start < 0 || i < start) {
+			start, quote = i, q
+		}
+	}
+	if start < 0 {
+		return "", false
+	}
 
-	if verbose {
-		log.Println("Sending request to Gemini API...")
+	end := strings.Index(text[start+len(quote):], quote)
+	if end < 0 {
+		return "", false
+	}
+	end += start + 2*len(quote)
+
+	// Keep a string prefix like r or u
+	if start > 0 && strings.ContainsRune("rRuU", rune(text[start-1])) && (start == 1 || !isWordByte(text[start-2])) {
+		start--
+	}
+	return text[start:end], true
+}
+
+// isWordByte reports whether b can be part of an identifier
+func isWordByte(b byte) bool {
+	return b == '_' || ('0' <= b && b <= '9') || ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z')
+}
+
+// generate sends a prompt to the model and extracts the output in language from the response
+func (g *DocGenerator) generate(ctx context.Context, modelName string, temperature float32, prompt string, language string) (string, error) {
+	slog.Debug("Sending request", "provider", g.client.Name(), "model", modelName)
+
+	req := common.GenerateRequest{
+		Prompt:      prompt,
+		Model:       modelName,
+		Temperature: temperature,
+	}
+
+	// In structured mode the output comes back as a JSON field, so no extraction is needed
+	if g.Structured {
+		req.Prompt += "\n\nReturn the result as a JSON object with a single \"documentation\" field."
+		var result documentationResult
+		if err := common.GenerateJSON(ctx, g.client, req, &result); err != nil {
+			return "", fmt.Errorf("error generating documentation: %w", err)
+		}
+		return strings.TrimSpace(result.Documentation), nil
 	}
 
 	// Generate content using the AI client
-	result, err := g.client.Generate(ctx, prompt, modelName, temperature)
+	resp, err := common.GenerateText(ctx, g.client, req, g.Stream)
 	if err != nil {
-		return "", fmt.Errorf("error generating documentation: %v", err)
+		return "", fmt.Errorf("error generating documentation: %w", err)
 	}
 
 	// Extract and format the output
-	output := common.ExtractCode(result, getLanguageMarker(language))
-	
+	output := common.SelectCode(resp.Text, getLanguageMarkers(language), g.Selection)
+
 	// For markdown documentation, we're good to go
 	// For other styles, we need to format differently in the calling code
-	
+
 	return output, nil
 }
 
-// buildPrompt creates a prompt for the AI based on the code, language, and style
-func buildPrompt(code string, language string, style string) string {
-	var sb strings.Builder
-
-	// Base prompt
-	sb.WriteString(fmt.Sprintf("Generate high-quality documentation for the following %s code. ", getLanguageName(language)))
-	
-	// Style-specific instructions
-	switch style {
-	case "jsdoc", "tsdoc":
-		sb.WriteString("Use JSDoc style with detailed descriptions, @param, @returns, @throws tags where appropriate. Include type information and examples where helpful. ")
-	case "godoc":
-		sb.WriteString("Follow Go's standard godoc convention. Start with a brief summary. Include example usage where appropriate. Document parameters and return values. ")
-	case "docstring":
-		sb.WriteString("Use Python docstring conventions following Google style. Include descriptions for the function/class, Args, Returns, Raises sections with type information. ")
-	case "xml":
-		sb.WriteString("Use XML documentation comments style (e.g., /// for C# or /** */ for Java). Include parameter descriptions, return value details, and exception information. ")
-	case "markdown":
-		sb.WriteString("Create Markdown documentation with proper headings, code blocks, parameter tables, and examples. Include detailed usage information. ")
-	default:
-		// Default style based on the language
-		switch language {
-		case "typescript", "ts", "javascript", "js":
-			sb.WriteString("Use JSDoc style with detailed descriptions, @param, @returns, @throws tags. Include type information and examples where helpful. ")
-		case "go", "golang":
-			sb.WriteString("Follow Go's standard godoc convention. Start with a brief summary. Include example usage where appropriate. Document parameters and return values. ")
-		case "python", "py":
-			sb.WriteString("Use Python docstring conventions following Google style. Include descriptions for the function/class, Args, Returns, Raises sections with type information. ")
-		case "java":
-			sb.WriteString("Use JavaDoc style comments with @param, @return, and @throws tags. Include detailed descriptions for classes, methods, and fields. ")
-		case "csharp", "cs":
-			sb.WriteString("Use XML documentation comments (///) with <summary>, <param>, <returns>, and <exception> tags. ")
-		case "rust", "rs":
-			sb.WriteString("Use Rust's documentation syntax (///) following rustdoc conventions. Include examples in ```rust blocks. Document parameters, return values, and errors. ")
-		default:
-			sb.WriteString("Include detailed comments describing what the code does, parameters, return values, and examples where appropriate. ")
-		}
-	}
-
-	// Additional instructions
-	sb.WriteString("Ensure the documentation is comprehensive yet concise. Focus on explaining the purpose, usage, parameters, and return values. ")
-	sb.WriteString("The documentation should be directly applicable to the code and ready to use without modifications. ")
-	sb.WriteString("Maintain the original structure and formatting of the code, only adding documentation comments. ")
-	sb.WriteString("Output both the documentation comments and the original code together as a complete documented file. ")
-
-	// Add the code
-	sb.WriteString("\n\nCODE TO DOCUMENT:\n```")
-	sb.WriteString(language)
-	sb.WriteString("\n")
-	sb.WriteString(code)
-	sb.WriteString("\n```")
-
-	return sb.String()
+// outputLanguage returns the language of the generated output: Markdown for
+// the markdown style, the language of the source code otherwise
+func outputLanguage(language string, style string) string {
+	if style == "markdown" {
+		return "markdown"
+	}
+	return language
+}
+
+// chunkBudget returns the maximum number of source tokens to send in one request
+func (g *DocGenerator) chunkBudget(modelName string, language string, style string) int {
+	if g.ChunkTokens > 0 {
+		return g.ChunkTokens
+	}
+
+	template, _ := renderPrompt("", language, style)
+	overhead := common.EstimateTokens(template)
+	budget := common.InputBudget(modelName, overhead)
+
+	// Unless we only ask for Markdown, the model re-emits the whole source plus
+	// comments, so the source must also leave room in the output limit
+	if style != "markdown" {
+		if outputBudget := common.LimitsForModel(modelName).Output * 2 / 3; outputBudget < budget {
+			budget = outputBudget
+		}
+	}
+
+	return budget
 }
 
 // getLanguageName returns the full name of a language from its code
@@ -121,33 +322,43 @@ func getLanguageName(code string) string {
 		return "Swift"
 	case "kotlin", "kt":
 		return "Kotlin"
+	case "ruby":
+		return "Ruby"
+	case "cpp":
+		return "C++"
+	case "php":
+		return "PHP"
+	case "bash":
+		return "Bash"
 	default:
 		return code
 	}
 }
 
-// getLanguageMarker returns the language marker used in code blocks
-func getLanguageMarker(code string) string {
+// getLanguageMarkers returns the names a code block in the language may be labeled with
+func getLanguageMarkers(code string) []string {
 	switch code {
 	case "typescript", "ts":
-		return "typescript|ts"
+		return []string{"typescript", "ts"}
 	case "javascript", "js":
-		return "javascript|js"
+		return []string{"javascript", "js"}
 	case "go", "golang":
-		return "go|golang"
+		return []string{"go", "golang"}
 	case "python", "py":
-		return "python|py"
+		return []string{"python", "py"}
 	case "rust", "rs":
-		return "rust|rs"
+		return []string{"rust", "rs"}
 	case "java":
-		return "java"
+		return []string{"java"}
 	case "csharp", "cs":
-		return "csharp|cs"
+		return []string{"csharp", "cs"}
 	case "swift":
-		return "swift"
+		return []string{"swift"}
 	case "kotlin", "kt":
-		return "kotlin|kt"
+		return []string{"kotlin", "kt"}
+	case "markdown", "md":
+		return []string{"markdown", "md"}
 	default:
-		return code
+		return []string{code}
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,67 @@
+package docgen
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kamdyn/ai-toolkit/pkg/common"
+)
+
+// The model and temperature the cassettes under testdata were recorded with
+const (
+	testModel       = "gemini-2.0-flash"
+	testTemperature = 0.2
+)
+
+// newReplayGenerator returns a DocGenerator answering from a cassette under testdata
+func newReplayGenerator(t *testing.T, cassette string) *DocGenerator {
+	t.Helper()
+	c, err := common.LoadCassette(filepath.Join("testdata", cassette))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewDocGenerator(common.NewAIClientWithProvider(common.NewReplayProvider(common.ProviderGemini, c)))
+}
+
+// readTestdata returns the contents of a file under testdata
+func readTestdata(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestGenerateDocumentation(t *testing.T) {
+	g := newReplayGenerator(t, "docgen.cassette.json")
+
+	got, err := g.GenerateDocumentation(context.Background(), testModel, testTemperature, readTestdata(t, "sample.go"), "go", "godoc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.TrimSpace(readTestdata(t, "sample.documented.go")); got != want {
+		t.Errorf("documentation mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestGenerateDocumentationRejectsChangedCode(t *testing.T) {
+	// Every recorded answer renames the parameter of NewGreeter
+	g := newReplayGenerator(t, "docgen-changed.cassette.json")
+	g.VerifyRetries = 1
+
+	_, err := g.GenerateDocumentation(context.Background(), testModel, testTemperature, readTestdata(t, "sample.go"), "go", "godoc")
+	var changed *CodeChangedError
+	if !errors.As(err, &changed) {
+		t.Fatalf("expected a CodeChangedError, got %v", err)
+	}
+	for _, line := range []string{"-func NewGreeter(prefix string) *Greeter {", "+func NewGreeter(p string) *Greeter {"} {
+		if !strings.Contains(changed.Hunk, line) {
+			t.Errorf("hunk does not contain %q:\n%s", line, changed.Hunk)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package docgen
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/kamdyn/ai-toolkit/pkg/common"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// goContextLines is the number of lines around a declaration sent as context
+const goContextLines = 30
+
+// goDeclaration is an exported declaration missing a doc comment
+type goDeclaration struct {
+	// Name names the declaration in logs, e.g. "T.Method" or "const block"
+	Name string
+	// Pos is where the declaration, or its directives, start
+	Pos token.Pos
+	// End is the end of the declaration
+	End token.Pos
+	// Doc is the doc comment field of the declaration's node, which holds
+	// its directives if it has some
+	Doc **ast.CommentGroup
+}
+
+// DocumentGoSource adds doc comments to the exported declarations of a Go
+// source file that lack one. The model only writes the comment of each
+// declaration, which is attached to it as an *ast.CommentGroup, and the file
+// is printed with go/format: the result is gofmt'd and its tokens, comments
+// aside, are identical to those of src.
+func (g *DocGenerator) DocumentGoSource(ctx context.Context, modelName string, temperature float32, src []byte, filename string) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing Go source: %v", err)
+	}
+
+	decls := undocumentedDeclarations(file)
+	slog.Debug("Found undocumented exported declarations", "file", filename, "count", len(decls))
+
+	lines := strings.Split(string(src), "\n")
+	comments := make([][]string, len(decls))
+	var lineStarts []int
+	for i, decl := range decls {
+		start, end := fset.Position(decl.Pos), fset.Position(decl.End)
+
+		// A comment above the line would document the code before the declaration
+		if strings.TrimSpace(lines[start.Line-1][:start.Column-1]) != "" {
+			slog.Warn("Skipping declaration that does not start its line", "symbol", decl.Name, "line", start.Line)
+			continue
+		}
+
+		comments[i], err = g.goDocComment(ctx, modelName, temperature, lines, start.Line, end.Line, decl.Name)
+		if err != nil {
+			return nil, fmt.Errorf("error documenting %s: %w", decl.Name, err)
+		}
+		lineStarts = append(lineStarts, start.Offset-(start.Column-1))
+	}
+
+	// go/printer places comments by position. Each comment gets a line of its
+	// own: an empty line is added above every declaration being documented,
+	// and the source is parsed again with the comments placed on those lines.
+	padded := make([]byte, 0, len(src)+len(lineStarts))
+	last := 0
+	for _, offset := range lineStarts {
+		padded = append(append(padded, src[last:offset]...), '\n')
+		last = offset
+	}
+	padded = append(padded, src[last:]...)
+
+	fset = token.NewFileSet()
+	file, err = parser.ParseFile(fset, filename, padded, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing Go source: %v", err)
+	}
+	tokenFile := fset.File(file.Pos())
+
+	// Whitespace aside the source is unchanged, so the declarations are too
+	for i, decl := range undocumentedDeclarations(file) {
+		if comments[i] == nil {
+			continue
+		}
+
+		pos := fset.Position(decl.Pos)
+		slash := tokenFile.Pos(pos.Offset - (pos.Column - 1) - 1)
+		group := make([]*ast.Comment, len(comments[i]))
+		for j, line := range comments[i] {
+			group[j] = &ast.Comment{Slash: slash, Text: line}
+		}
+
+		// Directives the declaration already has stay below the comment
+		if *decl.Doc != nil {
+			(*decl.Doc).List = append(group, (*decl.Doc).List...)
+			continue
+		}
+		*decl.Doc = &ast.CommentGroup{List: group}
+		file.Comments = append(file.Comments, *decl.Doc)
+	}
+	sort.SliceStable(file.Comments, func(i, j int) bool {
+		return file.Comments[i].Pos() < file.Comments[j].Pos()
+	})
+
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, file); err != nil {
+		return nil, fmt.Errorf("error formatting documented source: %v", err)
+	}
+	formatted := buf.Bytes()
+	if changed := compareCode(string(src), string(formatted), "go"); changed != nil {
+		// gofmt normalizes some literals, like 0X1F to 0x1F
+		return nil, fmt.Errorf("error checking documented source, format the file with gofmt first: %w", changed)
+	}
+	return formatted, nil
+}
+
+// goDocComment asks the model for the doc comment of the declaration on
+// lines [startLine, endLine], one-based, and returns it as // comment lines
+func (g *DocGenerator) goDocComment(ctx context.Context, modelName string, temperature float32, lines []string, startLine, endLine int, name string) (comment []string, err error) {
+	ctx, span := common.StartSpan(ctx, "docgen.comment",
+		attribute.String("docgen.lang", "go"),
+		attribute.String("docgen.symbol", name))
+	defer func() { common.EndSpan(span, err) }()
+
+	contextStart, contextEnd := startLine-1-goContextLines, endLine+goContextLines
+	if contextStart < 0 {
+		contextStart = 0
+	}
+	if contextEnd > len(lines) {
+		contextEnd = len(lines)
+	}
+
+	slog.Debug("Generating doc comment", "symbol", name, "line", startLine)
+	text, err := g.GenerateDocComment(ctx, modelName, temperature,
+		strings.Join(lines[startLine-1:endLine], "\n"),
+		strings.Join(lines[contextStart:contextEnd], "\n"),
+		"go", "godoc")
+	if err != nil {
+		return nil, err
+	}
+
+	comment = goLineComment(text)
+	if len(comment) == 0 {
+		return nil, fmt.Errorf("the model returned no comment")
+	}
+	return comment, nil
+}
+
+// undocumentedDeclarations returns the exported top-level declarations of a
+// file without a doc comment, in source order. Grouped types are documented
+// one by one; grouped constants and variables get one comment for the group.
+func undocumentedDeclarations(file *ast.File) []goDeclaration {
+	var decls []goDeclaration
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if !ast.IsExported(d.Name.Name) || hasDocText(d.Doc) {
+				continue
+			}
+			name := d.Name.Name
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				recv := receiverTypeName(d.Recv.List[0].Type)
+				if !ast.IsExported(recv) {
+					continue
+				}
+				name = recv + "." + name
+			}
+			decls = append(decls, goDeclaration{Name: name, Pos: docStart(d.Doc, d.Pos()), End: d.End(), Doc: &d.Doc})
+
+		case *ast.GenDecl:
+			if d.Tok == token.IMPORT || hasDocText(d.Doc) {
+				continue
+			}
+
+			// A single declaration, or a group documented as a whole
+			if !d.Lparen.IsValid() || d.Tok != token.TYPE {
+				if name, ok := exportedSpecName(d); ok {
+					decls = append(decls, goDeclaration{Name: name, Pos: docStart(d.Doc, d.Pos()), End: d.End(), Doc: &d.Doc})
+				}
+				continue
+			}
+
+			for _, spec := range d.Specs {
+				ts := spec.(*ast.TypeSpec)
+				if ast.IsExported(ts.Name.Name) && !hasDocText(ts.Doc) {
+					decls = append(decls, goDeclaration{Name: ts.Name.Name, Pos: docStart(ts.Doc, ts.Pos()), End: ts.End(), Doc: &ts.Doc})
+				}
+			}
+		}
+	}
+	return decls
+}
+
+// exportedSpecName names a declaration with at least one exported name
+func exportedSpecName(d *ast.GenDecl) (string, bool) {
+	for _, spec := range d.Specs {
+		switch s := spec.(type) {
+		case *ast.TypeSpec:
+			if ast.IsExported(s.Name.Name) {
+				return s.Name.Name, true
+			}
+		case *ast.ValueSpec:
+			for _, name := range s.Names {
+				if ast.IsExported(name.Name) {
+					if d.Lparen.IsValid() {
+						return d.Tok.String() + " block", true
+					}
+					return name.Name, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// hasDocText reports whether a doc comment says something. Directives such as
+// //go:generate alone do not count.
+func hasDocText(doc *ast.CommentGroup) bool {
+	return doc != nil && strings.TrimSpace(doc.Text()) != ""
+}
+
+// docStart returns where a new doc comment goes: above the directives of the
+// declaration if it has some, so they stay last
+func docStart(doc *ast.CommentGroup, pos token.Pos) token.Pos {
+	if doc != nil {
+		return doc.Pos()
+	}
+	return pos
+}
+
+// receiverTypeName returns the name of a method's receiver type
+func receiverTypeName(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return receiverTypeName(t.X)
+	case *ast.ParenExpr:
+		return receiverTypeName(t.X)
+	case *ast.IndexExpr:
+		return receiverTypeName(t.X)
+	case *ast.IndexListExpr:
+		return receiverTypeName(t.X)
+	case *ast.Ident:
+		return t.Name
+	}
+	return ""
+}
+
+// goLineComment converts a generated comment to // comment lines, whatever
+// comment syntax the model used
+func goLineComment(text string) []string {
+	text = strings.TrimSpace(text)
+	block := strings.HasPrefix(text, "/*") && strings.HasSuffix(text, "*/")
+	if block {
+		text = strings.TrimSuffix(strings.TrimPrefix(text, "/*"), "*/")
+	}
+
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "//"):
+			line = strings.TrimSpace(strings.TrimPrefix(line, "//"))
+		case block && strings.HasPrefix(line, "*"):
+			// The leading stars of a block comment, not Markdown bullets
+			line = strings.TrimSpace(strings.TrimPrefix(line, "*"))
+		}
+		if line == "" {
+			lines = append(lines, "//")
+			continue
+		}
+		lines = append(lines, "// "+line)
+	}
+
+	// Drop blank lines around the comment
+	for len(lines) > 0 && lines[0] == "//" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "//" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
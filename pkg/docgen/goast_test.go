@@ -0,0 +1,145 @@
+package docgen
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/kamdyn/ai-toolkit/pkg/common"
+)
+
+// cannedProvider answers the requests with its responses in turn and
+// records their prompts
+type cannedProvider struct {
+	responses []string
+	calls     int
+	prompts   []string
+}
+
+func (p *cannedProvider) Name() string { return "test" }
+
+func (p *cannedProvider) Generate(ctx context.Context, req common.GenerateRequest) (*common.GenerateResponse, error) {
+	text := p.responses[p.calls%len(p.responses)]
+	p.calls++
+	p.prompts = append(p.prompts, req.Prompt)
+	return &common.GenerateResponse{Text: text, Model: req.Model, FinishReason: common.FinishStop}, nil
+}
+
+func (p *cannedProvider) GenerateStream(ctx context.Context, req common.GenerateRequest) (<-chan common.StreamChunk, error) {
+	resp, _ := p.Generate(ctx, req)
+	ch := make(chan common.StreamChunk, 1)
+	ch <- common.StreamChunk{Text: resp.Text, FinishReason: resp.FinishReason}
+	close(ch)
+	return ch, nil
+}
+
+func (p *cannedProvider) CountTokens(ctx context.Context, model, text string) (int, error) {
+	return len(text) / 4, nil
+}
+
+func (p *cannedProvider) Close() error { return nil }
+
+func TestDocumentGoSource(t *testing.T) {
+	src := `package shapes
+
+import "math"
+
+// Area is documented already
+func Area(r float64) float64 { return math.Pi * r * r }
+func Perimeter(r float64) float64 { return 2 * math.Pi * r }
+
+// Section: constants
+
+const Unit = "cm"
+
+//go:generate stringer -type=Kind
+type Kind int
+
+type (
+	Circle struct{ R float64 }
+	// Square is documented already
+	Square struct{ Side float64 }
+)
+
+func (c Circle) Scale(f float64) Circle {
+	return Circle{R: c.R * f}
+}
+
+func helper() {}
+`
+	want := `package shapes
+
+import "math"
+
+// Area is documented already
+func Area(r float64) float64 { return math.Pi * r * r }
+
+// Perimeter is documented by the model.
+func Perimeter(r float64) float64 { return 2 * math.Pi * r }
+
+// Section: constants
+
+// Unit is documented by the model.
+const Unit = "cm"
+
+// Kind is documented by the model.
+//
+//go:generate stringer -type=Kind
+type Kind int
+
+type (
+	// Circle is documented by the model.
+	Circle struct{ R float64 }
+	// Square is documented already
+	Square struct{ Side float64 }
+)
+
+// Circle.Scale is documented by the model.
+func (c Circle) Scale(f float64) Circle {
+	return Circle{R: c.R * f}
+}
+
+func helper() {}
+`
+	provider := &cannedProvider{responses: []string{
+		// Plain text, which is not a Go comment yet
+		"Perimeter is documented by the model.",
+		"// Unit is documented by the model.",
+		"/* Kind is documented by the model. */",
+		"```go\n// Circle is documented by the model.\n```",
+		"// Circle.Scale is documented by the model.",
+	}}
+	g := NewDocGenerator(common.NewAIClientWithProvider(provider))
+
+	got, err := g.DocumentGoSource(context.Background(), testModel, testTemperature, []byte(src), "shapes.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("documented source mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+	if provider.calls != 5 {
+		t.Errorf("expected 5 requests, got %d", provider.calls)
+	}
+}
+
+func TestGoLineComment(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"line comment", "// Open opens a file.\n//\n// It fails if the file is missing.", []string{"// Open opens a file.", "//", "// It fails if the file is missing."}},
+		{"plain text", "\nOpen opens a file.\n\n", []string{"// Open opens a file."}},
+		{"block comment", "/*\n * Open opens a file.\n *\n * It fails if the file is missing.\n */", []string{"// Open opens a file.", "//", "// It fails if the file is missing."}},
+		{"bullets", "// Open accepts:\n// * a path\n// * a URL", []string{"// Open accepts:", "// * a path", "// * a URL"}},
+		{"plain text bullets", "Open accepts:\n* a path\n* a URL", []string{"// Open accepts:", "// * a path", "// * a URL"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := goLineComment(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("goLineComment(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
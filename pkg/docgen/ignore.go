@@ -0,0 +1,212 @@
+package docgen
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// IgnoreFile is the name of the files listing paths docgen --dir skips, in
+// addition to those in .gitignore
+const IgnoreFile = ".docgenignore"
+
+// defaultIgnorePatterns skip dependencies and test fixtures. They are applied
+// before the ignore files, so a pattern like !vendor/ brings a directory back.
+var defaultIgnorePatterns = []string{"vendor/", "node_modules/", "testdata/"}
+
+// ignoreRule is a single gitignore pattern
+type ignoreRule struct {
+	// base is the directory of the ignore file, relative to the walked root
+	base    string
+	negate  bool
+	dirOnly bool
+	re      *regexp.Regexp
+}
+
+// ignoreRules holds the rules of the ignore files found so far, by the
+// directory they apply to
+type ignoreRules map[string][]ignoreRule
+
+// parseIgnoreRule parses a line of an ignore file. ok is false for blank
+// lines and comments.
+func parseIgnoreRule(line, base string) (rule ignoreRule, ok bool, err error) {
+	line = strings.TrimSuffix(line, "\r")
+
+	// Trailing spaces are ignored unless escaped
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false, nil
+	}
+
+	rule.base = base
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false, nil
+	}
+
+	rule.re, err = compileGlob(line)
+	if err != nil {
+		return ignoreRule{}, false, err
+	}
+	return rule, true, nil
+}
+
+// load reads the ignore file at file, which applies to the directory dir
+func (r ignoreRules) load(file, dir string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		rule, ok, err := parseIgnoreRule(scanner.Text(), dir)
+		if err != nil {
+			slog.Warn("Ignoring invalid pattern", "file", file, "pattern", scanner.Text(), "error", err)
+			continue
+		}
+		if ok {
+			r[dir] = append(r[dir], rule)
+		}
+	}
+	return scanner.Err()
+}
+
+// ignored reports whether the slash-separated path rel, relative to the
+// walked root, is ignored. Rules of deeper directories take precedence, and
+// within a directory the last matching rule wins.
+func (r ignoreRules) ignored(rel string, isDir bool) bool {
+	ignored := false
+	for _, dir := range ancestors(rel) {
+		for _, rule := range r[dir] {
+			if rule.matches(rel, isDir) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// matches reports whether the rule matches the path rel, relative to the walked root
+func (rule ignoreRule) matches(rel string, isDir bool) bool {
+	if rule.dirOnly && !isDir {
+		return false
+	}
+	if rule.base != "." {
+		rel = strings.TrimPrefix(rel, rule.base+"/")
+	}
+	return rule.re.MatchString(rel)
+}
+
+// ancestors returns the directories containing the slash-separated path rel,
+// from the root "." down to its parent
+func ancestors(rel string) []string {
+	dirs := []string{"."}
+	for i := 0; i < len(rel); i++ {
+		if rel[i] == '/' {
+			dirs = append(dirs, rel[:i])
+		}
+	}
+	return dirs
+}
+
+// globPatterns is a list of globs like those of --include and --exclude
+type globPatterns []*regexp.Regexp
+
+// compileGlobs compiles a list of globs
+func compileGlobs(patterns []string) (globPatterns, error) {
+	var globs globPatterns
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		re, err := compileGlob(strings.TrimSuffix(pattern, "/"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+		globs = append(globs, re)
+	}
+	return globs, nil
+}
+
+// match reports whether any glob matches the slash-separated path rel
+func (g globPatterns) match(rel string) bool {
+	for _, re := range g {
+		if re.MatchString(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// compileGlob translates a gitignore-style glob to a regular expression over
+// slash-separated paths. A glob without a slash, other than a trailing one,
+// matches a name at any depth; otherwise it is anchored at the base
+// directory. * and ? do not cross slashes, ** does.
+func compileGlob(glob string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	if strings.Contains(glob, "/") {
+		sb.WriteString("^")
+		glob = strings.TrimPrefix(glob, "/")
+	} else {
+		sb.WriteString("^(?:.*/)?")
+	}
+
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		atSegmentStart := i == 0 || glob[i-1] == '/'
+		switch {
+		case c == '*' && atSegmentStart && strings.HasPrefix(glob[i:], "**/"):
+			// Any number of directories, including none
+			sb.WriteString("(?:.*/)?")
+			i += 2
+		case c == '*' && atSegmentStart && glob[i:] == "**":
+			// Everything below
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			sb.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+
+	return regexp.Compile(sb.String())
+}
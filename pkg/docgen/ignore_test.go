@@ -0,0 +1,116 @@
+package docgen
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompileGlob(t *testing.T) {
+	tests := []struct {
+		glob string
+		path string
+		want bool
+	}{
+		// Globs without a slash match a name at any depth
+		{"*.go", "main.go", true},
+		{"*.go", "pkg/util/main.go", true},
+		{"*.go", "main.go.md", false},
+		{"build", "build", true},
+		{"build", "src/build", true},
+		{"build", "builder", false},
+		// Globs with a slash are anchored
+		{"/build", "build", true},
+		{"/build", "src/build", false},
+		{"src/*.js", "src/app.js", true},
+		{"src/*.js", "lib/src/app.js", false},
+		{"src/*.js", "src/lib/app.js", false},
+		{"src/**/*.js", "src/app.js", true},
+		{"src/**/*.js", "src/lib/deep/app.js", true},
+		{"**/gen/*.ts", "gen/api.ts", true},
+		{"**/gen/*.ts", "web/gen/api.ts", true},
+		{"vendor/**", "vendor/a/b.go", true},
+		{"vendor/**", "vendor", false},
+		{"file?.py", "file1.py", true},
+		{"file?.py", "file10.py", false},
+		{"[abc].rs", "b.rs", true},
+		{"[!abc].rs", "b.rs", false},
+		{"[!abc].rs", "d.rs", true},
+		{`\*.c`, "*.c", true},
+		{`\*.c`, "main.c", false},
+		{"a.b", "axb", false},
+	}
+
+	for _, tt := range tests {
+		re, err := compileGlob(tt.glob)
+		if err != nil {
+			t.Fatalf("compileGlob(%q): %v", tt.glob, err)
+		}
+		if got := re.MatchString(tt.path); got != tt.want {
+			t.Errorf("%q matches %q = %v, want %v", tt.glob, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestIgnoreRules(t *testing.T) {
+	// files maps the directory of each ignore file to its contents
+	tests := []struct {
+		name  string
+		files map[string]string
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"not listed", map[string]string{".": "*.log"}, "main.go", false, false},
+		{"comment", map[string]string{".": "# main.go\n\n"}, "main.go", false, false},
+		{"name at any depth", map[string]string{".": "*.pb.go"}, "api/v1/api.pb.go", false, true},
+		{"anchored", map[string]string{".": "/main.go"}, "cmd/main.go", false, false},
+		{"anchored at the root", map[string]string{".": "/main.go"}, "main.go", false, true},
+		{"directory only", map[string]string{".": "build/"}, "build", false, false},
+		{"directory", map[string]string{".": "build/"}, "build", true, true},
+		{"negation", map[string]string{".": "*.js\n!keep.js"}, "src/keep.js", false, false},
+		{"last match wins", map[string]string{".": "!keep.js\n*.js"}, "src/keep.js", false, true},
+		{"escaped", map[string]string{".": `\!important.py`}, "!important.py", false, true},
+		{"trailing spaces", map[string]string{".": "main.go   "}, "main.go", false, true},
+		{"nested file is relative to its directory", map[string]string{"web": "/app.js"}, "web/app.js", false, true},
+		{"nested file does not apply elsewhere", map[string]string{"web": "*.js"}, "api/app.js", false, false},
+		{"nested file overrides the root", map[string]string{".": "*.js", "web": "!app.js"}, "web/app.js", false, false},
+		{"root cannot override nested", map[string]string{".": "!app.js", "web": "*.js"}, "web/app.js", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := ignoreRules{}
+			for dir, contents := range tt.files {
+				for _, line := range strings.Split(contents, "\n") {
+					rule, ok, err := parseIgnoreRule(line, dir)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if ok {
+						rules[dir] = append(rules[dir], rule)
+					}
+				}
+			}
+
+			if got := rules.ignored(tt.path, tt.isDir); got != tt.want {
+				t.Errorf("ignored(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileGlobs(t *testing.T) {
+	globs, err := compileGlobs([]string{" src/** ", "", "docs/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]bool{"src/a/b.go": true, "docs": true, "docs/a.md": false, "lib/a.go": false} {
+		if got := globs.match(path); got != want {
+			t.Errorf("match(%q) = %v, want %v", path, got, want)
+		}
+	}
+
+	if _, err := compileGlobs([]string{"[z-a]"}); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
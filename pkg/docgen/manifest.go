@@ -0,0 +1,126 @@
+package docgen
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ManifestFile is the name of the manifest docgen --dir keeps in the docs directory
+const ManifestFile = ".docgen-manifest.json"
+
+// manifestVersion is bumped when the manifest format changes, which
+// regenerates every file once
+const manifestVersion = 1
+
+// manifest records the inputs each generated document was produced from, so
+// later runs regenerate only the files whose inputs changed
+type manifest struct {
+	Version int                      `json:"version"`
+	Files   map[string]manifestEntry `json:"files"`
+}
+
+// manifestEntry describes the document generated for one source file
+type manifestEntry struct {
+	// SourceHash and PromptHash are the SHA-256 of the source file and of the prompt template
+	SourceHash string `json:"source_hash"`
+	PromptHash string `json:"prompt_hash"`
+	Model      string `json:"model"`
+	// Output is the path of the document, relative to the docs directory
+	Output string `json:"output"`
+}
+
+// loadManifest reads the manifest of a docs directory. A missing, unreadable
+// or outdated manifest yields an empty one, so every file is regenerated.
+func loadManifest(docsDir string) *manifest {
+	empty := &manifest{Version: manifestVersion, Files: map[string]manifestEntry{}}
+
+	data, err := os.ReadFile(filepath.Join(docsDir, ManifestFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("Ignoring unreadable manifest", "dir", docsDir, "error", err)
+		}
+		return empty
+	}
+
+	var stored manifest
+	if err := json.Unmarshal(data, &stored); err != nil {
+		slog.Warn("Ignoring invalid manifest", "dir", docsDir, "error", err)
+		return empty
+	}
+	if stored.Version != manifestVersion || stored.Files == nil {
+		return empty
+	}
+	return &stored
+}
+
+// save writes the manifest to the docs directory
+func (m *manifest) save(docsDir string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding manifest: %v", err)
+	}
+
+	// Write to a temporary file first so an interrupted run never truncates the manifest
+	path := filepath.Join(docsDir, ManifestFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing manifest: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error writing manifest: %v", err)
+	}
+	return nil
+}
+
+// upToDate reports whether the document of relPath was generated from entry's
+// inputs and still exists
+func (m *manifest) upToDate(relPath string, entry manifestEntry, docsDir string) bool {
+	stored, ok := m.Files[relPath]
+	if !ok || stored != entry {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(docsDir, entry.Output))
+	return err == nil
+}
+
+// removeStale deletes the documents of the files in the manifest that are
+// not in relPaths, and forgets them
+func (m *manifest) removeStale(relPaths []string, docsDir string) {
+	current := make(map[string]bool, len(relPaths))
+	for _, relPath := range relPaths {
+		current[relPath] = true
+	}
+
+	var stale []string
+	for relPath := range m.Files {
+		if !current[relPath] {
+			stale = append(stale, relPath)
+		}
+	}
+	sort.Strings(stale)
+
+	for _, relPath := range stale {
+		// Only remove plain file names, never a path out of the docs directory
+		output := m.Files[relPath].Output
+		if output == filepath.Base(output) && output != ManifestFile {
+			if err := os.Remove(filepath.Join(docsDir, output)); err != nil && !os.IsNotExist(err) {
+				slog.Warn("Error removing stale documentation", "file", output, "error", err)
+			}
+		}
+		slog.Debug("Removed documentation of deleted file", "file", relPath)
+		delete(m.Files, relPath)
+	}
+}
+
+// hashContent returns the hex SHA-256 of data
+func hashContent(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
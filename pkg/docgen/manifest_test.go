@@ -0,0 +1,130 @@
+package docgen
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeDoc writes a generated document to the docs directory
+func writeDoc(t *testing.T, docsDir, name string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(docsDir, name), []byte("# "+name+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManifestUpToDate(t *testing.T) {
+	entry := manifestEntry{
+		SourceHash: hashContent([]byte("package main\n")),
+		PromptHash: hashContent([]byte("Document {{.Code}}")),
+		Model:      testModel,
+		Output:     "main.go.md",
+	}
+
+	tests := []struct {
+		name    string
+		change  func(e *manifestEntry)
+		noDoc   bool
+		relPath string
+		want    bool
+	}{
+		{name: "unchanged", want: true},
+		{name: "source changed", change: func(e *manifestEntry) { e.SourceHash = hashContent([]byte("package lib\n")) }},
+		{name: "prompt changed", change: func(e *manifestEntry) { e.PromptHash = hashContent([]byte("Describe {{.Code}}")) }},
+		{name: "model changed", change: func(e *manifestEntry) { e.Model = "gemini-1.5-pro" }},
+		{name: "output renamed", change: func(e *manifestEntry) { e.Output = "main.md" }},
+		{name: "document deleted", noDoc: true},
+		{name: "new file", relPath: "util.go"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docsDir := t.TempDir()
+			m := loadManifest(docsDir)
+			m.Files["main.go"] = entry
+			if !tt.noDoc {
+				writeDoc(t, docsDir, entry.Output)
+			}
+
+			current := entry
+			if tt.change != nil {
+				tt.change(&current)
+			}
+			relPath := "main.go"
+			if tt.relPath != "" {
+				relPath = tt.relPath
+			}
+			if got := m.upToDate(relPath, current, docsDir); got != tt.want {
+				t.Errorf("upToDate = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManifestRemoveStale(t *testing.T) {
+	docsDir := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "notes.md")
+	if err := os.WriteFile(outside, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := loadManifest(docsDir)
+	for relPath, output := range map[string]string{
+		"main.go":       "main.go.md",
+		"old.go":        "old.go.md",
+		"pkg/legacy.go": "pkg_legacy.go.md",
+		"escape.go":     outside,
+		"manifest.go":   ManifestFile,
+	} {
+		m.Files[relPath] = manifestEntry{Output: output}
+		if filepath.Base(output) == output {
+			writeDoc(t, docsDir, output)
+		}
+	}
+
+	m.removeStale([]string{"main.go", "new.go"}, docsDir)
+
+	if len(m.Files) != 1 {
+		t.Errorf("manifest still lists %d files, want only main.go", len(m.Files))
+	}
+	if _, ok := m.Files["main.go"]; !ok {
+		t.Error("the entry of a current file was removed")
+	}
+	for name, want := range map[string]bool{
+		filepath.Join(docsDir, "main.go.md"):       true,
+		filepath.Join(docsDir, "old.go.md"):        false,
+		filepath.Join(docsDir, "pkg_legacy.go.md"): false,
+		filepath.Join(docsDir, ManifestFile):       true,
+		outside:                                    true,
+	} {
+		_, err := os.Stat(name)
+		if got := err == nil; got != want {
+			t.Errorf("%s exists = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestManifestSaveAndLoad(t *testing.T) {
+	docsDir := t.TempDir()
+	m := loadManifest(docsDir)
+	m.Files["main.go"] = manifestEntry{SourceHash: "a", PromptHash: "b", Model: testModel, Output: "main.go.md"}
+	if err := m.save(docsDir); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := loadManifest(docsDir)
+	if len(loaded.Files) != 1 || loaded.Files["main.go"] != m.Files["main.go"] {
+		t.Errorf("loaded %+v, want %+v", loaded.Files, m.Files)
+	}
+
+	// An invalid or outdated manifest regenerates everything
+	for _, data := range []string{"{", `{"version": 0, "files": {"main.go": {}}}`} {
+		if err := os.WriteFile(filepath.Join(docsDir, ManifestFile), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if loaded := loadManifest(docsDir); len(loaded.Files) != 0 {
+			t.Errorf("manifest %q loaded %d files, want none", data, len(loaded.Files))
+		}
+	}
+}
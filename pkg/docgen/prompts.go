@@ -0,0 +1,80 @@
+package docgen
+
+import (
+	"context"
+	"embed"
+	"io/fs"
+
+	"github.com/kamdyn/ai-toolkit/pkg/common"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+//go:embed templates/*.tmpl
+var templateFiles embed.FS
+
+// Prompts holds the docgen prompt templates. Each can be overridden by a file
+// of the same name in .ai-toolkit/prompts/docgen or the user config directory.
+var Prompts = common.RegisterPromptSet("docgen", mustSub(templateFiles, "templates"))
+
+// documentPromptData is the data the document template is rendered with
+type documentPromptData struct {
+	Code         string
+	Language     string
+	LanguageName string
+	Style        string
+}
+
+// buildPrompt creates a prompt for the AI based on the code, language, and style
+func buildPrompt(ctx context.Context, code string, language string, style string) (string, error) {
+	_, span := common.StartSpan(ctx, "docgen.build_prompt",
+		attribute.String("docgen.lang", language),
+		attribute.String("docgen.style", style))
+	prompt, err := renderPrompt(code, language, style)
+	common.EndSpan(span, err)
+	return prompt, err
+}
+
+// renderPrompt renders the document template
+func renderPrompt(code string, language string, style string) (string, error) {
+	return Prompts.Render("document", documentPromptData{
+		Code:         code,
+		Language:     language,
+		LanguageName: getLanguageName(language),
+		Style:        style,
+	})
+}
+
+// commentPromptData is the data the comment template is rendered with
+type commentPromptData struct {
+	Code         string
+	Context      string
+	Language     string
+	LanguageName string
+	Style        string
+}
+
+// buildCommentPrompt creates a prompt asking for the doc comment of a single declaration
+func buildCommentPrompt(ctx context.Context, code string, surrounding string, language string, style string) (string, error) {
+	_, span := common.StartSpan(ctx, "docgen.build_prompt",
+		attribute.String("docgen.template", "comment"),
+		attribute.String("docgen.lang", language),
+		attribute.String("docgen.style", style))
+	prompt, err := Prompts.Render("comment", commentPromptData{
+		Code:         code,
+		Context:      surrounding,
+		Language:     language,
+		LanguageName: getLanguageName(language),
+		Style:        style,
+	})
+	common.EndSpan(span, err)
+	return prompt, err
+}
+
+// mustSub returns the subtree of an embedded file system
+func mustSub(files fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(files, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
@@ -0,0 +1,18 @@
+package sample
+
+import "strings"
+
+// Greeter builds greetings starting with a fixed prefix.
+type Greeter struct {
+	Prefix string
+}
+
+// NewGreeter returns a Greeter using prefix.
+func NewGreeter(prefix string) *Greeter {
+	return &Greeter{Prefix: prefix}
+}
+
+// Greet greets names, separated by commas.
+func (g *Greeter) Greet(names ...string) string {
+	return g.Prefix + " " + strings.Join(names, ", ")
+}
@@ -0,0 +1,15 @@
+package sample
+
+import "strings"
+
+type Greeter struct {
+	Prefix string
+}
+
+func NewGreeter(prefix string) *Greeter {
+	return &Greeter{Prefix: prefix}
+}
+
+func (g *Greeter) Greet(names ...string) string {
+	return g.Prefix + " " + strings.Join(names, ", ")
+}
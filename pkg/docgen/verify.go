@@ -0,0 +1,651 @@
+package docgen
+
+import (
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultVerifyRetries is how many times an output that changes the code is
+// regenerated unless --verify-retries is given
+const DefaultVerifyRetries = 2
+
+// maxHunkLines caps the lines shown for each side of a changed hunk
+const maxHunkLines = 12
+
+// CodeChangedError is returned when a documented output changes the code it
+// documents, beyond adding or editing comments
+type CodeChangedError struct {
+	Language string
+	// Hunk shows the changed lines in unified diff format
+	Hunk string
+	// Invalid describes the syntax error when the output is not valid code,
+	// in which case Hunk shows the line it is on
+	Invalid string
+}
+
+func (e *CodeChangedError) Error() string {
+	if e.Invalid != "" {
+		return fmt.Sprintf("the documented %s code is not valid, %s:\n%s", getLanguageName(e.Language), e.Invalid, e.Hunk)
+	}
+	return fmt.Sprintf("the documented %s code differs from the source apart from comments:\n%s", getLanguageName(e.Language), e.Hunk)
+}
+
+// tokenKind classifies the tokens compared by the verifier
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenPunct
+)
+
+// codeToken is a token of source code, with the lines it spans
+type codeToken struct {
+	text    string
+	kind    tokenKind
+	line    int
+	endLine int
+}
+
+// quote is a string literal delimiter
+type quote struct {
+	delim string
+	// escapes is set when a backslash escapes the next character
+	escapes bool
+	// multiline is set when the literal may span lines
+	multiline bool
+}
+
+// lexSyntax describes the comments and string literals of a language, which
+// is all the verifier needs to tell code from documentation
+type lexSyntax struct {
+	lineComments []string
+	blockComment [2]string
+	// nestedBlocks is set when block comments nest, as in Rust
+	nestedBlocks bool
+	// quotes are tried in order, so longer delimiters come first
+	quotes []quote
+	// hashAtWordStart is set when # starts a comment only at the start of a word, as in shell scripts
+	hashAtWordStart bool
+	// charLiterals is set when ' starts a literal only if it closes right after one character, as in Rust where it also marks lifetimes
+	charLiterals bool
+	// docstrings is set when string literals standing alone document code, as in Python
+	docstrings bool
+	// templates is set when backtick strings embed expressions in ${...}, as in JavaScript
+	templates bool
+	// regexLiterals is set when a slash where no operand is expected starts a regular expression, as in JavaScript
+	regexLiterals bool
+	// rawStrings is set when r"..." and r#"..."# are raw strings, as in Rust
+	rawStrings bool
+	// heredocs is set when <<DELIM starts a here-document on the next line, as in shell scripts
+	heredocs bool
+}
+
+var (
+	doubleQuote    = quote{delim: `"`, escapes: true}
+	singleQuote    = quote{delim: `'`, escapes: true}
+	backtick       = quote{delim: "`", escapes: true, multiline: true}
+	tripleDouble   = quote{delim: `"""`, escapes: true, multiline: true}
+	tripleSingle   = quote{delim: `'''`, escapes: true, multiline: true}
+	cBlockComments = [2]string{"/*", "*/"}
+)
+
+// lexSyntaxes holds the syntax of each language the verifier can tokenize,
+// Go aside, which has its own scanner
+var lexSyntaxes = map[string]lexSyntax{
+	"javascript": {lineComments: []string{"//"}, blockComment: cBlockComments, quotes: []quote{doubleQuote, singleQuote, backtick}, templates: true, regexLiterals: true},
+	"typescript": {lineComments: []string{"//"}, blockComment: cBlockComments, quotes: []quote{doubleQuote, singleQuote, backtick}, templates: true, regexLiterals: true},
+	"java":       {lineComments: []string{"//"}, blockComment: cBlockComments, quotes: []quote{tripleDouble, doubleQuote, singleQuote}},
+	"csharp":     {lineComments: []string{"//"}, blockComment: cBlockComments, quotes: []quote{doubleQuote, singleQuote}},
+	"cpp":        {lineComments: []string{"//"}, blockComment: cBlockComments, quotes: []quote{doubleQuote, singleQuote}},
+	"php":        {lineComments: []string{"//", "#"}, blockComment: cBlockComments, quotes: []quote{doubleQuote, singleQuote}},
+	"kotlin":     {lineComments: []string{"//"}, blockComment: cBlockComments, nestedBlocks: true, quotes: []quote{tripleDouble, doubleQuote, singleQuote}},
+	"swift":      {lineComments: []string{"//"}, blockComment: cBlockComments, nestedBlocks: true, quotes: []quote{tripleDouble, doubleQuote}},
+	"rust":       {lineComments: []string{"//"}, blockComment: cBlockComments, nestedBlocks: true, quotes: []quote{doubleQuote, singleQuote}, charLiterals: true, rawStrings: true},
+	"python":     {lineComments: []string{"#"}, quotes: []quote{tripleDouble, tripleSingle, doubleQuote, singleQuote}, docstrings: true},
+	"ruby":       {lineComments: []string{"#"}, quotes: []quote{doubleQuote, singleQuote}},
+	"bash":       {lineComments: []string{"#"}, quotes: []quote{{delim: `"`, escapes: true, multiline: true}, {delim: `'`, multiline: true}}, hashAtWordStart: true, heredocs: true},
+}
+
+// canonicalLanguage maps the short names of languages to those of lexSyntaxes
+func canonicalLanguage(language string) string {
+	switch language {
+	case "js":
+		return "javascript"
+	case "ts":
+		return "typescript"
+	case "golang":
+		return "go"
+	case "py":
+		return "python"
+	case "rs":
+		return "rust"
+	case "cs":
+		return "csharp"
+	case "kt":
+		return "kotlin"
+	case "c":
+		return "cpp"
+	case "sh":
+		return "bash"
+	default:
+		return language
+	}
+}
+
+// canVerify reports whether the verifier can tokenize the language
+func canVerify(language string) bool {
+	language = canonicalLanguage(language)
+	_, ok := lexSyntaxes[language]
+	return ok || language == "go"
+}
+
+// compareCode checks that documented holds the same code as original,
+// comments and whitespace aside, and returns the hunk spanning the changes if
+// not. An output that does not lex fails unless the original does not
+// either. Languages the verifier cannot tokenize always pass.
+func compareCode(original, documented, language string) *CodeChangedError {
+	if !canVerify(language) {
+		return nil
+	}
+	before, originalErr := codeTokens(original, language)
+	after, err := codeTokens(documented, language)
+	if err != nil && originalErr == nil {
+		return &CodeChangedError{
+			Language: language,
+			Hunk:     formatHunk(nil, 0, -1, strings.Split(documented, "\n"), err.Pos.Line, err.Pos.Line),
+			Invalid:  fmt.Sprintf("line %d: %s", err.Pos.Line, err.Msg),
+		}
+	}
+
+	// Narrow the change down to the tokens between the common prefix and suffix
+	prefix := 0
+	for prefix < len(before) && prefix < len(after) && before[prefix].text == after[prefix].text {
+		prefix++
+	}
+	if prefix == len(before) && prefix == len(after) {
+		return nil
+	}
+	suffix := 0
+	for suffix < len(before)-prefix && suffix < len(after)-prefix &&
+		before[len(before)-1-suffix].text == after[len(after)-1-suffix].text {
+		suffix++
+	}
+
+	beforeFirst, beforeLast := changedLines(before, prefix, len(before)-suffix)
+	afterFirst, afterLast := changedLines(after, prefix, len(after)-suffix)
+	return &CodeChangedError{
+		Language: language,
+		Hunk: formatHunk(strings.Split(original, "\n"), beforeFirst, beforeLast,
+			strings.Split(documented, "\n"), afterFirst, afterLast),
+	}
+}
+
+// changedLines returns the one-based lines spanned by tokens[from:to]. An
+// empty range spans no lines, with first set to the line before the change
+// as in unified diffs.
+func changedLines(tokens []codeToken, from, to int) (first, last int) {
+	switch {
+	case from < to:
+		return tokens[from].line, tokens[to-1].endLine
+	case from < len(tokens):
+		return tokens[from].line - 1, tokens[from].line - 2
+	case from > 0:
+		return tokens[from-1].endLine, tokens[from-1].endLine - 1
+	default:
+		return 0, -1
+	}
+}
+
+// formatHunk formats the changed lines of both sides as a unified diff hunk
+func formatHunk(before []string, beforeFirst, beforeLast int, after []string, afterFirst, afterLast int) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@", beforeFirst, beforeLast-beforeFirst+1, afterFirst, afterLast-afterFirst+1)
+
+	writeLines := func(prefix string, lines []string, first, last int) {
+		for n := first; n <= last && n <= len(lines); n++ {
+			if n-first == maxHunkLines {
+				fmt.Fprintf(&sb, "\n%s... %d more lines", prefix, last-n+1)
+				return
+			}
+			sb.WriteString("\n" + prefix + strings.TrimSuffix(lines[n-1], "\r"))
+		}
+	}
+	writeLines("-", before, beforeFirst, beforeLast)
+	writeLines("+", after, afterFirst, afterLast)
+	return sb.String()
+}
+
+// codeTokens splits source code into the tokens the verifier compares,
+// dropping comments and whitespace. Only Go source is checked for syntax
+// errors, of which the first is returned.
+func codeTokens(src, language string) ([]codeToken, *scanner.Error) {
+	language = canonicalLanguage(language)
+	if language == "go" {
+		return goTokens(src)
+	}
+	syntax := lexSyntaxes[language]
+	tokens := syntax.tokens(src)
+	if syntax.docstrings {
+		tokens = dropDocstrings(tokens)
+	}
+	return tokens, nil
+}
+
+// goTokens tokenizes Go source with the Go scanner, returning the first
+// error it reports along with the tokens
+func goTokens(src string) ([]codeToken, *scanner.Error) {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	var first *scanner.Error
+	var s scanner.Scanner
+	s.Init(file, []byte(src), func(pos token.Position, msg string) {
+		if first == nil {
+			first = &scanner.Error{Pos: pos, Msg: msg}
+		}
+	}, 0)
+
+	var tokens []codeToken
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			return tokens, first
+		}
+
+		t := codeToken{text: lit, kind: tokenPunct, line: fset.Position(pos).Line}
+		switch {
+		case tok == token.SEMICOLON:
+			// Semicolons inserted at line ends read "\n"
+			t.text = ";"
+		case tok == token.STRING || tok == token.CHAR:
+			t.kind = tokenString
+		case tok == token.IDENT || tok.IsLiteral() || tok.IsKeyword():
+			t.kind = tokenWord
+		}
+		if t.text == "" {
+			t.text = tok.String()
+		}
+		t.endLine = t.line + strings.Count(t.text, "\n")
+		tokens = append(tokens, t)
+	}
+}
+
+// tokens splits src into words, string literals and single punctuation
+// characters, skipping comments and whitespace
+func (syntax lexSyntax) tokens(src string) []codeToken {
+	var tokens []codeToken
+	line := 1
+	add := func(text string, kind tokenKind) {
+		tokens = append(tokens, codeToken{text: text, kind: kind, line: line, endLine: line + strings.Count(text, "\n")})
+		line += strings.Count(text, "\n")
+	}
+	// heredocs lists the here-documents whose bodies start on the next line
+	var heredocs []heredoc
+
+	for i := 0; i < len(src); {
+		c := src[i]
+		if c == '\n' {
+			line++
+			i++
+			// Here-document bodies are kept whole, like string literals
+			for _, doc := range heredocs {
+				if n := doc.bodyLength(src[i:]); n > 0 {
+					add(src[i:i+n], tokenString)
+					i += n
+				}
+			}
+			heredocs = nil
+			continue
+		}
+		if c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v' {
+			i++
+			continue
+		}
+
+		if n := syntax.commentLength(src, i); n > 0 {
+			line += strings.Count(src[i:i+n], "\n")
+			i += n
+			continue
+		}
+		if n := syntax.stringLength(src, i); n > 0 {
+			add(src[i:i+n], tokenString)
+			i += n
+			continue
+		}
+		if syntax.regexLiterals && c == '/' && regexAllowed(tokens) {
+			if n := regexLength(src[i:]); n > 0 {
+				add(src[i:i+n], tokenString)
+				i += n
+				continue
+			}
+		}
+		// The last two characters of <<< are not a here-document either
+		if syntax.heredocs && strings.HasPrefix(src[i:], "<<") && (i == 0 || src[i-1] != '<') {
+			if doc, n := parseHeredoc(src[i:]); n > 0 {
+				heredocs = append(heredocs, doc)
+				add(src[i:i+n], tokenPunct)
+				i += n
+				continue
+			}
+		}
+
+		if isWordByte(c) || c >= utf8.RuneSelf {
+			j := i
+			for j < len(src) && (isWordByte(src[j]) || src[j] >= utf8.RuneSelf) {
+				j++
+			}
+			// Rust raw strings start with r or br
+			if syntax.rawStrings && (src[i:j] == "r" || src[i:j] == "br") {
+				if n := rawStringLength(src[j:]); n > 0 {
+					add(src[i:j+n], tokenString)
+					i = j + n
+					continue
+				}
+			}
+			// Python string prefixes such as r or f belong to the string
+			if syntax.docstrings && j-i <= 2 && strings.Trim(src[i:j], "rRbBuUfF") == "" {
+				if n := syntax.stringLength(src, j); n > 0 {
+					add(src[i:j+n], tokenString)
+					i = j + n
+					continue
+				}
+			}
+			add(src[i:j], tokenWord)
+			i = j
+			continue
+		}
+
+		add(src[i:i+1], tokenPunct)
+		i++
+	}
+	return tokens
+}
+
+// commentLength returns the length of the comment starting at src[i], or 0.
+// Line comments stop before the newline.
+func (syntax lexSyntax) commentLength(src string, i int) int {
+	rest := src[i:]
+	for _, marker := range syntax.lineComments {
+		if !strings.HasPrefix(rest, marker) {
+			continue
+		}
+		if marker == "#" && syntax.hashAtWordStart && i > 0 && !strings.ContainsRune(" \t\n;|&(", rune(src[i-1])) {
+			continue
+		}
+		if end := strings.IndexByte(rest, '\n'); end >= 0 {
+			return end
+		}
+		return len(rest)
+	}
+
+	open, closing := syntax.blockComment[0], syntax.blockComment[1]
+	if open == "" || !strings.HasPrefix(rest, open) {
+		return 0
+	}
+	depth := 0
+	for j := 0; j < len(rest); {
+		switch {
+		case strings.HasPrefix(rest[j:], open) && (depth == 0 || syntax.nestedBlocks):
+			depth++
+			j += len(open)
+		case strings.HasPrefix(rest[j:], closing):
+			depth--
+			j += len(closing)
+			if depth == 0 {
+				return j
+			}
+		default:
+			j++
+		}
+	}
+	// An unterminated comment runs to the end
+	return len(rest)
+}
+
+// stringLength returns the length of the string literal starting at src[i],
+// or 0. A literal that may not span lines ends at the newline if unterminated.
+func (syntax lexSyntax) stringLength(src string, i int) int {
+	rest := src[i:]
+	for _, q := range syntax.quotes {
+		if !strings.HasPrefix(rest, q.delim) {
+			continue
+		}
+		if q.delim == "'" && syntax.charLiterals {
+			return charLiteralLength(rest)
+		}
+		if q.delim == "`" && syntax.templates {
+			return syntax.templateLength(rest)
+		}
+
+		for j := len(q.delim); j < len(rest); j++ {
+			switch {
+			case q.escapes && rest[j] == '\\':
+				j++
+			case strings.HasPrefix(rest[j:], q.delim):
+				return j + len(q.delim)
+			case rest[j] == '\n' && !q.multiline:
+				return j
+			}
+		}
+		return len(rest)
+	}
+	return 0
+}
+
+// templateLength returns the length of the template literal starting at
+// rest. The expressions embedded in ${...} may hold strings, comments and
+// templates of their own.
+func (syntax lexSyntax) templateLength(rest string) int {
+	for j := 1; j < len(rest); j++ {
+		switch {
+		case rest[j] == '\\':
+			j++
+		case rest[j] == '`':
+			return j + 1
+		case strings.HasPrefix(rest[j:], "${"):
+			depth := 0
+			for j++; j < len(rest); j++ {
+				if n := syntax.commentLength(rest, j); n > 0 {
+					j += n - 1
+					continue
+				}
+				if n := syntax.stringLength(rest, j); n > 0 {
+					j += n - 1
+					continue
+				}
+				if rest[j] == '{' {
+					depth++
+				} else if rest[j] == '}' {
+					if depth--; depth == 0 {
+						break
+					}
+				}
+			}
+		}
+	}
+	return len(rest)
+}
+
+// regexWords are the keywords after which a slash starts a regular expression
+var regexWords = map[string]bool{
+	"return": true, "typeof": true, "instanceof": true, "in": true, "of": true, "new": true, "delete": true,
+	"void": true, "throw": true, "case": true, "do": true, "else": true, "yield": true, "await": true,
+}
+
+// regexAllowed reports whether a slash following tokens starts a regular
+// expression rather than dividing: at the start, after an operator or an
+// opening bracket, or after a keyword such as return
+func regexAllowed(tokens []codeToken) bool {
+	if len(tokens) == 0 {
+		return true
+	}
+	prev := tokens[len(tokens)-1]
+	switch prev.kind {
+	case tokenPunct:
+		return !strings.Contains(")]}", prev.text)
+	case tokenWord:
+		return regexWords[prev.text]
+	default:
+		return false
+	}
+}
+
+// regexLength returns the length of the regular expression literal starting
+// at rest, flags included, or 0 if it does not end on the same line
+func regexLength(rest string) int {
+	inClass := false
+	for j := 1; j < len(rest); j++ {
+		switch rest[j] {
+		case '\\':
+			j++
+		case '\n':
+			return 0
+		case '[':
+			inClass = true
+		case ']':
+			inClass = false
+		case '/':
+			if inClass {
+				continue
+			}
+			for j++; j < len(rest) && isWordByte(rest[j]); j++ {
+			}
+			return j
+		}
+	}
+	return 0
+}
+
+// rawStringLength returns the length of the Rust raw string starting at rest,
+// after its r prefix, such as "..." or #"..."#, or 0 if none starts there
+func rawStringLength(rest string) int {
+	hashes := 0
+	for hashes < len(rest) && rest[hashes] == '#' {
+		hashes++
+	}
+	if hashes >= len(rest) || rest[hashes] != '"' {
+		return 0
+	}
+	closing := "\"" + strings.Repeat("#", hashes)
+	if end := strings.Index(rest[hashes+1:], closing); end >= 0 {
+		return hashes + 1 + end + len(closing)
+	}
+	return len(rest)
+}
+
+// heredoc is a pending here-document of a shell script
+type heredoc struct {
+	delim string
+	// stripTabs is set for <<-, which allows the closing line to be indented with tabs
+	stripTabs bool
+}
+
+// parseHeredoc parses the here-document operator starting at rest, such as
+// <<EOF, <<-'EOF' or << "EOF", returning its length or 0 if rest starts
+// something else, such as a here-string or a shift
+func parseHeredoc(rest string) (heredoc, int) {
+	var doc heredoc
+	j := 2
+	if strings.HasPrefix(rest, "<<<") {
+		return doc, 0
+	}
+	if j < len(rest) && rest[j] == '-' {
+		doc.stripTabs = true
+		j++
+	}
+	for j < len(rest) && (rest[j] == ' ' || rest[j] == '\t') {
+		j++
+	}
+
+	if j < len(rest) && (rest[j] == '\'' || rest[j] == '"') {
+		end := strings.IndexByte(rest[j+1:], rest[j])
+		if end <= 0 || strings.Contains(rest[j+1:j+1+end], "\n") {
+			return doc, 0
+		}
+		doc.delim = rest[j+1 : j+1+end]
+		return doc, j + end + 2
+	}
+
+	start := j
+	for j < len(rest) && (isWordByte(rest[j]) || rest[j] == '-' || rest[j] == '.') {
+		j++
+	}
+	// A number after << is a shift in an arithmetic expression
+	if j == start || ('0' <= rest[start] && rest[start] <= '9') {
+		return doc, 0
+	}
+	doc.delim = rest[start:j]
+	return doc, j
+}
+
+// bodyLength returns the length of the here-document body at the start of
+// rest, up to and including its closing line but not the newline after it.
+// An unterminated body runs to the end.
+func (doc heredoc) bodyLength(rest string) int {
+	for i := 0; i < len(rest); {
+		end := strings.IndexByte(rest[i:], '\n')
+		if end < 0 {
+			end = len(rest) - i
+		}
+		line := strings.TrimSuffix(rest[i:i+end], "\r")
+		if doc.stripTabs {
+			line = strings.TrimLeft(line, "\t")
+		}
+		if line == doc.delim {
+			return i + end
+		}
+		i += end + 1
+	}
+	return len(rest)
+}
+
+// charLiteralLength returns the length of the character literal starting at
+// rest, or 0 if the quote starts something else, such as a Rust lifetime
+func charLiteralLength(rest string) int {
+	if len(rest) > 2 && rest[1] == '\\' {
+		// An escape like '\n' or '\u{1F600}'
+		if end := strings.IndexByte(rest[2:], '\''); end >= 0 && end <= 10 {
+			return end + 3
+		}
+		return 0
+	}
+	_, size := utf8.DecodeRuneInString(rest[1:])
+	if size > 0 && len(rest) > 1+size && rest[1+size] == '\'' {
+		return size + 2
+	}
+	return 0
+}
+
+// dropDocstrings removes the string literals standing alone on their lines
+// after a block opener or another statement, which document the code rather
+// than being part of it
+func dropDocstrings(tokens []codeToken) []codeToken {
+	kept := tokens[:0:0]
+	for i, t := range tokens {
+		if t.kind == tokenString && alone(tokens, i) {
+			continue
+		}
+		kept = append(kept, t)
+	}
+	return kept
+}
+
+// alone reports whether tokens[i] is a statement of its own
+func alone(tokens []codeToken, i int) bool {
+	t := tokens[i]
+	if i+1 < len(tokens) && tokens[i+1].line <= t.endLine {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	prev := tokens[i-1]
+	if prev.endLine >= t.line {
+		return false
+	}
+	// After an operator, comma or opening bracket the string continues an expression
+	return prev.kind != tokenPunct || strings.Contains(":)]}", prev.text)
+}
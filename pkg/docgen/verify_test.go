@@ -0,0 +1,288 @@
+package docgen
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/kamdyn/ai-toolkit/pkg/common"
+)
+
+func TestCompareCodeRejectsInvalidGo(t *testing.T) {
+	tests := []struct {
+		name       string
+		original   string
+		documented string
+		invalid    bool
+	}{
+		{"comment", "", "// Area returns the area", false},
+		{"unterminated comment", "", "/* Area returns the area", true},
+		{"unterminated comment after the code", "func f() {}", "func f() {}\n/* f does nothing", true},
+		{"invalid original", "s := \"open", "// s is open\ns := \"open", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed := compareCode(tt.original, tt.documented, "go")
+			if !tt.invalid {
+				if changed != nil {
+					t.Errorf("unexpected error: %v", changed)
+				}
+				return
+			}
+			if changed == nil || !strings.Contains(changed.Invalid, "comment not terminated") {
+				t.Fatalf("expected the unterminated comment to be reported, got %v", changed)
+			}
+		})
+	}
+}
+
+func TestCodeTokens(t *testing.T) {
+	tests := []struct {
+		name     string
+		language string
+		src      string
+		want     []string
+	}{
+		// JavaScript and TypeScript
+		{"template literal", "js", "const u = `http://${host}/x` // url", []string{"const", "u", "=", "`http://${host}/x`"}},
+		{"nested template", "js", "s = `a ${`b ${c}`} d` // end", []string{"s", "=", "`a ${`b ${c}`} d`"}},
+		{"template expression with a brace", "js", "s = `${ '}' + `}` }` /* x */", []string{"s", "=", "`${ '}' + `}` }`"}},
+		{"template over lines", "ts", "const q = `\n  // not a comment\n`;", []string{"const", "q", "=", "`\n  // not a comment\n`", ";"}},
+		{"regex like a comment", "js", `re = /\/*x/g; // re`, []string{"re", "=", `/\/*x/g`, ";"}},
+		{"regex with a quote", "js", `if (/"/.test(s)) {}`, []string{"if", "(", `/"/`, ".", "test", "(", "s", ")", ")", "{", "}"}},
+		{"regex with a slash in a class", "js", "re = /[/]+/", []string{"re", "=", "/[/]+/"}},
+		{"regex after return", "ts", `return /\d+/i // digits`, []string{"return", `/\d+/i`}},
+		{"division", "js", "x = (a) / b / c // third", []string{"x", "=", "(", "a", ")", "/", "b", "/", "c"}},
+
+		// Python
+		{"triple-quoted string", "python", `s = """it's # here"""`, []string{"s", "=", `"""it's # here"""`}},
+		{"triple-quoted string over lines", "py", "s = '''\n# not a comment\n'''", []string{"s", "=", "'''\n# not a comment\n'''"}},
+		{"prefixed string", "python", `p = rb'\d' # bytes`, []string{"p", "=", `rb'\d'`}},
+		{"docstring", "python", "def f():\n    \"\"\"Doc.\"\"\"\n    return 1", []string{"def", "f", "(", ")", ":", "return", "1"}},
+
+		// Rust
+		{"raw string", "rust", `let s = r#"say "hi" // no"#;`, []string{"let", "s", "=", `r#"say "hi" // no"#`, ";"}},
+		{"raw byte string", "rs", `let b = br"\d"; // digit`, []string{"let", "b", "=", `br"\d"`, ";"}},
+		{"r as an identifier", "rust", "let r = 1; // r", []string{"let", "r", "=", "1", ";"}},
+		{"nested block comments", "rust", "/* a /* b */ c */ fn f() {}", []string{"fn", "f", "(", ")", "{", "}"}},
+		{"lifetimes and chars", "rust", "fn f<'a>(x: &'a str) -> char { 'x' }", []string{"fn", "f", "<", "'", "a", ">", "(", "x", ":", "&", "'", "a", "str", ")", "-", ">", "char", "{", "'x'", "}"}},
+
+		// Shell
+		{"heredoc", "bash", "cat <<EOF\nit's # here\nEOF\necho done # end", []string{"cat", "<<EOF", "it's # here\nEOF", "echo", "done"}},
+		{"quoted heredoc with tabs", "sh", "cat <<-'END' | grep x\n\tline # 1\n\tEND\nls", []string{"cat", "<<-'END'", "|", "grep", "x", "\tline # 1\n\tEND", "ls"}},
+		{"unterminated heredoc", "bash", "cat << EOF\n# text", []string{"cat", "<< EOF", "# text"}},
+		{"here-string", "bash", `grep x <<< "$s" # c`, []string{"grep", "x", "<", "<", "<", `"$s"`}},
+		{"shift", "bash", "echo $((1 << 2)) # c", []string{"echo", "$", "(", "(", "1", "<", "<", "2", ")", ")"}},
+		{"hash inside a word", "bash", "echo a#b # c", []string{"echo", "a", "#", "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := codeTokens(tt.src, tt.language)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, token := range tokens {
+				got = append(got, token.text)
+			}
+			if strings.Join(got, "\x00") != strings.Join(tt.want, "\x00") {
+				t.Errorf("got tokens %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareCode(t *testing.T) {
+	tests := []struct {
+		name       string
+		language   string
+		original   string
+		documented string
+		// hunk is the expected hunk, empty when the code is unchanged
+		hunk string
+	}{
+		{"js comment", "js", "const re = /\\/*x/;\n", "// re matches x\nconst re = /\\/*x/;\n", ""},
+		{"js regex changed", "js", "const re = /\\/*x/;\n", "// re matches x\nconst re = /\\/*y/;\n", "@@ -1,1 +2,1 @@\n-const re = /\\/*x/;\n+const re = /\\/*y/;"},
+		{"ts template changed", "ts", "f(`a ${b} c`)\n", "/** Calls f */\nf(`a ${b} d`)\n", "@@ -1,1 +2,1 @@\n-f(`a ${b} c`)\n+f(`a ${b} d`)"},
+		{"python docstring", "python", "def f():\n    return 1\n", "def f():\n    '''Return one.'''\n    return 1\n", ""},
+		{"python string changed", "python", "x = '''a'''\n", "# x\nx = '''b'''\n", "@@ -1,1 +2,1 @@\n-x = '''a'''\n+x = '''b'''"},
+		{"rust comment", "rust", "let s = r#\"a\"#;\n", "/* s /* nested */ */\nlet s = r#\"a\"#;\n", ""},
+		{"rust raw string changed", "rust", "let s = r#\"// a\"#;\n", "let s = r#\"// b\"#;\n", "@@ -1,1 +1,1 @@\n-let s = r#\"// a\"#;\n+let s = r#\"// b\"#;"},
+		{"bash comment", "bash", "cat <<EOF\n# a\nEOF\n", "# Prints a\ncat <<EOF\n# a\nEOF\n", ""},
+		{"bash heredoc changed", "bash", "cat <<EOF\n# a\nEOF\n", "cat <<EOF\n# b\nEOF\n", "@@ -2,2 +2,2 @@\n-# a\n-EOF\n+# b\n+EOF"},
+		{"removed code", "python", "a = 1\nb = 2\n", "a = 1\n", "@@ -2,1 +1,0 @@\n-b = 2"},
+		{"unverifiable language", "haskell", "main = print 1", "main = print 2", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed := compareCode(tt.original, tt.documented, tt.language)
+			if tt.hunk == "" {
+				if changed != nil {
+					t.Errorf("unexpected change: %v", changed)
+				}
+				return
+			}
+			if changed == nil {
+				t.Fatal("the change was not detected")
+			}
+			if changed.Hunk != tt.hunk {
+				t.Errorf("got hunk\n%s\nwant\n%s", changed.Hunk, tt.hunk)
+			}
+		})
+	}
+}
+
+func TestDropDocstrings(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"module docstring", "\"\"\"Module.\"\"\"\nimport os", "import os"},
+		{"function docstring", "def f():\n    \"Doc.\"\n    pass", "def f ( ) : pass"},
+		{"after a statement", "x = 1\n'''x is one'''\ny = 2", "x = 1 y = 2"},
+		{"after a closing bracket", "f(a)\n'''Called f'''", "f ( a )"},
+		{"assigned", "s = '''text'''", "s = '''text'''"},
+		{"argument on its own line", "f(\n    'a',\n    'b'\n)", "f ( 'a' , 'b' )"},
+		{"continued on the same line", "'a'.join(x)", "'a' . join ( x )"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, token := range dropDocstrings(lexSyntaxes["python"].tokens(tt.src)) {
+				got = append(got, token.text)
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("got %q, want %q", strings.Join(got, " "), tt.want)
+			}
+		})
+	}
+}
+
+func TestCanVerify(t *testing.T) {
+	// Every language docgen detects can be verified
+	for _, ext := range []string{".js", ".ts", ".tsx", ".go", ".py", ".rs", ".java", ".cs", ".swift", ".kt", ".rb", ".c", ".cpp", ".h", ".php", ".sh"} {
+		if language := DetectLanguage("file" + ext); !canVerify(language) {
+			t.Errorf("canVerify(%q) = false for %s files", language, ext)
+		}
+	}
+
+	tests := map[string]bool{"go": true, "golang": true, "js": true, "ts": true, "py": true, "rs": true, "sh": true, "c": true, "kt": true, "cs": true, "haskell": false, "": false}
+	for language, want := range tests {
+		if got := canVerify(language); got != want {
+			t.Errorf("canVerify(%q) = %v, want %v", language, got, want)
+		}
+	}
+}
+
+func TestVerified(t *testing.T) {
+	const code = "func Area() int { return 1 }\n"
+	changed := "```go\n// Area returns one\nfunc Area() int { return 2 }\n```"
+	valid := "```go\n// Area returns one\nfunc Area() int { return 1 }\n```"
+	invalid := "```go\n/* Area returns one\nfunc Area() int { return 1 }\n```"
+
+	tests := []struct {
+		name      string
+		responses []string
+		configure func(g *DocGenerator)
+		style     string
+		language  string
+		calls     int
+		wantErr   string
+		// notes are expected in the prompts of the retries
+		notes []string
+	}{
+		{
+			name:      "valid",
+			responses: []string{valid},
+			calls:     1,
+		},
+		{
+			name:      "changed then valid",
+			responses: []string{changed, valid},
+			calls:     2,
+			notes: []string{"A previous answer was rejected because it changed the code, which must stay exactly as it is apart from comments:\n" +
+				"```diff\n@@ -1,1 +2,1 @@\n-func Area() int { return 1 }\n+func Area() int { return 2 }\n```\nOnly add documentation comments."},
+		},
+		{
+			name:      "invalid then valid",
+			responses: []string{invalid, valid},
+			calls:     2,
+			notes:     []string{"A previous answer was rejected because it is not valid code (line 1: comment not terminated):\n```diff\n@@ -0,0 +1,1 @@\n+/* Area returns one\n```\nOnly add documentation comments."},
+		},
+		{
+			name:      "gives up",
+			responses: []string{changed},
+			configure: func(g *DocGenerator) { g.VerifyRetries = 1 },
+			calls:     2,
+			wantErr:   "output rejected after 2 attempts: the documented Go code differs from the source apart from comments:\n@@ -1,1 +2,1 @@",
+		},
+		{
+			name:      "no retries",
+			responses: []string{changed, valid},
+			configure: func(g *DocGenerator) { g.VerifyRetries = 0 },
+			calls:     1,
+			wantErr:   "output rejected after 1 attempts",
+		},
+		{
+			name:      "not verified",
+			responses: []string{changed},
+			configure: func(g *DocGenerator) { g.NoVerify = true },
+			calls:     1,
+		},
+		{
+			name:      "markdown",
+			responses: []string{"```markdown\n# Area\n```"},
+			style:     "markdown",
+			calls:     1,
+		},
+		{
+			name:      "unverifiable language",
+			responses: []string{"```haskell\narea = 2\n```"},
+			language:  "haskell",
+			calls:     1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &cannedProvider{responses: tt.responses}
+			g := NewDocGenerator(common.NewAIClientWithProvider(provider))
+			if tt.configure != nil {
+				tt.configure(g)
+			}
+			language, style := "go", "godoc"
+			if tt.language != "" {
+				language = tt.language
+			}
+			if tt.style != "" {
+				style = tt.style
+			}
+
+			_, err := g.generateDocumented(context.Background(), testModel, testTemperature, "Document this", code, language, style)
+			if tt.wantErr == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr != "" {
+				var changedErr *CodeChangedError
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) || !errors.As(err, &changedErr) {
+					t.Errorf("got error %v, want %q", err, tt.wantErr)
+				}
+			}
+			if provider.calls != tt.calls {
+				t.Errorf("got %d calls, want %d", provider.calls, tt.calls)
+			}
+
+			for i, note := range tt.notes {
+				if prompt := provider.prompts[i+1]; prompt != "Document this\n\n"+note {
+					t.Errorf("retry %d prompt:\n%s\nwant the note:\n%s", i+1, prompt, note)
+				}
+			}
+		})
+	}
+}
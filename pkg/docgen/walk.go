@@ -0,0 +1,166 @@
+package docgen
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// DefaultMaxFileSizeKB is the size above which files are skipped unless --max-file-size is given
+const DefaultMaxFileSizeKB = 256
+
+// generatedHeaderLines is the number of lines at the top of a file searched for a generated code marker
+const generatedHeaderLines = 20
+
+// generatedMarker matches the comments tools put at the top of generated
+// files: Go's "Code generated ... DO NOT EDIT.", @generated and similar
+// notices asking not to edit the file
+var generatedMarker = regexp.MustCompile(`Code generated .* DO NOT EDIT|@generated\b|(?i:(?:auto-?generated|generated by)\b.*\bdo not (?:edit|modify))`)
+
+// fileFilter selects the files of a project to document
+type fileFilter struct {
+	// include limits the walk to the files matching one of its globs, if any
+	include globPatterns
+	// exclude skips the files and directories matching one of its globs
+	exclude globPatterns
+	// maxSize skips files larger than this many bytes; zero means no limit
+	maxSize int64
+}
+
+// findSourceFiles walks a project directory and returns its source files,
+// skipping the docs directory, the paths ignored by .gitignore and
+// .docgenignore files, excluded or not included paths, generated files and
+// files above the size limit
+func findSourceFiles(dirPath string, filter fileFilter) ([]string, error) {
+	// The defaults come first so the ignore files can override them
+	rules := ignoreRules{}
+	for _, pattern := range defaultIgnorePatterns {
+		rule, _, err := parseIgnoreRule(pattern, ".")
+		if err != nil {
+			return nil, err
+		}
+		rules["."] = append(rules["."], rule)
+	}
+
+	var codeFiles []string
+	err := filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dirPath, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		// Load the ignore files of each directory before looking at its contents
+		if d.IsDir() {
+			if rel != "." {
+				// Skip the docs directory itself
+				if rel == "docs" || rel == ".git" {
+					return filepath.SkipDir
+				}
+				if skip, reason := filter.skipPath(rules, rel, true); skip {
+					slog.Debug("Skipping directory", "dir", rel, "reason", reason)
+					return filepath.SkipDir
+				}
+			}
+			for _, name := range []string{".gitignore", IgnoreFile} {
+				if err := rules.load(filepath.Join(path, name), rel); err != nil {
+					return fmt.Errorf("error reading %s: %v", filepath.Join(rel, name), err)
+				}
+			}
+			return nil
+		}
+
+		// Only include regular source code files
+		if !d.Type().IsRegular() || !isSourceCodeFile(strings.ToLower(filepath.Ext(path))) {
+			return nil
+		}
+		if skip, reason := filter.skipPath(rules, rel, false); skip {
+			slog.Debug("Skipping file", "file", rel, "reason", reason)
+			return nil
+		}
+		if skip, reason, err := filter.skipContent(path, d); err != nil {
+			return err
+		} else if skip {
+			slog.Debug("Skipping file", "file", rel, "reason", reason)
+			return nil
+		}
+
+		codeFiles = append(codeFiles, path)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codeFiles, nil
+}
+
+// skipPath reports whether a path is left out by its name, and why
+func (f fileFilter) skipPath(rules ignoreRules, rel string, isDir bool) (bool, string) {
+	if rules.ignored(rel, isDir) {
+		return true, "ignored"
+	}
+	if f.exclude.match(rel) {
+		return true, "excluded"
+	}
+	// Directories are walked even when not included, since files below may be
+	if !isDir && len(f.include) > 0 && !f.include.match(rel) {
+		return true, "not included"
+	}
+	return false, ""
+}
+
+// skipContent reports whether a file is left out by its size or because it
+// is generated, and why
+func (f fileFilter) skipContent(path string, d fs.DirEntry) (bool, string, error) {
+	info, err := d.Info()
+	if err != nil {
+		return false, "", err
+	}
+	if f.maxSize > 0 && info.Size() > f.maxSize {
+		return true, fmt.Sprintf("larger than %d KB", f.maxSize/1024), nil
+	}
+
+	generated, err := isGeneratedFile(path)
+	if err != nil {
+		return false, "", err
+	}
+	if generated {
+		return true, "generated", nil
+	}
+	return false, "", nil
+}
+
+// isGeneratedFile reports whether the top of a file carries a generated code marker
+func isGeneratedFile(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for i := 0; i < generatedHeaderLines; i++ {
+		line, err := reader.ReadString('\n')
+		if generatedMarker.MatchString(line) {
+			return true, nil
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,158 @@
+package docgen
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeTree creates the files of a project under a temporary directory
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, contents := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestFindSourceFiles(t *testing.T) {
+	project := map[string]string{
+		"main.go":                   "package main\n",
+		"README.md":                 "# Project\n",
+		"docs/main.go.md":           "# main.go\n",
+		"docs/example.go":           "package example\n",
+		"pkg/api/api.go":            "package api\n",
+		"pkg/api/api.pb.go":         "// Code generated by protoc-gen-go. DO NOT EDIT.\n\npackage api\n",
+		"pkg/api/api_test.go":       "package api\n",
+		"vendor/lib/lib.go":         "package lib\n",
+		"testdata/sample.go":        "package sample\n",
+		"web/app.js":                "export const app = 1\n",
+		"web/bundle.js":             "// @generated\nexport const bundle = 1\n",
+		"web/dist/app.min.js":       "var a=1\n",
+		"scripts/build.py":          "#!/usr/bin/env python3\n# Auto-generated by gen.py, do not edit\nprint(1)\n",
+		"scripts/deploy.py":         "# Deploys the generated files. Do not run twice.\nprint(2)\n",
+		"scripts/late_marker.py":    strings.Repeat("# line\n", generatedHeaderLines) + "# Code generated by hand. DO NOT EDIT.\n",
+		"big/large.go":              "package big\n\n// " + strings.Repeat("x", 2048) + "\n",
+		"node_modules/pkg/index.js": "module.exports = 1\n",
+	}
+
+	tests := []struct {
+		name   string
+		ignore map[string]string
+		filter fileFilter
+		want   []string
+	}{
+		{
+			name: "defaults",
+			want: []string{"big/large.go", "main.go", "pkg/api/api.go", "pkg/api/api_test.go", "scripts/deploy.py", "scripts/late_marker.py", "web/app.js", "web/dist/app.min.js"},
+		},
+		{
+			name:   "gitignore",
+			ignore: map[string]string{".gitignore": "dist/\n*_test.go\n", "web/.gitignore": "/app.js\n"},
+			want:   []string{"big/large.go", "main.go", "pkg/api/api.go", "scripts/deploy.py", "scripts/late_marker.py"},
+		},
+		{
+			name:   "docgenignore brings back a default",
+			ignore: map[string]string{IgnoreFile: "!vendor/\nscripts/\n"},
+			want:   []string{"big/large.go", "main.go", "pkg/api/api.go", "pkg/api/api_test.go", "vendor/lib/lib.go", "web/app.js", "web/dist/app.min.js"},
+		},
+		{
+			name:   "include",
+			filter: fileFilter{include: mustCompileGlobs(t, "pkg/**", "*.js")},
+			want:   []string{"pkg/api/api.go", "pkg/api/api_test.go", "web/app.js", "web/dist/app.min.js"},
+		},
+		{
+			name:   "exclude",
+			filter: fileFilter{exclude: mustCompileGlobs(t, "*_test.go", "web/dist", "scripts/")},
+			want:   []string{"big/large.go", "main.go", "pkg/api/api.go", "web/app.js"},
+		},
+		{
+			name:   "include and exclude",
+			filter: fileFilter{include: mustCompileGlobs(t, "*.go"), exclude: mustCompileGlobs(t, "*_test.go")},
+			want:   []string{"big/large.go", "main.go", "pkg/api/api.go"},
+		},
+		{
+			name:   "max file size",
+			filter: fileFilter{maxSize: 1024},
+			want:   []string{"main.go", "pkg/api/api.go", "pkg/api/api_test.go", "scripts/deploy.py", "scripts/late_marker.py", "web/app.js", "web/dist/app.min.js"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{}
+			for name, contents := range project {
+				files[name] = contents
+			}
+			for name, contents := range tt.ignore {
+				files[name] = contents
+			}
+			root := writeTree(t, files)
+
+			found, err := findSourceFiles(root, tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, path := range found {
+				rel, err := filepath.Rel(root, path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, filepath.ToSlash(rel))
+			}
+			sort.Strings(got)
+
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got files\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestIsGeneratedFile(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"// Code generated by mockgen. DO NOT EDIT.\n", true},
+		{"# Code generated by protoc. DO NOT EDIT.", true},
+		{"/* @generated by relay-compiler */\n", true},
+		{"// This file is auto-generated, do not modify\n", true},
+		{"# Generated by Django 4.2. Do not edit by hand.\n", true},
+		{"// @generatedBy is not a marker\n", false},
+		{"// Code generated with care\n", false},
+		{"// Package gen generates code\n", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		root := writeTree(t, map[string]string{"file.go": tt.header + "\npackage gen\n"})
+		got, err := isGeneratedFile(filepath.Join(root, "file.go"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("isGeneratedFile(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+// mustCompileGlobs compiles globs for a test
+func mustCompileGlobs(t *testing.T, patterns ...string) globPatterns {
+	t.Helper()
+	globs, err := compileGlobs(patterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return globs
+}
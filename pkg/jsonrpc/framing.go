@@ -0,0 +1,115 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Framing is how messages are delimited on the stream
+type Framing int
+
+const (
+	// Lines puts each message on a line of its own, as the MCP stdio transport does
+	Lines Framing = iota
+	// Headers prefixes each message with a Content-Length header, as LSP does
+	Headers
+)
+
+// framing reads and writes the messages of a stream
+type framing interface {
+	// read returns the next message, or io.EOF once the stream is closed
+	read() ([]byte, error)
+	write(data []byte) error
+}
+
+// new returns the framing of r and w
+func (f Framing) new(r io.Reader, w io.Writer, maxMessageBytes int) framing {
+	if f == Headers {
+		return &headerFraming{r: bufio.NewReader(r), w: w, max: maxMessageBytes}
+	}
+	// The scanner's limit is the larger of the buffer and the maximum
+	initial := 64 * 1024
+	if initial > maxMessageBytes {
+		initial = maxMessageBytes
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, initial), maxMessageBytes)
+	return &lineFraming{scanner: scanner, w: w}
+}
+
+// lineFraming delimits messages with newlines
+type lineFraming struct {
+	scanner *bufio.Scanner
+	w       io.Writer
+}
+
+func (f *lineFraming) read() ([]byte, error) {
+	for f.scanner.Scan() {
+		// Blank lines between messages are tolerated
+		if len(f.scanner.Bytes()) > 0 {
+			return append([]byte(nil), f.scanner.Bytes()...), nil
+		}
+	}
+	if err := f.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (f *lineFraming) write(data []byte) error {
+	_, err := f.w.Write(append(data, '\n'))
+	return err
+}
+
+// headerFraming delimits messages with a Content-Length header
+type headerFraming struct {
+	r   *bufio.Reader
+	w   io.Writer
+	max int
+}
+
+func (f *headerFraming) read() ([]byte, error) {
+	length := -1
+	for {
+		line, err := f.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+
+		name, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("invalid Content-Length: %v", err)
+			}
+		}
+	}
+
+	if length < 0 {
+		return nil, fmt.Errorf("missing Content-Length header")
+	}
+	if length > f.max {
+		return nil, fmt.Errorf("message of %d bytes exceeds the limit of %d", length, f.max)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(f.r, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func (f *headerFraming) write(data []byte) error {
+	if _, err := fmt.Fprintf(f.w, "Content-Length: %d\r\n\r\n", len(data)); err != nil {
+		return err
+	}
+	_, err := f.w.Write(data)
+	return err
+}
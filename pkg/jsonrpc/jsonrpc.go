@@ -0,0 +1,185 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+)
+
+// JSON-RPC error codes
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// Message is a JSON-RPC 2.0 request, notification or response
+type Message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *Error           `json:"error,omitempty"`
+}
+
+// Error is the error of a JSON-RPC response
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Errorf returns an error with the given code and formatted message
+func Errorf(code int, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Conn is a JSON-RPC connection over a byte stream, such as the standard
+// input and output of a server started by an editor or an agent
+type Conn struct {
+	framing framing
+
+	// outMu serializes the messages written to the peer
+	outMu sync.Mutex
+}
+
+// NewConn creates a connection reading from r and writing to w with the
+// given framing
+func NewConn(r io.Reader, w io.Writer, f Framing, maxMessageBytes int) *Conn {
+	return &Conn{framing: f.new(r, w, maxMessageBytes)}
+}
+
+// Serve reads messages and passes them to handle one at a time until the
+// peer closes the stream, ctx is canceled or handle returns true
+func (c *Conn) Serve(ctx context.Context, handle func(data []byte) (exit bool)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Read in the background so a canceled ctx stops the server even while
+	// the peer is silent
+	messages := make(chan []byte)
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			data, err := c.framing.read()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case messages <- data:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-readErr:
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("error reading from client: %v", err)
+		case data := <-messages:
+			if handle(data) {
+				return nil
+			}
+		}
+	}
+}
+
+// Respond writes the response to a request. Errors for requests whose ID
+// could not be read carry a null ID, and a successful response always has a
+// result, null included.
+func (c *Conn) Respond(id *json.RawMessage, result interface{}, err *Error) {
+	msg := Message{JSONRPC: "2.0", ID: id, Error: err}
+	if id == nil {
+		null := json.RawMessage("null")
+		msg.ID = &null
+	}
+	if err == nil {
+		msg.Result = json.RawMessage("null")
+		if result != nil {
+			msg.Result = result
+		}
+	}
+	c.Write(msg)
+}
+
+// Write sends a message to the peer. Failures are logged, as there is no one
+// left to report them to.
+func (c *Conn) Write(msg Message) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		slog.Error("Error encoding message", "error", err)
+		return
+	}
+
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	if err := c.framing.write(data); err != nil {
+		slog.Error("Error writing message", "error", err)
+	}
+}
+
+// Calls tracks the requests handled in the background, so that they can be
+// canceled by the peer and waited for when the connection ends
+type Calls struct {
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// Go runs fn in the background with a context canceled by Cancel(id)
+func (c *Calls) Go(ctx context.Context, id json.RawMessage, fn func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	c.mu.Lock()
+	if c.cancels == nil {
+		c.cancels = map[string]context.CancelFunc{}
+	}
+	c.cancels[string(id)] = cancel
+	c.mu.Unlock()
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer func() {
+			c.mu.Lock()
+			delete(c.cancels, string(id))
+			c.mu.Unlock()
+			cancel()
+		}()
+		fn(ctx)
+	}()
+}
+
+// Cancel cancels the request with the given ID, reporting whether it was
+// still running
+func (c *Calls) Cancel(id json.RawMessage) bool {
+	c.mu.Lock()
+	cancel, ok := c.cancels[string(id)]
+	c.mu.Unlock()
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// Wait waits for the requests running in the background
+func (c *Calls) Wait() {
+	c.wg.Wait()
+}
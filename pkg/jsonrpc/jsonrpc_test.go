@@ -0,0 +1,110 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestConnRespond(t *testing.T) {
+	id := json.RawMessage("7")
+	tests := []struct {
+		name   string
+		id     *json.RawMessage
+		result interface{}
+		err    *Error
+		want   string
+	}{
+		{"result", &id, map[string]int{"n": 1}, nil, `{"jsonrpc":"2.0","id":7,"result":{"n":1}}`},
+		{"null result", &id, nil, nil, `{"jsonrpc":"2.0","id":7,"result":null}`},
+		{"error", &id, nil, Errorf(CodeMethodNotFound, "method not found: %s", "x"), `{"jsonrpc":"2.0","id":7,"error":{"code":-32601,"message":"method not found: x"}}`},
+		{"error without an ID", nil, nil, Errorf(CodeParseError, "invalid JSON"), `{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"invalid JSON"}}`},
+	}
+
+	for _, framing := range []Framing{Lines, Headers} {
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				var out bytes.Buffer
+				NewConn(strings.NewReader(""), &out, framing, 1024).Respond(tt.id, tt.result, tt.err)
+
+				want := tt.want + "\n"
+				if framing == Headers {
+					want = "Content-Length: " + strconv.Itoa(len(tt.want)) + "\r\n\r\n" + tt.want
+				}
+				if out.String() != want {
+					t.Errorf("got %q, want %q", out.String(), want)
+				}
+			})
+		}
+	}
+}
+
+func TestConnServe(t *testing.T) {
+	tests := []struct {
+		name    string
+		framing Framing
+		input   string
+		want    []string
+		wantErr bool
+	}{
+		{"lines", Lines, "{\"a\":1}\n\n{\"b\":2}\n", []string{`{"a":1}`, `{"b":2}`}, false},
+		{"headers", Headers, "Content-Length: 7\r\n\r\n{\"a\":1}content-length:7\r\nContent-Type: application/json\r\n\r\n{\"b\":2}", []string{`{"a":1}`, `{"b":2}`}, false},
+		{"missing length", Headers, "Content-Type: application/json\r\n\r\n{}", nil, true},
+		{"too large", Headers, "Content-Length: 2048\r\n\r\n{}", nil, true},
+		{"line too large", Lines, strings.Repeat("x", 2048) + "\n", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			err := NewConn(strings.NewReader(tt.input), &bytes.Buffer{}, tt.framing, 1024).Serve(context.Background(), func(data []byte) bool {
+				got = append(got, string(data))
+				return false
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Serve error = %v, want error %v", err, tt.wantErr)
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("got messages %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConnServeStopsOnExit(t *testing.T) {
+	var got []string
+	err := NewConn(strings.NewReader("1\n2\n3\n"), &bytes.Buffer{}, Lines, 1024).Serve(context.Background(), func(data []byte) bool {
+		got = append(got, string(data))
+		return string(data) == "2"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Errorf("handled %q, want the messages up to the exit", got)
+	}
+}
+
+func TestCallsCancel(t *testing.T) {
+	var calls Calls
+	started := make(chan struct{})
+	calls.Go(context.Background(), json.RawMessage(`"a"`), func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+	})
+	<-started
+
+	if calls.Cancel(json.RawMessage(`"b"`)) {
+		t.Error("canceled a request that is not running")
+	}
+	if !calls.Cancel(json.RawMessage(`"a"`)) {
+		t.Error("the running request was not found")
+	}
+	calls.Wait()
+	if calls.Cancel(json.RawMessage(`"a"`)) {
+		t.Error("a finished request is still tracked")
+	}
+}
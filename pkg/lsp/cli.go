@@ -0,0 +1,79 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/kamdyn/ai-toolkit/pkg/common"
+	"github.com/kamdyn/ai-toolkit/pkg/docgen"
+	"github.com/urfave/cli/v2"
+)
+
+func init() {
+	common.RegisterTool(common.Tool{
+		Name:    "lsp",
+		Command: GetLSPCommand,
+	})
+}
+
+// GetLSPCommand returns the CLI command for the language server
+func GetLSPCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "lsp",
+		Usage: "Serve a language server over stdio offering a code action that generates doc comments",
+		Flags: append(common.CommonFlags(),
+			&cli.StringFlag{
+				Name:    "style",
+				Aliases: []string{"s"},
+				Usage:   "Documentation style (jsdoc, tsdoc, godoc, docstring, xml); chosen by language if not specified",
+			},
+		),
+		Before: func(c *cli.Context) error {
+			// Fill unset options from the config files and profile
+			if err := common.ApplyConfig(c); err != nil {
+				return err
+			}
+
+			// Validate API key
+			return common.ValidateCredentials(c)
+		},
+		Action: func(c *cli.Context) error {
+			return runLSP(c)
+		},
+	}
+}
+
+// runLSP serves the language server on stdin and stdout until the editor exits
+func runLSP(c *cli.Context) error {
+	// Extract configuration
+	config := common.ExtractCommonConfig(c)
+
+	// Logs go to stderr, stdout carries the protocol
+	common.PrepareLogger("LSP", config.LogFormat, config.Verbose)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Export traces of the generated comments if enabled
+	shutdownTracing, err := common.SetupTracing(ctx, "lsp", config.Trace)
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing()
+
+	// One client serves every code action, sharing the cache, rate limits and budget
+	aiClient, err := common.NewAIClient(ctx, config)
+	if err != nil {
+		return fmt.Errorf("error creating AI client: %v", err)
+	}
+	defer aiClient.Close()
+
+	// Print token usage and cost of the session once the editor exits
+	defer common.ReportUsage(aiClient.Usage(), config.LogFormat)
+
+	server := NewServer(docgen.NewDocGenerator(aiClient), config, c.String("style"))
+	return server.Serve(ctx, os.Stdin, os.Stdout)
+}
@@ -0,0 +1,57 @@
+package lsp
+
+// LSP error codes, beyond those of JSON-RPC
+const (
+	codeRequestCancelled = -32800
+	codeContentModified  = -32801
+)
+
+// maxMessageBytes caps the size of a single message read from the client
+const maxMessageBytes = 64 << 20
+
+// Position is a zero-based line and character offset in a document
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// Range is a span of a document, end exclusive
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// TextEdit replaces a range of a document with new text
+type TextEdit struct {
+	Range   Range  `json:"range"`
+	NewText string `json:"newText"`
+}
+
+// WorkspaceEdit holds the edits to apply, by document URI
+type WorkspaceEdit struct {
+	Changes map[string][]TextEdit `json:"changes"`
+}
+
+// Command is a command the client runs through workspace/executeCommand
+type Command struct {
+	Title     string        `json:"title"`
+	Command   string        `json:"command"`
+	Arguments []interface{} `json:"arguments,omitempty"`
+}
+
+// CodeAction is an action offered in the editor
+type CodeAction struct {
+	Title   string         `json:"title"`
+	Kind    string         `json:"kind,omitempty"`
+	Edit    *WorkspaceEdit `json:"edit,omitempty"`
+	Command *Command       `json:"command,omitempty"`
+	Data    *actionData    `json:"data,omitempty"`
+}
+
+// TextDocumentItem is a document opened in the editor
+type TextDocumentItem struct {
+	URI        string `json:"uri"`
+	LanguageID string `json:"languageId"`
+	Version    int    `json:"version"`
+	Text       string `json:"text"`
+}
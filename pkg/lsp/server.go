@@ -0,0 +1,482 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kamdyn/ai-toolkit/pkg/common"
+	"github.com/kamdyn/ai-toolkit/pkg/docgen"
+	"github.com/kamdyn/ai-toolkit/pkg/jsonrpc"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// commandGenerateDocComment is the command run for clients that cannot
+// resolve the edit of a code action lazily
+const commandGenerateDocComment = "ai-toolkit.generateDocComment"
+
+// codeActionKind is the kind of the offered code action
+const codeActionKind = "refactor.rewrite"
+
+// contextLines is the number of lines around a declaration sent as context
+const contextLines = 30
+
+// actionData identifies the declaration a code action documents
+type actionData struct {
+	URI     string `json:"uri"`
+	Version int    `json:"version"`
+	Start   int    `json:"start"`
+	End     int    `json:"end"`
+}
+
+// document is a text document opened in the editor
+type document struct {
+	languageID string
+	version    int
+	text       string
+}
+
+// Server is a language server offering a code action that generates the doc
+// comment of the declaration at the cursor or the selected code
+type Server struct {
+	generator *docgen.DocGenerator
+	config    common.ToolConfig
+	style     string
+
+	conn *jsonrpc.Conn
+	// calls are the requests in flight
+	calls jsonrpc.Calls
+
+	docsMu sync.Mutex
+	docs   map[string]*document
+
+	// resolveEdits is set when the client resolves the edits of code actions lazily
+	resolveEdits bool
+	// nextID numbers the requests sent to the client
+	nextID atomic.Int64
+}
+
+// NewServer creates a language server generating comments with generator.
+// style is the documentation style; empty selects one by language.
+func NewServer(generator *docgen.DocGenerator, config common.ToolConfig, style string) *Server {
+	return &Server{
+		generator: generator,
+		config:    config,
+		style:     style,
+		docs:      map[string]*document{},
+	}
+}
+
+// Serve reads messages from r and writes responses to w until the client
+// sends exit, r is closed or ctx is canceled
+func (s *Server) Serve(ctx context.Context, r io.Reader, w io.Writer) error {
+	s.conn = jsonrpc.NewConn(r, w, jsonrpc.Headers, maxMessageBytes)
+
+	// Deferred in this order, requests are canceled before they are waited for
+	defer s.calls.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	return s.conn.Serve(ctx, func(data []byte) bool {
+		return s.handleMessage(ctx, data)
+	})
+}
+
+// handleMessage dispatches a single message from the client. It returns true
+// when the client asked the server to exit.
+func (s *Server) handleMessage(ctx context.Context, data []byte) bool {
+	var msg jsonrpc.Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		s.conn.Respond(nil, nil, jsonrpc.Errorf(jsonrpc.CodeParseError, "invalid JSON: %v", err))
+		return false
+	}
+
+	// Responses to our workspace/applyEdit requests
+	if msg.Method == "" {
+		if msg.Error != nil {
+			slog.Warn("Client rejected the edit", "error", msg.Error.Message)
+		}
+		return false
+	}
+
+	// Notifications get no response
+	if msg.ID == nil {
+		return s.handleNotification(msg)
+	}
+
+	switch msg.Method {
+	case "initialize":
+		s.conn.Respond(msg.ID, s.initialize(msg.Params), nil)
+	case "shutdown":
+		s.conn.Respond(msg.ID, nil, nil)
+	case "textDocument/codeAction":
+		result, err := s.codeActions(msg.Params)
+		s.conn.Respond(msg.ID, result, err)
+	case "codeAction/resolve", "workspace/executeCommand":
+		// Generating takes seconds, so these run in the background
+		s.calls.Go(ctx, *msg.ID, func(callCtx context.Context) {
+			var result interface{}
+			var err *jsonrpc.Error
+			if msg.Method == "codeAction/resolve" {
+				result, err = s.resolveCodeAction(callCtx, msg.Params)
+			} else {
+				result, err = s.executeCommand(callCtx, msg.Params)
+			}
+			if err != nil && callCtx.Err() != nil {
+				err = jsonrpc.Errorf(codeRequestCancelled, "request canceled")
+			}
+			s.conn.Respond(msg.ID, result, err)
+		})
+	default:
+		s.conn.Respond(msg.ID, nil, jsonrpc.Errorf(jsonrpc.CodeMethodNotFound, "method not found: %s", msg.Method))
+	}
+	return false
+}
+
+// handleNotification handles a message that expects no response. It returns
+// true on exit.
+func (s *Server) handleNotification(msg jsonrpc.Message) bool {
+	switch msg.Method {
+	case "exit":
+		return true
+	case "textDocument/didOpen":
+		var params struct {
+			TextDocument TextDocumentItem `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err == nil {
+			s.docsMu.Lock()
+			s.docs[params.TextDocument.URI] = &document{
+				languageID: params.TextDocument.LanguageID,
+				version:    params.TextDocument.Version,
+				text:       params.TextDocument.Text,
+			}
+			s.docsMu.Unlock()
+		}
+	case "textDocument/didChange":
+		// Documents are synced in full, so the last change holds the whole text
+		var params struct {
+			TextDocument struct {
+				URI     string `json:"uri"`
+				Version int    `json:"version"`
+			} `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err == nil && len(params.ContentChanges) > 0 {
+			s.docsMu.Lock()
+			if doc, ok := s.docs[params.TextDocument.URI]; ok {
+				doc.version = params.TextDocument.Version
+				doc.text = params.ContentChanges[len(params.ContentChanges)-1].Text
+			}
+			s.docsMu.Unlock()
+		}
+	case "textDocument/didClose":
+		var params struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err == nil {
+			s.docsMu.Lock()
+			delete(s.docs, params.TextDocument.URI)
+			s.docsMu.Unlock()
+		}
+	case "$/cancelRequest":
+		var params struct {
+			ID json.RawMessage `json:"id"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err == nil {
+			s.calls.Cancel(params.ID)
+		}
+	default:
+		// initialized and other notifications need no action
+	}
+	return false
+}
+
+// initialize answers the handshake with the server's capabilities
+func (s *Server) initialize(params json.RawMessage) interface{} {
+	var request struct {
+		ClientInfo struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"clientInfo"`
+		Capabilities struct {
+			TextDocument struct {
+				CodeAction struct {
+					ResolveSupport struct {
+						Properties []string `json:"properties"`
+					} `json:"resolveSupport"`
+				} `json:"codeAction"`
+			} `json:"textDocument"`
+		} `json:"capabilities"`
+	}
+	_ = json.Unmarshal(params, &request)
+
+	for _, property := range request.Capabilities.TextDocument.CodeAction.ResolveSupport.Properties {
+		if property == "edit" {
+			s.resolveEdits = true
+		}
+	}
+	slog.Debug("Client connected", "client", request.ClientInfo.Name, "client_version", request.ClientInfo.Version, "resolve_edits", s.resolveEdits)
+
+	return map[string]interface{}{
+		"capabilities": map[string]interface{}{
+			"textDocumentSync": map[string]interface{}{
+				"openClose": true,
+				"change":    1, // full
+			},
+			"codeActionProvider": map[string]interface{}{
+				"codeActionKinds": []string{codeActionKind},
+				"resolveProvider": true,
+			},
+			"executeCommandProvider": map[string]interface{}{
+				"commands": []string{commandGenerateDocComment},
+			},
+		},
+		"serverInfo": map[string]interface{}{
+			"name":    "ai-toolkit",
+			"version": common.Version,
+		},
+	}
+}
+
+// codeActions offers to document the declaration at the requested range.
+// Listing is cheap: the comment is only generated when the action is picked.
+func (s *Server) codeActions(params json.RawMessage) (interface{}, *jsonrpc.Error) {
+	var request struct {
+		TextDocument struct {
+			URI string `json:"uri"`
+		} `json:"textDocument"`
+		Range   Range `json:"range"`
+		Context struct {
+			Only []string `json:"only"`
+		} `json:"context"`
+	}
+	if err := json.Unmarshal(params, &request); err != nil {
+		return nil, jsonrpc.Errorf(jsonrpc.CodeInvalidParams, "invalid params: %v", err)
+	}
+
+	// Respect a filter on the kinds of actions
+	if len(request.Context.Only) > 0 {
+		wanted := false
+		for _, kind := range request.Context.Only {
+			if kind == "refactor" || kind == codeActionKind {
+				wanted = true
+			}
+		}
+		if !wanted {
+			return []CodeAction{}, nil
+		}
+	}
+
+	uri := request.TextDocument.URI
+	doc, ok := s.document(uri)
+	if !ok {
+		return []CodeAction{}, nil
+	}
+
+	language := documentLanguage(uri, doc.languageID)
+	t, ok := findTarget(splitLines(doc.text), language, request.Range)
+	if !ok {
+		return []CodeAction{}, nil
+	}
+
+	title := "Generate doc comment"
+	if t.Name != "" {
+		title = fmt.Sprintf("Generate doc comment for %s", t.Name)
+	}
+	data := &actionData{URI: uri, Version: doc.version, Start: t.Start, End: t.End}
+
+	action := CodeAction{Title: title, Kind: codeActionKind, Data: data}
+	if !s.resolveEdits {
+		action.Data = nil
+		action.Command = &Command{
+			Title:     title,
+			Command:   commandGenerateDocComment,
+			Arguments: []interface{}{data},
+		}
+	}
+	return []CodeAction{action}, nil
+}
+
+// resolveCodeAction fills in the edit of a code action picked by the user
+func (s *Server) resolveCodeAction(ctx context.Context, params json.RawMessage) (interface{}, *jsonrpc.Error) {
+	var action CodeAction
+	if err := json.Unmarshal(params, &action); err != nil || action.Data == nil {
+		return nil, jsonrpc.Errorf(jsonrpc.CodeInvalidParams, "invalid code action")
+	}
+
+	edit, err := s.docCommentEdit(ctx, *action.Data)
+	if err != nil {
+		return nil, err
+	}
+	action.Edit = edit
+	return action, nil
+}
+
+// executeCommand generates a doc comment and asks the client to apply it,
+// for clients without lazy code action resolution
+func (s *Server) executeCommand(ctx context.Context, params json.RawMessage) (interface{}, *jsonrpc.Error) {
+	var request struct {
+		Command   string       `json:"command"`
+		Arguments []actionData `json:"arguments"`
+	}
+	if err := json.Unmarshal(params, &request); err != nil || len(request.Arguments) != 1 {
+		return nil, jsonrpc.Errorf(jsonrpc.CodeInvalidParams, "invalid command arguments")
+	}
+	if request.Command != commandGenerateDocComment {
+		return nil, jsonrpc.Errorf(jsonrpc.CodeInvalidParams, "unknown command: %s", request.Command)
+	}
+
+	edit, err := s.docCommentEdit(ctx, request.Arguments[0])
+	if err != nil {
+		return nil, err
+	}
+
+	id := json.RawMessage(fmt.Sprintf("%d", s.nextID.Add(1)))
+	s.conn.Write(jsonrpc.Message{
+		JSONRPC: "2.0",
+		ID:      &id,
+		Method:  "workspace/applyEdit",
+		Params:  mustMarshal(map[string]interface{}{"label": "Generate doc comment", "edit": edit}),
+	})
+	return nil, nil
+}
+
+// docCommentEdit generates the doc comment of a declaration and returns the
+// edit inserting it
+func (s *Server) docCommentEdit(ctx context.Context, data actionData) (edit *WorkspaceEdit, rpcErr *jsonrpc.Error) {
+	doc, ok := s.document(data.URI)
+	if !ok {
+		return nil, jsonrpc.Errorf(jsonrpc.CodeInvalidParams, "document not open: %s", data.URI)
+	}
+	if doc.version != data.Version {
+		return nil, jsonrpc.Errorf(codeContentModified, "the document changed, try again")
+	}
+
+	lines := splitLines(doc.text)
+	if data.Start < 0 || data.End < data.Start || data.End >= len(lines) {
+		return nil, jsonrpc.Errorf(jsonrpc.CodeInvalidParams, "invalid declaration range")
+	}
+	language := documentLanguage(data.URI, doc.languageID)
+	t := target{Start: data.Start, End: data.End}
+
+	place, err := placeComment(lines, language, t)
+	if err != nil {
+		return nil, &jsonrpc.Error{Code: jsonrpc.CodeInternalError, Message: err.Error()}
+	}
+
+	contextStart, contextEnd := t.Start-contextLines, t.End+contextLines+1
+	if contextStart < 0 {
+		contextStart = 0
+	}
+	if contextEnd > len(lines) {
+		contextEnd = len(lines)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.config.Timeout)*time.Second)
+	defer cancel()
+
+	ctx, span := common.StartSpan(ctx, "docgen.comment",
+		attribute.String("file.path", data.URI),
+		attribute.String("docgen.lang", language))
+	defer func() {
+		if rpcErr != nil {
+			common.EndSpan(span, errors.New(rpcErr.Message))
+			return
+		}
+		common.EndSpan(span, nil)
+	}()
+
+	slog.Debug("Generating doc comment", "file", data.URI, "lang", language, "start", t.Start+1, "end", t.End+1)
+	start := time.Now()
+
+	ctx = common.WithUsageLabel(ctx, data.URI)
+	comment, err := s.generator.GenerateDocComment(ctx, s.config.Model, s.config.Temperature,
+		strings.Join(lines[t.Start:t.End+1], "\n"), strings.Join(lines[contextStart:contextEnd], "\n"),
+		language, s.style)
+	if err != nil {
+		return nil, &jsonrpc.Error{Code: jsonrpc.CodeInternalError, Message: err.Error()}
+	}
+	slog.Debug("Doc comment generated", "file", data.URI, "duration", time.Since(start))
+
+	// The edit is only valid for the text it was computed from
+	if current, ok := s.document(data.URI); !ok || current.version != data.Version {
+		return nil, jsonrpc.Errorf(codeContentModified, "the document changed while generating, try again")
+	}
+
+	newline := "\n"
+	if strings.Contains(doc.text, "\r\n") {
+		newline = "\r\n"
+	}
+	return &WorkspaceEdit{
+		Changes: map[string][]TextEdit{
+			data.URI: {{
+				Range: Range{
+					Start: Position{Line: place.Start},
+					End:   Position{Line: place.End},
+				},
+				NewText: formatComment(comment, place.Indent, newline),
+			}},
+		},
+	}, nil
+}
+
+// document returns a copy of an open document
+func (s *Server) document(uri string) (document, bool) {
+	s.docsMu.Lock()
+	defer s.docsMu.Unlock()
+	doc, ok := s.docs[uri]
+	if !ok {
+		return document{}, false
+	}
+	return *doc, true
+}
+
+// mustMarshal encodes v, which is known to be encodable
+func mustMarshal(v interface{}) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// splitLines splits a document into lines without their line breaks
+func splitLines(text string) []string {
+	return strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+}
+
+// documentLanguage returns the docgen language of a document from the
+// editor's language ID, falling back to the file extension
+func documentLanguage(uri, languageID string) string {
+	switch languageID {
+	case "go", "python", "rust", "java", "csharp", "swift", "kotlin", "ruby", "php", "javascript", "typescript":
+		return languageID
+	case "typescriptreact":
+		return "typescript"
+	case "javascriptreact":
+		return "javascript"
+	case "c", "cpp", "objective-c", "objective-cpp":
+		return "cpp"
+	case "shellscript", "sh", "bash":
+		return "bash"
+	}
+
+	path := uri
+	if u, err := url.Parse(uri); err == nil && u.Path != "" {
+		path = u.Path
+	}
+	return docgen.DetectLanguage(filepath.Base(path))
+}
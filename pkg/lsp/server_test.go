@@ -0,0 +1,306 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/kamdyn/ai-toolkit/pkg/common"
+	"github.com/kamdyn/ai-toolkit/pkg/docgen"
+	"github.com/kamdyn/ai-toolkit/pkg/jsonrpc"
+)
+
+// commentProvider answers every request with the same doc comment
+type commentProvider struct {
+	comment string
+}
+
+func (p *commentProvider) Name() string { return "test" }
+
+func (p *commentProvider) Generate(ctx context.Context, req common.GenerateRequest) (*common.GenerateResponse, error) {
+	return &common.GenerateResponse{Text: "```go\n" + p.comment + "\n```", Model: req.Model, FinishReason: common.FinishStop}, nil
+}
+
+func (p *commentProvider) GenerateStream(ctx context.Context, req common.GenerateRequest) (<-chan common.StreamChunk, error) {
+	resp, _ := p.Generate(ctx, req)
+	ch := make(chan common.StreamChunk, 1)
+	ch <- common.StreamChunk{Text: resp.Text, FinishReason: resp.FinishReason}
+	close(ch)
+	return ch, nil
+}
+
+func (p *commentProvider) CountTokens(ctx context.Context, model, text string) (int, error) {
+	return common.EstimateTokens(text), nil
+}
+
+func (p *commentProvider) Close() error { return nil }
+
+// testClient talks to a server over in-memory pipes
+type testClient struct {
+	t        *testing.T
+	conn     *jsonrpc.Conn
+	messages chan rawMessage
+	done     chan error
+}
+
+// rawMessage is a message from the server with its result left encoded
+type rawMessage struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *jsonrpc.Error  `json:"error"`
+}
+
+// startServer runs a language server whose model answers with comment
+func startServer(t *testing.T, comment string) *testClient {
+	t.Helper()
+	generator := docgen.NewDocGenerator(common.NewAIClientWithProvider(&commentProvider{comment: comment}))
+	server := NewServer(generator, common.ToolConfig{Model: "gemini-2.0-flash", Temperature: 0.2, Timeout: 30}, "")
+
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+	c := &testClient{
+		t:        t,
+		conn:     jsonrpc.NewConn(clientReader, clientWriter, jsonrpc.Headers, maxMessageBytes),
+		messages: make(chan rawMessage, 16),
+		done:     make(chan error, 1),
+	}
+
+	go func() {
+		c.done <- server.Serve(context.Background(), serverReader, serverWriter)
+		serverWriter.Close()
+	}()
+	go c.conn.Serve(context.Background(), func(data []byte) bool {
+		var msg rawMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Errorf("invalid message from the server: %s", data)
+		}
+		c.messages <- msg
+		return false
+	})
+	t.Cleanup(func() { clientWriter.Close() })
+	return c
+}
+
+// notify sends a notification
+func (c *testClient) notify(method string, params interface{}) {
+	c.conn.Write(jsonrpc.Message{JSONRPC: "2.0", Method: method, Params: mustMarshal(params)})
+}
+
+// call sends a request and returns the server's response to it
+func (c *testClient) call(id int, method string, params interface{}) rawMessage {
+	c.t.Helper()
+	rawID := json.RawMessage(mustMarshal(id))
+	c.conn.Write(jsonrpc.Message{JSONRPC: "2.0", ID: &rawID, Method: method, Params: mustMarshal(params)})
+	for {
+		msg := c.next()
+		if msg.Method == "" && string(msg.ID) == string(rawID) {
+			return msg
+		}
+	}
+}
+
+// next returns the next message from the server
+func (c *testClient) next() rawMessage {
+	c.t.Helper()
+	select {
+	case msg := <-c.messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("timed out waiting for the server")
+		return rawMessage{}
+	}
+}
+
+// decode decodes the result of a response
+func (c *testClient) decode(msg rawMessage, v interface{}) {
+	c.t.Helper()
+	if msg.Error != nil {
+		c.t.Fatalf("request failed: %v", msg.Error)
+	}
+	if err := json.Unmarshal(msg.Result, v); err != nil {
+		c.t.Fatalf("invalid result %s: %v", msg.Result, err)
+	}
+}
+
+// shutdown ends the session and waits for the server to exit
+func (c *testClient) shutdown(id int) {
+	c.t.Helper()
+	if msg := c.call(id, "shutdown", nil); msg.Error != nil || string(msg.Result) != "null" {
+		c.t.Errorf("unexpected shutdown response: %+v", msg)
+	}
+	c.notify("exit", nil)
+	select {
+	case err := <-c.done:
+		if err != nil {
+			c.t.Errorf("Serve returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("the server did not exit")
+	}
+}
+
+const (
+	testURI    = "file:///src/shapes/area.go"
+	testSource = "package shapes\r\n\r\n// Area is old\r\nfunc Area(r float64) float64 {\r\n\treturn r * r\r\n}\r\n"
+)
+
+// initialize performs the handshake and opens the test document
+func (c *testClient) initialize(resolveEdits bool) {
+	c.t.Helper()
+	var properties []string
+	if resolveEdits {
+		properties = []string{"edit"}
+	}
+	var result struct {
+		Capabilities struct {
+			CodeActionProvider struct {
+				ResolveProvider bool `json:"resolveProvider"`
+			} `json:"codeActionProvider"`
+		} `json:"capabilities"`
+	}
+	c.decode(c.call(1, "initialize", map[string]interface{}{
+		"clientInfo": map[string]string{"name": "test"},
+		"capabilities": map[string]interface{}{
+			"textDocument": map[string]interface{}{
+				"codeAction": map[string]interface{}{
+					"resolveSupport": map[string]interface{}{"properties": properties},
+				},
+			},
+		},
+	}), &result)
+	if !result.Capabilities.CodeActionProvider.ResolveProvider {
+		c.t.Error("the server does not resolve code actions")
+	}
+
+	c.notify("initialized", map[string]interface{}{})
+	c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": TextDocumentItem{URI: testURI, LanguageID: "go", Version: 3, Text: testSource},
+	})
+}
+
+// codeActionParams asks for the actions at a line of the test document
+func codeActionParams(line int) map[string]interface{} {
+	cursor := Position{Line: line, Character: 1}
+	return map[string]interface{}{
+		"textDocument": map[string]string{"uri": testURI},
+		"range":        Range{Start: cursor, End: cursor},
+		"context":      map[string]interface{}{"diagnostics": []interface{}{}},
+	}
+}
+
+// wantEdit is the edit replacing the old comment of Area
+var wantEdit = WorkspaceEdit{Changes: map[string][]TextEdit{
+	testURI: {{
+		Range:   Range{Start: Position{Line: 2}, End: Position{Line: 3}},
+		NewText: "// Area returns the area of a square\r\n// with sides of length r.\r\n",
+	}},
+}}
+
+// checkEdit compares an edit with wantEdit
+func checkEdit(t *testing.T, edit *WorkspaceEdit) {
+	t.Helper()
+	if edit == nil {
+		t.Fatal("no edit")
+	}
+	got, _ := json.Marshal(edit)
+	want, _ := json.Marshal(wantEdit)
+	if string(got) != string(want) {
+		t.Errorf("got edit %s, want %s", got, want)
+	}
+}
+
+func TestServeResolvesCodeAction(t *testing.T) {
+	c := startServer(t, "// Area returns the area of a square\n// with sides of length r.")
+	c.initialize(true)
+
+	var actions []CodeAction
+	c.decode(c.call(2, "textDocument/codeAction", codeActionParams(4)), &actions)
+	if len(actions) != 1 {
+		t.Fatalf("got %d code actions, want 1", len(actions))
+	}
+	action := actions[0]
+	if action.Title != "Generate doc comment for Area" || action.Kind != codeActionKind || action.Edit != nil || action.Command != nil {
+		t.Errorf("unexpected code action: %+v", action)
+	}
+	if action.Data == nil || *action.Data != (actionData{URI: testURI, Version: 3, Start: 3, End: 5}) {
+		t.Errorf("unexpected code action data: %+v", action.Data)
+	}
+
+	var resolved CodeAction
+	c.decode(c.call(3, "codeAction/resolve", action), &resolved)
+	checkEdit(t, resolved.Edit)
+
+	// Outside any declaration nothing is offered
+	c.decode(c.call(4, "textDocument/codeAction", codeActionParams(1)), &actions)
+	if len(actions) != 0 {
+		t.Errorf("got %d code actions on a blank line", len(actions))
+	}
+
+	c.shutdown(5)
+}
+
+func TestServeExecutesCommand(t *testing.T) {
+	c := startServer(t, "// Area returns the area of a square\n// with sides of length r.")
+	c.initialize(false)
+
+	var actions []CodeAction
+	c.decode(c.call(2, "textDocument/codeAction", codeActionParams(3)), &actions)
+	if len(actions) != 1 || actions[0].Command == nil || actions[0].Data != nil {
+		t.Fatalf("want a code action running a command, got %+v", actions)
+	}
+
+	// The server asks the client to apply the edit, then answers the command
+	command := actions[0].Command
+	rawID := json.RawMessage("2")
+	c.conn.Write(jsonrpc.Message{JSONRPC: "2.0", ID: &rawID, Method: "workspace/executeCommand", Params: mustMarshal(map[string]interface{}{
+		"command":   command.Command,
+		"arguments": command.Arguments,
+	})})
+
+	apply := c.next()
+	if apply.Method != "workspace/applyEdit" {
+		t.Fatalf("got %+v, want a workspace/applyEdit request", apply)
+	}
+	var params struct {
+		Edit *WorkspaceEdit `json:"edit"`
+	}
+	if err := json.Unmarshal(apply.Params, &params); err != nil {
+		t.Fatal(err)
+	}
+	checkEdit(t, params.Edit)
+
+	if response := c.next(); string(response.ID) != "2" || response.Error != nil || string(response.Result) != "null" {
+		t.Errorf("unexpected command response: %+v", response)
+	}
+
+	c.shutdown(3)
+}
+
+func TestServeRejectsStaleActions(t *testing.T) {
+	c := startServer(t, "// Area returns the area of a square\n// with sides of length r.")
+	c.initialize(true)
+
+	var actions []CodeAction
+	c.decode(c.call(2, "textDocument/codeAction", codeActionParams(4)), &actions)
+	if len(actions) != 1 {
+		t.Fatalf("got %d code actions, want 1", len(actions))
+	}
+
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": testURI, "version": 4},
+		"contentChanges": []map[string]string{{"text": "package shapes\n"}},
+	})
+	if msg := c.call(3, "codeAction/resolve", actions[0]); msg.Error == nil || msg.Error.Code != codeContentModified {
+		t.Errorf("got %+v, want a content modified error", msg)
+	}
+
+	if msg := c.call(4, "textDocument/hover", map[string]interface{}{}); msg.Error == nil || msg.Error.Code != jsonrpc.CodeMethodNotFound {
+		t.Errorf("got %+v, want a method not found error", msg)
+	}
+
+	c.shutdown(5)
+}
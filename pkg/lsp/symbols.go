@@ -0,0 +1,443 @@
+package lsp
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxDeclarationLines caps the lines of a declaration sent to the model; the
+// signature and the start of the body are enough to describe it
+const maxDeclarationLines = 200
+
+// maxLookBack is how far above the cursor the enclosing declaration is searched
+const maxLookBack = 500
+
+// modifierWords are the keywords that may precede a declaration in C-like languages
+var modifierWords = []string{
+	"public", "private", "protected", "internal", "static", "final", "abstract", "sealed", "virtual",
+	"override", "async", "export", "default", "declare", "readonly", "synchronized", "native", "open",
+	"data", "inline", "suspend", "partial", "extern", "unsafe", "const", "constexpr", "mutating",
+	"fileprivate", "required", "convenience", "lateinit", "operator", "infix", "tailrec",
+}
+
+// modifiers matches any number of modifier words
+var modifiers = `(?:(?:` + strings.Join(modifierWords, "|") + `)\s+)*`
+
+// declarationPatterns match the first line of a declaration, by language
+var declarationPatterns = map[string]*regexp.Regexp{
+	"go":         regexp.MustCompile(`^(?:func|type|var|const)\b`),
+	"python":     regexp.MustCompile(`^\s*(?:async\s+def|def|class)\s`),
+	"ruby":       regexp.MustCompile(`^\s*(?:def|class|module)\s`),
+	"javascript": regexp.MustCompile(`^\s*` + modifiers + `(?:function\*?|class|const|let|var)\b|^\s*` + modifiers + `(?:get\s+|set\s+|\*)?[A-Za-z_$][\w$]*\s*\([^;]*\)\s*\{\s*$`),
+	"typescript": regexp.MustCompile(`^\s*` + modifiers + `(?:function\*?|class|interface|type|enum|namespace|const|let|var)\b|^\s*` + modifiers + `(?:get\s+|set\s+)?[A-Za-z_$][\w$]*\s*(?:<[^>]*>)?\s*\([^;]*\)\s*(?::\s*[^;=]+)?\{\s*$`),
+	"rust":       regexp.MustCompile(`^\s*(?:pub(?:\([^)]*\))?\s+)?(?:async\s+|const\s+|unsafe\s+|extern\s+"[^"]*"\s+)*(?:fn|struct|enum|trait|impl|type|const|static|mod|union|macro_rules!)\b`),
+	"java":       regexp.MustCompile(`^\s*` + modifiers + `(?:class|interface|enum|record|@interface)\b|^\s*` + modifiers + `(?:<[^>]*>\s*)?[\w<>\[\],.? ]+\s+\w+\s*\([^;]*$`),
+	"csharp":     regexp.MustCompile(`^\s*` + modifiers + `(?:class|interface|enum|record|struct|delegate)\b|^\s*` + modifiers + `[\w<>\[\],.? ]+\s+\w+\s*(?:<[^>]*>)?\s*\([^;]*$|^\s*` + modifiers + `[\w<>\[\],.?]+\s+\w+\s*\{\s*(?:get|set)`),
+	"kotlin":     regexp.MustCompile(`^\s*` + modifiers + `(?:fun|class|interface|object|val|var|enum\s+class|typealias)\b`),
+	"swift":      regexp.MustCompile(`^\s*` + modifiers + `(?:func|class|struct|enum|protocol|extension|init|var|let|typealias)\b`),
+	"php":        regexp.MustCompile(`^\s*` + modifiers + `(?:function|class|interface|trait|enum)\b`),
+	"cpp":        regexp.MustCompile(`^\s*(?:template\s*<.*>\s*)?(?:class|struct|namespace|enum|union)\b|^\s*` + modifiers + `(?:template\s*<.*>\s*)?[\w:<>,*& ]+[\s*&]\**[\w:~]+\s*\([^;]*$`),
+	"bash":       regexp.MustCompile(`^\s*(?:function\s+[\w-]+|[\w-]+\s*\(\s*\))`),
+}
+
+// controlKeywords start statements that look like calls or signatures
+var controlKeywords = map[string]bool{
+	"if": true, "else": true, "for": true, "foreach": true, "while": true, "do": true, "switch": true,
+	"case": true, "catch": true, "try": true, "return": true, "throw": true, "new": true, "await": true,
+	"using": true, "lock": true, "when": true, "guard": true, "elif": true, "with": true, "sizeof": true,
+	"typeof": true, "delete": true, "yield": true, "super": true, "this": true,
+}
+
+// nameKeywords are followed by the name of the declaration they introduce
+var nameKeywords = map[string]bool{
+	"func": true, "function": true, "def": true, "fn": true, "fun": true, "class": true, "struct": true,
+	"interface": true, "type": true, "enum": true, "trait": true, "module": true, "object": true,
+	"protocol": true, "extension": true, "namespace": true, "record": true, "const": true, "let": true,
+	"var": true, "val": true, "mod": true, "union": true, "typealias": true, "impl": true, "static": true,
+}
+
+// identifierPattern matches identifiers in all supported languages
+var identifierPattern = regexp.MustCompile(`[A-Za-z_$][\w$]*`)
+
+// target is the declaration a doc comment is generated for
+type target struct {
+	// Start and End are the first and last lines of the declaration, zero-based
+	Start int
+	End   int
+	// Name is the declared name, if it could be determined
+	Name string
+}
+
+// isDeclaration reports whether line starts a declaration in language
+func isDeclaration(line, language string) bool {
+	pattern, ok := declarationPatterns[language]
+	if !ok || !pattern.MatchString(line) {
+		return false
+	}
+
+	// Calls and control statements match the method patterns of C-like languages
+	words := identifierPattern.FindAllString(line, 2)
+	if len(words) > 0 && controlKeywords[words[0]] {
+		return false
+	}
+	trimmed := strings.TrimSpace(line)
+	return !strings.HasSuffix(trimmed, ";") || language == "go"
+}
+
+// findTarget returns the declaration a code action at rng applies to: the
+// selected lines, or the declaration enclosing the cursor
+func findTarget(lines []string, language string, rng Range) (target, bool) {
+	if rng.Start.Line < 0 || rng.Start.Line >= len(lines) {
+		return target{}, false
+	}
+
+	// A selection is documented as is, without the comments and blank lines it starts with
+	if rng.End.Line > rng.Start.Line || rng.End.Character > rng.Start.Character {
+		start, end := rng.Start.Line, rng.End.Line
+		if end > start && rng.End.Character == 0 {
+			end--
+		}
+		if end >= len(lines) {
+			end = len(lines) - 1
+		}
+		for start < end && (strings.TrimSpace(lines[start]) == "" || isCommentLine(lines[start], language)) {
+			start++
+		}
+		if strings.TrimSpace(lines[start]) == "" {
+			return target{}, false
+		}
+		return target{Start: start, End: end, Name: declarationName(lines[start])}, true
+	}
+
+	if _, ok := declarationPatterns[language]; !ok {
+		return target{}, false
+	}
+
+	// On a doc comment or annotation, the action applies to the declaration below it
+	cursor := rng.Start.Line
+	for i := cursor; i < len(lines) && i < cursor+maxLookBack; i++ {
+		if isDeclaration(lines[i], language) {
+			if i > cursor {
+				return newTarget(lines, language, i), true
+			}
+			break
+		}
+		if !isCommentLine(lines[i], language) && !isAnnotationLine(lines[i], language) {
+			break
+		}
+	}
+
+	// Otherwise the innermost declaration enclosing the cursor
+	for i := cursor; i >= 0 && i > cursor-maxLookBack; i-- {
+		if isDeclaration(lines[i], language) {
+			t := newTarget(lines, language, i)
+			if t.End >= cursor {
+				return t, true
+			}
+		}
+	}
+
+	return target{}, false
+}
+
+// newTarget returns the declaration starting at line start
+func newTarget(lines []string, language string, start int) target {
+	return target{
+		Start: start,
+		End:   declarationEnd(lines, language, start),
+		Name:  declarationName(lines[start]),
+	}
+}
+
+// declarationEnd returns the last line of the declaration starting at line start
+func declarationEnd(lines []string, language string, start int) int {
+	limit := start + maxDeclarationLines
+	if limit > len(lines) {
+		limit = len(lines)
+	}
+
+	// Indentation delimits blocks in Python, and in practice in Ruby
+	if language == "python" || language == "ruby" {
+		indent := indentation(lines[start])
+		end := start
+		for i := start + 1; i < limit; i++ {
+			trimmed := strings.TrimSpace(lines[i])
+			if trimmed == "" {
+				continue
+			}
+			if len(indentation(lines[i])) > len(indent) {
+				end = i
+				continue
+			}
+
+			// Wrapped Python signatures close at the definition's indentation,
+			// and Ruby closes the block with end
+			if language == "python" && startsWithCloser(trimmed) {
+				end = i
+				continue
+			}
+			if language == "ruby" && trimmed == "end" {
+				end = i
+			}
+			break
+		}
+		return end
+	}
+
+	// Brace languages end where the first opened block closes
+	depth := 0
+	opened := false
+	for i := start; i < limit; i++ {
+		line := stripStringsAndComments(lines[i])
+		trimmed := strings.TrimSpace(line)
+
+		if i > start && !opened {
+			if trimmed == "" {
+				return i - 1
+			}
+			if isDeclaration(lines[i], language) && len(indentation(lines[i])) <= len(indentation(lines[start])) {
+				return i - 1
+			}
+		}
+
+		for _, r := range line {
+			switch r {
+			case '{':
+				depth++
+				opened = true
+			case '}':
+				depth--
+			}
+		}
+		if opened && depth <= 0 {
+			return i
+		}
+		if !opened && strings.HasSuffix(trimmed, ";") {
+			return i
+		}
+	}
+	return limit - 1
+}
+
+// startsWithCloser reports whether a line starts by closing a bracket, as the
+// end of a wrapped signature does
+func startsWithCloser(trimmed string) bool {
+	return strings.HasPrefix(trimmed, ")") || strings.HasPrefix(trimmed, "]")
+}
+
+// stripStringsAndComments blanks out string literals and line comments, so
+// braces inside them are not counted
+func stripStringsAndComments(line string) string {
+	var sb strings.Builder
+	var quote rune
+	escaped := false
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if escaped {
+				escaped = false
+			} else if r == '\\' {
+				escaped = true
+			} else if r == quote {
+				quote = 0
+			}
+			sb.WriteRune(' ')
+		case r == '"' || r == '\'' || r == '`':
+			quote = r
+			sb.WriteRune(' ')
+		case r == '/' && strings.HasPrefix(line[i:], "//"):
+			return sb.String()
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// declarationName returns the name declared on a line, or "" if unsure
+func declarationName(line string) string {
+	// The identifier right before the parameter list, skipping receivers like Go's (r *T)
+	for i := strings.Index(line, "("); i >= 0; {
+		words := identifierPattern.FindAllString(line[:i], -1)
+		if len(words) > 0 {
+			name := words[len(words)-1]
+			if !nameKeywords[name] && !controlKeywords[name] && !isModifier(name) {
+				return name
+			}
+		}
+		next := strings.Index(line[i+1:], "(")
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+
+	// Otherwise the identifier following a keyword like class or type
+	words := identifierPattern.FindAllString(line, -1)
+	for i := 0; i+1 < len(words); i++ {
+		if nameKeywords[words[i]] && !nameKeywords[words[i+1]] {
+			return words[i+1]
+		}
+	}
+	return ""
+}
+
+// isModifier reports whether word is a declaration modifier
+func isModifier(word string) bool {
+	for _, modifier := range modifierWords {
+		if word == modifier {
+			return true
+		}
+	}
+	return false
+}
+
+// isCommentLine reports whether line is (part of) a comment in language
+func isCommentLine(line, language string) bool {
+	trimmed := strings.TrimSpace(line)
+	switch {
+	case trimmed == "":
+		return false
+	case strings.HasPrefix(trimmed, "//"), strings.HasPrefix(trimmed, "/*"), strings.HasPrefix(trimmed, "*"):
+		return language != "python" && language != "ruby" && language != "bash"
+	case strings.HasPrefix(trimmed, "#"):
+		if language == "python" || language == "ruby" || language == "bash" || language == "php" {
+			return !strings.HasPrefix(trimmed, "#!")
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// isAnnotationLine reports whether line is an annotation, decorator or
+// attribute, which sit between a declaration and its doc comment
+func isAnnotationLine(line, language string) bool {
+	trimmed := strings.TrimSpace(line)
+	switch language {
+	case "rust":
+		return strings.HasPrefix(trimmed, "#[")
+	case "csharp":
+		return strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]")
+	case "php":
+		return strings.HasPrefix(trimmed, "#[")
+	default:
+		return strings.HasPrefix(trimmed, "@")
+	}
+}
+
+// placement is where the comment of a target goes: it replaces lines
+// [Start, End), so Start == End inserts before line Start
+type placement struct {
+	Start  int
+	End    int
+	Indent string
+}
+
+// placeComment returns where the doc comment of t goes. An existing doc
+// comment is replaced.
+func placeComment(lines []string, language string, t target) (placement, error) {
+	if language == "python" {
+		return placeDocstring(lines, t)
+	}
+
+	// Above the annotations of the declaration
+	anchor := t.Start
+	for anchor > 0 && isAnnotationLine(lines[anchor-1], language) {
+		anchor--
+	}
+
+	start := anchor
+	for start > 0 && isCommentLine(lines[start-1], language) {
+		start--
+	}
+
+	return placement{
+		Start:  start,
+		End:    anchor,
+		Indent: indentation(lines[t.Start]),
+	}, nil
+}
+
+// placeDocstring returns where the docstring of a Python definition goes:
+// first in its body, replacing the existing docstring if any
+func placeDocstring(lines []string, t target) (placement, error) {
+	// The body starts after the line ending the signature with a colon
+	signatureEnd := -1
+	for i := t.Start; i <= t.End && i < len(lines); i++ {
+		code := lines[i]
+		if j := strings.Index(code, "#"); j >= 0 {
+			code = code[:j]
+		}
+		if strings.HasSuffix(strings.TrimSpace(code), ":") {
+			signatureEnd = i
+			break
+		}
+	}
+	if signatureEnd < 0 {
+		return placement{}, fmt.Errorf("cannot place a docstring: the definition has no block body")
+	}
+
+	indent := indentation(lines[t.Start]) + "    "
+	insert := signatureEnd + 1
+	first := insert
+	for first < len(lines) && strings.TrimSpace(lines[first]) == "" {
+		first++
+	}
+	if first < len(lines) && len(indentation(lines[first])) > len(indentation(lines[t.Start])) {
+		indent = indentation(lines[first])
+	}
+
+	// Replace an existing docstring
+	if first < len(lines) {
+		trimmed := strings.TrimLeft(strings.TrimSpace(lines[first]), "rRuU")
+		for _, quote := range []string{`"""`, "'''"} {
+			if !strings.HasPrefix(trimmed, quote) {
+				continue
+			}
+			end := first
+			if !strings.Contains(trimmed[len(quote):], quote) {
+				for end = first + 1; end < len(lines) && !strings.Contains(lines[end], quote); end++ {
+				}
+			}
+			if end < len(lines) {
+				return placement{Start: insert, End: end + 1, Indent: indent}, nil
+			}
+		}
+	}
+
+	return placement{Start: insert, End: insert, Indent: indent}, nil
+}
+
+// formatComment indents a generated comment for insertion, one line per line
+// of the comment, ending with a line break
+func formatComment(comment, indent, newline string) string {
+	lines := strings.Split(strings.ReplaceAll(comment, "\r\n", "\n"), "\n")
+
+	// Remove the indentation common to all lines, then indent at the target
+	common := -1
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if n := len(indentation(line)); common < 0 || n < common {
+			common = n
+		}
+	}
+
+	var sb strings.Builder
+	for _, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			sb.WriteString(indent)
+			sb.WriteString(strings.TrimRight(line[common:], " \t"))
+		}
+		sb.WriteString(newline)
+	}
+	return sb.String()
+}
+
+// indentation returns the leading whitespace of line
+func indentation(line string) string {
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
@@ -0,0 +1,241 @@
+package lsp
+
+import (
+	"testing"
+)
+
+// symbolTests are a declaration in each language docgen detects, with the
+// cursor inside it, the declaration found and where its comment goes
+var symbolTests = []struct {
+	language string
+	src      string
+	cursor   int
+	want     target
+	place    placement
+}{
+	{
+		language: "go",
+		src:      "package shapes\n\n// Area is old\nfunc Area(r float64) float64 {\n\treturn r * r\n}",
+		cursor:   4,
+		want:     target{Start: 3, End: 5, Name: "Area"},
+		place:    placement{Start: 2, End: 3},
+	},
+	{
+		language: "python",
+		src:      "class Shape:\n    @property\n    def area(self):\n        \"\"\"Old.\"\"\"\n        return 1",
+		cursor:   4,
+		want:     target{Start: 2, End: 4, Name: "area"},
+		place:    placement{Start: 3, End: 4, Indent: "        "},
+	},
+	{
+		language: "ruby",
+		src:      "module Geo\n  # Old\n  def area\n    1\n  end\nend",
+		cursor:   3,
+		want:     target{Start: 2, End: 4, Name: "area"},
+		place:    placement{Start: 1, End: 2, Indent: "  "},
+	},
+	{
+		language: "javascript",
+		src:      "/** Old */\nexport function area(r) {\n  return r * r;\n}",
+		cursor:   2,
+		want:     target{Start: 1, End: 3, Name: "area"},
+		place:    placement{Start: 0, End: 1},
+	},
+	{
+		language: "typescript",
+		src:      "class Circle {\n  // Old\n  @memo\n  area(): number {\n    return 1;\n  }\n}",
+		// On the comment, the action applies to the declaration below
+		cursor: 1,
+		want:   target{Start: 3, End: 5, Name: "area"},
+		place:  placement{Start: 1, End: 2, Indent: "  "},
+	},
+	{
+		language: "rust",
+		src:      "/// Old\n#[inline]\npub fn area(r: f64) -> f64 {\n    r * r\n}",
+		cursor:   3,
+		want:     target{Start: 2, End: 4, Name: "area"},
+		place:    placement{Start: 0, End: 1},
+	},
+	{
+		language: "java",
+		src:      "public class Shape {\n    /**\n     * Old\n     */\n    public double area() {\n        return 1;\n    }\n}",
+		cursor:   5,
+		want:     target{Start: 4, End: 6, Name: "area"},
+		place:    placement{Start: 1, End: 4, Indent: "    "},
+	},
+	{
+		language: "csharp",
+		src:      "namespace Geo\n{\n    [Obsolete]\n    public static int Area(int r)\n    {\n        return r;\n    }\n}",
+		cursor:   5,
+		want:     target{Start: 3, End: 6, Name: "Area"},
+		place:    placement{Start: 2, End: 2, Indent: "    "},
+	},
+	{
+		language: "swift",
+		src:      "struct Circle {\n    var r: Double\n    func area() -> Double {\n        return r * r\n    }\n}",
+		cursor:   3,
+		want:     target{Start: 2, End: 4, Name: "area"},
+		place:    placement{Start: 2, End: 2, Indent: "    "},
+	},
+	{
+		language: "kotlin",
+		src:      "// Old\nfun area(r: Double): Double = r * r\n\nval unit = \"cm\"",
+		cursor:   1,
+		want:     target{Start: 1, End: 1, Name: "area"},
+		place:    placement{Start: 0, End: 1},
+	},
+	{
+		language: "cpp",
+		src:      "#include <cmath>\n\n/* Old */\ndouble area(double r) {\n    return r * r;\n}",
+		cursor:   4,
+		want:     target{Start: 3, End: 5, Name: "area"},
+		place:    placement{Start: 2, End: 3},
+	},
+	{
+		language: "php",
+		src:      "<?php\n# Old\n#[Pure]\nfunction area($r) {\n    return $r * $r;\n}",
+		cursor:   4,
+		want:     target{Start: 3, End: 5, Name: "area"},
+		place:    placement{Start: 1, End: 2},
+	},
+	{
+		language: "bash",
+		src:      "#!/bin/bash\n# Old\narea() {\n  echo $(( $1 * $1 ))\n}",
+		cursor:   3,
+		want:     target{Start: 2, End: 4, Name: "area"},
+		place:    placement{Start: 1, End: 2},
+	},
+}
+
+func TestFindTarget(t *testing.T) {
+	for _, tt := range symbolTests {
+		t.Run(tt.language, func(t *testing.T) {
+			cursor := Position{Line: tt.cursor, Character: 2}
+			got, ok := findTarget(splitLines(tt.src), tt.language, Range{Start: cursor, End: cursor})
+			if !ok || got != tt.want {
+				t.Errorf("findTarget = %+v, %v; want %+v", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindTargetSelection(t *testing.T) {
+	lines := splitLines("package shapes\n\n// Area is old\nfunc Area(r float64) float64 {\n\treturn r * r\n}\n")
+
+	tests := []struct {
+		name string
+		rng  Range
+		want target
+		ok   bool
+	}{
+		{"whole lines", Range{Start: Position{Line: 2}, End: Position{Line: 6}}, target{Start: 3, End: 5, Name: "Area"}, true},
+		{"part of a line", Range{Start: Position{Line: 4, Character: 1}, End: Position{Line: 4, Character: 7}}, target{Start: 4, End: 4}, true},
+		{"blank line", Range{Start: Position{Line: 1}, End: Position{Line: 1, Character: 1}}, target{}, false},
+		{"cursor outside declarations", Range{Start: Position{Line: 1}, End: Position{Line: 1}}, target{}, false},
+		{"past the end", Range{Start: Position{Line: 9}, End: Position{Line: 9}}, target{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := findTarget(lines, "go", tt.rng)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("findTarget = %+v, %v; want %+v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+
+	// Without declaration patterns only selections are documented
+	cursor := Position{Line: 3}
+	if _, ok := findTarget(lines, "text", Range{Start: cursor, End: cursor}); ok {
+		t.Error("found a declaration in plain text")
+	}
+}
+
+func TestPlaceComment(t *testing.T) {
+	for _, tt := range symbolTests {
+		t.Run(tt.language, func(t *testing.T) {
+			got, err := placeComment(splitLines(tt.src), tt.language, tt.want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.place {
+				t.Errorf("placeComment = %+v, want %+v", got, tt.place)
+			}
+		})
+	}
+}
+
+func TestPlaceDocstring(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want placement
+	}{
+		{"no docstring", "def f(x):\n    return x", placement{Start: 1, End: 1, Indent: "    "}},
+		{"wrapped signature", "def f(\n    x,\n):\n  return x", placement{Start: 3, End: 3, Indent: "  "}},
+		{"multiline docstring", "def f():\n    '''Old\n\n    text.'''\n    pass", placement{Start: 1, End: 4, Indent: "    "}},
+		{"prefixed docstring", "def f():\n    r\"\"\"Old \\d.\"\"\"\n    pass", placement{Start: 1, End: 2, Indent: "    "}},
+		{"colon in a comment", "def f(x):  # returns: x\n    return x", placement{Start: 1, End: 1, Indent: "    "}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := splitLines(tt.src)
+			got, err := placeComment(lines, "python", target{Start: 0, End: len(lines) - 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("placeComment = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := placeComment([]string{"f = lambda x: x"}, "python", target{}); err == nil {
+		t.Error("expected an error for a definition without a block")
+	}
+}
+
+func TestFormatComment(t *testing.T) {
+	tests := []struct {
+		name    string
+		comment string
+		indent  string
+		newline string
+		want    string
+	}{
+		{"go", "// Area returns\n// the area.", "\t", "\n", "\t// Area returns\n\t// the area.\n"},
+		{"common indentation", "  \"\"\"Doc.\n\n    More.\n  \"\"\"", "    ", "\n", "    \"\"\"Doc.\n\n      More.\n    \"\"\"\n"},
+		{"trailing spaces", "/**  \n * Doc.\t\n */", "", "\n", "/**\n * Doc.\n */\n"},
+		{"CRLF", "// a\r\n// b", "  ", "\r\n", "  // a\r\n  // b\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatComment(tt.comment, tt.indent, tt.newline); got != tt.want {
+				t.Errorf("formatComment = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDocumentLanguage(t *testing.T) {
+	tests := []struct {
+		uri, languageID, want string
+	}{
+		{"file:///src/app.tsx", "typescriptreact", "typescript"},
+		{"file:///src/app.jsx", "javascriptreact", "javascript"},
+		{"file:///src/main.c", "c", "cpp"},
+		{"file:///src/run.sh", "shellscript", "bash"},
+		{"file:///src/main.go", "go", "go"},
+		{"file:///src/lib.rs", "", "rust"},
+		{"file:///src/My%20App/main.py", "plaintext", "python"},
+		{"untitled:Untitled-1", "", "text"},
+	}
+
+	for _, tt := range tests {
+		if got := documentLanguage(tt.uri, tt.languageID); got != tt.want {
+			t.Errorf("documentLanguage(%q, %q) = %q, want %q", tt.uri, tt.languageID, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/kamdyn/ai-toolkit/pkg/common"
+	"github.com/urfave/cli/v2"
+)
+
+func init() {
+	common.RegisterTool(common.Tool{
+		Name:    "mcp",
+		Command: GetMCPCommand,
+	})
+}
+
+// GetMCPCommand returns the CLI command for the MCP server
+func GetMCPCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "mcp",
+		Usage: "Serve typegen and docgen as Model Context Protocol tools over stdio",
+		Flags: append(common.CommonFlags(),
+			&cli.StringFlag{
+				Name:    "lang",
+				Aliases: []string{"l"},
+				Usage:   "Output language of generate_types calls that do not specify one",
+				Value:   common.GetEnvOrDefault("DEFAULT_LANG", "typescript"),
+				EnvVars: []string{"DEFAULT_LANG"},
+			},
+			&cli.StringFlag{
+				Name:    "root",
+				Usage:   "Directory generate_docs may read files from (default: the working directory)",
+				EnvVars: []string{"AI_TOOLKIT_ROOT"},
+			},
+		),
+		Before: func(c *cli.Context) error {
+			// Fill unset options from the config files and profile
+			if err := common.ApplyConfig(c); err != nil {
+				return err
+			}
+
+			// Validate API key
+			return common.ValidateCredentials(c)
+		},
+		Action: func(c *cli.Context) error {
+			return runMCP(c)
+		},
+	}
+}
+
+// runMCP serves MCP on stdin and stdout until the client disconnects
+func runMCP(c *cli.Context) error {
+	// Extract configuration
+	config := common.ExtractCommonConfig(c)
+
+	// Logs go to stderr, stdout carries the protocol
+	common.PrepareLogger("MCP", config.LogFormat, config.Verbose)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Export traces of the tool calls if enabled
+	shutdownTracing, err := common.SetupTracing(ctx, "mcp", config.Trace)
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing()
+
+	// One client serves every tool call, sharing the cache, rate limits and budget
+	aiClient, err := common.NewAIClient(ctx, config)
+	if err != nil {
+		return fmt.Errorf("error creating AI client: %v", err)
+	}
+	defer aiClient.Close()
+
+	// Print token usage and cost of the session once the client disconnects
+	defer common.ReportUsage(aiClient.Usage(), config.LogFormat)
+
+	server := NewServer("ai-toolkit", common.Version)
+	if err := RegisterTools(server, aiClient, config, c.String("lang"), c.String("root")); err != nil {
+		return err
+	}
+
+	return server.Serve(ctx, os.Stdin, os.Stdout)
+}
@@ -0,0 +1,262 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/kamdyn/ai-toolkit/pkg/common"
+	"github.com/kamdyn/ai-toolkit/pkg/jsonrpc"
+)
+
+// LatestProtocolVersion is the MCP revision offered to clients asking for one
+// the server does not know
+const LatestProtocolVersion = "2025-06-18"
+
+// supportedProtocolVersions lists the MCP revisions the server speaks
+var supportedProtocolVersions = []string{LatestProtocolVersion, "2025-03-26", "2024-11-05"}
+
+// maxMessageBytes caps the size of a single message read from the client
+const maxMessageBytes = 10 << 20
+
+// Tool is a tool exposed to clients
+type Tool struct {
+	Name        string
+	Title       string
+	Description string
+	// Input and Output are zero values of the argument and result types,
+	// from which the JSON schemas are derived
+	Input  interface{}
+	Output interface{}
+	// Call runs the tool with the arguments decoded into a new value of the Input type
+	Call func(ctx context.Context, args json.RawMessage) (interface{}, error)
+}
+
+// tool is a registered tool with its schemas
+type tool struct {
+	Tool
+	input  *common.Schema
+	output *common.Schema
+}
+
+// Server is an MCP server speaking newline-delimited JSON-RPC, as used by the
+// stdio transport. Tool calls run concurrently and are canceled by the
+// client's notifications/cancelled.
+type Server struct {
+	name    string
+	version string
+	tools   []*tool
+
+	conn *jsonrpc.Conn
+	// calls are the tool calls in flight
+	calls jsonrpc.Calls
+}
+
+// NewServer creates a server announcing itself with name and version
+func NewServer(name, version string) *Server {
+	return &Server{
+		name:    name,
+		version: version,
+	}
+}
+
+// AddTool registers a tool
+func (s *Server) AddTool(t Tool) error {
+	input, err := common.SchemaFor(t.Input)
+	if err != nil {
+		return fmt.Errorf("error deriving input schema of %s: %v", t.Name, err)
+	}
+	output, err := common.SchemaFor(t.Output)
+	if err != nil {
+		return fmt.Errorf("error deriving output schema of %s: %v", t.Name, err)
+	}
+
+	s.tools = append(s.tools, &tool{Tool: t, input: input, output: output})
+	return nil
+}
+
+// Serve reads messages from r and writes responses to w until r is closed or
+// ctx is canceled. Tool calls still running at that point are canceled and
+// waited for.
+func (s *Server) Serve(ctx context.Context, r io.Reader, w io.Writer) error {
+	s.conn = jsonrpc.NewConn(r, w, jsonrpc.Lines, maxMessageBytes)
+
+	// Deferred in this order, calls are canceled before they are waited for
+	defer s.calls.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	return s.conn.Serve(ctx, func(data []byte) bool {
+		s.handleMessage(ctx, data)
+		return false
+	})
+}
+
+// handleMessage dispatches a single message from the client
+func (s *Server) handleMessage(ctx context.Context, data []byte) {
+	var msg jsonrpc.Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		s.conn.Respond(nil, nil, jsonrpc.Errorf(jsonrpc.CodeParseError, "invalid JSON: %v", err))
+		return
+	}
+	if msg.JSONRPC != "2.0" || msg.Method == "" {
+		// Responses to server requests are not expected, since the server sends none
+		if msg.ID != nil && msg.Method == "" && (msg.Result != nil || msg.Error != nil) {
+			return
+		}
+		s.conn.Respond(msg.ID, nil, jsonrpc.Errorf(jsonrpc.CodeInvalidRequest, "invalid JSON-RPC request"))
+		return
+	}
+
+	// Notifications get no response
+	if msg.ID == nil {
+		s.handleNotification(msg)
+		return
+	}
+
+	switch msg.Method {
+	case "initialize":
+		s.conn.Respond(msg.ID, s.initialize(msg.Params), nil)
+	case "ping":
+		s.conn.Respond(msg.ID, struct{}{}, nil)
+	case "tools/list":
+		s.conn.Respond(msg.ID, s.listTools(), nil)
+	case "tools/call":
+		// Calls can take minutes, so they run in the background
+		s.calls.Go(ctx, *msg.ID, func(callCtx context.Context) {
+			result, err := s.callTool(callCtx, msg.Params)
+			if callCtx.Err() != nil && ctx.Err() == nil {
+				// Canceled by the client, which expects no response
+				return
+			}
+			s.conn.Respond(msg.ID, result, err)
+		})
+	default:
+		s.conn.Respond(msg.ID, nil, jsonrpc.Errorf(jsonrpc.CodeMethodNotFound, "method not found: %s", msg.Method))
+	}
+}
+
+// handleNotification handles a message that expects no response
+func (s *Server) handleNotification(msg jsonrpc.Message) {
+	switch msg.Method {
+	case "notifications/cancelled":
+		var params struct {
+			RequestID json.RawMessage `json:"requestId"`
+			Reason    string          `json:"reason"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return
+		}
+		if s.calls.Cancel(params.RequestID) {
+			slog.Debug("Tool call canceled by the client", "request", string(params.RequestID), "reason", params.Reason)
+		}
+	default:
+		// notifications/initialized and others need no action
+	}
+}
+
+// initialize answers the handshake, agreeing on the protocol version
+func (s *Server) initialize(params json.RawMessage) interface{} {
+	var request struct {
+		ProtocolVersion string `json:"protocolVersion"`
+		ClientInfo      struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"clientInfo"`
+	}
+	_ = json.Unmarshal(params, &request)
+
+	version := LatestProtocolVersion
+	for _, supported := range supportedProtocolVersions {
+		if request.ProtocolVersion == supported {
+			version = supported
+		}
+	}
+	slog.Debug("Client connected", "client", request.ClientInfo.Name, "client_version", request.ClientInfo.Version, "protocol", version)
+
+	return map[string]interface{}{
+		"protocolVersion": version,
+		"capabilities": map[string]interface{}{
+			"tools": map[string]interface{}{"listChanged": false},
+		},
+		"serverInfo": map[string]interface{}{
+			"name":    s.name,
+			"version": s.version,
+		},
+	}
+}
+
+// listTools describes the registered tools
+func (s *Server) listTools() interface{} {
+	tools := make([]map[string]interface{}, 0, len(s.tools))
+	for _, t := range s.tools {
+		tools = append(tools, map[string]interface{}{
+			"name":         t.Name,
+			"title":        t.Title,
+			"description":  t.Description,
+			"inputSchema":  t.input.JSONSchema(),
+			"outputSchema": t.output.JSONSchema(),
+		})
+	}
+	return map[string]interface{}{"tools": tools}
+}
+
+// callTool runs a tool. Unknown tools and invalid arguments are protocol
+// errors; failures of the tool itself are reported in the result so the
+// model calling it can see them.
+func (s *Server) callTool(ctx context.Context, params json.RawMessage) (interface{}, *jsonrpc.Error) {
+	var request struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal(params, &request); err != nil {
+		return nil, jsonrpc.Errorf(jsonrpc.CodeInvalidParams, "invalid params: %v", err)
+	}
+
+	var t *tool
+	for _, candidate := range s.tools {
+		if candidate.Name == request.Name {
+			t = candidate
+		}
+	}
+	if t == nil {
+		return nil, jsonrpc.Errorf(jsonrpc.CodeInvalidParams, "unknown tool: %s", request.Name)
+	}
+
+	// Check the arguments against the advertised schema
+	if len(request.Arguments) == 0 {
+		request.Arguments = json.RawMessage("{}")
+	}
+	var args interface{}
+	if err := json.Unmarshal(request.Arguments, &args); err != nil {
+		return nil, jsonrpc.Errorf(jsonrpc.CodeInvalidParams, "invalid arguments: %v", err)
+	}
+	if err := t.input.Validate(args); err != nil {
+		return nil, jsonrpc.Errorf(jsonrpc.CodeInvalidParams, "invalid arguments: %v", err)
+	}
+
+	slog.Debug("Calling tool", "tool", t.Name)
+	result, err := t.Call(ctx, request.Arguments)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Warn("Tool call failed", "tool", t.Name, "error", err)
+		}
+		return map[string]interface{}{
+			"content": []map[string]string{{"type": "text", "text": err.Error()}},
+			"isError": true,
+		}, nil
+	}
+
+	// The text content repeats the structured content for clients predating it
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, jsonrpc.Errorf(jsonrpc.CodeInternalError, "error encoding result: %v", err)
+	}
+	return map[string]interface{}{
+		"content":           []map[string]string{{"type": "text", "text": string(data)}},
+		"structuredContent": result,
+		"isError":           false,
+	}, nil
+}
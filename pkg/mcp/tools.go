@@ -0,0 +1,230 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kamdyn/ai-toolkit/pkg/common"
+	"github.com/kamdyn/ai-toolkit/pkg/docgen"
+	"github.com/kamdyn/ai-toolkit/pkg/typegen"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// generateTypesArgs are the arguments of the generate_types tool
+type generateTypesArgs struct {
+	URL  string `json:"url" description:"URL of the API documentation page to generate types from"`
+	Func string `json:"func,omitempty" description:"Specific function or method to generate types for"`
+	Lang string `json:"lang,omitempty" enum:"typescript,go,python,rust,java,csharp,swift,kotlin" description:"Output language, the server's default if omitted"`
+}
+
+// generateTypesResult is the result of the generate_types tool
+type generateTypesResult struct {
+	Code string `json:"code" description:"Source of the generated type definitions"`
+	Lang string `json:"lang" description:"Language of the type definitions"`
+}
+
+// generateDocsArgs are the arguments of the generate_docs tool
+type generateDocsArgs struct {
+	Path  string `json:"path" description:"Path of the source file to document, absolute or relative to the server's root directory, which it must be inside"`
+	Style string `json:"style,omitempty" enum:"jsdoc,tsdoc,godoc,docstring,xml,markdown" description:"Documentation style; markdown produces a separate document, the others return the source with doc comments added"`
+	Lang  string `json:"lang,omitempty" description:"Language of the source, detected from the file extension if omitted"`
+}
+
+// generateDocsResult is the result of the generate_docs tool
+type generateDocsResult struct {
+	Documentation string `json:"documentation" description:"The generated documentation, or the documented source for comment styles"`
+	Lang          string `json:"lang" description:"Language of the source"`
+	Path          string `json:"path" description:"Path of the documented file"`
+}
+
+// toolset runs the tools with one shared AI client
+type toolset struct {
+	config  common.ToolConfig
+	lang    string
+	root    string
+	typegen *typegen.TypeGenerator
+	docgen  *docgen.DocGenerator
+}
+
+// RegisterTools registers generate_types and generate_docs on server. lang is
+// the output language of generate_types calls that do not specify one, and
+// generate_docs only reads files inside the root directory.
+func RegisterTools(server *Server, client common.Provider, config common.ToolConfig, lang, root string) error {
+	root, err := resolveRoot(root)
+	if err != nil {
+		return err
+	}
+
+	typeGenerator := typegen.NewTypeGenerator(client)
+	typeGenerator.ChunkTokens = config.ChunkTokens
+	typeGenerator.Structured = config.Structured
+	typeGenerator.Selection = config.CodeBlocks
+
+	docGenerator := docgen.NewDocGenerator(client)
+	docGenerator.ChunkTokens = config.ChunkTokens
+	docGenerator.Structured = config.Structured
+	docGenerator.Selection = config.CodeBlocks
+
+	t := &toolset{
+		config:  config,
+		lang:    typegen.NormalizeLanguage(lang),
+		root:    root,
+		typegen: typeGenerator,
+		docgen:  docGenerator,
+	}
+
+	tools := []Tool{
+		{
+			Name:        "generate_types",
+			Title:       "Generate type definitions",
+			Description: "Scrape an API documentation page and generate type definitions for its requests and responses in the chosen language.",
+			Input:       generateTypesArgs{},
+			Output:      generateTypesResult{},
+			Call:        t.generateTypes,
+		},
+		{
+			Name:        "generate_docs",
+			Title:       "Generate documentation",
+			Description: "Generate documentation for a source file: a Markdown document, or the source with doc comments in the chosen style.",
+			Input:       generateDocsArgs{},
+			Output:      generateDocsResult{},
+			Call:        t.generateDocs,
+		},
+	}
+	for _, tool := range tools {
+		if err := server.AddTool(tool); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// generateTypes runs the generate_types tool
+func (t *toolset) generateTypes(ctx context.Context, raw json.RawMessage) (result interface{}, err error) {
+	var args generateTypesArgs
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	language := t.lang
+	if args.Lang != "" {
+		language = typegen.NormalizeLanguage(args.Lang)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(t.config.Timeout)*time.Second)
+	defer cancel()
+
+	ctx, span := common.StartSpan(ctx, "typegen",
+		attribute.String("url.full", args.URL),
+		attribute.String("typegen.lang", language))
+	defer func() { common.EndSpan(span, err) }()
+
+	// The URL comes from the agent, so it may only point to public addresses
+	docContent, err := typegen.ScrapePublicDocumentation(ctx, args.URL, args.Func)
+	if err != nil {
+		return nil, fmt.Errorf("error scraping documentation: %w", err)
+	}
+
+	ctx = common.WithUsageLabel(ctx, args.URL)
+	code, err := t.typegen.GenerateTypeDefinitions(ctx, t.config.Model, t.config.Temperature, docContent, language, args.Func)
+	if err != nil {
+		return nil, fmt.Errorf("error generating type definitions: %v", err)
+	}
+
+	return generateTypesResult{
+		Code: code,
+		Lang: language,
+	}, nil
+}
+
+// generateDocs runs the generate_docs tool
+func (t *toolset) generateDocs(ctx context.Context, raw json.RawMessage) (result interface{}, err error) {
+	var args generateDocsArgs
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	language := strings.ToLower(args.Lang)
+	if language == "" {
+		language = docgen.DetectLanguage(args.Path)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(t.config.Timeout)*time.Second)
+	defer cancel()
+
+	ctx, span := common.StartSpan(ctx, "docgen",
+		attribute.String("file.path", args.Path),
+		attribute.String("docgen.lang", language))
+	defer func() { common.EndSpan(span, err) }()
+
+	// Read the source code
+	path, err := t.resolvePath(args.Path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %v", err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("not a regular file: %s", args.Path)
+	}
+	codeBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %v", err)
+	}
+
+	ctx = common.WithUsageLabel(ctx, args.Path)
+	documentation, err := t.docgen.GenerateDocumentation(ctx, t.config.Model, t.config.Temperature, string(codeBytes), language, args.Style)
+	if err != nil {
+		return nil, err
+	}
+
+	return generateDocsResult{
+		Documentation: documentation,
+		Lang:          language,
+		Path:          args.Path,
+	}, nil
+}
+
+// resolveRoot returns the absolute path of the root directory, with symbolic
+// links resolved. An empty root is the working directory.
+func resolveRoot(root string) (string, error) {
+	if root == "" {
+		root = "."
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return "", fmt.Errorf("error resolving root directory: %v", err)
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return "", fmt.Errorf("error resolving root directory: %v", err)
+	}
+	return resolved, nil
+}
+
+// resolvePath returns the real path of a file a client asked for, which may
+// be relative to the root directory. Paths outside the root, including those
+// reaching it through symbolic links, are rejected.
+func (t *toolset) resolvePath(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(t.root, path)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading file: %v", err)
+	}
+
+	rel, err := filepath.Rel(t.root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		return "", fmt.Errorf("path is outside the root directory %s: %s", t.root, path)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,52 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kamdyn/ai-toolkit/pkg/common"
+	"github.com/kamdyn/ai-toolkit/pkg/typegen"
+)
+
+func TestResolvePath(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	for _, dir := range []string{root, outside} {
+		if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(outside, "main.go"), filepath.Join(root, "link.go")); err != nil {
+		t.Skipf("symbolic links are not supported: %v", err)
+	}
+
+	resolvedRoot, err := resolveRoot(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tools := &toolset{root: resolvedRoot}
+
+	for _, path := range []string{"main.go", "./sub/../main.go", filepath.Join(root, "main.go")} {
+		if _, err := tools.resolvePath(path); err != nil {
+			t.Errorf("resolvePath(%q): %v", path, err)
+		}
+	}
+	for _, path := range []string{filepath.Join(outside, "main.go"), filepath.Join("..", filepath.Base(outside), "main.go"), "link.go"} {
+		if _, err := tools.resolvePath(path); err == nil {
+			t.Errorf("resolvePath(%q) accepted a file outside the root", path)
+		}
+	}
+}
+
+func TestGenerateTypesRejectsNonPublicURL(t *testing.T) {
+	tools := &toolset{config: common.ToolConfig{Timeout: 5}, lang: "typescript"}
+
+	_, err := tools.generateTypes(context.Background(), json.RawMessage(`{"url": "http://127.0.0.1/"}`))
+	if !errors.Is(err, typegen.ErrNonPublicAddress) {
+		t.Fatalf("expected ErrNonPublicAddress, got %v", err)
+	}
+}
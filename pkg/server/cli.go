@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/kamdyn/ai-toolkit/pkg/common"
+	"github.com/urfave/cli/v2"
+)
+
+// DefaultAddr is the address the server listens on unless --addr is given
+const DefaultAddr = "localhost:8080"
+
+func init() {
+	common.RegisterTool(common.Tool{
+		Name:    "serve",
+		Command: GetServeCommand,
+	})
+}
+
+// GetServeCommand returns the CLI command for the HTTP server
+func GetServeCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "serve",
+		Usage: "Serve typegen and docgen as an HTTP API",
+		Flags: append(common.CommonFlags(),
+			&cli.StringFlag{
+				Name:    "addr",
+				Usage:   "Address to listen on",
+				Value:   common.GetEnvOrDefault("AI_TOOLKIT_ADDR", DefaultAddr),
+				EnvVars: []string{"AI_TOOLKIT_ADDR"},
+			},
+			&cli.StringFlag{
+				Name:    "lang",
+				Aliases: []string{"l"},
+				Usage:   "Output language of type generation requests that do not specify one",
+				Value:   common.GetEnvOrDefault("DEFAULT_LANG", "typescript"),
+				EnvVars: []string{"DEFAULT_LANG"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "allowed-models",
+				Usage:   "Models requests may select besides --model and its fallbacks",
+				EnvVars: []string{"AI_TOOLKIT_ALLOWED_MODELS"},
+			},
+		),
+		Before: func(c *cli.Context) error {
+			// Fill unset options from the config files and profile
+			if err := common.ApplyConfig(c); err != nil {
+				return err
+			}
+
+			// Validate API key
+			return common.ValidateCredentials(c)
+		},
+		Action: func(c *cli.Context) error {
+			return runServe(c)
+		},
+	}
+}
+
+// runServe runs the HTTP server until it is interrupted
+func runServe(c *cli.Context) error {
+	// Extract configuration
+	config := common.ExtractCommonConfig(c)
+	addr := c.String("addr")
+
+	// Configure logging based on verbose flag
+	common.PrepareLogger("Serve", config.LogFormat, config.Verbose)
+
+	// Stop on Ctrl-C or when the process manager asks
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Export traces of the requests if enabled
+	shutdownTracing, err := common.SetupTracing(ctx, "serve", config.Trace)
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing()
+
+	// One client serves every request, sharing the cache, rate limits and budget
+	aiClient, err := common.NewAIClient(ctx, config)
+	if err != nil {
+		return fmt.Errorf("error creating AI client: %v", err)
+	}
+	defer aiClient.Close()
+
+	// Print token usage and cost of all requests once the server stops
+	defer common.ReportUsage(aiClient.Usage(), config.LogFormat)
+
+	httpServer := &http.Server{
+		Addr:              addr,
+		Handler:           NewServer(aiClient, config, c.String("lang"), c.StringSlice("allowed-models")).Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpServer.ListenAndServe()
+	}()
+	slog.Info("Listening", "addr", addr, "provider", config.Provider, "model", config.Model)
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("error serving HTTP: %v", err)
+	case <-ctx.Done():
+	}
+
+	// Let requests in flight finish, for at most one generation timeout
+	slog.Info("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(config.Timeout)*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Requests in flight were aborted", "error", err)
+	}
+
+	return nil
+}
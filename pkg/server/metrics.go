@@ -0,0 +1,146 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kamdyn/ai-toolkit/pkg/common"
+)
+
+// metricsPrefix namespaces the exported metrics
+const metricsPrefix = "ai_toolkit_"
+
+// requestKey identifies a request counter
+type requestKey struct {
+	endpoint string
+	status   int
+}
+
+// durationSummary accumulates the request durations of an endpoint
+type durationSummary struct {
+	count int
+	sum   time.Duration
+}
+
+// metrics counts the requests served. Token usage and cost are read from the
+// client's usage tracker when rendering.
+type metrics struct {
+	start time.Time
+
+	mu        sync.Mutex
+	inFlight  int
+	requests  map[requestKey]int
+	durations map[string]*durationSummary
+}
+
+// newMetrics creates empty metrics
+func newMetrics() *metrics {
+	return &metrics{
+		start:     time.Now(),
+		requests:  map[requestKey]int{},
+		durations: map[string]*durationSummary{},
+	}
+}
+
+// begin counts a request in flight
+func (m *metrics) begin() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight++
+}
+
+// end counts the end of a request in flight
+func (m *metrics) end() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight--
+}
+
+// observe records a completed request
+func (m *metrics) observe(endpoint string, status int, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[requestKey{endpoint: endpoint, status: status}]++
+	summary, ok := m.durations[endpoint]
+	if !ok {
+		summary = &durationSummary{}
+		m.durations[endpoint] = summary
+	}
+	summary.count++
+	summary.sum += duration
+}
+
+// render writes the metrics in the Prometheus text exposition format
+func (m *metrics) render(usage *common.UsageTracker) []byte {
+	var buf bytes.Buffer
+
+	m.mu.Lock()
+	writeMetric(&buf, "uptime_seconds", "gauge", "Seconds since the server started")
+	fmt.Fprintf(&buf, "%suptime_seconds %s\n", metricsPrefix, formatFloat(time.Since(m.start).Seconds()))
+
+	writeMetric(&buf, "http_requests_in_flight", "gauge", "Requests being served")
+	fmt.Fprintf(&buf, "%shttp_requests_in_flight %d\n", metricsPrefix, m.inFlight)
+
+	keys := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].endpoint != keys[j].endpoint {
+			return keys[i].endpoint < keys[j].endpoint
+		}
+		return keys[i].status < keys[j].status
+	})
+	writeMetric(&buf, "http_requests_total", "counter", "Requests served by endpoint and status")
+	for _, key := range keys {
+		fmt.Fprintf(&buf, "%shttp_requests_total{endpoint=%q,status=\"%d\"} %d\n", metricsPrefix, key.endpoint, key.status, m.requests[key])
+	}
+
+	endpoints := make([]string, 0, len(m.durations))
+	for endpoint := range m.durations {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+	writeMetric(&buf, "http_request_duration_seconds", "summary", "Time spent serving requests by endpoint")
+	for _, endpoint := range endpoints {
+		summary := m.durations[endpoint]
+		fmt.Fprintf(&buf, "%shttp_request_duration_seconds_sum{endpoint=%q} %s\n", metricsPrefix, endpoint, formatFloat(summary.sum.Seconds()))
+		fmt.Fprintf(&buf, "%shttp_request_duration_seconds_count{endpoint=%q} %d\n", metricsPrefix, endpoint, summary.count)
+	}
+	m.mu.Unlock()
+
+	// Generation calls are accounted under the endpoint that made them
+	summaries := usage.Summaries()
+	writeMetric(&buf, "model_calls_total", "counter", "Model calls by endpoint, including cached responses")
+	for _, summary := range summaries {
+		fmt.Fprintf(&buf, "%smodel_calls_total{endpoint=%q,cached=\"false\"} %d\n", metricsPrefix, summary.Label, summary.Calls-summary.CachedCalls)
+		fmt.Fprintf(&buf, "%smodel_calls_total{endpoint=%q,cached=\"true\"} %d\n", metricsPrefix, summary.Label, summary.CachedCalls)
+	}
+	writeMetric(&buf, "tokens_total", "counter", "Tokens sent to and generated by the model by endpoint")
+	for _, summary := range summaries {
+		fmt.Fprintf(&buf, "%stokens_total{endpoint=%q,type=\"prompt\"} %d\n", metricsPrefix, summary.Label, summary.PromptTokens)
+		fmt.Fprintf(&buf, "%stokens_total{endpoint=%q,type=\"output\"} %d\n", metricsPrefix, summary.Label, summary.CandidateTokens)
+	}
+	writeMetric(&buf, "cost_usd_total", "counter", "Estimated cost of the model calls in USD by endpoint")
+	for _, summary := range summaries {
+		fmt.Fprintf(&buf, "%scost_usd_total{endpoint=%q} %s\n", metricsPrefix, summary.Label, formatFloat(summary.Cost))
+	}
+
+	return buf.Bytes()
+}
+
+// writeMetric writes the HELP and TYPE lines of a metric
+func writeMetric(buf *bytes.Buffer, name, kind, help string) {
+	fmt.Fprintf(buf, "# HELP %s%s %s\n", metricsPrefix, name, help)
+	fmt.Fprintf(buf, "# TYPE %s%s %s\n", metricsPrefix, name, kind)
+}
+
+// formatFloat formats a sample value
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
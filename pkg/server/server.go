@@ -0,0 +1,372 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kamdyn/ai-toolkit/pkg/common"
+	"github.com/kamdyn/ai-toolkit/pkg/docgen"
+	"github.com/kamdyn/ai-toolkit/pkg/typegen"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// maxRequestBytes caps the size of a request body, uploaded pages included
+const maxRequestBytes = 10 << 20
+
+// TypeGenRequest is the body of POST /v1/typegen. Exactly one of URL and HTML
+// must be set.
+type TypeGenRequest struct {
+	// URL is the documentation page to scrape
+	URL string `json:"url,omitempty"`
+	// HTML is the content of a documentation page, for pages the server cannot reach
+	HTML string `json:"html,omitempty"`
+	// Func restricts the types to a specific function or method
+	Func string `json:"func,omitempty"`
+	// Lang is the output language; the server's --lang is used if empty
+	Lang string `json:"lang,omitempty"`
+	// Model overrides the server's model for this request
+	Model string `json:"model,omitempty"`
+	// Temperature overrides the server's temperature for this request
+	Temperature *float32 `json:"temperature,omitempty"`
+}
+
+// TypeGenResponse is the body of a successful POST /v1/typegen
+type TypeGenResponse struct {
+	Code string `json:"code"`
+	Lang string `json:"lang"`
+}
+
+// DocGenRequest is the body of POST /v1/docgen
+type DocGenRequest struct {
+	// Code is the source to document
+	Code string `json:"code"`
+	// Lang is the language of the source; it is detected from Filename if empty
+	Lang string `json:"lang,omitempty"`
+	// Filename is the name of the source file, used to detect the language
+	Filename string `json:"filename,omitempty"`
+	// Style is the documentation style (jsdoc, godoc, docstring, xml, markdown)
+	Style string `json:"style,omitempty"`
+	// Model overrides the server's model for this request
+	Model string `json:"model,omitempty"`
+	// Temperature overrides the server's temperature for this request
+	Temperature *float32 `json:"temperature,omitempty"`
+}
+
+// DocGenResponse is the body of a successful POST /v1/docgen
+type DocGenResponse struct {
+	Documentation string `json:"documentation"`
+	Lang          string `json:"lang"`
+}
+
+// ErrorResponse is the body of a failed request
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server serves the tools over HTTP. All requests share one AI client, so the
+// cache, rate limits and cost budget apply across them.
+type Server struct {
+	client  *common.AIClient
+	config  common.ToolConfig
+	lang    string
+	typegen *typegen.TypeGenerator
+	docgen  *docgen.DocGenerator
+	metrics *metrics
+
+	// models are the models requests may select, the server's own included
+	models map[string]bool
+}
+
+// NewServer creates a server generating with client. lang is the output
+// language of type generation requests that do not specify one, and
+// allowedModels are the models requests may select in addition to the
+// server's model and fallbacks.
+func NewServer(client *common.AIClient, config common.ToolConfig, lang string, allowedModels []string) *Server {
+	typeGenerator := typegen.NewTypeGenerator(client)
+	typeGenerator.ChunkTokens = config.ChunkTokens
+	typeGenerator.Structured = config.Structured
+	typeGenerator.Selection = config.CodeBlocks
+
+	docGenerator := docgen.NewDocGenerator(client)
+	docGenerator.ChunkTokens = config.ChunkTokens
+	docGenerator.Structured = config.Structured
+	docGenerator.Selection = config.CodeBlocks
+
+	models := map[string]bool{config.Model: true}
+	for _, model := range append(config.Models, allowedModels...) {
+		if model = strings.TrimSpace(model); model != "" {
+			models[model] = true
+		}
+	}
+
+	return &Server{
+		client:  client,
+		config:  config,
+		lang:    typegen.NormalizeLanguage(lang),
+		typegen: typeGenerator,
+		docgen:  docGenerator,
+		metrics: newMetrics(),
+		models:  models,
+	}
+}
+
+// Handler returns the HTTP handler of the API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/v1/typegen", s.instrument("typegen", http.MethodPost, s.handleTypeGen))
+	mux.Handle("/v1/docgen", s.instrument("docgen", http.MethodPost, s.handleDocGen))
+	mux.Handle("/healthz", s.instrument("healthz", http.MethodGet, s.handleHealth))
+	mux.Handle("/metrics", s.instrument("metrics", http.MethodGet, s.handleMetrics))
+	return mux
+}
+
+// handlerFunc is an API handler. It returns the status and body of the
+// response, which is written as JSON.
+type handlerFunc func(r *http.Request) (int, interface{})
+
+// instrument adapts an API handler, restricting it to method and recording
+// the request in the logs and metrics
+func (s *Server) instrument(endpoint, method string, handler handlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		s.metrics.begin()
+		defer s.metrics.end()
+
+		var status int
+		var body interface{}
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			status, body = http.StatusMethodNotAllowed, ErrorResponse{Error: "method not allowed"}
+		} else {
+			status, body = handler(r)
+		}
+
+		// Nobody is waiting for the response of a canceled request
+		if r.Context().Err() == nil {
+			writeJSON(w, status, body)
+		}
+
+		duration := time.Since(start)
+		s.metrics.observe(endpoint, status, duration)
+
+		attrs := []any{"method", r.Method, "path", r.URL.Path, "status", status, "duration", duration}
+		if errResp, ok := body.(ErrorResponse); ok {
+			attrs = append(attrs, "error", errResp.Error)
+		}
+		if status >= http.StatusInternalServerError {
+			slog.Warn("Request failed", attrs...)
+		} else if endpoint == "typegen" || endpoint == "docgen" {
+			slog.Info("Request handled", attrs...)
+		} else {
+			slog.Debug("Request handled", attrs...)
+		}
+	})
+}
+
+// handleTypeGen generates type definitions from a documentation URL or page
+func (s *Server) handleTypeGen(r *http.Request) (int, interface{}) {
+	var req TypeGenRequest
+	if status, err := decodeJSON(r, &req); err != nil {
+		return status, ErrorResponse{Error: err.Error()}
+	}
+	if (req.URL == "") == (req.HTML == "") {
+		return http.StatusBadRequest, ErrorResponse{Error: "exactly one of url and html is required"}
+	}
+
+	model, temperature, err := s.modelSettings(req.Model, req.Temperature)
+	if err != nil {
+		return http.StatusBadRequest, ErrorResponse{Error: err.Error()}
+	}
+
+	language := s.lang
+	if req.Lang != "" {
+		language = typegen.NormalizeLanguage(req.Lang)
+	}
+
+	ctx, cancel := s.requestContext(r, "typegen")
+	defer cancel()
+
+	ctx, span := common.StartSpan(ctx, "typegen",
+		attribute.String("url.full", req.URL),
+		attribute.String("typegen.lang", language))
+	defer func() { common.EndSpan(span, err) }()
+
+	// 1. Extract the documentation content. Pages are only fetched from
+	// public addresses, so requests cannot reach into the server's network.
+	var docContent string
+	if req.URL != "" {
+		docContent, err = typegen.ScrapePublicDocumentation(ctx, req.URL, req.Func)
+	} else {
+		docContent, err = typegen.ScrapeHTML(ctx, req.HTML, req.Func)
+	}
+	if errors.Is(err, typegen.ErrNonPublicAddress) {
+		return http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("url is not allowed: %v", err)}
+	}
+	if err != nil {
+		return errorStatus(err, http.StatusUnprocessableEntity), ErrorResponse{Error: fmt.Sprintf("error scraping documentation: %v", err)}
+	}
+
+	// 2. Generate type definitions
+	code, err := s.typegen.GenerateTypeDefinitions(ctx, model, temperature, docContent, language, req.Func)
+	if err != nil {
+		return errorStatus(err, http.StatusBadGateway), ErrorResponse{Error: fmt.Sprintf("error generating type definitions: %v", err)}
+	}
+
+	return http.StatusOK, TypeGenResponse{
+		Code: code,
+		Lang: language,
+	}
+}
+
+// handleDocGen generates documentation for source code
+func (s *Server) handleDocGen(r *http.Request) (int, interface{}) {
+	var req DocGenRequest
+	if status, err := decodeJSON(r, &req); err != nil {
+		return status, ErrorResponse{Error: err.Error()}
+	}
+	if strings.TrimSpace(req.Code) == "" {
+		return http.StatusBadRequest, ErrorResponse{Error: "code is required"}
+	}
+
+	model, temperature, err := s.modelSettings(req.Model, req.Temperature)
+	if err != nil {
+		return http.StatusBadRequest, ErrorResponse{Error: err.Error()}
+	}
+
+	language := strings.ToLower(req.Lang)
+	if language == "" {
+		language = docgen.DetectLanguage(req.Filename)
+	}
+
+	ctx, cancel := s.requestContext(r, "docgen")
+	defer cancel()
+
+	ctx, span := common.StartSpan(ctx, "docgen",
+		attribute.String("file.path", req.Filename),
+		attribute.String("docgen.lang", language))
+	defer func() { common.EndSpan(span, err) }()
+
+	documentation, err := s.docgen.GenerateDocumentation(ctx, model, temperature, req.Code, language, req.Style)
+	if err != nil {
+		return errorStatus(err, http.StatusBadGateway), ErrorResponse{Error: err.Error()}
+	}
+
+	return http.StatusOK, DocGenResponse{
+		Documentation: documentation,
+		Lang:          language,
+	}
+}
+
+// handleHealth reports that the server is up
+func (s *Server) handleHealth(r *http.Request) (int, interface{}) {
+	return http.StatusOK, map[string]string{
+		"status":   "ok",
+		"version":  common.Version,
+		"provider": s.config.Provider,
+		"model":    s.config.Model,
+	}
+}
+
+// handleMetrics writes the metrics in the Prometheus text format
+func (s *Server) handleMetrics(r *http.Request) (int, interface{}) {
+	return http.StatusOK, rawBody{
+		contentType: "text/plain; version=0.0.4; charset=utf-8",
+		data:        s.metrics.render(s.client.Usage()),
+	}
+}
+
+// requestContext returns the context of a generation request: canceled when
+// the client goes away or after the configured timeout, and accounting usage
+// to the endpoint
+func (s *Server) requestContext(r *http.Request, endpoint string) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(s.config.Timeout)*time.Second)
+	return common.WithUsageLabel(ctx, endpoint), cancel
+}
+
+// modelSettings returns the model and temperature of a request, defaulting to
+// the server's. Only the server's models and those allowed with
+// --allowed-models may be requested.
+func (s *Server) modelSettings(model string, temperature *float32) (string, float32, error) {
+	if model == "" {
+		model = s.config.Model
+	}
+	if !s.models[model] {
+		return "", 0, fmt.Errorf("model %q is not allowed (allowed: %s)", model, strings.Join(s.allowedModels(), ", "))
+	}
+	if temperature == nil {
+		return model, s.config.Temperature, nil
+	}
+	return model, *temperature, nil
+}
+
+// allowedModels returns the models requests may select, sorted
+func (s *Server) allowedModels() []string {
+	models := make([]string, 0, len(s.models))
+	for model := range s.models {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	return models
+}
+
+// decodeJSON decodes the JSON body of a request into v. On failure it returns
+// the status to respond with.
+func decodeJSON(r *http.Request, v interface{}) (int, error) {
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxRequestBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return http.StatusRequestEntityTooLarge, fmt.Errorf("request body exceeds %d bytes", maxRequestBytes)
+		}
+		return http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err)
+	}
+	return http.StatusOK, nil
+}
+
+// errorStatus returns the status for a failed generation: timeouts and the
+// exhausted budget have their own, everything else gets fallback
+func errorStatus(err error, fallback int) int {
+	var budgetErr *common.BudgetExceededError
+	switch {
+	case errors.As(err, &budgetErr):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	default:
+		return fallback
+	}
+}
+
+// rawBody is a response body written as is instead of as JSON
+type rawBody struct {
+	contentType string
+	data        []byte
+}
+
+// writeJSON writes body with the given status, as JSON unless it is a rawBody
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	if raw, ok := body.(rawBody); ok {
+		w.Header().Set("Content-Type", raw.contentType)
+		w.WriteHeader(status)
+		w.Write(raw.data)
+		return
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error encoding response: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(data, '\n'))
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kamdyn/ai-toolkit/pkg/common"
+)
+
+// newTestServer returns a server whose requests are rejected before they reach a model
+func newTestServer(t *testing.T) http.Handler {
+	t.Helper()
+	config := common.ToolConfig{Model: "primary", Models: []string{"primary", "fallback"}, Timeout: 10}
+	return NewServer(common.NewAIClientWithProvider(nil), config, "typescript", []string{"extra"}).Handler()
+}
+
+// post sends a JSON request to the handler and returns the status and error message
+func post(t *testing.T, handler http.Handler, path, body string) (int, string) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+
+	var resp ErrorResponse
+	json.Unmarshal(recorder.Body.Bytes(), &resp)
+	return recorder.Code, resp.Error
+}
+
+func TestModelAllowlist(t *testing.T) {
+	handler := newTestServer(t)
+
+	status, message := post(t, handler, "/v1/docgen", `{"code": "package a", "lang": "go", "model": "expensive"}`)
+	if status != http.StatusBadRequest || !strings.Contains(message, `model "expensive" is not allowed`) {
+		t.Errorf("got %d %q, want 400 for a model that is not allowed", status, message)
+	}
+
+	status, message = post(t, handler, "/v1/typegen", `{"html": "<p>x</p>", "model": "expensive"}`)
+	if status != http.StatusBadRequest || !strings.Contains(message, "allowed: extra, fallback, primary") {
+		t.Errorf("got %d %q, want 400 listing the allowed models", status, message)
+	}
+}
+
+func TestTypeGenRejectsNonPublicURL(t *testing.T) {
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the internal server must not be reached")
+	}))
+	defer internal.Close()
+
+	body, _ := json.Marshal(TypeGenRequest{URL: internal.URL, Model: "fallback"})
+	status, message := post(t, newTestServer(t), "/v1/typegen", string(body))
+	if status != http.StatusBadRequest || !strings.Contains(message, "url is not allowed") {
+		t.Errorf("got %d %q, want 400 for a loopback URL", status, message)
+	}
+}
@@ -3,15 +3,24 @@ package typegen
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/kamdyn/ai-toolkit/pkg/common"
 	"github.com/urfave/cli/v2"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+func init() {
+	common.RegisterTool(common.Tool{
+		Name:    "typegen",
+		Aliases: []string{"types", "t"},
+		Command: GetTypeGenCommand,
+	})
+}
+
 // GetTypeGenCommand returns the CLI command for the type generator
 func GetTypeGenCommand() *cli.Command {
 	return &cli.Command{
@@ -38,8 +47,13 @@ func GetTypeGenCommand() *cli.Command {
 			},
 		),
 		Before: func(c *cli.Context) error {
+			// Fill unset options from the config files and profile
+			if err := common.ApplyConfig(c); err != nil {
+				return err
+			}
+
 			// Validate API key
-			if err := common.ValidateAPIKey(c.String("api-key")); err != nil {
+			if err := common.ValidateCredentials(c); err != nil {
 				return err
 			}
 
@@ -58,16 +72,16 @@ func GetTypeGenCommand() *cli.Command {
 }
 
 // runTypeGen runs the type generator
-func runTypeGen(c *cli.Context) error {
+func runTypeGen(c *cli.Context) (err error) {
 	// Extract configuration
 	config := common.ExtractCommonConfig(c)
 	
 	docURL := c.String("url")
 	funcName := c.String("func")
-	language := normalizeLanguage(c.String("lang"))
+	language := NormalizeLanguage(c.String("lang"))
 	
 	// Configure logging based on verbose flag
-	common.PrepareLogger("TypeGen", config.Verbose)
+	common.PrepareLogger("TypeGen", config.LogFormat, config.Verbose)
 
 	// If no output file specified, use default based on language
 	if config.OutputFile == "" {
@@ -75,25 +89,29 @@ func runTypeGen(c *cli.Context) error {
 		config.OutputFile = fmt.Sprintf("types%s", ext)
 	}
 
-	if config.Verbose {
-		log.Printf("Starting type generation from URL: %s", docURL)
-		if funcName != "" {
-			log.Printf("Focusing on function/method: %s", funcName)
-		}
-		log.Printf("Output language: %s", language)
-		log.Printf("Output file: %s", config.OutputFile)
-	}
+	slog.Debug("Starting type generation", "url", docURL, "func", funcName, "lang", language, "file", config.OutputFile)
 
 	// Create timeout context
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.Timeout)*time.Second)
 	defer cancel()
 
-	// 1. Scrape the documentation URL
-	if config.Verbose {
-		log.Printf("Scraping documentation from %s...", docURL)
+	// Export traces of the run if enabled
+	shutdownTracing, err := common.SetupTracing(ctx, "typegen", config.Trace)
+	if err != nil {
+		return err
 	}
-	
-	docContent, err := ScrapeDocumentation(docURL, funcName, config.Verbose)
+	defer shutdownTracing()
+
+	ctx, span := common.StartSpan(ctx, "typegen",
+		attribute.String("url.full", docURL),
+		attribute.String("typegen.lang", language))
+	defer func() { common.EndSpan(span, err) }()
+
+	// 1. Scrape the documentation URL
+	slog.Debug("Scraping documentation", "url", docURL)
+	scrapeStart := time.Now()
+
+	docContent, err := ScrapeDocumentation(ctx, docURL, funcName)
 	if err != nil {
 		return fmt.Errorf("error scraping documentation: %v", err)
 	}
@@ -102,56 +120,59 @@ func runTypeGen(c *cli.Context) error {
 		return fmt.Errorf("no content extracted from the documentation URL")
 	}
 
-	if config.Verbose {
-		preview := docContent
-		if len(preview) > 200 {
-			preview = preview[:200] + "... [truncated]"
-		}
-		log.Printf("Documentation successfully scraped (%d bytes)", len(docContent))
-		log.Printf("Content preview: %s", strings.ReplaceAll(preview, "\n", " "))
+	preview := docContent
+	if len(preview) > 200 {
+		preview = preview[:200] + "... [truncated]"
 	}
+	slog.Debug("Documentation successfully scraped", "url", docURL, "bytes", len(docContent),
+		"duration", time.Since(scrapeStart), "preview", strings.ReplaceAll(preview, "\n", " "))
 
 	// 2. Create AI client
-	aiClient, err := common.NewAIClient(ctx, config.APIKey)
+	aiClient, err := common.NewAIClient(ctx, config)
 	if err != nil {
 		return fmt.Errorf("error creating AI client: %v", err)
 	}
 	defer aiClient.Close()
 
+	// Print token usage and cost once the run is over, including when it fails
+	defer common.ReportUsage(aiClient.Usage(), config.LogFormat)
+
 	// 3. Create generator
 	generator := NewTypeGenerator(aiClient)
+	generator.ChunkTokens = config.ChunkTokens
+	generator.Structured = config.Structured
+	generator.Selection = config.CodeBlocks
+	if config.Stream {
+		generator.Stream = os.Stdout
+	}
 
 	// 4. Generate type definitions
-	if config.Verbose {
-		log.Printf("Generating type definitions using model %s...", config.Model)
-	}
-	
-	typeDefinitions, err := generator.GenerateTypeDefinitions(ctx, config.Model, config.Temperature, docContent, language, funcName, config.Verbose)
+	slog.Debug("Generating type definitions", "url", docURL, "model", config.Model)
+	generateStart := time.Now()
+
+	ctx = common.WithUsageLabel(ctx, docURL)
+	typeDefinitions, err := generator.GenerateTypeDefinitions(ctx, config.Model, config.Temperature, docContent, language, funcName)
 	if err != nil {
 		return fmt.Errorf("error generating type definitions: %v", err)
 	}
 
-	if config.Verbose {
-		log.Println("Type definitions successfully generated")
-	}
+	slog.Debug("Type definitions successfully generated", "url", docURL, "duration", time.Since(generateStart))
 
 	// 5. Write to output file
+	_, writeSpan := common.StartSpan(ctx, "typegen.write", attribute.String("file.path", config.OutputFile))
 	err = os.WriteFile(config.OutputFile, []byte(typeDefinitions), 0644)
+	common.EndSpan(writeSpan, err)
 	if err != nil {
 		return fmt.Errorf("error writing to output file: %v", err)
 	}
 
-	if config.Verbose {
-		log.Printf("Types successfully written to %s", config.OutputFile)
-	} else {
-		fmt.Printf("Types successfully written to %s\n", config.OutputFile)
-	}
+	slog.Info("Types successfully written", "file", config.OutputFile)
 
 	return nil
 }
 
-// normalizeLanguage normalizes language names to standard format
-func normalizeLanguage(lang string) string {
+// NormalizeLanguage normalizes language names to standard format
+func NormalizeLanguage(lang string) string {
 	// Convert to lowercase
 	lang = strings.ToLower(lang)
 
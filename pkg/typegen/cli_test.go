@@ -0,0 +1,46 @@
+package typegen
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+func TestTypeGenCommand(t *testing.T) {
+	// Keep the user's configuration file out of the run
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	page := readTestdata(t, "charges.html")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(page))
+	}))
+	defer server.Close()
+
+	output := filepath.Join(t.TempDir(), "charges.ts")
+	app := &cli.App{Commands: []*cli.Command{GetTypeGenCommand()}}
+	err := app.Run([]string{"ai-tools", "typegen",
+		"--url", server.URL,
+		"--lang", "typescript",
+		"--output", output,
+		"--model", testModel,
+		"--temp", "0.2",
+		"--cassette", filepath.Join("testdata", "typegen.cassette.json"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.TrimSpace(readTestdata(t, "charges.ts")); string(got) != want {
+		t.Errorf("output mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -3,7 +3,8 @@ package typegen
 import (
 	"context"
 	"fmt"
-	"regexp"
+	"io"
+	"log/slog"
 	"strings"
 
 	"github.com/kamdyn/ai-toolkit/pkg/common"
@@ -11,114 +12,147 @@ import (
 
 // TypeGenerator handles the generation of type definitions
 type TypeGenerator struct {
-	client *common.AIClient
+	client common.Provider
+
+	// Stream receives the raw model output as it is generated when non-nil
+	Stream io.Writer
+
+	// ChunkTokens caps the number of documentation tokens sent per request.
+	// Zero derives the limit from the model's context window.
+	ChunkTokens int
+
+	// Structured requests a JSON response instead of extracting fenced code blocks
+	Structured bool
+
+	// Selection chooses among the code blocks of a response. Empty uses common.DefaultCodeSelection.
+	Selection common.CodeSelection
+}
+
+// typeDefinitionsResult is the JSON response requested in structured mode
+type typeDefinitionsResult struct {
+	Code  string   `json:"code" description:"The complete type definitions source, without Markdown code fences"`
+	Types []string `json:"types,omitempty" description:"Names of the types defined in code"`
 }
 
 // NewTypeGenerator creates a new TypeGenerator
-func NewTypeGenerator(client *common.AIClient) *TypeGenerator {
+func NewTypeGenerator(client common.Provider) *TypeGenerator {
 	return &TypeGenerator{
 		client: client,
 	}
 }
 
-// GenerateTypeDefinitions generates type definitions from documentation content
-func (g *TypeGenerator) GenerateTypeDefinitions(ctx context.Context, modelName string, temperature float32, docContent string, language string, funcName string, verbose bool) (string, error) {
-	// Prepare the prompt based on the language
-	prompt := buildPrompt(docContent, language, funcName)
-
-	// Generate content using the AI client
-	result, err := g.client.Generate(ctx, prompt, modelName, temperature)
-	if err != nil {
-		return "", fmt.Errorf("error generating type definitions: %v", err)
+// GenerateTypeDefinitions generates type definitions from documentation content.
+// Documentation too large for the model is split at section headings, each
+// part is processed separately and the resulting definitions are merged.
+func (g *TypeGenerator) GenerateTypeDefinitions(ctx context.Context, modelName string, temperature float32, docContent string, language string, funcName string) (string, error) {
+	budget := g.chunkBudget(modelName, language, funcName)
+	if common.FitsInBudget(ctx, g.client, modelName, docContent, budget) {
+		// Prepare the prompt based on the language
+		prompt, err := buildPrompt(ctx, docContent, language, funcName)
+		if err != nil {
+			return "", err
+		}
+		return g.generate(ctx, modelName, temperature, prompt, language)
 	}
 
-	// Extract and format the output
-	output := extractOutput(result, language)
+	chunks := common.SplitText(docContent, budget, common.SectionBoundary)
+	slog.Debug("Documentation exceeds the token budget, splitting", "model", modelName, "tokens", budget, "parts", len(chunks))
+
+	parts := make([]string, 0, len(chunks))
+	for i, chunk := range chunks {
+		slog.Debug("Generating types for part", "part", i+1, "parts", len(chunks))
 
-	return output, nil
+		prompt, err := buildPrompt(ctx, chunk, language, funcName)
+		if err != nil {
+			return "", err
+		}
+		prompt = common.ChunkNote(i, len(chunks), "documentation page") + prompt
+		output, err := g.generate(ctx, modelName, temperature, prompt, language)
+		if err != nil {
+			return "", fmt.Errorf("error generating types for part %d of %d: %w", i+1, len(chunks), err)
+		}
+		parts = append(parts, output)
+	}
+
+	return mergeTypeDefinitions(parts), nil
 }
 
-// buildPrompt creates a prompt for the AI based on the documentation and target language
-func buildPrompt(docContent string, language string, funcName string) string {
-	var sb strings.Builder
-
-	// Base prompt
-	sb.WriteString("Based on the following API documentation, ")
-	
-	if funcName != "" {
-		sb.WriteString(fmt.Sprintf("generate accurate and complete type definitions for the function or method named '%s' ", funcName))
-	} else {
-		sb.WriteString("generate accurate and complete type definitions ")
+// generate sends a prompt to the model and extracts the type definitions from the response
+func (g *TypeGenerator) generate(ctx context.Context, modelName string, temperature float32, prompt string, language string) (string, error) {
+	req := common.GenerateRequest{
+		Prompt:      prompt,
+		Model:       modelName,
+		Temperature: temperature,
 	}
-	
-	sb.WriteString(fmt.Sprintf("in %s. ", getLanguageName(language)))
 
-	// Language-specific instructions
-	switch language {
-	case "typescript", "ts":
-		sb.WriteString("Include proper TypeScript interfaces, types, enums, generics, and all necessary types including parameters, request/response objects, and return types. Use strict typing (avoid 'any' when possible). Add JSDoc comments for all types. ")
-	case "go", "golang":
-		sb.WriteString("Create Go structs with proper field types and appropriate struct tags (json, xml, etc. as needed). Include interfaces, type aliases, and constants where appropriate. Add godoc style comments. ")
-	case "python", "py":
-		sb.WriteString("Use modern Python type annotations (typing module). Include type hints for function parameters, return types, class attributes, etc. Use dataclasses or Pydantic models where appropriate. Add docstrings for all types. ")
-	case "rust", "rs":
-		sb.WriteString("Create Rust structs and enums with proper field types. Include trait implementations, derive macros, and proper documentation comments. Use appropriate Serde annotations for serialization if needed. ")
-	case "java":
-		sb.WriteString("Create Java classes with proper field types, getters, setters and constructors. Include interfaces, enums, and generics where appropriate. Add Javadoc comments. Use appropriate annotations (e.g., Jackson annotations for JSON processing). ")
-	case "csharp", "cs":
-		sb.WriteString("Create C# classes with proper field types, properties, and constructors. Include interfaces, enums, and generics where appropriate. Add XML documentation comments. Use appropriate attributes (e.g., JsonProperty for JSON processing). ")
-	case "swift":
-		sb.WriteString("Create Swift structs/classes with proper field types and codable conformance where appropriate. Include protocols, enums, and optionals where needed. Add documentation comments. ")
-	case "kotlin", "kt":
-		sb.WriteString("Create Kotlin data classes with proper field types. Include interfaces, sealed classes, and nullable types where appropriate. Add KDoc comments. Use appropriate annotations (e.g., Serializable, JsonProperty). ")
+	// In structured mode the code comes back as a JSON field, so no extraction is needed
+	if g.Structured {
+		req.Prompt += "\n\nReturn the result as a JSON object with the source in the \"code\" field and the defined type names in the \"types\" field."
+		var result typeDefinitionsResult
+		if err := common.GenerateJSON(ctx, g.client, req, &result); err != nil {
+			return "", fmt.Errorf("error generating type definitions: %w", err)
+		}
+		return strings.TrimSpace(result.Code), nil
 	}
 
-	// Additional instructions for all languages
-	sb.WriteString("Ensure all types accurately represent the API's data structures, parameter types, and return values. Do not include implementation logic, only type definitions. ")
-	
-	if funcName != "" {
-		sb.WriteString(fmt.Sprintf("Focus specifically on the '%s' function/method and its associated types. ", funcName))
+	// Generate content using the AI client
+	resp, err := common.GenerateText(ctx, g.client, req, g.Stream)
+	if err != nil {
+		return "", fmt.Errorf("error generating type definitions: %w", err)
 	}
 
-	sb.WriteString("Include all necessary imports/includes at the top of the file. ")
-	sb.WriteString("Output only code, no additional explanation. ")
+	// Extract and format the output
+	return common.SelectCode(resp.Text, getLanguageMarkers(language), g.Selection), nil
+}
 
-	// Add the documentation content
-	sb.WriteString("\n\nAPI DOCUMENTATION:\n")
-	sb.WriteString(docContent)
+// chunkBudget returns the maximum number of documentation tokens to send in one request
+func (g *TypeGenerator) chunkBudget(modelName string, language string, funcName string) int {
+	if g.ChunkTokens > 0 {
+		return g.ChunkTokens
+	}
 
-	return sb.String()
+	template, _ := renderPrompt("", language, funcName)
+	overhead := common.EstimateTokens(template)
+	return common.InputBudget(modelName, overhead)
 }
 
-// extractOutput processes the API response and returns formatted output
-func extractOutput(text string, language string) string {
-	var result strings.Builder
-
-	// Try to extract code blocks using language-specific markers
-	codeBlockPattern := fmt.Sprintf("```(?:%s)?([\\s\\S]*?)```", getLanguageMarker(language))
-	re := regexp.MustCompile(codeBlockPattern)
-	matches := re.FindAllStringSubmatch(text, -1)
-
-	if len(matches) > 0 {
-		// Extract code from inside the code blocks
-		for _, match := range matches {
-			if len(match) > 1 {
-				code := strings.TrimSpace(match[1])
-				result.WriteString(code)
-				result.WriteString("\n\n")
+// mergeTypeDefinitions joins the definitions generated for each part of the
+// documentation, keeping only the first occurrence of import-like lines
+func mergeTypeDefinitions(parts []string) string {
+	seen := make(map[string]bool)
+	merged := make([]string, 0, len(parts))
+
+	for _, part := range parts {
+		var kept []string
+		for _, line := range strings.Split(part, "\n") {
+			trimmed := strings.TrimSpace(line)
+			if isImportLine(trimmed) {
+				if seen[trimmed] {
+					continue
+				}
+				seen[trimmed] = true
 			}
+			kept = append(kept, line)
+		}
+
+		part = strings.TrimSpace(strings.Join(kept, "\n"))
+		if part != "" {
+			merged = append(merged, part)
 		}
-	} else {
-		// If no code blocks found, try to clean up the text by removing markdown-like content
-		// Remove headers
-		text = regexp.MustCompile(`(?m)^#+ .*$`).ReplaceAllString(text, "")
-		// Remove bullet points
-		text = regexp.MustCompile(`(?m)^[*-] `).ReplaceAllString(text, "")
-
-		result.WriteString(text)
 	}
 
-	return strings.TrimSpace(result.String())
+	return strings.Join(merged, "\n\n")
+}
+
+// isImportLine reports whether a line is a package, import or using declaration
+func isImportLine(line string) bool {
+	prefixes := []string{"import ", "from ", "using ", "use ", "package ", "#include "}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
 }
 
 // getLanguageName returns the full name of a language from its code
@@ -145,26 +179,26 @@ func getLanguageName(code string) string {
 	}
 }
 
-// getLanguageMarker returns the language marker used in code blocks
-func getLanguageMarker(code string) string {
+// getLanguageMarkers returns the names a code block in the language may be labeled with
+func getLanguageMarkers(code string) []string {
 	switch code {
 	case "typescript", "ts":
-		return "typescript|ts"
+		return []string{"typescript", "ts"}
 	case "go", "golang":
-		return "go|golang"
+		return []string{"go", "golang"}
 	case "python", "py":
-		return "python|py"
+		return []string{"python", "py"}
 	case "rust", "rs":
-		return "rust|rs"
+		return []string{"rust", "rs"}
 	case "java":
-		return "java"
+		return []string{"java"}
 	case "csharp", "cs":
-		return "csharp|cs"
+		return []string{"csharp", "cs"}
 	case "swift":
-		return "swift"
+		return []string{"swift"}
 	case "kotlin", "kt":
-		return "kotlin|kt"
+		return []string{"kotlin", "kt"}
 	default:
-		return code
+		return []string{code}
 	}
-}
\ No newline at end of file
+}
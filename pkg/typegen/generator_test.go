@@ -0,0 +1,58 @@
+package typegen
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kamdyn/ai-toolkit/pkg/common"
+)
+
+// The model and temperature the cassettes under testdata were recorded with
+const (
+	testModel       = "gemini-2.0-flash"
+	testTemperature = 0.2
+)
+
+// readTestdata returns the contents of a file under testdata
+func readTestdata(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestGenerateTypeDefinitions(t *testing.T) {
+	cassette, err := common.LoadCassette(filepath.Join("testdata", "typegen.cassette.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewTypeGenerator(common.NewAIClientWithProvider(common.NewReplayProvider(common.ProviderGemini, cassette)))
+
+	// The documentation is scraped from the page the cassette was recorded with
+	page := readTestdata(t, "charges.html")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(page))
+	}))
+	defer server.Close()
+
+	docContent, err := ScrapeDocumentation(context.Background(), server.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := g.GenerateTypeDefinitions(context.Background(), testModel, testTemperature, docContent, "typescript", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.TrimSpace(readTestdata(t, "charges.ts")); got != want {
+		t.Errorf("type definitions mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,54 @@
+package typegen
+
+import (
+	"context"
+	"embed"
+	"io/fs"
+
+	"github.com/kamdyn/ai-toolkit/pkg/common"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+//go:embed templates/*.tmpl
+var templateFiles embed.FS
+
+// Prompts holds the typegen prompt templates. Each can be overridden by a file
+// of the same name in .ai-toolkit/prompts/typegen or the user config directory.
+var Prompts = common.RegisterPromptSet("typegen", mustSub(templateFiles, "templates"))
+
+// typesPromptData is the data the types template is rendered with
+type typesPromptData struct {
+	Documentation string
+	Language      string
+	LanguageName  string
+	FuncName      string
+}
+
+// buildPrompt creates a prompt for the AI based on the documentation and target language
+func buildPrompt(ctx context.Context, docContent string, language string, funcName string) (string, error) {
+	_, span := common.StartSpan(ctx, "typegen.build_prompt",
+		attribute.String("typegen.lang", language),
+		attribute.String("typegen.func", funcName))
+	prompt, err := renderPrompt(docContent, language, funcName)
+	common.EndSpan(span, err)
+	return prompt, err
+}
+
+// renderPrompt renders the types template
+func renderPrompt(docContent string, language string, funcName string) (string, error) {
+	return Prompts.Render("types", typesPromptData{
+		Documentation: docContent,
+		Language:      language,
+		LanguageName:  getLanguageName(language),
+		FuncName:      funcName,
+	})
+}
+
+// mustSub returns the subtree of an embedded file system
+func mustSub(files fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(files, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
@@ -0,0 +1,99 @@
+package typegen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrNonPublicAddress is returned when a page of an untrusted URL would be
+// fetched from a loopback, private, link-local or otherwise reserved address
+var ErrNonPublicAddress = errors.New("address is not public")
+
+// reservedNetworks are the ranges that are neither routable on the internet
+// nor covered by the net.IP predicates
+var reservedNetworks = parseNetworks(
+	"0.0.0.0/8",       // "this" network
+	"100.64.0.0/10",   // carrier-grade NAT
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // documentation
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // documentation
+	"203.0.113.0/24",  // documentation
+	"240.0.0.0/4",     // reserved, broadcast included
+	"64:ff9b::/96",    // NAT64, which can reach private IPv4 addresses
+	"2001:db8::/32",   // documentation
+)
+
+// ScrapePublicDocumentation is ScrapeDocumentation for URLs from untrusted
+// callers, such as the HTTP server's clients. The page, its redirects and the
+// pages it links to are only fetched over HTTP(S) from public addresses, which
+// are checked after DNS resolution so a name cannot point back into the network.
+func ScrapePublicDocumentation(ctx context.Context, docURL string, funcName string) (string, error) {
+	u, err := url.ParseRequestURI(docURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid URL: unsupported scheme %q", u.Scheme)
+	}
+
+	return scrape(ctx, docURL, publicTransport(), funcName)
+}
+
+// publicTransport returns a transport that only connects to public addresses.
+// Proxies are not used, as the check would apply to the proxy instead of the page.
+func publicTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("%s: %w", host, ErrNonPublicAddress)
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return transport
+}
+
+// isPublicIP reports whether ip is a unicast address routable on the internet
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseNetworks parses CIDR ranges, panicking on invalid ones
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
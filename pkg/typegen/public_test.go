@@ -0,0 +1,52 @@
+package typegen
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	tests := map[string]bool{
+		"93.184.216.34":    true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"::1":              false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"100.64.0.1":       false,
+		"0.0.0.0":          false,
+		"::ffff:127.0.0.1": false,
+		"64:ff9b::a00:1":   false,
+	}
+	for address, want := range tests {
+		if got := isPublicIP(net.ParseIP(address)); got != want {
+			t.Errorf("isPublicIP(%s) = %v, want %v", address, got, want)
+		}
+	}
+}
+
+func TestScrapePublicDocumentationRejectsLocalServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the local server must not be reached")
+	}))
+	defer server.Close()
+
+	_, err := ScrapePublicDocumentation(context.Background(), server.URL, "")
+	if !errors.Is(err, ErrNonPublicAddress) {
+		t.Fatalf("expected ErrNonPublicAddress, got %v", err)
+	}
+}
+
+func TestScrapePublicDocumentationRejectsScheme(t *testing.T) {
+	if _, err := ScrapePublicDocumentation(context.Background(), "file:///etc/passwd", ""); err == nil {
+		t.Fatal("expected an error for a file URL")
+	}
+}
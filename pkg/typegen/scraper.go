@@ -1,32 +1,61 @@
 package typegen
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net/http"
 	"net/url"
 	"strings"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/gocolly/colly/v2"
+	"github.com/kamdyn/ai-toolkit/pkg/common"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/net/html"
 )
 
 // Maximum depth for recursion when extracting content
 const maxDepth = 3
 
+// uploadedPageURL is the address under which an uploaded page is scraped. The
+// .invalid domain can never resolve, and the page is served from memory anyway.
+const uploadedPageURL = "http://uploaded.invalid/"
+
 // ScrapeDocumentation fetches and extracts relevant content from an API documentation URL
-func ScrapeDocumentation(docURL string, funcName string, verbose bool) (string, error) {
+func ScrapeDocumentation(ctx context.Context, docURL string, funcName string) (string, error) {
 	// Validate URL
 	_, err := url.ParseRequestURI(docURL)
 	if err != nil {
 		return "", fmt.Errorf("invalid URL: %v", err)
 	}
 
-	if verbose {
-		log.Printf("Starting scraping of %s", docURL)
-	}
+	return scrape(ctx, docURL, http.DefaultTransport, funcName)
+}
+
+// ScrapeHTML extracts relevant content from the HTML of a documentation page
+// that was fetched by the caller, e.g. uploaded to the HTTP server
+func ScrapeHTML(ctx context.Context, page string, funcName string) (string, error) {
+	return scrape(ctx, uploadedPageURL, staticTransport(page), funcName)
+}
+
+// scrape extracts the documentation content of docURL, fetched through transport
+func scrape(ctx context.Context, docURL string, transport http.RoundTripper, funcName string) (result string, err error) {
+	ctx, span := common.StartSpan(ctx, "typegen.scrape",
+		attribute.String("url.full", docURL),
+		attribute.String("typegen.func", funcName))
+	defer func() {
+		span.SetAttributes(attribute.Int("typegen.content_bytes", len(result)))
+		common.EndSpan(span, err)
+	}()
+
+	// Requests are canceled with ctx
+	transport = &contextTransport{ctx: ctx, base: transport}
+
+	slog.Debug("Starting scraping", "url", docURL)
 
 	// Initialize a new collector
 	c := colly.NewCollector(
@@ -36,6 +65,7 @@ func ScrapeDocumentation(docURL string, funcName string, verbose bool) (string,
 
 	// Set timeout to prevent hanging
 	c.SetRequestTimeout(30 * time.Second)
+	c.WithTransport(transport)
 
 	// Store the extracted content
 	var content strings.Builder
@@ -75,7 +105,7 @@ func ScrapeDocumentation(docURL string, funcName string, verbose bool) (string,
 				// Check if the element contains the function name in text
 				if strings.Contains(e.Text, funcName) {
 					// Extract relevant section and append to content
-					extractedText := extractRelevantSection(e.DOM, funcName, verbose)
+					extractedText := extractRelevantSection(e.DOM, funcName)
 					if extractedText != "" {
 						content.WriteString(extractedText)
 						content.WriteString("\n\n")
@@ -123,20 +153,20 @@ func ScrapeDocumentation(docURL string, funcName string, verbose bool) (string,
 	// Visit the URL
 	err = c.Visit(docURL)
 	if err != nil {
-		return "", fmt.Errorf("error visiting URL: %v", err)
+		return "", fmt.Errorf("error visiting URL: %w", err)
 	}
 
 	// Wait for scraping to finish
 	c.Wait()
 
-	result := content.String()
+	result = content.String()
 
 	// If we didn't find anything specific but a function name was provided,
 	// try a more aggressive approach with a second pass
 	if funcName != "" && !strings.Contains(result, funcName) {
-		if verbose {
-			log.Printf("Function %s not found in first pass, trying second pass", funcName)
-		}
+		slog.Debug("Function not found in first pass, trying second pass", "url", docURL, "func", funcName)
+
+		_, secondPass := common.StartSpan(ctx, "typegen.scrape.second_pass")
 
 		// Reset the content builder
 		content.Reset()
@@ -148,6 +178,7 @@ func ScrapeDocumentation(docURL string, funcName string, verbose bool) (string,
 
 		// Set timeout
 		c2.SetRequestTimeout(30 * time.Second)
+		c2.WithTransport(transport)
 
 		// Look for any element that contains the function name
 		c2.OnHTML("*", func(e *colly.HTMLElement) {
@@ -155,7 +186,7 @@ func ScrapeDocumentation(docURL string, funcName string, verbose bool) (string,
 			if strings.Contains(e.Text, funcName) {
 				// Extract only if it's a reasonable container element
 				if isContentElement(e.Name) {
-					extractedText := extractRelevantSection(e.DOM, funcName, verbose)
+					extractedText := extractRelevantSection(e.DOM, funcName)
 					if extractedText != "" {
 						content.WriteString(extractedText)
 						content.WriteString("\n\n")
@@ -167,11 +198,13 @@ func ScrapeDocumentation(docURL string, funcName string, verbose bool) (string,
 		// Visit the URL
 		err = c2.Visit(docURL)
 		if err != nil {
-			return "", fmt.Errorf("error in second visit to URL: %v", err)
+			common.EndSpan(secondPass, err)
+			return "", fmt.Errorf("error in second visit to URL: %w", err)
 		}
 
 		// Wait for scraping to finish
 		c2.Wait()
+		common.EndSpan(secondPass, nil)
 
 		// Update result
 		result = content.String()
@@ -184,19 +217,49 @@ func ScrapeDocumentation(docURL string, funcName string, verbose bool) (string,
 		return "", errors.New("no content extracted from the documentation URL")
 	}
 
-	if verbose {
-		contentPreview := result
-		if len(contentPreview) > 200 {
-			contentPreview = contentPreview[:200] + "..."
-		}
-		log.Printf("Extracted content preview: %s", contentPreview)
+	contentPreview := result
+	if len(contentPreview) > 200 {
+		contentPreview = contentPreview[:200] + "..."
 	}
+	slog.Debug("Extracted content", "url", docURL, "bytes", len(result), "preview", contentPreview)
 
 	return result, nil
 }
 
+// contextTransport binds the requests of a collector to a context, which
+// colly has no option for
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req.WithContext(t.ctx))
+}
+
+// staticTransport answers every request with the same HTML page
+type staticTransport string
+
+func (t staticTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"text/html; charset=utf-8"}},
+		Body:          io.NopCloser(strings.NewReader(string(t))),
+		ContentLength: int64(len(t)),
+		Request:       req,
+	}, nil
+}
+
 // extractRelevantSection extracts content relevant to a specific function
-func extractRelevantSection(s *goquery.Selection, funcName string, verbose bool) string {
+func extractRelevantSection(s *goquery.Selection, funcName string) string {
 	var content strings.Builder
 
 	// First check if this element itself is a good container for the function
@@ -0,0 +1,9 @@
+/** Parameters of POST /v1/charges */
+export interface CreateChargeParams {
+  /** Amount in cents */
+  amount: number;
+  /** Three-letter ISO currency code */
+  currency: string;
+  /** Optional description */
+  description?: string;
+}